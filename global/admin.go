@@ -0,0 +1,62 @@
+package global
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// AdminAPI is a module which adds endpoints to Caddy's admin API, allowing
+// operators to discover information about this package at runtime.
+//
+// GET /mediocre-caddy-plugins/metrics responds with a JSON array describing
+// each metric which has been registered by this package, including both the
+// globally configured histograms and the metrics registered by handlers.
+type AdminAPI struct{}
+
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.mediocre_caddy_plugins",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+func (a AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/mediocre-caddy-plugins/metrics",
+			Handler: caddy.AdminHandlerFunc(a.handleMetrics),
+		},
+	}
+}
+
+func (a AdminAPI) handleMetrics(rw http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method %s not allowed", r.Method),
+		}
+	}
+
+	descs := []MetricDescription{}
+
+	appI, err := caddy.ActiveContext().AppIfConfigured("mediocre_caddy_plugins")
+	if err == nil {
+		descs = appI.(*App).Metrics.Descriptions()
+	} else if !errors.Is(err, caddy.ErrNotConfigured) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("getting mediocre_caddy_plugins app: %w", err),
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(rw).Encode(descs)
+}
@@ -0,0 +1,35 @@
+package global
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAPIMetrics(t *testing.T) {
+	t.Parallel()
+
+	handler := caddy.AdminHandlerFunc(AdminAPI{}.handleMetrics)
+
+	t.Log("Checking that an empty list is returned when the app isn't configured")
+	rw := httptest.NewRecorder()
+	require.NoError(t, handler.ServeHTTP(
+		rw, httptest.NewRequest("GET", "/mediocre-caddy-plugins/metrics", nil),
+	))
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[]`, rw.Body.String())
+
+	t.Log("Checking that other methods are rejected")
+	err := handler.ServeHTTP(
+		httptest.NewRecorder(),
+		httptest.NewRequest("POST", "/mediocre-caddy-plugins/metrics", nil),
+	)
+
+	var apiErr caddy.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusMethodNotAllowed, apiErr.HTTPStatus)
+}
@@ -64,11 +64,26 @@ func (a *App) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 //				help <help/description of the metric>
 //				buckets <float> [<float>...]
 //				labels <labelName> [<labelName>...]
+//
+//				// enables native histogram buckets, in which case buckets
+//				// are only used if given.
+//				native_factor <float>
+//				native_max_buckets <int>
 //			}
 //
 //			// multiple histograms may be specified, but they must have
 //			// different names.
 //			histogram <name>
+//
+//			counter <name> { // all fields inside the block are optional
+//				help <help/description of the metric>
+//				labels <labelName> [<labelName>...]
+//			}
+//
+//			gauge <name> { // all fields inside the block are optional
+//				help <help/description of the metric>
+//				labels <labelName> [<labelName>...]
+//			}
 //		}
 //	}
 func parseApp(d *caddyfile.Dispenser, existingVal any) (any, error) {
@@ -1,9 +1,12 @@
 package global
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
@@ -17,6 +20,36 @@ type MetricHistogram struct {
 	Help    string    `json:"help"`
 	Buckets []float64 `json:"buckets"`
 	Labels  []string  `json:"labels"`
+
+	// NativeFactor, if given, enables native (sparse) buckets for the
+	// histogram, with each bucket being at most this factor wider than the
+	// previous one. It must be greater than 1. If native buckets are enabled
+	// then classic buckets are only used if Buckets is given explicitly.
+	NativeFactor float64 `json:"native_factor,omitempty"`
+
+	// NativeMaxBuckets limits the number of native buckets which may be
+	// populated, after which the resolution of the histogram is reduced. Zero
+	// means no limit. Requires NativeFactor.
+	NativeMaxBuckets uint32 `json:"native_max_buckets,omitempty"`
+}
+
+// isNative returns true if the histogram has native buckets enabled.
+func (mh MetricHistogram) isNative() bool {
+	return mh.NativeFactor != 0
+}
+
+func (mh MetricHistogram) validate() error {
+	if mh.isNative() && mh.NativeFactor <= 1 {
+		return fmt.Errorf(
+			"native_factor must be greater than 1, got %v", mh.NativeFactor,
+		)
+	}
+
+	if mh.NativeMaxBuckets > 0 && !mh.isNative() {
+		return errors.New("native_max_buckets requires native_factor")
+	}
+
+	return nil
 }
 
 func (mh *MetricHistogram) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
@@ -51,6 +84,89 @@ func (mh *MetricHistogram) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 		case "labels":
 			mh.Labels = d.RemainingArgs()
 
+		case "native_factor":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			var err error
+			if mh.NativeFactor, err = strconv.ParseFloat(d.Val(), 64); err != nil {
+				return fmt.Errorf("parsing native_factor %q: %w", d.Val(), err)
+			}
+
+		case "native_max_buckets":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+
+			n, err := strconv.ParseUint(d.Val(), 10, 32)
+			if err != nil {
+				return fmt.Errorf(
+					"parsing native_max_buckets %q: %w", d.Val(), err,
+				)
+			}
+			mh.NativeMaxBuckets = uint32(n)
+
+		default:
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// MetricCounter describes a counter metric which will be registered with
+// Caddy's prometheus registry.
+type MetricCounter struct {
+	Name   string   `json:"name"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels"`
+}
+
+func (mc *MetricCounter) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&mc.Name) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "help":
+			if !d.Args(&mc.Help) {
+				return d.ArgErr()
+			}
+
+		case "labels":
+			mc.Labels = d.RemainingArgs()
+
+		default:
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// MetricGauge describes a gauge metric which will be registered with Caddy's
+// prometheus registry.
+type MetricGauge struct {
+	Name   string   `json:"name"`
+	Help   string   `json:"help"`
+	Labels []string `json:"labels"`
+}
+
+func (mg *MetricGauge) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if !d.Args(&mg.Name) {
+		return d.ArgErr()
+	}
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "help":
+			if !d.Args(&mg.Help) {
+				return d.ArgErr()
+			}
+
+		case "labels":
+			mg.Labels = d.RemainingArgs()
+
 		default:
 			return d.ArgErr()
 		}
@@ -58,10 +174,38 @@ func (mh *MetricHistogram) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	return nil
 }
 
+// MetricDescription describes a metric which has been registered by this
+// package, either globally or by a handler, for the purposes of discovery.
+type MetricDescription struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Help   string   `json:"help,omitempty"`
+	Labels []string `json:"labels"`
+
+	// Buckets is only given for histograms, and is omitted for native
+	// histograms which have no classic buckets.
+	Buckets []float64 `json:"buckets,omitempty"`
+
+	// NativeFactor is only given for native histograms.
+	NativeFactor float64 `json:"native_factor,omitempty"`
+}
+
+// metricDescriptions is a thread-safe set of MetricDescriptions, keyed by
+// name.
+type metricDescriptions struct {
+	l sync.Mutex
+	m map[string]MetricDescription
+}
+
 // Metrics describe all global metrics used within a running Caddy instance.
 type Metrics struct {
-	Histograms []MetricHistogram `json:"histograms"`
-	histograms map[string]*prometheus.HistogramVec
+	Histograms   []MetricHistogram `json:"histograms"`
+	Counters     []MetricCounter   `json:"counters,omitempty"`
+	Gauges       []MetricGauge     `json:"gauges,omitempty"`
+	histograms   map[string]*prometheus.HistogramVec
+	counters     map[string]*prometheus.CounterVec
+	gauges       map[string]*prometheus.GaugeVec
+	descriptions *metricDescriptions
 }
 
 // HistogramByName returns the prometheus histogram object configured with the
@@ -71,27 +215,208 @@ func (m Metrics) HistogramByName(name string) (*prometheus.HistogramVec, bool) {
 	return h, ok
 }
 
+// CounterByName returns the prometheus counter object configured with the given
+// name.
+func (m Metrics) CounterByName(name string) (*prometheus.CounterVec, bool) {
+	c, ok := m.counters[name]
+	return c, ok
+}
+
+// GaugeByName returns the prometheus gauge object configured with the given
+// name.
+func (m Metrics) GaugeByName(name string) (*prometheus.GaugeVec, bool) {
+	g, ok := m.gauges[name]
+	return g, ok
+}
+
+// Describe records the description of a metric which has been registered, so
+// that it will be included in the result of Descriptions. Handlers which
+// register their own metrics should use this to make them discoverable.
+func (m *Metrics) Describe(desc MetricDescription) {
+	desc.Labels = slices.Clone(desc.Labels)
+	slices.Sort(desc.Labels)
+	if desc.Labels == nil {
+		desc.Labels = []string{}
+	}
+
+	m.descriptions.l.Lock()
+	defer m.descriptions.l.Unlock()
+	m.descriptions.m[desc.Name] = desc
+}
+
+// Descriptions returns the descriptions of all metrics which have been
+// registered, sorted by name.
+func (m *Metrics) Descriptions() []MetricDescription {
+	if m.descriptions == nil {
+		return []MetricDescription{}
+	}
+
+	m.descriptions.l.Lock()
+	defer m.descriptions.l.Unlock()
+
+	descs := make([]MetricDescription, 0, len(m.descriptions.m))
+	for _, desc := range m.descriptions.m {
+		descs = append(descs, desc)
+	}
+
+	slices.SortFunc(descs, func(a, b MetricDescription) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return descs
+}
+
 func (m *Metrics) provision(ctx caddy.Context) error {
+	return m.register(ctx.GetMetricsRegistry())
+}
+
+// registerCollector registers the given collector with the registerer. If an
+// identical collector is already registered then that is returned instead.
+func registerCollector[T prometheus.Collector](
+	reg prometheus.Registerer, kind, name string, collector T,
+) (
+	T, error,
+) {
+	err := reg.Register(collector)
+	if err == nil {
+		return collector, nil
+	}
+
+	var alreadyErr prometheus.AlreadyRegisteredError
+	if !errors.As(err, &alreadyErr) {
+		return collector, fmt.Errorf("registering %s %q: %w", kind, name, err)
+	}
+
+	existing, ok := alreadyErr.ExistingCollector.(T)
+	if !ok {
+		return collector, fmt.Errorf(
+			"%s %q already registered as a different type of metric", kind, name,
+		)
+	}
+
+	return existing, nil
+}
+
+// register registers all configured histograms, counters, and gauges with the
+// given registerer.
+//
+// A metric may already be registered, e.g. when the config is reloaded, in
+// which case the existing collector is reused so long as it has an identical
+// definition. Note that buckets, classic or native, are not considered part of
+// the definition by prometheus, so changing the buckets of a histogram requires
+// a restart.
+func (m *Metrics) register(reg prometheus.Registerer) error {
 	m.histograms = make(map[string]*prometheus.HistogramVec, len(m.Histograms))
+	m.counters = make(map[string]*prometheus.CounterVec, len(m.Counters))
+	m.gauges = make(map[string]*prometheus.GaugeVec, len(m.Gauges))
+	m.descriptions = &metricDescriptions{m: map[string]MetricDescription{}}
+
+	names := map[string]struct{}{}
+	useName := func(name string) error {
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("name already used: %q", name)
+		}
+		names[name] = struct{}{}
+		return nil
+	}
+
 	for _, hCfg := range m.Histograms {
-		if _, ok := m.histograms[hCfg.Name]; ok {
-			return fmt.Errorf("name already used: %q", hCfg.Name)
+		if err := useName(hCfg.Name); err != nil {
+			return err
 		}
 
-		histogram := prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name:    hCfg.Name,
-				Help:    hCfg.Help,
-				Buckets: hCfg.Buckets,
-			},
-			hCfg.Labels,
-		)
+		if err := hCfg.validate(); err != nil {
+			return fmt.Errorf("histogram %q: %w", hCfg.Name, err)
+		}
 
-		if err := ctx.GetMetricsRegistry().Register(histogram); err != nil {
-			return fmt.Errorf("registering histogram %q: %w", hCfg.Name, err)
+		histogram, err := registerCollector(reg, "histogram", hCfg.Name,
+			prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    hCfg.Name,
+					Help:    hCfg.Help,
+					Buckets: hCfg.Buckets,
+
+					NativeHistogramBucketFactor:    hCfg.NativeFactor,
+					NativeHistogramMaxBucketNumber: hCfg.NativeMaxBuckets,
+				},
+				hCfg.Labels,
+			),
+		)
+		if err != nil {
+			return err
 		}
 
 		m.histograms[hCfg.Name] = histogram
+
+		buckets := hCfg.Buckets
+		if len(buckets) == 0 && !hCfg.isNative() {
+			buckets = prometheus.DefBuckets
+		}
+
+		m.Describe(MetricDescription{
+			Name:         hCfg.Name,
+			Type:         "histogram",
+			Help:         hCfg.Help,
+			Labels:       hCfg.Labels,
+			Buckets:      buckets,
+			NativeFactor: hCfg.NativeFactor,
+		})
+	}
+
+	for _, cCfg := range m.Counters {
+		if err := useName(cCfg.Name); err != nil {
+			return err
+		}
+
+		counter, err := registerCollector(reg, "counter", cCfg.Name,
+			prometheus.NewCounterVec(
+				prometheus.CounterOpts{
+					Name: cCfg.Name,
+					Help: cCfg.Help,
+				},
+				cCfg.Labels,
+			),
+		)
+		if err != nil {
+			return err
+		}
+
+		m.counters[cCfg.Name] = counter
+
+		m.Describe(MetricDescription{
+			Name:   cCfg.Name,
+			Type:   "counter",
+			Help:   cCfg.Help,
+			Labels: cCfg.Labels,
+		})
+	}
+
+	for _, gCfg := range m.Gauges {
+		if err := useName(gCfg.Name); err != nil {
+			return err
+		}
+
+		gauge, err := registerCollector(reg, "gauge", gCfg.Name,
+			prometheus.NewGaugeVec(
+				prometheus.GaugeOpts{
+					Name: gCfg.Name,
+					Help: gCfg.Help,
+				},
+				gCfg.Labels,
+			),
+		)
+		if err != nil {
+			return err
+		}
+
+		m.gauges[gCfg.Name] = gauge
+
+		m.Describe(MetricDescription{
+			Name:   gCfg.Name,
+			Type:   "gauge",
+			Help:   gCfg.Help,
+			Labels: gCfg.Labels,
+		})
 	}
 
 	return nil
@@ -108,6 +433,20 @@ func (m *Metrics) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 			}
 			m.Histograms = append(m.Histograms, mh)
 
+		case "counter":
+			var mc MetricCounter
+			if err := mc.UnmarshalCaddyfile(d); err != nil {
+				return fmt.Errorf("unmarshaling counter: %w", err)
+			}
+			m.Counters = append(m.Counters, mc)
+
+		case "gauge":
+			var mg MetricGauge
+			if err := mg.UnmarshalCaddyfile(d); err != nil {
+				return fmt.Errorf("unmarshaling gauge: %w", err)
+			}
+			m.Gauges = append(m.Gauges, mg)
+
 		default:
 			return d.ArgErr()
 		}
@@ -0,0 +1,314 @@
+package global
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsRegister(t *testing.T) {
+	t.Parallel()
+
+	newMetrics := func(help string, labels ...string) *Metrics {
+		return &Metrics{Histograms: []MetricHistogram{{
+			Name:   "test_histogram",
+			Help:   help,
+			Labels: labels,
+		}}}
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			reg = prometheus.NewRegistry()
+			a   = newMetrics("help", "handler")
+			b   = newMetrics("help", "handler")
+		)
+
+		require.NoError(t, a.register(reg))
+
+		t.Log("Checking that an identical histogram reuses the existing one")
+		require.NoError(t, b.register(reg))
+
+		aHist, ok := a.HistogramByName("test_histogram")
+		require.True(t, ok)
+		bHist, ok := b.HistogramByName("test_histogram")
+		require.True(t, ok)
+		assert.Same(t, aHist, bHist)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		t.Parallel()
+
+		reg := prometheus.NewRegistry()
+		require.NoError(t, newMetrics("help", "handler").register(reg))
+
+		t.Log("Checking that a histogram with different labels is rejected")
+		assert.Error(t, newMetrics("help", "other").register(reg))
+
+		t.Log("Checking that a histogram with different help is rejected")
+		assert.Error(t, newMetrics("other help", "handler").register(reg))
+	})
+
+	t.Run("different type", func(t *testing.T) {
+		t.Parallel()
+
+		reg := prometheus.NewRegistry()
+		require.NoError(t, reg.Register(prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "test_histogram", Help: "help"},
+			[]string{"handler"},
+		)))
+
+		assert.Error(t, newMetrics("help", "handler").register(reg))
+	})
+	t.Run("counters", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			reg = prometheus.NewRegistry()
+			m   = &Metrics{Counters: []MetricCounter{{
+				Name:   "test_total",
+				Help:   "help",
+				Labels: []string{"status"},
+			}}}
+		)
+
+		require.NoError(t, m.register(reg))
+
+		counter, ok := m.CounterByName("test_total")
+		require.True(t, ok)
+		counter.WithLabelValues("500").Inc()
+
+		t.Log("Checking that an identical counter reuses the existing one")
+		m2 := &Metrics{Counters: m.Counters}
+		require.NoError(t, m2.register(reg))
+		counter2, ok := m2.CounterByName("test_total")
+		require.True(t, ok)
+		assert.Same(t, counter, counter2)
+
+		_, ok = m.HistogramByName("test_total")
+		assert.False(t, ok)
+
+		t.Log("Checking that a counter can't share a name with a histogram")
+		assert.Error(t, (&Metrics{
+			Histograms: []MetricHistogram{{Name: "test_metric"}},
+			Counters:   []MetricCounter{{Name: "test_metric"}},
+		}).register(prometheus.NewRegistry()))
+
+		t.Log("Checking that a histogram can't reuse a counter's registration")
+		assert.Error(t, (&Metrics{Histograms: []MetricHistogram{{
+			Name: "test_total", Help: "help", Labels: []string{"status"},
+		}}}).register(reg))
+	})
+
+	t.Run("gauges", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			reg = prometheus.NewRegistry()
+			m   = &Metrics{Gauges: []MetricGauge{{
+				Name:   "test_inflight",
+				Help:   "help",
+				Labels: []string{"route"},
+			}}}
+		)
+
+		require.NoError(t, m.register(reg))
+
+		gauge, ok := m.GaugeByName("test_inflight")
+		require.True(t, ok)
+
+		t.Log("Checking that an identical gauge reuses the existing one")
+		m2 := &Metrics{Gauges: m.Gauges}
+		require.NoError(t, m2.register(reg))
+		gauge2, ok := m2.GaugeByName("test_inflight")
+		require.True(t, ok)
+		assert.Same(t, gauge, gauge2)
+
+		t.Log("Checking that a gauge can't share a name with a counter")
+		assert.Error(t, (&Metrics{
+			Counters: []MetricCounter{{Name: "test_metric"}},
+			Gauges:   []MetricGauge{{Name: "test_metric"}},
+		}).register(prometheus.NewRegistry()))
+
+		t.Log("Checking that a counter can't reuse a gauge's registration")
+		assert.Error(t, (&Metrics{Counters: []MetricCounter{{
+			Name: "test_inflight", Help: "help", Labels: []string{"route"},
+		}}}).register(reg))
+	})
+
+	t.Run("native", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			reg = prometheus.NewRegistry()
+			m   = &Metrics{Histograms: []MetricHistogram{
+				{Name: "test_classic"},
+				{Name: "test_native", NativeFactor: 1.1, NativeMaxBuckets: 10},
+				{
+					Name:         "test_both",
+					Buckets:      []float64{1, 2},
+					NativeFactor: 1.1,
+				},
+			}}
+		)
+
+		require.NoError(t, m.register(reg))
+
+		for _, name := range []string{"test_classic", "test_native", "test_both"} {
+			h, ok := m.HistogramByName(name)
+			require.True(t, ok)
+			h.WithLabelValues().Observe(1.5)
+		}
+
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+
+		histograms := map[string]*dto.Histogram{}
+		for _, mf := range mfs {
+			histograms[mf.GetName()] = mf.GetMetric()[0].GetHistogram()
+		}
+
+		t.Log("Checking that classic buckets still work when native is unset")
+		classic := histograms["test_classic"]
+		assert.Len(t, classic.GetBucket(), len(prometheus.DefBuckets))
+		assert.Nil(t, classic.Schema)
+
+		t.Log("Checking that native options are applied")
+		native := histograms["test_native"]
+		assert.Empty(t, native.GetBucket())
+		assert.Equal(t, int32(3), native.GetSchema()) // 2^(2^-3) ~= 1.09
+		assert.NotEmpty(t, native.GetPositiveSpan())
+
+		t.Log("Checking that explicit buckets are kept alongside native ones")
+		both := histograms["test_both"]
+		assert.Len(t, both.GetBucket(), 2)
+		assert.Equal(t, int32(3), both.GetSchema())
+
+		t.Log("Checking that native histograms are described without buckets")
+		assert.Equal(t, MetricDescription{
+			Name:         "test_native",
+			Type:         "histogram",
+			Labels:       []string{},
+			NativeFactor: 1.1,
+		}, m.Descriptions()[2])
+
+		t.Log("Checking that invalid native options are rejected")
+		for _, mh := range []MetricHistogram{
+			{Name: "test_invalid", NativeFactor: 1},
+			{Name: "test_invalid", NativeFactor: -2},
+			{Name: "test_invalid", NativeMaxBuckets: 10},
+		} {
+			assert.Error(t, (&Metrics{
+				Histograms: []MetricHistogram{mh},
+			}).register(prometheus.NewRegistry()))
+		}
+	})
+
+	t.Run("descriptions", func(t *testing.T) {
+		t.Parallel()
+
+		m := &Metrics{Histograms: []MetricHistogram{
+			{Name: "b_histogram", Help: "help", Labels: []string{"z", "a"}},
+			{Name: "a_histogram", Buckets: []float64{1, 2}},
+		}}
+		require.NoError(t, m.register(prometheus.NewRegistry()))
+
+		m.Describe(MetricDescription{
+			Name:   "c_total",
+			Type:   "counter",
+			Labels: []string{"handler"},
+		})
+
+		assert.Equal(t, []MetricDescription{
+			{
+				Name:    "a_histogram",
+				Type:    "histogram",
+				Labels:  []string{},
+				Buckets: []float64{1, 2},
+			},
+			{
+				Name:    "b_histogram",
+				Type:    "histogram",
+				Help:    "help",
+				Labels:  []string{"a", "z"},
+				Buckets: prometheus.DefBuckets,
+			},
+			{
+				Name:   "c_total",
+				Type:   "counter",
+				Labels: []string{"handler"},
+			},
+		}, m.Descriptions())
+	})
+}
+
+func TestMetricsUnmarshalCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	d := caddyfile.NewTestDispenser(`
+		metrics {
+			histogram test_seconds {
+				buckets 1 2
+			}
+			histogram test_native_seconds {
+				native_factor 1.1
+				native_max_buckets 100
+			}
+			counter test_errors_total {
+				help "Number of errors"
+				labels vhost status
+			}
+			counter test_requests_total
+			gauge test_inflight {
+				labels route
+			}
+		}
+	`)
+
+	var m Metrics
+	require.NoError(t, m.UnmarshalCaddyfile(d))
+	assert.Equal(t, Metrics{
+		Histograms: []MetricHistogram{
+			{Name: "test_seconds", Buckets: []float64{1, 2}},
+			{
+				Name:             "test_native_seconds",
+				NativeFactor:     1.1,
+				NativeMaxBuckets: 100,
+			},
+		},
+		Counters: []MetricCounter{
+			{
+				Name:   "test_errors_total",
+				Help:   "Number of errors",
+				Labels: []string{"vhost", "status"},
+			},
+			{Name: "test_requests_total"},
+		},
+		Gauges: []MetricGauge{
+			{Name: "test_inflight", Labels: []string{"route"}},
+		},
+	}, m)
+
+	t.Log("Checking that invalid native options are rejected")
+	for _, config := range []string{
+		`metrics {
+			histogram test_seconds {
+				native_factor big
+			}
+		}`,
+		`metrics {
+			histogram test_seconds {
+				native_max_buckets -1
+			}
+		}`,
+	} {
+		var m Metrics
+		assert.Error(t, m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(config)))
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(FeedsOPML{})
+	httpcaddyfile.RegisterHandlerDirective("feeds_opml", feedsOPMLParseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder(
+		"feeds_opml", httpcaddyfile.Before, "file_server",
+	)
+}
+
+// FeedsOPMLFeed describes a single feed listed by FeedsOPML.
+type FeedsOPMLFeed struct {
+	// Required. The URL of the feed itself.
+	URL string `json:"url"`
+
+	// Optional title of the feed. Defaults to the URL.
+	Title string `json:"title,omitempty"`
+}
+
+// FeedsOPML is an HTTP handler module which responds with an [OPML] document
+// listing a set of feeds, such as those produced by `gemlog_to_feed`, so that
+// they can be subscribed to all at once by feed readers.
+//
+// [OPML]: http://opml.org/spec2.opml
+type FeedsOPML struct {
+	// Optional title of the OPML document.
+	Title string `json:"title,omitempty"`
+
+	// The feeds to be listed in the document.
+	Feeds []FeedsOPMLFeed `json:"feeds,omitempty"`
+
+	body []byte
+}
+
+var _ caddyhttp.MiddlewareHandler = (*FeedsOPML)(nil)
+
+func (FeedsOPML) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.feeds_opml",
+		New: func() caddy.Module { return new(FeedsOPML) },
+	}
+}
+
+type opmlOutline struct {
+	Type   string `xml:"type,attr"`
+	Text   string `xml:"text,attr"`
+	Title  string `xml:"title,attr"`
+	XMLURL string `xml:"xmlUrl,attr"`
+}
+
+type opmlDocument struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    struct {
+		Title string `xml:"title,omitempty"`
+	} `xml:"head"`
+	Body struct {
+		Outlines []opmlOutline `xml:"outline"`
+	} `xml:"body"`
+}
+
+func (f *FeedsOPML) Provision(ctx caddy.Context) error {
+	doc := opmlDocument{Version: "2.0"}
+	doc.Head.Title = f.Title
+
+	for _, feed := range f.Feeds {
+		title := feed.Title
+		if title == "" {
+			title = feed.URL
+		}
+
+		doc.Body.Outlines = append(doc.Body.Outlines, opmlOutline{
+			Type:   "rss",
+			Text:   title,
+			Title:  title,
+			XMLURL: feed.URL,
+		})
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling OPML document: %w", err)
+	}
+
+	f.body = append([]byte(xml.Header), body...)
+	return nil
+}
+
+// Validate ensures f has a valid configuration.
+func (f *FeedsOPML) Validate() error {
+	if len(f.Feeds) == 0 {
+		return errors.New("at least one feed is required")
+	}
+
+	for _, feed := range f.Feeds {
+		if _, err := url.Parse(feed.URL); feed.URL == "" || err != nil {
+			return fmt.Errorf("invalid feed URL %q", feed.URL)
+		}
+	}
+
+	return nil
+}
+
+func (f *FeedsOPML) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, _ caddyhttp.Handler,
+) error {
+	rw.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	rw.Header().Set("Content-Length", strconv.Itoa(len(f.body)))
+	if r.Method != http.MethodHead {
+		_, _ = rw.Write(f.body)
+	}
+	return nil
+}
+
+// feedsOPMLParseCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	feeds_opml [<matcher>] {
+//		title <title>
+//		feed <url> [<title>] # repeatable
+//	}
+func feedsOPMLParseCaddyfile(
+	h httpcaddyfile.Helper,
+) (
+	caddyhttp.MiddlewareHandler, error,
+) {
+	h.Next() // consume directive name
+	f := new(FeedsOPML)
+	for h.NextBlock(0) {
+		switch h.Val() {
+		case "title":
+			if !h.Args(&f.Title) {
+				return nil, h.ArgErr()
+			}
+		case "feed":
+			args := h.RemainingArgs()
+			if len(args) < 1 || len(args) > 2 {
+				return nil, h.ArgErr()
+			}
+
+			feed := FeedsOPMLFeed{URL: args[0]}
+			if len(args) == 2 {
+				feed.Title = args[1]
+			}
+
+			f.Feeds = append(f.Feeds, feed)
+		default:
+			return nil, fmt.Errorf("unknown field: %q", h.Val())
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedsOPML(t *testing.T) {
+	t.Parallel()
+
+	f := &FeedsOPML{
+		Title: "My <Gemlogs>",
+		Feeds: []FeedsOPMLFeed{
+			{URL: "https://example.com/a/feed.xml", Title: "A & B"},
+			{URL: "https://example.com/c/feed.xml"},
+		},
+	}
+	require.NoError(t, f.Validate())
+	require.NoError(t, f.Provision(caddy.Context{}))
+
+	var (
+		rw = httptest.NewRecorder()
+		r  = httptest.NewRequest("GET", "/feeds.opml", nil)
+	)
+
+	require.NoError(t, f.ServeHTTP(rw, r, nil))
+	assert.Equal(t, "text/x-opml; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+  <head>
+    <title>My &lt;Gemlogs&gt;</title>
+  </head>
+  <body>
+    <outline type="rss" text="A &amp; B" title="A &amp; B" xmlUrl="https://example.com/a/feed.xml"></outline>
+    <outline type="rss" text="https://example.com/c/feed.xml" title="https://example.com/c/feed.xml" xmlUrl="https://example.com/c/feed.xml"></outline>
+  </body>
+</opml>`, rw.Body.String())
+
+	t.Run("validate", func(t *testing.T) {
+		assert.Error(t, (&FeedsOPML{}).Validate())
+		assert.Error(t, (&FeedsOPML{Feeds: []FeedsOPMLFeed{{}}}).Validate())
+	})
+}
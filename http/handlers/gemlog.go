@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// defaultGemlogFeedSuffixes are the FeedSuffixes used by Gemlog when none are
+// configured.
+var defaultGemlogFeedSuffixes = []string{"feed.xml"}
+
+func init() {
+	caddy.RegisterModule(Gemlog{})
+	httpcaddyfile.RegisterHandlerDirective("gemlog", gemlogParseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder(
+		"gemlog", httpcaddyfile.Before, "templates",
+	)
+}
+
+// Gemlog is an HTTP middleware module which serves a [gemlog] as either an
+// HTML page or as a feed, depending on the request path, from a single
+// gemtext source document.
+//
+// Requests whose path ends in one of the FeedSuffixes have that suffix
+// stripped from their path, and are handled by a GemlogToFeed handler. All
+// other requests are handled by a Gemtext handler. In both cases the
+// response from the rest of the handler chain is expected to be the gemlog
+// document itself.
+//
+// [gemlog]: https://geminiprotocol.net/docs/companion/subscription.gmi
+type Gemlog struct {
+
+	// Configuration of the Gemtext handler used to render pages as HTML.
+	HTML *Gemtext `json:"html"`
+
+	// Configuration of the GemlogToFeed handler used to render feeds.
+	Feed *GemlogToFeed `json:"feed"`
+
+	// Path suffixes which, when requested, cause the feed to be served rather
+	// than the HTML page. The suffix is stripped from the request path prior
+	// to the rest of the handler chain being invoked, so e.g. a request for
+	// `/posts/feed.xml` will cause the document at `/posts/` to be translated
+	// into a feed. If multiple suffixes match then the longest is used.
+	//
+	// Defaults to `feed.xml`.
+	FeedSuffixes []string `json:"feed_suffixes,omitempty"`
+}
+
+var (
+	_ caddyhttp.MiddlewareHandler = (*Gemlog)(nil)
+	_ caddy.CleanerUpper          = (*Gemlog)(nil)
+)
+
+func (Gemlog) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gemlog",
+		New: func() caddy.Module { return new(Gemlog) },
+	}
+}
+
+func (g *Gemlog) Provision(ctx caddy.Context) error {
+	if len(g.FeedSuffixes) == 0 {
+		g.FeedSuffixes = defaultGemlogFeedSuffixes
+	}
+
+	if g.HTML != nil {
+		if err := g.HTML.Provision(ctx); err != nil {
+			return fmt.Errorf("provisioning html: %w", err)
+		}
+	}
+
+	if g.Feed != nil {
+		if err := g.Feed.Provision(ctx); err != nil {
+			return fmt.Errorf("provisioning feed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (g *Gemlog) Validate() error {
+	if g.HTML == nil {
+		return errors.New("html is required")
+	} else if err := g.HTML.Validate(); err != nil {
+		return fmt.Errorf("validating html: %w", err)
+	}
+
+	if g.Feed == nil {
+		return errors.New("feed is required")
+	} else if err := g.Feed.Validate(); err != nil {
+		return fmt.Errorf("validating feed: %w", err)
+	}
+
+	for _, suffix := range g.FeedSuffixes {
+		if suffix == "" {
+			return errors.New("feed suffixes cannot be empty")
+		}
+	}
+
+	return nil
+}
+
+func (g *Gemlog) Cleanup() error {
+	if g.Feed != nil {
+		return g.Feed.Cleanup()
+	}
+	return nil
+}
+
+// feedSuffix returns the longest of the FeedSuffixes which the path ends in,
+// if any.
+func (g *Gemlog) feedSuffix(path string) (string, bool) {
+	var bestSuffix string
+	for _, suffix := range g.FeedSuffixes {
+		if strings.HasSuffix(path, suffix) && len(suffix) > len(bestSuffix) {
+			bestSuffix = suffix
+		}
+	}
+	return bestSuffix, bestSuffix != ""
+}
+
+func (g *Gemlog) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	suffix, ok := g.feedSuffix(r.URL.Path)
+	if !ok {
+		return g.HTML.ServeHTTP(rw, r, next)
+	}
+
+	r = r.Clone(r.Context())
+	r.URL.Path = strings.TrimSuffix(r.URL.Path, suffix)
+	r.URL.RawPath = ""
+
+	return g.Feed.ServeHTTP(rw, r, next)
+}
+
+// gemlogParseCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	gemlog [<matcher>] {
+//		feed_suffix <suffix> [<suffix>...]
+//
+//		# The html block takes the same form as the gemtext directive.
+//		html {
+//			...
+//		}
+//
+//		# The feed block takes the same form as the gemlog_to_feed
+//		# directive.
+//		feed {
+//			...
+//		}
+//	}
+func gemlogParseCaddyfile(
+	h httpcaddyfile.Helper,
+) (
+	caddyhttp.MiddlewareHandler, error,
+) {
+	h.Next() // consume directive name
+	g := new(Gemlog)
+	for h.NextBlock(0) {
+		switch h.Val() {
+		case "feed_suffix":
+			suffixes := h.RemainingArgs()
+			if len(suffixes) == 0 {
+				return nil, h.ArgErr()
+			}
+			g.FeedSuffixes = append(g.FeedSuffixes, suffixes...)
+		case "html":
+			segment := h
+			segment.Dispenser = h.NewFromNextSegment()
+
+			handler, err := gemtextParseCaddyfile(segment)
+			if err != nil {
+				return nil, fmt.Errorf("parsing html: %w", err)
+			}
+			g.HTML = handler.(*Gemtext)
+		case "feed":
+			segment := h
+			segment.Dispenser = h.NewFromNextSegment()
+
+			handler, err := gemlogToFeedParseCaddyfile(segment)
+			if err != nil {
+				return nil, fmt.Errorf("parsing feed: %w", err)
+			}
+			g.Feed = handler.(*GemlogToFeed)
+		default:
+			return nil, fmt.Errorf("unknown field: %q", h.Val())
+		}
+	}
+	return g, nil
+}
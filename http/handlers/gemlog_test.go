@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGemlog(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "render.html"),
+		[]byte(`<title>{{ .Title }}</title>{{ .Body }}`),
+		0644,
+	))
+
+	g := Gemlog{
+		HTML: &Gemtext{
+			FileRoot:       dir,
+			TemplatePath:   "render.html",
+			NoRegisterMIME: true,
+		},
+		Feed: &GemlogToFeed{
+			Format:  feedFormatRSS,
+			BaseURL: "https://example.com/posts/",
+		},
+		FeedSuffixes: []string{"feed.xml", "feed.atom.xml"},
+	}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path != "/posts/" {
+			return caddyhttp.Error(http.StatusNotFound, nil)
+		}
+
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(
+			rw, "# My Gemlog\n=> /posts/first.gmi 2024-01-02 - First Post\n",
+		)
+		return nil
+	})
+
+	tests := []struct {
+		name string
+		path string
+
+		// Content-Type is left unset for HTML, so that Caddy can detect it.
+		expType      string
+		expInBody    string
+		expErrStatus int
+	}{
+		{
+			name:      "html",
+			path:      "/posts/",
+			expInBody: "<title>My Gemlog</title>",
+		},
+		{
+			name:      "feed",
+			path:      "/posts/feed.xml",
+			expType:   "application/rss+xml",
+			expInBody: "<title>First Post</title>",
+		},
+		{
+			name:      "longest suffix",
+			path:      "/posts/feed.atom.xml",
+			expType:   "application/rss+xml",
+			expInBody: "<title>First Post</title>",
+		},
+		{
+			name:         "not found",
+			path:         "/other/feed.xml",
+			expErrStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				rw = httptest.NewRecorder()
+				r  = httptest.NewRequest("GET", test.path, nil)
+			)
+
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			))
+
+			err := g.ServeHTTP(rw, r, next)
+			if test.expErrStatus != 0 {
+				var hErr caddyhttp.HandlerError
+				require.ErrorAs(t, err, &hErr)
+				assert.Equal(t, test.expErrStatus, hErr.StatusCode)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.path, r.URL.Path, "request was modified")
+			assert.Equal(t, test.expType, rw.Header().Get("Content-Type"))
+			assert.Contains(t, rw.Body.String(), test.expInBody)
+		})
+	}
+}
+
+func TestGemlogParseCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		gemlog {
+			feed_suffix feed.xml feed.json
+			html {
+				template render.html
+				tables on
+			}
+			feed {
+				format rss
+				author_name "Tester"
+			}
+		}
+	`)}
+
+	handler, err := gemlogParseCaddyfile(h)
+	require.NoError(t, err)
+
+	g := handler.(*Gemlog)
+	assert.Equal(t, []string{"feed.xml", "feed.json"}, g.FeedSuffixes)
+	assert.Equal(t, &Gemtext{TemplatePath: "render.html", Tables: true}, g.HTML)
+	assert.Equal(
+		t, &GemlogToFeed{Format: feedFormatRSS, AuthorName: "Tester"}, g.Feed,
+	)
+}
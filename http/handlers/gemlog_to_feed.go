@@ -1,17 +1,29 @@
 package handlers
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/gemtext"
 	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/toolkit"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/gorilla/feeds"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 )
 
 const (
@@ -20,6 +32,70 @@ const (
 	feedFormatJSON = "json"
 )
 
+// feedFormatsByMIME maps the MIME types of feeds to their formats, for use when
+// negotiating the format based on the Accept header.
+var feedFormatsByMIME = map[string]string{
+	"application/rss+xml":   feedFormatRSS,
+	"application/atom+xml":  feedFormatAtom,
+	"application/feed+json": feedFormatJSON,
+	"application/json":      feedFormatJSON,
+}
+
+// negotiateFeedFormat returns the feed format most preferred by the given
+// Accept header value, or the fallback if the header doesn't indicate any
+// preference for a supported format.
+func negotiateFeedFormat(accept, fallback string) string {
+	var (
+		format = fallback
+		bestQ  float64
+	)
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+
+		f, ok := feedFormatsByMIME[mediaType]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil {
+				continue
+			}
+		}
+
+		if q > bestQ {
+			format, bestQ = f, q
+		}
+	}
+
+	return format
+}
+
+// feedFormatContentTypes maps each feed format to the Content-Type it is served
+// with.
+var feedFormatContentTypes = map[string]string{
+	feedFormatRSS:  "application/rss+xml",
+	feedFormatAtom: "application/atom+xml",
+	feedFormatJSON: "application/feed+json",
+}
+
+// defaultFeedFormatSuffixes are the FormatSuffixes used by GemlogToFeed when
+// none are configured.
+var defaultFeedFormatSuffixes = map[string]string{
+	".xml":  feedFormatRSS,
+	".atom": feedFormatAtom,
+	".json": feedFormatJSON,
+}
+
+// gemlogToFeedBufInitialCap is the initial capacity of the buffers used by
+// GemlogToFeed, which only need to hold the gemlog index document.
+const gemlogToFeedBufInitialCap = 16 * 1024
+
 func init() {
 	caddy.RegisterModule(GemlogToFeed{})
 	httpcaddyfile.RegisterHandlerDirective("gemlog_to_feed", gemlogToFeedParseCaddyfile)
@@ -46,14 +122,157 @@ type GemlogToFeed struct {
 	// Optional email to provide in the output feed under author metadata.
 	AuthorEmail string `json:"author_email"`
 
+	// Optional description of the feed, used as the `description` of RSS and
+	// JSON feeds and the `subtitle` of Atom feeds.
+	Description string `json:"description,omitempty"`
+
+	// Optional URLs of WebSub hubs which the feed is published to, which will
+	// be advertised in the feed, along with a link to the feed itself.
+	Hubs []string `json:"hubs,omitempty"`
+
+	// Optional URL of a small icon representing the feed, relative to the base
+	// URL. Used as the `icon` of Atom feeds and the `favicon` of JSON feeds.
+	Icon string `json:"icon,omitempty"`
+
+	// Optional URL of a larger logo representing the feed, relative to the base
+	// URL. Used as the `logo` of Atom feeds, the `icon` of JSON feeds, and the
+	// `image` of RSS feeds. If not given then RSS feeds use Icon instead.
+	Logo string `json:"logo,omitempty"`
+
 	// Optional URL in format `[scheme://host[:port]]/path` to use as the
 	// absolute URL all links in the feed will be relative to. If not given then
 	// it will be inferred from the request.
 	BaseURL string `json:"base_url"`
 	baseURL *url.URL
+
+	// If true then any quoted (`>` prefix) or indented lines which directly
+	// follow an entry's link line will be used as the summary of that entry.
+	ParseSummary bool `json:"parse_summary,omitempty"`
+
+	// If true then the format of the feed will be chosen based on the Accept
+	// header of the request, falling back to Format if the header doesn't
+	// indicate a preference for any supported format.
+	Negotiate bool `json:"negotiate,omitempty"`
+
+	// If true then the format of the feed will be chosen based on the suffix of
+	// the original request path, using FormatSuffixes. If the path doesn't
+	// match any suffix then the format is chosen as it otherwise would be.
+	MultiFormat bool `json:"multi_format,omitempty"`
+
+	// Mapping of path suffixes to the feed format which should be served for
+	// paths having that suffix, used when MultiFormat is true. If multiple
+	// suffixes match then the longest is used.
+	//
+	// Defaults to `.xml` for `rss`, `.atom` for `atom`, and `.json` for
+	// `json`.
+	FormatSuffixes map[string]string `json:"format_suffixes,omitempty"`
+
+	// Determines whether each entry's section of the gemlog is included as the
+	// content of the entry. An entry's section is its link line, plus all
+	// lines following it up until the next link line. Can be one of `gemtext`,
+	// to include the raw gemtext wrapped in a `<pre>` tag, or `html`, to
+	// include the gemtext translated to HTML. Default is to not include any
+	// content.
+	EntryContent string `json:"entry_content,omitempty"`
+
+	// The maximum number of bytes of gemtext which will be included from an
+	// entry's section, when EntryContent is set. Sections are truncated to the
+	// last full line which fits. Defaults to 4096.
+	MaxEntryContentSize int `json:"max_entry_content_size,omitempty"`
+
+	// If true then, for each entry which links to a local gemtext document
+	// (i.e. its link is relative and ends in `.gmi`), the document is read
+	// from within FileRoot, translated to HTML, and included as the content of
+	// the entry. Entries whose document doesn't exist have no content. Cannot
+	// be used with EntryContent.
+	IncludeContent bool `json:"include_content,omitempty"`
+
+	// The root path from which to read documents when IncludeContent is set.
+	// The path of each entry's link, resolved against the base URL, is used as
+	// the path within the root. Default is `{http.vars.root}` if set, or the
+	// current working directory otherwise. Placeholders which depend on the
+	// request are not available when feeds are regenerated in the background.
+	FileRoot string `json:"file_root,omitempty"`
+
+	// Name of a histogram defined as part of the
+	// `mediocre_caddy_plugins.metrics` global configuration, into which the
+	// time taken to translate each document will be observed. The histogram
+	// must have a single label, `handler`, which will be set to `gemlog_to_feed`.
+	TranslationMetric string `json:"translation_metric,omitempty"`
+
+	// If true then entries whose date is after the current day will be
+	// excluded from the feed, e.g. those which have been scheduled for future
+	// publication.
+	ExcludeFuture bool `json:"exclude_future,omitempty"`
+
+	// Additional layouts, in the format used by Go's `time.Parse`, which the
+	// date stamp at the beginning of an entry's label may have. They are tried
+	// in order, followed by `2006-01-02`. If a layout includes a time of day
+	// then it is used as the entry's updated time, otherwise noon UTC on the
+	// given day is used.
+	DateFormats []string `json:"date_formats,omitempty"`
+
+	// The order of the entries in the feed. Can be one of `appearance` (the
+	// order the entries appear in the gemlog), `date_asc`, `date_desc`, or
+	// `title`. Defaults to `appearance`.
+	Order string `json:"order,omitempty"`
+
+	// If greater than zero then the feed will contain at most this many
+	// entries. The most recently dated entries are kept, and are then ordered
+	// according to Order. Defaults to no limit.
+	MaxItems int `json:"max_items,omitempty"`
+
+	// If true then parsing of the gemlog will stop once MaxItems entries have
+	// been found, rather than the whole gemlog being read. This assumes that
+	// the gemlog lists its entries newest-first, as is conventional.
+	EarlyStop bool `json:"early_stop,omitempty"`
+
+	// If given then feeds will be regenerated in the background on this
+	// interval from the gemlog file at SourcePath, and requests will be served
+	// from the most recently generated feeds without the rest of the handler
+	// chain being invoked. If not given then feeds are generated on-demand
+	// for each request.
+	//
+	// BaseURL must be given in conjunction with this.
+	RegenerateInterval time.Duration `json:"regenerate_interval,omitempty"`
+
+	// Path to the gemlog file on disk from which feeds are regenerated, when
+	// RegenerateInterval is given.
+	SourcePath string `json:"source_path,omitempty"`
+
+	// Additional top-level links to include in the feed, e.g. to the HTML or
+	// gemini versions of the gemlog. These are only included in Atom feeds,
+	// as RSS and JSON feeds only support a single top-level link.
+	AlternateLinks []GemlogToFeedLink `json:"alternate_links,omitempty"`
+
+	translationObserver prometheus.Observer
+	bufPool             *toolkit.BufferPool
+	logger              *zap.Logger
+
+	// feeds holds the most recently regenerated feed of each format, when
+	// RegenerateInterval is given. It will be nil if regeneration has not yet
+	// succeeded, in which case feeds are generated on-demand.
+	feeds   *atomic.Pointer[map[string][]byte]
+	closeCh chan struct{}
+	doneCh  chan struct{}
+}
+
+// GemlogToFeedLink describes a top-level link to be included in a feed.
+type GemlogToFeedLink struct {
+	// Required. The URL being linked to.
+	Href string `json:"href"`
+
+	// The relationship of the URL to the feed. Defaults to `alternate`.
+	Rel string `json:"rel,omitempty"`
+
+	// Optional MIME type of the document at the URL.
+	Type string `json:"type,omitempty"`
 }
 
-var _ caddyhttp.MiddlewareHandler = (*GemlogToFeed)(nil)
+var (
+	_ caddyhttp.MiddlewareHandler = (*GemlogToFeed)(nil)
+	_ caddy.CleanerUpper          = (*GemlogToFeed)(nil)
+)
 
 func (GemlogToFeed) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -63,6 +282,17 @@ func (GemlogToFeed) CaddyModule() caddy.ModuleInfo {
 }
 
 func (g *GemlogToFeed) Provision(ctx caddy.Context) error {
+	g.bufPool = toolkit.NewBufferPool(gemlogToFeedBufInitialCap)
+	g.logger = ctx.Logger()
+	g.feeds = new(atomic.Pointer[map[string][]byte])
+
+	var err error
+	if g.translationObserver, err = translationObserver(
+		ctx, g.TranslationMetric, "gemlog_to_feed",
+	); err != nil {
+		return fmt.Errorf("setting up translation metric: %w", err)
+	}
+
 	g.Format = strings.ToLower(g.Format)
 	switch g.Format {
 	case feedFormatRSS, feedFormatAtom, feedFormatJSON:
@@ -70,16 +300,174 @@ func (g *GemlogToFeed) Provision(ctx caddy.Context) error {
 		g.Format = feedFormatAtom
 	}
 
+	for i := range g.AlternateLinks {
+		if g.AlternateLinks[i].Rel == "" {
+			g.AlternateLinks[i].Rel = "alternate"
+		}
+	}
+
+	if g.FileRoot == "" {
+		g.FileRoot = "{http.vars.root}"
+	}
+
+	if g.MultiFormat && len(g.FormatSuffixes) == 0 {
+		g.FormatSuffixes = defaultFeedFormatSuffixes
+	}
+
 	if g.BaseURL != "" {
-		var err error
 		if g.baseURL, err = url.Parse(g.BaseURL); err != nil {
 			return fmt.Errorf("Parsing BaseURL failed: %w", err)
 		}
 	}
 
+	if g.RegenerateInterval > 0 && g.baseURL != nil && g.SourcePath != "" {
+		// A failure here isn't fatal, as the source may not exist yet. Until
+		// regeneration succeeds feeds will be generated on-demand.
+		if err := g.regenerate(); err != nil {
+			g.logger.Error("Failed to generate feeds", zap.Error(err))
+		}
+
+		g.closeCh = make(chan struct{})
+		g.doneCh = make(chan struct{})
+		go g.spin(time.NewTicker(g.RegenerateInterval))
+	}
+
+	return nil
+}
+
+func (g *GemlogToFeed) Cleanup() error {
+	if g.closeCh != nil {
+		close(g.closeCh)
+		<-g.doneCh
+	}
+	return nil
+}
+
+func (g *GemlogToFeed) spin(ticker *time.Ticker) {
+	defer close(g.doneCh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := g.regenerate(); err != nil {
+				g.logger.Error("Failed to regenerate feeds", zap.Error(err))
+			}
+
+		case <-g.closeCh:
+			return
+		}
+	}
+}
+
+// regenerate generates a feed of each format from the gemlog file at
+// SourcePath, and stores them to be served by ServeHTTP.
+func (g *GemlogToFeed) regenerate() error {
+	src, err := os.ReadFile(g.SourcePath)
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", g.SourcePath, err)
+	}
+
+	rootDir := caddy.NewReplacer().ReplaceAll(g.FileRoot, ".")
+	translator := g.translator(g.baseURL, rootDir)
+
+	feeds := make(map[string][]byte, len(feedFormatContentTypes))
+	for format := range feedFormatContentTypes {
+		var (
+			buf            = new(bytes.Buffer)
+			translateStart = time.Now()
+		)
+
+		if err := feedTranslateFunc(translator, format)(
+			buf, bytes.NewReader(src),
+		); err != nil {
+			return fmt.Errorf("translating to %q: %w", format, err)
+		}
+
+		g.translationObserver.Observe(time.Since(translateStart).Seconds())
+		feeds[format] = buf.Bytes()
+	}
+
+	g.feeds.Store(&feeds)
 	return nil
 }
 
+// entryResolver returns a function, for use as FeedTranslator.ResolveEntry,
+// which opens documents from within the given root directory.
+func entryResolver(rootDir string) func(string) (io.ReadCloser, error) {
+	fsys := os.DirFS(rootDir)
+	return func(path string) (io.ReadCloser, error) {
+		name := strings.TrimPrefix(path, "/")
+		if !fs.ValidPath(name) {
+			// Paths which could escape the root are treated as not existing,
+			// so that the entry is skipped rather than the feed failing.
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+		}
+		return fsys.Open(name)
+	}
+}
+
+// translator returns the FeedTranslator used to generate feeds, with links
+// relative to the given URL. When IncludeContent is set, documents linked to by
+// entries are read from within rootDir.
+func (g *GemlogToFeed) translator(
+	baseURL *url.URL, rootDir string,
+) gemtext.FeedTranslator {
+	alternateLinks := make([]feeds.Link, len(g.AlternateLinks))
+	for i, link := range g.AlternateLinks {
+		alternateLinks[i] = feeds.Link{
+			Href: link.Href,
+			Rel:  link.Rel,
+			Type: link.Type,
+		}
+	}
+
+	var resolveEntry func(string) (io.ReadCloser, error)
+	if g.IncludeContent {
+		resolveEntry = entryResolver(rootDir)
+	}
+
+	return gemtext.FeedTranslator{
+		BaseURL:        baseURL,
+		AuthorName:     g.AuthorName,
+		AuthorEmail:    g.AuthorEmail,
+		Description:    g.Description,
+		SelfURL:        baseURL,
+		Hubs:           g.Hubs,
+		Icon:           g.Icon,
+		Logo:           g.Logo,
+		AlternateLinks: alternateLinks,
+		ParseSummary:   g.ParseSummary,
+
+		DateFormats:   g.DateFormats,
+		ExcludeFuture: g.ExcludeFuture,
+		Order:         g.Order,
+		MaxItems:      g.MaxItems,
+		EarlyStop:     g.EarlyStop,
+
+		EntryContent:        g.EntryContent,
+		MaxEntryContentSize: g.MaxEntryContentSize,
+		ResolveEntry:        resolveEntry,
+	}
+}
+
+// feedTranslateFunc returns the method of the FeedTranslator which translates
+// to the given feed format, or nil if the format is not valid.
+func feedTranslateFunc(
+	translator gemtext.FeedTranslator, format string,
+) func(io.Writer, io.Reader) error {
+	switch format {
+	case feedFormatRSS:
+		return translator.ToRSS
+	case feedFormatAtom:
+		return translator.ToAtom
+	case feedFormatJSON:
+		return translator.ToJSON
+	default:
+		return nil
+	}
+}
+
 func (g *GemlogToFeed) Validate() error {
 	switch strings.ToLower(g.Format) {
 	case feedFormatRSS, feedFormatAtom, feedFormatJSON, "":
@@ -87,13 +475,134 @@ func (g *GemlogToFeed) Validate() error {
 		return fmt.Errorf("invalid feed format %q", g.Format)
 	}
 
+	switch g.EntryContent {
+	case gemtext.FeedEntryContentNone,
+		gemtext.FeedEntryContentGemtext,
+		gemtext.FeedEntryContentHTML:
+	default:
+		return fmt.Errorf("invalid entry content %q", g.EntryContent)
+	}
+
+	switch g.Order {
+	case "",
+		gemtext.FeedOrderAppearance,
+		gemtext.FeedOrderDateAsc,
+		gemtext.FeedOrderDateDesc,
+		gemtext.FeedOrderTitle:
+	default:
+		return fmt.Errorf("invalid order %q", g.Order)
+	}
+
+	if g.IncludeContent && g.EntryContent != gemtext.FeedEntryContentNone {
+		return errors.New("include_content cannot be used with entry_content")
+	}
+
+	if g.MaxEntryContentSize < 0 {
+		return errors.New("max_entry_content_size cannot be negative")
+	}
+
+	for _, layout := range g.DateFormats {
+		if layout == "" {
+			return errors.New("date_format cannot be empty")
+		}
+	}
+
+	for _, hub := range g.Hubs {
+		if u, err := url.Parse(hub); err != nil {
+			return fmt.Errorf("parsing hub %q: %w", hub, err)
+		} else if !u.IsAbs() {
+			return fmt.Errorf("hub %q must be an absolute URL", hub)
+		}
+	}
+
+	for _, link := range g.AlternateLinks {
+		if link.Href == "" {
+			return errors.New("alternate links must have an href")
+		}
+	}
+
+	if g.MaxItems < 0 {
+		return errors.New("max_items cannot be negative")
+	} else if g.EarlyStop && g.MaxItems == 0 {
+		return errors.New("max_items is required when early_stop is enabled")
+	}
+
+	if g.RegenerateInterval < 0 {
+		return errors.New("regenerate_interval cannot be negative")
+	} else if g.RegenerateInterval > 0 && (g.BaseURL == "" || g.SourcePath == "") {
+		return errors.New(
+			"base_url and source_path are required when regenerate_interval is given",
+		)
+	}
+
+	for suffix, format := range g.FormatSuffixes {
+		switch format {
+		case feedFormatRSS, feedFormatAtom, feedFormatJSON:
+		default:
+			return fmt.Errorf(
+				"invalid feed format %q for suffix %q", format, suffix,
+			)
+		}
+	}
+
 	return nil
 }
 
+// formatForPath returns the feed format which the path's suffix maps to, if
+// any.
+func (g *GemlogToFeed) formatForPath(path string) (string, bool) {
+	var bestSuffix, format string
+	for suffix, f := range g.FormatSuffixes {
+		if strings.HasSuffix(path, suffix) && len(suffix) > len(bestSuffix) {
+			bestSuffix, format = suffix, f
+		}
+	}
+	return format, bestSuffix != ""
+}
+
+// requestFormat returns the feed format which should be served for the request.
+func (g *GemlogToFeed) requestFormat(
+	rw http.ResponseWriter, r *http.Request, repl *caddy.Replacer,
+) string {
+	format := g.Format
+	if g.Negotiate {
+		rw.Header().Add("Vary", "Accept")
+		format = negotiateFeedFormat(r.Header.Get("Accept"), format)
+	}
+
+	if g.MultiFormat {
+		origPath, _ := repl.GetString("http.request.orig_uri.path")
+		if f, ok := g.formatForPath(origPath); ok {
+			format = f
+		}
+	}
+
+	return format
+}
+
 func (g *GemlogToFeed) ServeHTTP(
 	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
 ) error {
-	buf, bufDone := toolkit.GetBuffer()
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+	if feeds := g.feeds.Load(); feeds != nil {
+		format := g.requestFormat(rw, r, repl)
+		feed, ok := (*feeds)[format]
+		if !ok {
+			return fmt.Errorf("invalid feed format %q", format)
+		}
+
+		rw.Header().Set("Content-Type", feedFormatContentTypes[format])
+		rw.Header().Set("Content-Length", strconv.Itoa(len(feed)))
+		rw.WriteHeader(http.StatusOK)
+
+		if r.Method != http.MethodHead {
+			_, _ = rw.Write(feed)
+		}
+		return nil
+	}
+
+	buf, bufDone := g.bufPool.Get()
 	defer bufDone()
 
 	shouldBuf := func(int, http.Header) bool { return true }
@@ -112,8 +621,8 @@ func (g *GemlogToFeed) ServeHTTP(
 	buf = rec.Buffer() // probably redundant, but just in case
 
 	var (
-		repl    = r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
 		baseURL = g.baseURL
+		selfURL = g.baseURL
 		err     error
 	)
 
@@ -134,30 +643,57 @@ func (g *GemlogToFeed) ServeHTTP(
 		if baseURL.Scheme == "" {
 			baseURL.Scheme, _ = repl.GetString("http.request.scheme")
 		}
+
+		selfURL = baseURL
+	} else if reqURIStr, ok := repl.GetString("http.request.orig_uri"); ok {
+		// The request's host may not be the public one, so only its path is
+		// used.
+		if reqURI, err := url.Parse(reqURIStr); err == nil {
+			selfURL = baseURL.ResolveReference(&url.URL{
+				Path:     reqURI.Path,
+				RawPath:  reqURI.RawPath,
+				RawQuery: reqURI.RawQuery,
+			})
+		}
 	}
 
-	translator := gemtext.FeedTranslator{
-		BaseURL:     baseURL,
-		AuthorName:  g.AuthorName,
-		AuthorEmail: g.AuthorEmail,
+	translator := g.translator(baseURL, repl.ReplaceAll(g.FileRoot, "."))
+	translator.SelfURL = selfURL
+
+	var (
+		format    = g.requestFormat(rw, r, repl)
+		translate = feedTranslateFunc(translator, format)
+	)
+
+	if translate == nil {
+		return fmt.Errorf("invalid feed format %q", format)
 	}
 
-	switch g.Format {
-	case feedFormatRSS:
-		rw.Header().Set("Content-Type", "application/rss+xml")
-		return translator.ToRSS(rw, buf)
+	rw.Header().Set("Content-Type", feedFormatContentTypes[format])
 
-	case feedFormatAtom:
-		rw.Header().Set("Content-Type", "application/atom+xml")
-		return translator.ToAtom(rw, buf)
+	// The body of a HEAD response is never sent, so there's no point in
+	// translating it. Content-Length is omitted, as it can't be known without
+	// translating.
+	if r.Method == http.MethodHead {
+		rw.WriteHeader(http.StatusOK)
+		return nil
+	}
 
-	case feedFormatJSON:
-		rw.Header().Set("Content-Type", "application/feed+json")
-		return translator.ToJSON(rw, buf)
+	_, span := startSpan(
+		r.Context(), "gemlog_to_feed.translate",
+		attribute.String("feed.format", format),
+		attribute.Int("gemtext.document_size", buf.Len()),
+	)
 
-	default:
-		return fmt.Errorf("invalid feed format %q", g.Format)
+	translateStart := time.Now()
+	err = translate(rw, buf)
+	endSpan(span, err)
+	if err != nil {
+		return err
 	}
+	g.translationObserver.Observe(time.Since(translateStart).Seconds())
+
+	return nil
 }
 
 // gemlogToFeedParseCaddyfile sets up the handler from Caddyfile tokens. Syntax:
@@ -166,6 +702,28 @@ func (g *GemlogToFeed) ServeHTTP(
 //		format <format>
 //		author_name <author name>
 //		author_email <author email>
+//		description <description>
+//		hub <url> # repeatable
+//		icon <url>
+//		logo <url>
+//		base_url <url>
+//		parse_summary on|off
+//		negotiate on|off
+//		multi_format on|off
+//		format_suffix <suffix> <format>
+//		entry_content gemtext|html
+//		max_entry_content_size <bytes>
+//		include_content on|off
+//		file_root <path>
+//		translation_metric <histogram name>
+//		exclude_future on|off
+//		date_format <layout> # repeatable
+//		order appearance|date_asc|date_desc|title
+//		max_items <n>
+//		early_stop on|off
+//		regenerate_interval <duration>
+//		source_path <path>
+//		alternate <href> [<rel> [<type>]] # repeatable
 //	}
 func gemlogToFeedParseCaddyfile(
 	h httpcaddyfile.Helper,
@@ -188,10 +746,135 @@ func gemlogToFeedParseCaddyfile(
 			if !h.Args(&g.AuthorEmail) {
 				return nil, h.ArgErr()
 			}
+		case "description":
+			if !h.Args(&g.Description) {
+				return nil, h.ArgErr()
+			}
+		case "hub":
+			var hub string
+			if !h.Args(&hub) {
+				return nil, h.ArgErr()
+			}
+			g.Hubs = append(g.Hubs, hub)
+		case "icon":
+			if !h.Args(&g.Icon) {
+				return nil, h.ArgErr()
+			}
+		case "logo":
+			if !h.Args(&g.Logo) {
+				return nil, h.ArgErr()
+			}
 		case "base_url":
 			if !h.Args(&g.BaseURL) {
 				return nil, h.ArgErr()
 			}
+		case "parse_summary":
+			var err error
+			if g.ParseSummary, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "order":
+			if !h.Args(&g.Order) {
+				return nil, h.ArgErr()
+			}
+		case "max_items":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.MaxItems, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+		case "early_stop":
+			var err error
+			if g.EarlyStop, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "regenerate_interval":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.RegenerateInterval, err = time.ParseDuration(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as interval: %w", h.Val(), err)
+			}
+		case "source_path":
+			if !h.Args(&g.SourcePath) {
+				return nil, h.ArgErr()
+			}
+		case "alternate":
+			args := h.RemainingArgs()
+			if len(args) == 0 || len(args) > 3 {
+				return nil, h.ArgErr()
+			}
+
+			var link GemlogToFeedLink
+			link.Href = args[0]
+			if len(args) > 1 {
+				link.Rel = args[1]
+			}
+			if len(args) > 2 {
+				link.Type = args[2]
+			}
+			g.AlternateLinks = append(g.AlternateLinks, link)
+		case "exclude_future":
+			var err error
+			if g.ExcludeFuture, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "date_format":
+			var layout string
+			if !h.Args(&layout) {
+				return nil, h.ArgErr()
+			}
+			g.DateFormats = append(g.DateFormats, layout)
+		case "negotiate":
+			var err error
+			if g.Negotiate, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "multi_format":
+			var err error
+			if g.MultiFormat, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "format_suffix":
+			var suffix, format string
+			if !h.Args(&suffix, &format) {
+				return nil, h.ArgErr()
+			}
+			if g.FormatSuffixes == nil {
+				g.FormatSuffixes = map[string]string{}
+			}
+			g.FormatSuffixes[suffix] = strings.ToLower(format)
+		case "translation_metric":
+			if !h.Args(&g.TranslationMetric) {
+				return nil, h.ArgErr()
+			}
+		case "entry_content":
+			if !h.Args(&g.EntryContent) {
+				return nil, h.ArgErr()
+			}
+		case "max_entry_content_size":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.MaxEntryContentSize, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+		case "include_content":
+			var err error
+			if g.IncludeContent, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "file_root":
+			if !h.Args(&g.FileRoot) {
+				return nil, h.ArgErr()
+			}
 		}
 	}
 	return g, nil
@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFeedFormat(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		accept string
+		exp    string
+	}{
+		{"", feedFormatAtom},
+		{"*/*", feedFormatAtom},
+		{"text/html", feedFormatAtom},
+		{"application/rss+xml", feedFormatRSS},
+		{"application/atom+xml", feedFormatAtom},
+		{"application/feed+json", feedFormatJSON},
+		{"application/json", feedFormatJSON},
+		{"text/html, application/rss+xml;q=0.9, */*;q=0.8", feedFormatRSS},
+		{"application/rss+xml;q=0.5, application/feed+json", feedFormatJSON},
+		{"application/rss+xml, application/feed+json", feedFormatRSS},
+		{"application/rss+xml;q=0", feedFormatAtom},
+		{"application/rss+xml;q=bogus", feedFormatAtom},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			assert.Equal(
+				t, test.exp, negotiateFeedFormat(test.accept, feedFormatAtom),
+			)
+		})
+	}
+}
+
+func TestGemlogToFeedFormatForPath(t *testing.T) {
+	t.Parallel()
+
+	g := GemlogToFeed{
+		FormatSuffixes: map[string]string{
+			".xml":      feedFormatRSS,
+			".atom.xml": feedFormatAtom,
+			".json":     feedFormatJSON,
+		},
+	}
+
+	tests := []struct {
+		path  string
+		exp   string
+		expOK bool
+	}{
+		{"/feed.xml", feedFormatRSS, true},
+		{"/feed.atom.xml", feedFormatAtom, true},
+		{"/feed.json", feedFormatJSON, true},
+		{"/feed.gmi", "", false},
+		{"/", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			format, ok := g.formatForPath(test.path)
+			assert.Equal(t, test.expOK, ok)
+			assert.Equal(t, test.exp, format)
+		})
+	}
+}
+
+func TestGemlogToFeedHEAD(t *testing.T) {
+	t.Parallel()
+
+	g := GemlogToFeed{Format: feedFormatRSS, BaseURL: "https://example.com/"}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	var (
+		rw   = httptest.NewRecorder()
+		r    = httptest.NewRequest("HEAD", "/feed.xml", nil)
+		next = caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			rw.Header().Set("Content-Type", gemtextMIME)
+			rw.Header().Set("Content-Length", "42")
+			rw.WriteHeader(http.StatusOK)
+			return nil
+		})
+	)
+
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	require.NoError(t, g.ServeHTTP(rw, r, next))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "application/rss+xml", rw.Header().Get("Content-Type"))
+	assert.NotContains(t, rw.Header(), "Content-Length")
+	assert.Empty(t, rw.Body.String())
+}
+
+func TestGemlogToFeedRegenerate(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dir        = t.TempDir()
+		sourcePath = filepath.Join(dir, "gemlog.gmi")
+	)
+
+	writeSource := func(t *testing.T, title string) {
+		require.NoError(t, os.WriteFile(
+			sourcePath,
+			[]byte("# My Gemlog\n=> /post.gmi 2024-01-02 - "+title+"\n"),
+			0644,
+		))
+	}
+
+	writeSource(t, "First Title")
+
+	g := GemlogToFeed{
+		Format:             feedFormatRSS,
+		BaseURL:            "https://example.com/",
+		RegenerateInterval: time.Hour,
+		SourcePath:         sourcePath,
+	}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+	t.Cleanup(func() { g.Cleanup() })
+
+	next := caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+		return errors.New("next handler should not be called")
+	})
+
+	serve := func(t *testing.T) *httptest.ResponseRecorder {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest("GET", "/feed.xml", nil)
+		)
+		r = r.WithContext(context.WithValue(
+			r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+		))
+		require.NoError(t, g.ServeHTTP(rw, r, next))
+		return rw
+	}
+
+	t.Log("Checking that the initially generated feed is served")
+	rw := serve(t)
+	assert.Equal(t, "application/rss+xml", rw.Header().Get("Content-Type"))
+	assert.Contains(t, rw.Body.String(), "First Title")
+
+	t.Log("Checking that the regenerated feed is served")
+	writeSource(t, "Second Title")
+	require.NoError(t, g.regenerate())
+	assert.Contains(t, serve(t).Body.String(), "Second Title")
+
+	t.Log("Checking that a failed regeneration leaves the previous feed in place")
+	require.NoError(t, os.Remove(sourcePath))
+	assert.Error(t, g.regenerate())
+	assert.Contains(t, serve(t).Body.String(), "Second Title")
+
+	t.Run("validate", func(t *testing.T) {
+		g := GemlogToFeed{RegenerateInterval: time.Hour}
+		assert.Error(t, g.Validate())
+
+		g.BaseURL, g.SourcePath = "https://example.com/", sourcePath
+		assert.NoError(t, g.Validate())
+	})
+}
+
+func TestGemlogToFeedAlternateLinks(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		gemlog_to_feed {
+			base_url https://example.com/gemlog/
+			alternate https://example.com/gemlog.html alternate text/html
+			alternate gemini://example.com/gemlog/
+		}
+	`)}
+
+	handler, err := gemlogToFeedParseCaddyfile(h)
+	require.NoError(t, err)
+
+	g := handler.(*GemlogToFeed)
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	t.Log("Checking that rel defaults to alternate")
+	assert.Equal(t, []GemlogToFeedLink{
+		{Href: "https://example.com/gemlog.html", Rel: "alternate", Type: "text/html"},
+		{Href: "gemini://example.com/gemlog/", Rel: "alternate"},
+	}, g.AlternateLinks)
+
+	t.Log("Checking that an href is required")
+	assert.Error(t, (&GemlogToFeed{
+		AlternateLinks: []GemlogToFeedLink{{Rel: "alternate"}},
+	}).Validate())
+}
+
+func TestGemlogToFeedDescriptionAndImages(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		gemlog_to_feed {
+			description "Thoughts on things"
+			icon /favicon.ico
+			logo /logo.png
+		}
+	`)}
+
+	handler, err := gemlogToFeedParseCaddyfile(h)
+	require.NoError(t, err)
+
+	g := handler.(*GemlogToFeed)
+	assert.Equal(t, "Thoughts on things", g.Description)
+	assert.Equal(t, "/favicon.ico", g.Icon)
+	assert.Equal(t, "/logo.png", g.Logo)
+
+	translator := g.translator(&url.URL{Scheme: "https", Host: "example.com"}, "")
+	assert.Equal(t, "Thoughts on things", translator.Description)
+	assert.Equal(t, "/favicon.ico", translator.Icon)
+	assert.Equal(t, "/logo.png", translator.Logo)
+}
+
+func TestGemlogToFeedDateFormats(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		gemlog_to_feed {
+			base_url https://example.com/gemlog/
+			date_format 2006/01/02
+			date_format "2006-01-02 15:04"
+		}
+	`)}
+
+	handler, err := gemlogToFeedParseCaddyfile(h)
+	require.NoError(t, err)
+
+	g := handler.(*GemlogToFeed)
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	assert.Equal(t, []string{"2006/01/02", "2006-01-02 15:04"}, g.DateFormats)
+
+	t.Log("Checking that an empty layout is invalid")
+	assert.Error(t, (&GemlogToFeed{DateFormats: []string{""}}).Validate())
+}
+
+func TestGemlogToFeedIncludeContent(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "gemlog"), 0755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "gemlog", "post.gmi"),
+		[]byte("# Local Post\nHello!\n"),
+		0644,
+	))
+
+	const doc = `# My Gemlog
+=> post.gmi 2024-01-02 - Local Post
+=> gemini://example.org/remote.gmi 2024-01-01 - Remote Post
+`
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		gemlog_to_feed {
+			format json
+			base_url https://example.com/gemlog/
+			include_content on
+		}
+	`)}
+
+	handler, err := gemlogToFeedParseCaddyfile(h)
+	require.NoError(t, err)
+
+	g := handler.(*GemlogToFeed)
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+	assert.True(t, g.IncludeContent)
+
+	var (
+		rw   = httptest.NewRecorder()
+		r    = httptest.NewRequest("GET", "/gemlog/feed.json", nil)
+		repl = caddy.NewReplacer()
+		next = caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			rw.Header().Set("Content-Type", gemtextMIME)
+			rw.WriteHeader(http.StatusOK)
+			_, _ = io.WriteString(rw, doc)
+			return nil
+		})
+	)
+
+	t.Log("Checking that file_root defaults to the root variable")
+	repl.Set("http.vars.root", dir)
+	r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+
+	require.NoError(t, g.ServeHTTP(rw, r, next))
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	var feed struct {
+		Items []struct {
+			Title       string `json:"title"`
+			ContentHTML string `json:"content_html"`
+		} `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(rw.Body.Bytes(), &feed))
+	require.Len(t, feed.Items, 2)
+
+	assert.Equal(t, "Local Post", feed.Items[0].Title)
+	assert.Equal(t, "<h1>Local Post</h1>\n<p>Hello!</p>\n", feed.Items[0].ContentHTML)
+
+	t.Log("Checking that remote links are skipped")
+	assert.Equal(t, "Remote Post", feed.Items[1].Title)
+	assert.Empty(t, feed.Items[1].ContentHTML)
+
+	t.Run("validate", func(t *testing.T) {
+		assert.Error(t, (&GemlogToFeed{
+			IncludeContent: true,
+			EntryContent:   "html",
+		}).Validate())
+	})
+}
+
+func TestGemlogToFeedHubs(t *testing.T) {
+	t.Parallel()
+
+	const doc = "# My Gemlog\n=> post.gmi 2024-01-02 - Post\n"
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(rw, doc)
+		return nil
+	})
+
+	tests := []struct {
+		name, baseURL, expSelf string
+	}{
+		{
+			name:    "base url",
+			baseURL: "https://example.com/gemlog/",
+			expSelf: "https://example.com/feeds/gemlog.atom?v=1",
+		},
+		{
+			name:    "inferred",
+			expSelf: "https://internal.example.com/feeds/gemlog.atom?v=1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := GemlogToFeed{
+				Format:  feedFormatAtom,
+				BaseURL: test.baseURL,
+				Hubs:    []string{"https://hub.example.com/"},
+			}
+			require.NoError(t, g.Provision(caddy.Context{}))
+			require.NoError(t, g.Validate())
+
+			var (
+				rw   = httptest.NewRecorder()
+				r    = httptest.NewRequest("GET", "/feeds/gemlog.atom?v=1", nil)
+				repl = caddy.NewReplacer()
+			)
+			r.Host = "internal.example.com"
+			repl.Set("http.request.orig_uri", "/feeds/gemlog.atom?v=1")
+			repl.Set("http.request.scheme", "https")
+			r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+
+			require.NoError(t, g.ServeHTTP(rw, r, next))
+			assert.Contains(t, rw.Body.String(),
+				`<link href="`+test.expSelf+`" rel="self" type="application/atom+xml"></link>`,
+			)
+			assert.Contains(t, rw.Body.String(),
+				`<link href="https://hub.example.com/" rel="hub"></link>`,
+			)
+		})
+	}
+
+	t.Run("caddyfile", func(t *testing.T) {
+		t.Parallel()
+
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+			gemlog_to_feed {
+				hub https://hub.example.com/
+				hub https://hub.example.org/
+			}
+		`)}
+
+		handler, err := gemlogToFeedParseCaddyfile(h)
+		require.NoError(t, err)
+		assert.Equal(t,
+			[]string{"https://hub.example.com/", "https://hub.example.org/"},
+			handler.(*GemlogToFeed).Hubs,
+		)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		t.Parallel()
+
+		t.Log("Checking that hubs must be absolute URLs")
+		assert.Error(t, (&GemlogToFeed{Hubs: []string{"/hub"}}).Validate())
+	})
+}
@@ -1,15 +1,23 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"io/fs"
 	"mime"
 	"net/http"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/gemtext"
 	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/toolkit"
@@ -17,7 +25,12 @@ import (
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp/templates"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 )
 
 // The implementation here is heavily based on the implementation of the
@@ -26,42 +39,90 @@ import (
 
 const gemtextMIME = "text/gemini"
 
+// gemtextBufInitialCap is the initial capacity of the buffers used by Gemtext,
+// which need to be able to hold both the gemtext document and the rendered HTML
+// page.
+const gemtextBufInitialCap = 64 * 1024
+
+// gemtextIncludeScheme is the URL scheme of link lines which will be replaced
+// with the contents of another file, when AllowIncludes is enabled.
+const gemtextIncludeScheme = "include:"
+
+// gemtextDefaultMaxIncludeDepth is the default value of
+// Gemtext.MaxIncludeDepth.
+const gemtextDefaultMaxIncludeDepth = 4
+
+// Values which Gemtext.Output may take.
+const (
+	gemtextOutputHTML = "html"
+	gemtextOutputJSON = "json"
+	gemtextOutputText = "text"
+)
+
+// gemtextTextContentType is the Content-Type of responses when Gemtext.Output
+// is `text`.
+const gemtextTextContentType = "text/plain; charset=utf-8"
+
 func init() {
 	caddy.RegisterModule(Gemtext{})
 	httpcaddyfile.RegisterHandlerDirective("gemtext", gemtextParseCaddyfile)
 	httpcaddyfile.RegisterDirectiveOrder(
 		"gemtext", httpcaddyfile.Before, "templates",
 	)
-
-	// Since this module relies on Content-Type, but text/gemtext is not a
-	// standard type, we add it if it's missing
-	if mime.TypeByExtension(".gmi") == "" {
-		mime.AddExtensionType(".gmi", gemtextMIME)
-	}
 }
 
 // Gemtext is an HTTP middleware module which will render gemtext documents as
 // HTML documents, using user-provided templates to do so.
 //
 // Only responses with a Content-Type of `text/gemini` will be modified by this
-// module.
+// module, unless MatchContentTypes is given.
 type Gemtext struct {
 
 	// Path to the template which will be used to render the HTML page, relative
-	// to the `file_root`.
+	// to the `file_root`. Required unless `output` is `json`.
 	//
 	// The template will be rendered with these extra data fields:
 	//
 	// ##### `.Title`
 	//
 	// The Title of the gemini document, determined based on the first primary
-	// header (single `#` prefix) found. This will be an empty string if no
-	// primary header is found.
+	// header (single `#` prefix) found, or the `title` field of the
+	// frontmatter if `frontmatter` is enabled. This will be an empty string if
+	// no primary header is found.
+	//
+	// ##### `.Description`
+	//
+	// The first text line following the Title, or the first text line of the
+	// document if it has no Title, HTML escaped. This is suitable for use as a
+	// `<meta name="description">` element, see `description_length`.
 	//
 	// ##### `.Body`
 	//
 	// A string containing all rendered HTML DOM elements.
 	//
+	// ##### `.Headings`
+	//
+	// All headings of the document, in order, which can be used to render a
+	// table of contents. Each has a `.Level` (1, 2, or 3), an HTML escaped
+	// `.Text`, and a `.Slug` which is unique within the document. The Slug
+	// matches the heading's `id` attribute if `heading_ids` is enabled.
+	//
+	// ##### `.DanglingFragments`
+	//
+	// The fragments of same-document links which don't match any heading, if
+	// `check_fragment_links` is enabled.
+	//
+	// ##### `.Meta`
+	//
+	// The fields of the document's YAML frontmatter, if `frontmatter` is
+	// enabled and the document has any, e.g. `{{ .Meta.date }}`. Values are
+	// not HTML escaped.
+	//
+	// ##### `.FeedURL`
+	//
+	// The value of `feed_url`, with placeholders expanded, or an empty string
+	// if not given. Can be used to render a `<link rel="alternate">` element.
+	//
 	TemplatePath string `json:"template"`
 
 	// Path to a template which will be used for rendering headings. If not
@@ -73,6 +134,12 @@ type Gemtext struct {
 	//
 	// Which level of heading is being rendered, 1, 2, or 3.
 	//
+	// ##### `.ID`
+	//
+	// A slug of the heading's text, unique within the document, which can be
+	// used as the heading's `id` attribute. This matches the heading's `.Slug`
+	// in `.Headings`.
+	//
 	// ##### `.Text`
 	//
 	// The text of the heading.
@@ -94,6 +161,23 @@ type Gemtext struct {
 	// this will be equivalent to `.URL`.
 	LinkTemplatePath string `json:"link_template"`
 
+	// Path to a template which will be used for rendering preformatted
+	// blocks. If not given then preformatted blocks will be rendered using a
+	// `pre` tag. This can be used to apply syntax highlighting to code.
+	//
+	// The template will be rendered with these extra data fields:
+	//
+	// ##### `.AltText`
+	//
+	// The alt text following the opening "```" of the block, e.g. the name of
+	// the language which the block contains. Empty if not given.
+	//
+	// ##### `.Content`
+	//
+	// The contents of the block, not HTML escaped. Each line is terminated by
+	// a newline.
+	CodeTemplatePath string `json:"code_template"`
+
 	// The root path from which to load files. Default is `{http.vars.root}` if
 	// set, or current working directory otherwise.
 	FileRoot string `json:"file_root,omitempty"`
@@ -102,7 +186,178 @@ type Gemtext struct {
 	// the opening and closing delimiters. Default: `["{{", "}}"]`
 	Delimiters []string `json:"delimiters,omitempty"`
 
-	logger *zap.Logger
+	// Since this module relies on Content-Type, but `text/gemini` is not a
+	// standard type, the `.gmi` extension will be registered as having that
+	// type if it doesn't already have one. Setting this to true disables that
+	// registration.
+	//
+	// Note that the registration is process-wide, so this option only has an
+	// effect if no other `gemtext` or `gemtext_to_markdown` handler performs
+	// the registration.
+	NoRegisterMIME bool `json:"no_register_mime,omitempty"`
+
+	// The Content-Types of responses which will be translated. A response's
+	// Content-Type matches if its media type is equal to one of these,
+	// ignoring case and any parameters, e.g. `; charset=utf-8`. Defaults to
+	// `text/gemini`.
+	MatchContentTypes []string `json:"match_content_types,omitempty"`
+
+	// If given then requests which have a query parameter of this name will
+	// have their gemtext documents passed through as-is, without translation.
+	// This can be useful for debugging.
+	RawQueryParam string `json:"raw_query_param,omitempty"`
+
+	// The URL schemes which links may have. Links whose URL has a scheme not in
+	// this list (e.g. `javascript:`) will be rendered as plain text, with only
+	// their label included. Relative URLs are always allowed.
+	//
+	// Defaults to `http`, `https`, `gemini`, and `mailto`.
+	AllowedLinkSchemes []string `json:"allowed_link_schemes,omitempty"`
+
+	// The label used for links which don't have one. Can be `url`, to use the
+	// link's full URL, or `host`, to use only the host of the URL (or the full
+	// URL if it has no host, e.g. it's relative). Defaults to `url`.
+	//
+	// This also applies to the `.Label` given to `link_template`.
+	EmptyLinkLabel string `json:"empty_link_label,omitempty"`
+
+	// Name of a histogram defined as part of the
+	// `mediocre_caddy_plugins.metrics` global configuration, into which the
+	// time taken to translate each document will be observed. The histogram
+	// must have a single label, `handler`, which will be set to `gemtext`.
+	TranslationMetric string `json:"translation_metric,omitempty"`
+
+	// Path to a template which will be used to render the HTML page, in place
+	// of `template`, when the gemtext document is effectively empty, i.e. it
+	// contains nothing but whitespace. The template will be rendered with the
+	// same data fields as `template`.
+	EmptyTemplatePath string `json:"empty_template,omitempty"`
+
+	// HTTP status code to respond with when the gemtext document is
+	// effectively empty, i.e. it contains nothing but whitespace. Error
+	// statuses (400 and above) are returned as errors, so that they can be
+	// handled by the server's error routes. Any other status is written with
+	// an empty body.
+	//
+	// Cannot be used in conjunction with `empty_template`.
+	EmptyStatus int `json:"empty_status,omitempty"`
+
+	// If true then link lines whose URL has the `include:` scheme, e.g.
+	//
+	//	=> include:/partials/header.gmi
+	//
+	// will be replaced with the contents of the referenced gemtext file prior
+	// to translation. Paths starting with `/` are relative to the `file_root`,
+	// otherwise they are relative to the directory of the including file.
+	// Paths may not contain `..` segments. Include lines within preformatted
+	// blocks are left as-is.
+	//
+	// Included files may themselves include other files, up to
+	// MaxIncludeDepth. Cycles are not allowed.
+	AllowIncludes bool `json:"allow_includes,omitempty"`
+
+	// The maximum depth to which included files may themselves include other
+	// files. A depth of 0 means that only the document being served may
+	// include files, 1 means that the files it includes may too, and so on.
+	// Defaults to 4.
+	MaxIncludeDepth *int `json:"max_include_depth,omitempty"`
+
+	// If true then a non-standard extension to gemtext is enabled, wherein
+	// consecutive lines starting with a pipe, e.g. `| a | b |`, are rendered
+	// as an HTML table. If the first row is followed by a separator row, e.g.
+	// `|---|---|`, then it is rendered as the table's header.
+	Tables bool `json:"tables,omitempty"`
+
+	// If true then headings are rendered with an `id` attribute, so that they
+	// can be linked to, e.g. from a table of contents. Each id is a slug of
+	// the heading's text: lowercased, folded to ASCII where possible, and with
+	// punctuation replaced by dashes. Headings with the same slug have a
+	// numeric suffix added, e.g. `-2`. This has no effect on headings rendered
+	// by `heading_template`, which are given the id as `.ID` instead.
+	HeadingIDs bool `json:"heading_ids,omitempty"`
+
+	// If true then links to fragments within the same document, e.g.
+	// `=> #section`, will be checked against the IDs given to the document's
+	// headings. Fragments which match a heading once slugified are rewritten
+	// to use its ID, and fragments which don't match any heading are given a
+	// `dangling-fragment` class and listed in `.DanglingFragments`.
+	CheckFragmentLinks bool `json:"check_fragment_links,omitempty"`
+
+	// If true then a YAML frontmatter block at the start of a document, i.e.
+	// between a `---` first line and the next `---` line, is parsed into
+	// `.Meta` rather than being rendered. A `title` field overrides the
+	// heading-derived `.Title`. Documents without frontmatter are unaffected,
+	// while documents with malformed frontmatter result in an error.
+	Frontmatter bool `json:"frontmatter,omitempty"`
+
+	// If true then non-standard extensions to gemtext lists are enabled.
+	// List items indented with leading whitespace, e.g. `  * b`, are rendered
+	// as a list nested within the preceding item, and lines starting with a
+	// number followed by a period and a space, e.g. `1. a`, are rendered as
+	// items of an ordered list.
+	OrderLists bool `json:"order_lists,omitempty"`
+
+	// If true then the newlines which are normally written between HTML
+	// elements will be omitted, producing more compact output. Newlines within
+	// preformatted blocks are always retained.
+	Minify bool `json:"minify,omitempty"`
+
+	// If true then the leading whitespace of text lines will be retained as
+	// non-breaking spaces, rather than being trimmed, with each tab counting
+	// as four spaces.
+	PreserveIndent bool `json:"preserve_indent,omitempty"`
+
+	// The level of the heading, 1, 2, or 3, whose first occurrence in the
+	// document is used as the `.Title` of the page. Defaults to 1.
+	TitleLevel int `json:"title_level,omitempty"`
+
+	// If true then a group of consecutive quote lines whose last line starts
+	// with `--` or `—` will be rendered as a `<blockquote>` within a
+	// `<figure>`, with the last line as a `<cite>` attribution.
+	QuoteAttribution bool `json:"quote_attribution,omitempty"`
+
+	// URL of an Atom feed corresponding to the page, e.g. one served by the
+	// `gemlog_to_feed` handler. If given then a `Link` header advertising the
+	// feed will be included in the response, so that it can be autodiscovered,
+	// and the URL will be available to templates as `.FeedURL`. Placeholders
+	// are supported.
+	FeedURL string `json:"feed_url,omitempty"`
+
+	// The maximum number of characters in `.Description`. Longer descriptions
+	// are truncated, at a word boundary where possible, and end with an
+	// ellipsis. Defaults to no limit.
+	DescriptionLength int `json:"description_length,omitempty"`
+
+	// If given then the description of the page, i.e. `.Description` without
+	// HTML escaping, will be set in a response header of this name, e.g. for
+	// link preview services which don't parse the page itself. The header is
+	// omitted if the page has no description.
+	DescriptionHeader string `json:"description_header,omitempty"`
+
+	// The format which gemtext documents are translated into. Can be `html`,
+	// in which case `template` is used to render an HTML page, `json`, in
+	// which case a JSON document describing the parsed gemtext is served, for
+	// clients which wish to render gemtext themselves, or `text`, in which
+	// case a plain text rendering of the document is served. Defaults to
+	// `html`.
+	//
+	// The JSON document has a `title` and an ordered array of `nodes`, each
+	// having a `type` of `text`, `heading`, `link`, `list`, `quote`, or
+	// `preformatted`.
+	//
+	// The plain text rendering has all gemtext syntax stripped: headings and
+	// quotes become bare lines, links become their label (or their URL if they
+	// have no label), and preformatted blocks are retained verbatim without
+	// their fences. List items retain a leading `* `.
+	//
+	// Templates are not used for `json` or `text` output, and nor do
+	// `empty_template`, `empty_status`, or the options affecting HTML
+	// rendering apply.
+	Output string `json:"output,omitempty"`
+
+	translationObserver prometheus.Observer
+	bufPool             *toolkit.BufferPool
+	logger              *zap.Logger
 }
 
 var _ caddyhttp.MiddlewareHandler = (*Gemtext)(nil)
@@ -116,6 +371,14 @@ func (Gemtext) CaddyModule() caddy.ModuleInfo {
 
 func (g *Gemtext) Provision(ctx caddy.Context) error {
 	g.logger = ctx.Logger()
+	g.bufPool = toolkit.NewBufferPool(gemtextBufInitialCap)
+
+	var err error
+	if g.translationObserver, err = translationObserver(
+		ctx, g.TranslationMetric, "gemtext",
+	); err != nil {
+		return fmt.Errorf("setting up translation metric: %w", err)
+	}
 
 	if g.FileRoot == "" {
 		g.FileRoot = "{http.vars.root}"
@@ -125,40 +388,281 @@ func (g *Gemtext) Provision(ctx caddy.Context) error {
 		g.Delimiters = []string{"{{", "}}"}
 	}
 
+	if g.MaxIncludeDepth == nil {
+		maxIncludeDepth := gemtextDefaultMaxIncludeDepth
+		g.MaxIncludeDepth = &maxIncludeDepth
+	}
+
+	if len(g.MatchContentTypes) == 0 {
+		g.MatchContentTypes = []string{gemtextMIME}
+	}
+
+	if err := g.preloadTemplates(); err != nil {
+		return err
+	}
+
+	if !g.NoRegisterMIME {
+		if err := registerGemtextMIME(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchesContentType returns whether the media type of the given Content-Type
+// header value is equal to any of the given media types, ignoring case and
+// parameters.
+func matchesContentType(contentType string, mediaTypes []string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	for _, t := range mediaTypes {
+		if strings.EqualFold(mediaType, strings.TrimSpace(t)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// registerGemtextMIME registers the `.gmi` extension as having the gemtext
+// MIME type, if it doesn't already have a type.
+func registerGemtextMIME() error {
+	if mime.TypeByExtension(".gmi") != "" {
+		return nil
+	}
+
+	if err := mime.AddExtensionType(".gmi", gemtextMIME); err != nil {
+		return fmt.Errorf("registering .gmi MIME type: %w", err)
+	}
+
 	return nil
 }
 
 // Validate ensures t has a valid configuration.
 func (g *Gemtext) Validate() error {
-	if g.TemplatePath == "" {
-		return errors.New("TemplatePath is required")
+	switch g.Output {
+	case "", gemtextOutputHTML:
+		if g.TemplatePath == "" {
+			return errors.New("TemplatePath is required")
+		}
+	case gemtextOutputJSON, gemtextOutputText:
+	default:
+		return fmt.Errorf("invalid Output %q", g.Output)
 	}
 
 	if len(g.Delimiters) != 0 && len(g.Delimiters) != 2 {
 		return fmt.Errorf("delimiters must consist of exactly two elements: opening and closing")
 	}
+
+	if g.MaxIncludeDepth != nil && *g.MaxIncludeDepth < 0 {
+		return errors.New("MaxIncludeDepth cannot be negative")
+	}
+
+	if g.DescriptionLength < 0 {
+		return errors.New("DescriptionLength cannot be negative")
+	}
+
+	if g.TitleLevel < 0 || g.TitleLevel > 3 {
+		return fmt.Errorf("invalid TitleLevel %d, must be 1, 2, or 3", g.TitleLevel)
+	}
+
+	switch g.EmptyLinkLabel {
+	case "", gemtext.EmptyLinkLabelURL, gemtext.EmptyLinkLabelHost:
+	default:
+		return fmt.Errorf("invalid EmptyLinkLabel %q", g.EmptyLinkLabel)
+	}
+
+	if g.EmptyStatus != 0 {
+		if g.EmptyTemplatePath != "" {
+			return errors.New("EmptyStatus and EmptyTemplatePath cannot both be set")
+		} else if g.EmptyStatus < 200 || g.EmptyStatus > 599 {
+			return fmt.Errorf("invalid EmptyStatus %d", g.EmptyStatus)
+		}
+	}
+
 	return nil
 }
 
-func (g *Gemtext) render(
-	into io.Writer,
-	ctx *templates.TemplateContext,
-	osFS fs.FS,
-	tplPath string,
-	payload any,
+// decodeCharset wraps the given Reader such that it will be decoded into UTF-8
+// from the charset indicated by the Content-Type. If no charset is indicated
+// then UTF-8 is assumed.
+func decodeCharset(r io.Reader, contentType string) (io.Reader, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Content-Type %q: %w", contentType, err)
+	}
+
+	charset, ok := params["charset"]
+	if !ok {
+		return r, nil
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", charset, err)
+	} else if enc == unicode.UTF8 {
+		return r, nil
+	}
+
+	return transform.NewReader(r, enc.NewDecoder()), nil
+}
+
+// expandGemtextIncludes copies the gemtext document from src into dst,
+// replacing any include lines with the contents of the files they reference,
+// recursively. srcPath is the path of the document within fsys, and stack is
+// the paths of all documents which are currently being expanded, starting with
+// the top-level document. Included files may themselves include other files up
+// to maxDepth levels deep, so a maxDepth of 0 only allows the top-level
+// document to include files.
+func expandGemtextIncludes(
+	dst io.Writer,
+	fsys fs.FS,
+	src io.Reader,
+	srcPath string,
+	stack []string,
+	maxDepth int,
 ) error {
+	var (
+		r   = bufio.NewReader(src)
+		pft bool
+	)
+
+	for {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			return nil
+		} else if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading next line: %w", err)
+		}
+
+		if strings.HasPrefix(line, "```") {
+			pft = !pft
+		}
+
+		var target string
+		if fields := strings.Fields(strings.TrimPrefix(line, "=>")); !pft &&
+			strings.HasPrefix(line, "=>") &&
+			len(fields) > 0 &&
+			strings.HasPrefix(fields[0], gemtextIncludeScheme) {
+			target = strings.TrimPrefix(fields[0], gemtextIncludeScheme)
+		}
+
+		if target == "" {
+			if _, err := io.WriteString(dst, line); err != nil {
+				return fmt.Errorf("writing line: %w", err)
+			}
+			continue
+		}
+
+		if slices.Contains(strings.Split(target, "/"), "..") {
+			return fmt.Errorf("include path %q may not contain '..'", target)
+		}
+
+		includePath := target
+		if !path.IsAbs(includePath) {
+			includePath = path.Join(path.Dir(srcPath), includePath)
+		}
+		includePath = path.Clean("/" + includePath)
+
+		if slices.Contains(stack, includePath) {
+			return fmt.Errorf(
+				"include cycle detected: %s -> %s",
+				strings.Join(stack, " -> "), includePath,
+			)
+		} else if len(stack)-1 > maxDepth {
+			return fmt.Errorf(
+				"including %q: max include depth of %d exceeded",
+				includePath, maxDepth,
+			)
+		}
+
+		f, err := fsys.Open(strings.TrimPrefix(includePath, "/"))
+		if err != nil {
+			return fmt.Errorf("opening included file %q: %w", includePath, err)
+		}
+
+		err = expandGemtextIncludes(
+			dst, fsys, f, includePath, append(stack, includePath), maxDepth,
+		)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("including %q: %w", includePath, err)
+		}
+
+		// Ensure whatever follows the include starts on its own line.
+		if _, err := io.WriteString(dst, "\n"); err != nil {
+			return fmt.Errorf("writing line: %w", err)
+		}
+	}
+}
+
+func (g *Gemtext) loadTemplate(
+	ctx *templates.TemplateContext, osFS fs.FS, tplPath string,
+) (
+	*template.Template, error,
+) {
 	tplStr, err := fs.ReadFile(osFS, tplPath)
 	if err != nil {
-		return fmt.Errorf("loading template: %w", err)
+		return nil, fmt.Errorf("loading template: %w", err)
 	}
 
 	tpl := ctx.NewTemplate(tplPath)
 	if _, err := tpl.Parse(string(tplStr)); err != nil {
-		return fmt.Errorf("parsing template: %w", err)
+		return nil, fmt.Errorf("parsing template: %w", err)
 	}
 
 	tpl.Delims(g.Delimiters[0], g.Delimiters[1])
 
+	return tpl, nil
+}
+
+// preloadTemplates loads and parses all configured templates, so that broken
+// templates are caught on startup rather than on first request. Templates
+// whose path, or the FileRoot, contain placeholders are skipped, since they
+// can only be resolved in the context of a request.
+func (g *Gemtext) preloadTemplates() error {
+	if strings.Contains(g.FileRoot, "{") {
+		return nil
+	}
+
+	var (
+		ctx  = new(templates.TemplateContext)
+		osFS = os.DirFS(g.FileRoot)
+	)
+
+	for _, tplPath := range []string{
+		g.TemplatePath,
+		g.HeadingTemplatePath,
+		g.LinkTemplatePath,
+		g.CodeTemplatePath,
+		g.EmptyTemplatePath,
+	} {
+		if tplPath == "" || strings.Contains(tplPath, "{") {
+			continue
+		}
+
+		if _, err := g.loadTemplate(ctx, osFS, tplPath); err != nil {
+			return fmt.Errorf("template %q: %w", tplPath, err)
+		}
+	}
+
+	return nil
+}
+
+func (g *Gemtext) render(
+	into io.Writer,
+	ctx *templates.TemplateContext,
+	osFS fs.FS,
+	tplPath string,
+	payload any,
+) error {
+	tpl, err := g.loadTemplate(ctx, osFS, tplPath)
+	if err != nil {
+		return err
+	}
+
 	if err := tpl.Execute(into, payload); err != nil {
 		return fmt.Errorf("executing template: %w", err)
 	}
@@ -169,13 +673,18 @@ func (g *Gemtext) render(
 func (g *Gemtext) ServeHTTP(
 	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
 ) error {
-	buf, bufDone := toolkit.GetBuffer()
+	if g.RawQueryParam != "" && r.URL.Query().Has(g.RawQueryParam) {
+		return next.ServeHTTP(rw, r)
+	}
+
+	buf, bufDone := g.bufPool.Get()
 	defer bufDone()
 
 	// We only want to buffer and work on responses which are gemtext files.
 	shouldBuf := func(status int, header http.Header) bool {
-		ct := header.Get("Content-Type")
-		return strings.HasPrefix(ct, gemtextMIME)
+		return matchesContentType(
+			header.Get("Content-Type"), g.MatchContentTypes,
+		)
 	}
 
 	rec := caddyhttp.NewResponseRecorder(rw, buf, shouldBuf)
@@ -183,10 +692,41 @@ func (g *Gemtext) ServeHTTP(
 		return err
 	}
 
+	repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+	var feedURL string
+	if g.FeedURL != "" {
+		feedURL = repl.ReplaceAll(g.FeedURL, "")
+		rec.Header().Add("Link", fmt.Sprintf(
+			`<%s>; rel="alternate"; type="application/atom+xml"`, feedURL,
+		))
+	}
+
+	// A HEAD response has no body to translate, and so neither the length of
+	// the translated document nor whether it's empty can be known. Rather than
+	// fetching and translating the document anyway, only the headers are
+	// sent, with Content-Length omitted.
+	if r.Method == http.MethodHead {
+		for _, h := range []string{
+			"Content-Length", "Accept-Ranges", "Last-Modified", "Etag",
+		} {
+			rec.Header().Del(h)
+		}
+		switch g.Output {
+		case gemtextOutputJSON:
+			rec.Header().Set("Content-Type", "application/json")
+		case gemtextOutputText:
+			rec.Header().Set("Content-Type", gemtextTextContentType)
+		default:
+			rec.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		rw.WriteHeader(rec.Status())
+		return nil
+	}
+
 	buf = rec.Buffer() // probably redundant, but just in case
 
 	var (
-		repl    = r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
 		rootDir = repl.ReplaceAll(g.FileRoot, ".")
 		osFS    = os.DirFS(rootDir)
 		httpFS  = http.Dir(rootDir)
@@ -196,17 +736,31 @@ func (g *Gemtext) ServeHTTP(
 			RespHeader: templates.WrappedHeader{Header: rec.Header()},
 		}
 
-		parser gemtext.HTMLTranslator
+		parser = gemtext.HTMLTranslator{
+			AllowedLinkSchemes: g.AllowedLinkSchemes,
+			EmptyLinkLabel:     g.EmptyLinkLabel,
+			HeadingIDs:         g.HeadingIDs,
+			CheckFragmentLinks: g.CheckFragmentLinks,
+			Frontmatter:        g.Frontmatter,
+			Tables:             g.Tables,
+			OrderLists:         g.OrderLists,
+			Minify:             g.Minify,
+			PreserveIndent:     g.PreserveIndent,
+			TitleLevel:         g.TitleLevel,
+			QuoteAttribution:   g.QuoteAttribution,
+			DescriptionLength:  g.DescriptionLength,
+		}
 	)
 
 	if g.HeadingTemplatePath != "" {
-		parser.RenderHeading = func(w io.Writer, level int, text string) error {
+		parser.RenderHeading = func(w io.Writer, level int, id, text string) error {
 			payload := struct {
 				*templates.TemplateContext
 				Level int
+				ID    string
 				Text  string
 			}{
-				ctx, level, text,
+				ctx, level, id, text,
 			}
 
 			return g.render(w, ctx, osFS, g.HeadingTemplatePath, payload)
@@ -227,21 +781,110 @@ func (g *Gemtext) ServeHTTP(
 		}
 	}
 
-	translated, err := parser.Translate(buf)
+	if g.CodeTemplatePath != "" {
+		parser.RenderPreformatted = func(w io.Writer, altText, content string) error {
+			payload := struct {
+				*templates.TemplateContext
+				AltText string
+				Content string
+			}{
+				ctx, altText, content,
+			}
+
+			return g.render(w, ctx, osFS, g.CodeTemplatePath, payload)
+		}
+	}
+
+	src, err := decodeCharset(buf, rec.Header().Get("Content-Type"))
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	if g.AllowIncludes {
+		expanded, expandedDone := g.bufPool.Get()
+		defer expandedDone()
+
+		docPath := path.Clean("/" + r.URL.Path)
+		if err := expandGemtextIncludes(
+			expanded, osFS, src, docPath, []string{docPath}, *g.MaxIncludeDepth,
+		); err != nil {
+			return caddyhttp.Error(
+				http.StatusInternalServerError,
+				fmt.Errorf("expanding includes: %w", err),
+			)
+		}
+
+		src = expanded
+	}
+
+	switch g.Output {
+	case gemtextOutputJSON:
+		return g.serveJSON(r, rec, buf, src)
+	case gemtextOutputText:
+		return g.serveText(r, rec, buf, src)
+	}
+
+	_, span := startSpan(
+		r.Context(), "gemtext.translate",
+		attribute.Int("gemtext.document_size", buf.Len()),
+		attribute.Bool("gemtext.includes", g.AllowIncludes),
+	)
+
+	// The body is translated into a pooled buffer, rather than letting the
+	// translator allocate and grow its own for every request.
+	body, bodyDone := g.bufPool.Get()
+	defer bodyDone()
+
+	translateStart := time.Now()
+	translated, err := parser.TranslateTo(body, src)
+	endSpan(span, err)
 	if err != nil {
 		return fmt.Errorf("translating gemtext: %w", err)
 	}
+	g.translationObserver.Observe(time.Since(translateStart).Seconds())
+
+	translated.Body = body.String()
+
+	if g.DescriptionHeader != "" && translated.Description != "" {
+		rec.Header().Set(
+			g.DescriptionHeader, html.UnescapeString(translated.Description),
+		)
+	}
+
+	tplPath := g.TemplatePath
+	if translated.Empty {
+		switch {
+		case g.EmptyStatus >= 400:
+			return caddyhttp.Error(
+				g.EmptyStatus, errors.New("gemtext document is empty"),
+			)
+
+		case g.EmptyStatus != 0:
+			for _, h := range []string{
+				"Content-Length", "Content-Type", "Accept-Ranges",
+				"Last-Modified", "Etag",
+			} {
+				rw.Header().Del(h)
+			}
+			rw.WriteHeader(g.EmptyStatus)
+			return nil
+
+		case g.EmptyTemplatePath != "":
+			tplPath = g.EmptyTemplatePath
+		}
+	}
 
 	payload := struct {
 		*templates.TemplateContext
 		gemtext.HTML
+		FeedURL string
 	}{
-		ctx, translated,
+		ctx, translated, feedURL,
 	}
 
 	buf.Reset()
 	if err := g.render(
-		buf, ctx, osFS, g.TemplatePath, payload,
+		buf, ctx, osFS, tplPath, payload,
 	); err != nil {
 		// templates may return a custom HTTP error to be propagated to the
 		// client, otherwise for any other error we assume the template is
@@ -271,11 +914,120 @@ func (g *Gemtext) ServeHTTP(
 	return rec.WriteResponse()
 }
 
+// serveJSON translates the gemtext document read from src into a JSON document,
+// and writes it as the response. buf holds the original document, and will be
+// reused to hold the JSON document once src has been read.
+func (g *Gemtext) serveJSON(
+	r *http.Request,
+	rec caddyhttp.ResponseRecorder,
+	buf *bytes.Buffer,
+	src io.Reader,
+) error {
+	translator := gemtext.DocumentTranslator{
+		AllowedLinkSchemes: g.AllowedLinkSchemes,
+		TitleLevel:         g.TitleLevel,
+	}
+
+	_, span := startSpan(
+		r.Context(), "gemtext.translate",
+		attribute.Int("gemtext.document_size", buf.Len()),
+		attribute.Bool("gemtext.includes", g.AllowIncludes),
+	)
+
+	translateStart := time.Now()
+	doc, err := translator.Translate(src)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("translating gemtext: %w", err)
+	}
+	g.translationObserver.Observe(time.Since(translateStart).Seconds())
+
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(doc); err != nil {
+		return caddyhttp.Error(
+			http.StatusInternalServerError,
+			fmt.Errorf("encoding document as JSON: %w", err),
+		)
+	}
+
+	rec.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	rec.Header().Set("Content-Type", "application/json")
+	rec.Header().Del("Accept-Ranges")
+	rec.Header().Del("Last-Modified")
+	rec.Header().Del("Etag")
+
+	return rec.WriteResponse()
+}
+
+// serveText translates the gemtext document read from src into plain text, and
+// writes it as the response. buf holds the original document, and will be
+// reused to hold the plain text once src has been read.
+func (g *Gemtext) serveText(
+	r *http.Request,
+	rec caddyhttp.ResponseRecorder,
+	buf *bytes.Buffer,
+	src io.Reader,
+) error {
+	translator := gemtext.TextTranslator{TitleLevel: g.TitleLevel}
+
+	_, span := startSpan(
+		r.Context(), "gemtext.translate",
+		attribute.Int("gemtext.document_size", buf.Len()),
+		attribute.Bool("gemtext.includes", g.AllowIncludes),
+	)
+
+	translateStart := time.Now()
+	text, err := translator.Translate(src)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("translating gemtext: %w", err)
+	}
+	g.translationObserver.Observe(time.Since(translateStart).Seconds())
+
+	buf.Reset()
+	buf.WriteString(text.Body)
+
+	rec.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	rec.Header().Set("Content-Type", gemtextTextContentType)
+	rec.Header().Del("Accept-Ranges")
+	rec.Header().Del("Last-Modified")
+	rec.Header().Del("Etag")
+
+	return rec.WriteResponse()
+}
+
 // gemtextParseCaddyfile sets up the handler from Caddyfile tokens. Syntax:
 //
 //	gemtext [<matcher>] {
+//	    template <path>
+//	    heading_template <path>
+//	    link_template <path>
+//	    code_template <path>
 //	    between <open_delim> <close_delim>
 //	    root <path>
+//	    no_register_mime
+//	    match_content_type <type> [<type>...]
+//	    raw_query_param <name>
+//	    allowed_link_schemes <scheme> [<scheme>...]
+//	    translation_metric <histogram name>
+//	    empty_template <path>
+//	    empty_status <code>
+//	    allow_includes on|off
+//	    max_include_depth <n>
+//	    heading_ids on|off
+//	    check_fragment_links on|off
+//	    frontmatter on|off
+//	    tables on|off
+//	    order_lists on|off
+//	    minify on|off
+//	    preserve_indent on|off
+//	    title_level 1|2|3
+//	    quote_attribution on|off
+//	    feed_url <url>
+//	    description_length <length>
+//	    description_header <header name>
+//	    empty_link_label url|host
+//	    output html|json|text
 //	}
 func gemtextParseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	h.Next() // consume directive name
@@ -294,6 +1046,10 @@ func gemtextParseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler,
 			if !h.Args(&g.LinkTemplatePath) {
 				return nil, h.ArgErr()
 			}
+		case "code_template":
+			if !h.Args(&g.CodeTemplatePath) {
+				return nil, h.ArgErr()
+			}
 		case "root":
 			if !h.Args(&g.FileRoot) {
 				return nil, h.ArgErr()
@@ -303,6 +1059,132 @@ func gemtextParseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler,
 			if len(g.Delimiters) != 2 {
 				return nil, h.ArgErr()
 			}
+		case "no_register_mime":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			g.NoRegisterMIME = true
+		case "match_content_type":
+			types := h.RemainingArgs()
+			if len(types) == 0 {
+				return nil, h.ArgErr()
+			}
+			g.MatchContentTypes = append(g.MatchContentTypes, types...)
+		case "raw_query_param":
+			if !h.Args(&g.RawQueryParam) {
+				return nil, h.ArgErr()
+			}
+		case "translation_metric":
+			if !h.Args(&g.TranslationMetric) {
+				return nil, h.ArgErr()
+			}
+		case "empty_template":
+			if !h.Args(&g.EmptyTemplatePath) {
+				return nil, h.ArgErr()
+			}
+		case "empty_status":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.EmptyStatus, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+		case "allow_includes":
+			var err error
+			if g.AllowIncludes, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "empty_link_label":
+			if !h.Args(&g.EmptyLinkLabel) {
+				return nil, h.ArgErr()
+			}
+		case "feed_url":
+			if !h.Args(&g.FeedURL) {
+				return nil, h.ArgErr()
+			}
+		case "description_length":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.DescriptionLength, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+		case "description_header":
+			if !h.Args(&g.DescriptionHeader) {
+				return nil, h.ArgErr()
+			}
+		case "output":
+			if !h.Args(&g.Output) {
+				return nil, h.ArgErr()
+			}
+		case "heading_ids":
+			var err error
+			if g.HeadingIDs, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "check_fragment_links":
+			var err error
+			if g.CheckFragmentLinks, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "frontmatter":
+			var err error
+			if g.Frontmatter, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "tables":
+			var err error
+			if g.Tables, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "order_lists":
+			var err error
+			if g.OrderLists, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "minify":
+			var err error
+			if g.Minify, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "quote_attribution":
+			var err error
+			if g.QuoteAttribution, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "preserve_indent":
+			var err error
+			if g.PreserveIndent, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "title_level":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.TitleLevel, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+		case "max_include_depth":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			maxIncludeDepth, err := strconv.Atoi(h.Val())
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+			g.MaxIncludeDepth = &maxIncludeDepth
+		case "allowed_link_schemes":
+			g.AllowedLinkSchemes = h.RemainingArgs()
+			if len(g.AllowedLinkSchemes) == 0 {
+				return nil, h.ArgErr()
+			}
 		}
 	}
 	return g, nil
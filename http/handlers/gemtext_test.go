@@ -0,0 +1,758 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeCharset(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		contentType string
+		in          string
+		exp         string
+	}{
+		{"no charset", "text/gemini", "# Café", "# Café"},
+		{"utf-8", "text/gemini; charset=utf-8", "# Café", "# Café"},
+		{"iso-8859-1", "text/gemini; charset=iso-8859-1", "# Caf\xe9", "# Café"},
+		{"windows-1252", "text/gemini; charset=windows-1252", "\x93hi\x94", "“hi”"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := decodeCharset(strings.NewReader(test.in), test.contentType)
+			require.NoError(t, err)
+
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, string(got))
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := decodeCharset(
+			strings.NewReader(""), "text/gemini; charset=bogus",
+		)
+		assert.Error(t, err)
+	})
+}
+
+func TestExpandGemtextIncludes(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"header.gmi":       {Data: []byte("# Header\n")},
+		"footer.gmi":       {Data: []byte("=> include:footer-links.gmi\nBye")},
+		"footer-links.gmi": {Data: []byte("=> / Home\n")},
+		"docs/nested.gmi":  {Data: []byte("=> include:sibling.gmi\n")},
+		"docs/sibling.gmi": {Data: []byte("Sibling\n")},
+		"cycle/a.gmi":      {Data: []byte("=> include:b.gmi\n")},
+		"cycle/b.gmi":      {Data: []byte("=> include:/cycle/a.gmi\n")},
+		"deep/0.gmi":       {Data: []byte("=> include:1.gmi\n")},
+		"deep/1.gmi":       {Data: []byte("=> include:2.gmi\n")},
+		"deep/2.gmi":       {Data: []byte("=> include:3.gmi\n")},
+		"deep/3.gmi":       {Data: []byte("Deep\n")},
+	}
+
+	tests := []struct {
+		name     string
+		in       string
+		maxDepth int
+		exp      string
+		wantErr  bool
+	}{
+		{
+			name: "no includes",
+			in:   "# Title\n=> /foo.gmi Foo\n",
+			exp:  "# Title\n=> /foo.gmi Foo\n",
+		},
+		{
+			name:     "header and nested footer",
+			in:       "=> include:/header.gmi\nBody\n=> include:footer.gmi\n",
+			maxDepth: 2,
+			exp:      "# Header\n\nBody\n=> / Home\n\nBye\n",
+		},
+		{
+			name:     "relative to included file",
+			in:       "=> include:docs/nested.gmi\n",
+			maxDepth: 2,
+			exp:      "Sibling\n\n\n",
+		},
+		{
+			name: "preformatted block",
+			in:   "```\n=> include:header.gmi\n```\n",
+			exp:  "```\n=> include:header.gmi\n```\n",
+		},
+		{
+			name:     "cycle",
+			in:       "=> include:cycle/a.gmi\n",
+			maxDepth: 2,
+			wantErr:  true,
+		},
+		{
+			name:     "depth 0",
+			in:       "=> include:deep/3.gmi\n",
+			maxDepth: 0,
+			exp:      "Deep\n\n",
+		},
+		{
+			name:     "depth 0 exceeded",
+			in:       "=> include:deep/2.gmi\n",
+			maxDepth: 0,
+			wantErr:  true,
+		},
+		{
+			name:     "depth 1",
+			in:       "=> include:deep/2.gmi\n",
+			maxDepth: 1,
+			exp:      "Deep\n\n\n",
+		},
+		{
+			name:     "depth 1 exceeded",
+			in:       "=> include:deep/1.gmi\n",
+			maxDepth: 1,
+			wantErr:  true,
+		},
+		{
+			name:     "depth N",
+			in:       "=> include:deep/1.gmi\n",
+			maxDepth: 2,
+			exp:      "Deep\n\n\n\n",
+		},
+		{
+			name:     "depth N exceeded",
+			in:       "=> include:deep/0.gmi\n",
+			maxDepth: 2,
+			wantErr:  true,
+		},
+		{
+			name:    "traversal",
+			in:      "=> include:../etc/passwd\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing",
+			in:      "=> include:missing.gmi\n",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				got strings.Builder
+				err = expandGemtextIncludes(
+					&got, fsys, strings.NewReader(test.in),
+					"/index.gmi", []string{"/index.gmi"}, test.maxDepth,
+				)
+			)
+
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.String())
+		})
+	}
+}
+
+func TestGemtextPreloadTemplates(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"good.html":   "<h1>{{ .Title }}</h1>{{ .Body }}",
+		"broken.html": "<h1>{{ .Title </h1>",
+	} {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dir, name), []byte(body), 0644,
+		))
+	}
+
+	tests := []struct {
+		name    string
+		g       Gemtext
+		wantErr string
+	}{
+		{
+			name: "good",
+			g:    Gemtext{FileRoot: dir, TemplatePath: "good.html"},
+		},
+		{
+			name:    "broken",
+			g:       Gemtext{FileRoot: dir, TemplatePath: "broken.html"},
+			wantErr: `"broken.html"`,
+		},
+		{
+			name: "broken link template",
+			g: Gemtext{
+				FileRoot:         dir,
+				TemplatePath:     "good.html",
+				LinkTemplatePath: "broken.html",
+			},
+			wantErr: `"broken.html"`,
+		},
+		{
+			name:    "missing",
+			g:       Gemtext{FileRoot: dir, TemplatePath: "missing.html"},
+			wantErr: `"missing.html"`,
+		},
+		{
+			name: "placeholder path",
+			g:    Gemtext{FileRoot: dir, TemplatePath: "{http.vars.tpl}"},
+		},
+		{
+			name: "placeholder root",
+			g:    Gemtext{TemplatePath: "broken.html"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := test.g
+			g.NoRegisterMIME = true
+			err := g.Provision(caddy.Context{})
+
+			if test.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestGemtextHEAD(t *testing.T) {
+	t.Parallel()
+
+	g := Gemtext{TemplatePath: "render.html", NoRegisterMIME: true}
+	require.NoError(t, g.Provision(caddy.Context{}))
+
+	var (
+		rw   = httptest.NewRecorder()
+		r    = httptest.NewRequest("HEAD", "/index.gmi", nil)
+		next = caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			rw.Header().Set("Content-Type", gemtextMIME)
+			rw.Header().Set("Content-Length", "42")
+			rw.Header().Set("Etag", `"abc"`)
+			rw.WriteHeader(http.StatusOK)
+			return nil
+		})
+	)
+
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	require.NoError(t, g.ServeHTTP(rw, r, next))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rw.Header().Get("Content-Type"))
+	assert.NotContains(t, rw.Header(), "Content-Length")
+	assert.NotContains(t, rw.Header(), "Etag")
+	assert.Empty(t, rw.Body.String())
+}
+
+func TestGemtextFeedURL(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "render.html"),
+		[]byte(`<link href="{{ .FeedURL }}">{{ .Body }}`),
+		0644,
+	))
+
+	g := Gemtext{
+		FileRoot:       dir,
+		TemplatePath:   "render.html",
+		FeedURL:        "/{http.vars.feed}",
+		NoRegisterMIME: true,
+	}
+	require.NoError(t, g.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = io.WriteString(rw, "# Hello\n")
+		}
+		return nil
+	})
+
+	for _, method := range []string{"GET", "HEAD"} {
+		t.Run(method, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				rw   = httptest.NewRecorder()
+				r    = httptest.NewRequest(method, "/index.gmi", nil)
+				repl = caddy.NewReplacer()
+			)
+
+			repl.Set("http.vars.feed", "gmisub.atom")
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, repl,
+			))
+
+			require.NoError(t, g.ServeHTTP(rw, r, next))
+			assert.Equal(
+				t,
+				`</gmisub.atom>; rel="alternate"; type="application/atom+xml"`,
+				rw.Header().Get("Link"),
+			)
+
+			if method == "GET" {
+				assert.Contains(t, rw.Body.String(), `<link href="/gmisub.atom">`)
+			}
+		})
+	}
+}
+
+func TestGemtextCodeTemplate(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"render.html": "{{ .Body }}",
+		"code.html":   `<pre lang="{{ .AltText }}">{{ .Content | html }}</pre>`,
+	} {
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dir, name), []byte(body), 0644,
+		))
+	}
+
+	g := Gemtext{
+		FileRoot:         dir,
+		TemplatePath:     "render.html",
+		CodeTemplatePath: "code.html",
+		NoRegisterMIME:   true,
+	}
+	require.NoError(t, g.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.WriteHeader(http.StatusOK)
+		_, err := io.WriteString(rw, "```go\na < b\n```\n```\nplain\n```\n")
+		return err
+	})
+
+	var (
+		rw = httptest.NewRecorder()
+		r  = httptest.NewRequest("GET", "/index.gmi", nil)
+	)
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	require.NoError(t, g.ServeHTTP(rw, r, next))
+	assert.Equal(
+		t,
+		`<pre lang="go">a &lt; b`+"\n"+`</pre><pre lang="">plain`+"\n"+`</pre>`,
+		rw.Body.String(),
+	)
+}
+
+func TestGemtextMatchContentTypes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "render.html"), []byte(`{{ .Body }}`), 0644,
+	))
+
+	tests := []struct {
+		name              string
+		matchContentTypes []string
+		contentType       string
+		expTranslated     bool
+	}{
+		{
+			name:          "default",
+			contentType:   "text/gemini",
+			expTranslated: true,
+		},
+		{
+			name:          "default with charset",
+			contentType:   "text/gemini; charset=utf-8",
+			expTranslated: true,
+		},
+		{
+			name:        "default not matching",
+			contentType: "text/x-gemini",
+		},
+		{
+			name:              "custom",
+			matchContentTypes: []string{"text/gemini", "text/x-gemini"},
+			contentType:       "Text/X-Gemini;charset=utf-8",
+			expTranslated:     true,
+		},
+		{
+			name:              "custom overrides default",
+			matchContentTypes: []string{"text/x-gemini"},
+			contentType:       "text/gemini",
+		},
+		{
+			name:        "prefix only",
+			contentType: "text/gemini-ish",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := Gemtext{
+				FileRoot:          dir,
+				TemplatePath:      "render.html",
+				MatchContentTypes: test.matchContentTypes,
+				NoRegisterMIME:    true,
+			}
+			require.NoError(t, g.Provision(caddy.Context{}))
+
+			var (
+				rw   = httptest.NewRecorder()
+				r    = httptest.NewRequest("GET", "/index.gmi", nil)
+				next = caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+					rw.Header().Set("Content-Type", test.contentType)
+					rw.WriteHeader(http.StatusOK)
+					_, err := io.WriteString(rw, "# Hello\n")
+					return err
+				})
+			)
+
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			))
+
+			require.NoError(t, g.ServeHTTP(rw, r, next))
+			if test.expTranslated {
+				assert.Equal(t, "<h1>Hello</h1>\n", rw.Body.String())
+			} else {
+				assert.Equal(t, "# Hello\n", rw.Body.String())
+			}
+		})
+	}
+
+	t.Run("caddyfile", func(t *testing.T) {
+		t.Parallel()
+
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+			gemtext {
+				template render.html
+				match_content_type text/gemini
+				match_content_type text/x-gemini application/gemini
+			}
+		`)}
+
+		handler, err := gemtextParseCaddyfile(h)
+		require.NoError(t, err)
+		assert.Equal(
+			t,
+			[]string{"text/gemini", "text/x-gemini", "application/gemini"},
+			handler.(*Gemtext).MatchContentTypes,
+		)
+	})
+}
+
+func TestGemtextFrontmatter(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "render.html"),
+		[]byte(`{{ .Title }}|{{ .Meta.author }}|{{ .Meta.date.Year }}|{{ .Body }}`),
+		0644,
+	))
+
+	g := Gemtext{
+		FileRoot:       dir,
+		TemplatePath:   "render.html",
+		Frontmatter:    true,
+		NoRegisterMIME: true,
+	}
+	require.NoError(t, g.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.WriteHeader(http.StatusOK)
+		_, err := io.WriteString(
+			rw, "---\ntitle: Hello\nauthor: Me\ndate: 2024-01-02\n---\n# Heading\n",
+		)
+		return err
+	})
+
+	var (
+		rw = httptest.NewRecorder()
+		r  = httptest.NewRequest("GET", "/index.gmi", nil)
+	)
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	require.NoError(t, g.ServeHTTP(rw, r, next))
+	assert.Equal(t, "Hello|Me|2024|<h1>Heading</h1>\n", rw.Body.String())
+}
+
+func TestGemtextCheckFragmentLinks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "render.html"),
+		[]byte(`{{ .Body }}{{ range .DanglingFragments }}dangling:{{ . }}{{ end }}`),
+		0644,
+	))
+
+	g := Gemtext{
+		FileRoot:           dir,
+		TemplatePath:       "render.html",
+		HeadingIDs:         true,
+		CheckFragmentLinks: true,
+		NoRegisterMIME:     true,
+	}
+	require.NoError(t, g.Provision(caddy.Context{}))
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.WriteHeader(http.StatusOK)
+		_, err := io.WriteString(
+			rw, "# Intro\n=> #intro Matching\n=> #missing Dangling\n",
+		)
+		return err
+	})
+
+	var (
+		rw = httptest.NewRecorder()
+		r  = httptest.NewRequest("GET", "/index.gmi", nil)
+	)
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	require.NoError(t, g.ServeHTTP(rw, r, next))
+	assert.Equal(
+		t,
+		"<h1 id=\"intro\">Intro</h1>\n"+
+			"<p><a href=\"#intro\">Matching</a></p>\n"+
+			"<p><a class=\"dangling-fragment\" href=\"#missing\">Dangling</a></p>\n"+
+			"dangling:missing",
+		rw.Body.String(),
+	)
+}
+
+func TestGemtextDescription(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "render.html"),
+		[]byte(`<meta name="description" content="{{ .Description }}">`),
+		0644,
+	))
+
+	g := Gemtext{
+		FileRoot:          dir,
+		TemplatePath:      "render.html",
+		DescriptionLength: 20,
+		DescriptionHeader: "X-Description",
+		NoRegisterMIME:    true,
+	}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	var (
+		rw   = httptest.NewRecorder()
+		r    = httptest.NewRequest("GET", "/index.gmi", nil)
+		next = caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			rw.Header().Set("Content-Type", gemtextMIME)
+			rw.WriteHeader(http.StatusOK)
+			_, err := io.WriteString(rw, "# Hello\n\nFish & chips, with mushy peas.\n")
+			return err
+		})
+	)
+
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	require.NoError(t, g.ServeHTTP(rw, r, next))
+	assert.Equal(
+		t,
+		`<meta name="description" content="Fish &amp; chips, with…">`,
+		rw.Body.String(),
+	)
+	assert.Equal(t, "Fish & chips, with…", rw.Header().Get("X-Description"))
+}
+
+func TestGemtextOutputJSON(t *testing.T) {
+	t.Parallel()
+
+	g := Gemtext{Output: "json", NoRegisterMIME: true}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.Header().Set("Etag", `"abc"`)
+		rw.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = io.WriteString(rw, "# Hello\n=> /foo Foo\n")
+		}
+		return nil
+	})
+
+	for _, method := range []string{"GET", "HEAD"} {
+		t.Run(method, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				rw = httptest.NewRecorder()
+				r  = httptest.NewRequest(method, "/index.gmi", nil)
+			)
+
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			))
+
+			require.NoError(t, g.ServeHTTP(rw, r, next))
+			assert.Equal(t, http.StatusOK, rw.Code)
+			assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+			assert.NotContains(t, rw.Header(), "Etag")
+
+			if method == "GET" {
+				assert.Equal(t, strconv.Itoa(rw.Body.Len()), rw.Header().Get("Content-Length"))
+				assert.JSONEq(t, `{
+					"title": "Hello",
+					"nodes": [
+						{"type": "heading", "level": 1, "text": "Hello"},
+						{"type": "link", "url": "/foo", "label": "Foo"}
+					]
+				}`, rw.Body.String())
+			}
+		})
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		t.Parallel()
+		assert.Error(t, (&Gemtext{}).Validate())
+		assert.Error(t, (&Gemtext{Output: "xml"}).Validate())
+		assert.NoError(t, (&Gemtext{Output: "html", TemplatePath: "render.html"}).Validate())
+	})
+}
+
+func TestGemtextOutputText(t *testing.T) {
+	t.Parallel()
+
+	g := Gemtext{Output: "text", NoRegisterMIME: true}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.Header().Set("Etag", `"abc"`)
+		rw.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = io.WriteString(rw, "# Hello\n=> /foo Foo\n")
+		}
+		return nil
+	})
+
+	for _, method := range []string{"GET", "HEAD"} {
+		t.Run(method, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				rw = httptest.NewRecorder()
+				r  = httptest.NewRequest(method, "/index.gmi", nil)
+			)
+
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			))
+
+			require.NoError(t, g.ServeHTTP(rw, r, next))
+			assert.Equal(t, http.StatusOK, rw.Code)
+			assert.Equal(
+				t, "text/plain; charset=utf-8", rw.Header().Get("Content-Type"),
+			)
+			assert.NotContains(t, rw.Header(), "Etag")
+
+			if method == "GET" {
+				assert.Equal(t, strconv.Itoa(rw.Body.Len()), rw.Header().Get("Content-Length"))
+				assert.Equal(t, "Hello\nFoo\n", rw.Body.String())
+			}
+		})
+	}
+}
+
+func BenchmarkGemtext(b *testing.B) {
+	dir := b.TempDir()
+	require.NoError(b, os.WriteFile(
+		filepath.Join(dir, "render.html"),
+		[]byte(`<title>{{ .Title }}</title>{{ .Body }}`),
+		0644,
+	))
+
+	g := Gemtext{
+		FileRoot:       dir,
+		TemplatePath:   "render.html",
+		NoRegisterMIME: true,
+	}
+	require.NoError(b, g.Provision(caddy.Context{}))
+
+	var doc strings.Builder
+	doc.WriteString("# Benchmark\n")
+	for i := 0; i < 2000; i++ {
+		doc.WriteString("Some text which makes up a paragraph & more.\n")
+		doc.WriteString("=> https://example.com/page A link\n")
+		doc.WriteString("* A list item\n")
+	}
+	body := doc.String()
+
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		rw.Header().Set("Content-Type", gemtextMIME)
+		rw.WriteHeader(http.StatusOK)
+		_, err := io.WriteString(rw, body)
+		return err
+	})
+
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest("GET", "/index.gmi", nil)
+		)
+		r = r.WithContext(context.WithValue(
+			r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+		))
+		if err := g.ServeHTTP(rw, r, next); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
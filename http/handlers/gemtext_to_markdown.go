@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/gemtext"
+	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/toolkit"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const markdownContentType = "text/markdown; charset=utf-8"
+
+func init() {
+	caddy.RegisterModule(GemtextToMarkdown{})
+	httpcaddyfile.RegisterHandlerDirective(
+		"gemtext_to_markdown", gemtextToMarkdownParseCaddyfile,
+	)
+	httpcaddyfile.RegisterDirectiveOrder(
+		"gemtext_to_markdown", httpcaddyfile.Before, "templates",
+	)
+}
+
+// GemtextToMarkdown is an HTTP middleware module which will translate gemtext
+// documents into Markdown documents.
+//
+// Only responses with a Content-Type of `text/gemini` will be modified by this
+// module, and their Content-Type will be changed to `text/markdown`.
+type GemtextToMarkdown struct {
+
+	// Since this module relies on Content-Type, but `text/gemini` is not a
+	// standard type, the `.gmi` extension will be registered as having that
+	// type if it doesn't already have one. Setting this to true disables that
+	// registration.
+	//
+	// Note that the registration is process-wide, so this option only has an
+	// effect if no other handler performs the registration.
+	NoRegisterMIME bool `json:"no_register_mime,omitempty"`
+
+	// The URL schemes which links may have. Links whose URL has a scheme not in
+	// this list (e.g. `javascript:`) will be translated as plain text, with
+	// only their label included. Relative URLs are always allowed.
+	//
+	// Defaults to `http`, `https`, `gemini`, and `mailto`.
+	AllowedLinkSchemes []string `json:"allowed_link_schemes,omitempty"`
+
+	// The level of the heading, 1, 2, or 3, which is used as the title of the
+	// document. The first heading of this level in the document is used.
+	// Defaults to 1.
+	TitleLevel int `json:"title_level,omitempty"`
+
+	// If true then the Markdown document will begin with a YAML front matter
+	// block containing the `title` of the document, as used by many static
+	// site generators. The block is omitted if the document has no title.
+	FrontMatter bool `json:"front_matter,omitempty"`
+
+	// Name of a histogram defined as part of the
+	// `mediocre_caddy_plugins.metrics` global configuration, into which the
+	// time taken to translate each document will be observed. The histogram
+	// must have a single label, `handler`, which will be set to
+	// `gemtext_to_markdown`.
+	TranslationMetric string `json:"translation_metric,omitempty"`
+
+	translationObserver prometheus.Observer
+	bufPool             *toolkit.BufferPool
+}
+
+var _ caddyhttp.MiddlewareHandler = (*GemtextToMarkdown)(nil)
+
+func (GemtextToMarkdown) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.gemtext_to_markdown",
+		New: func() caddy.Module { return new(GemtextToMarkdown) },
+	}
+}
+
+func (g *GemtextToMarkdown) Provision(ctx caddy.Context) error {
+	g.bufPool = toolkit.NewBufferPool(gemtextBufInitialCap)
+
+	var err error
+	if g.translationObserver, err = translationObserver(
+		ctx, g.TranslationMetric, "gemtext_to_markdown",
+	); err != nil {
+		return fmt.Errorf("setting up translation metric: %w", err)
+	}
+
+	if !g.NoRegisterMIME {
+		if err := registerGemtextMIME(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate ensures g has a valid configuration.
+func (g *GemtextToMarkdown) Validate() error {
+	if g.TitleLevel < 0 || g.TitleLevel > 3 {
+		return fmt.Errorf("invalid TitleLevel %d, must be 1, 2, or 3", g.TitleLevel)
+	}
+
+	return nil
+}
+
+func (g *GemtextToMarkdown) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	buf, bufDone := g.bufPool.Get()
+	defer bufDone()
+
+	// We only want to buffer and work on responses which are gemtext files.
+	shouldBuf := func(status int, header http.Header) bool {
+		ct := header.Get("Content-Type")
+		return strings.HasPrefix(ct, gemtextMIME)
+	}
+
+	rec := caddyhttp.NewResponseRecorder(rw, buf, shouldBuf)
+	if err := next.ServeHTTP(rec, r); err != nil || !rec.Buffered() {
+		return err
+	}
+
+	contentType := rec.Header().Get("Content-Type")
+
+	for _, h := range []string{
+		"Content-Length", "Accept-Ranges", "Last-Modified", "Etag",
+	} {
+		rec.Header().Del(h)
+	}
+	rec.Header().Set("Content-Type", markdownContentType)
+
+	// The body of a HEAD response is never sent, so there's no point in
+	// translating it. Content-Length is omitted, as it can't be known without
+	// translating.
+	if r.Method == http.MethodHead {
+		rw.WriteHeader(rec.Status())
+		return nil
+	}
+
+	buf = rec.Buffer() // probably redundant, but just in case
+
+	src, err := decodeCharset(buf, contentType)
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+
+	translator := gemtext.MarkdownTranslator{
+		AllowedLinkSchemes: g.AllowedLinkSchemes,
+		TitleLevel:         g.TitleLevel,
+	}
+
+	_, span := startSpan(
+		r.Context(), "gemtext_to_markdown.translate",
+		attribute.Int("gemtext.document_size", buf.Len()),
+	)
+
+	translateStart := time.Now()
+	md, err := translator.Translate(src)
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("translating gemtext: %w", err)
+	}
+	g.translationObserver.Observe(time.Since(translateStart).Seconds())
+
+	buf.Reset()
+
+	if g.FrontMatter && md.Title != "" {
+		// A JSON string is also a valid YAML string.
+		title, err := json.Marshal(md.Title)
+		if err != nil {
+			return fmt.Errorf("encoding title: %w", err)
+		}
+		fmt.Fprintf(buf, "---\ntitle: %s\n---\n\n", title)
+	}
+
+	buf.WriteString(md.Body)
+
+	rec.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	return rec.WriteResponse()
+}
+
+// gemtextToMarkdownParseCaddyfile sets up the handler from Caddyfile tokens.
+// Syntax:
+//
+//	gemtext_to_markdown [<matcher>] {
+//	    no_register_mime
+//	    allowed_link_schemes <scheme> [<scheme>...]
+//	    title_level 1|2|3
+//	    front_matter on|off
+//	    translation_metric <histogram name>
+//	}
+func gemtextToMarkdownParseCaddyfile(
+	h httpcaddyfile.Helper,
+) (
+	caddyhttp.MiddlewareHandler, error,
+) {
+	h.Next() // consume directive name
+	g := new(GemtextToMarkdown)
+	for h.NextBlock(0) {
+		switch h.Val() {
+		case "no_register_mime":
+			if h.NextArg() {
+				return nil, h.ArgErr()
+			}
+			g.NoRegisterMIME = true
+		case "allowed_link_schemes":
+			g.AllowedLinkSchemes = h.RemainingArgs()
+			if len(g.AllowedLinkSchemes) == 0 {
+				return nil, h.ArgErr()
+			}
+		case "title_level":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.TitleLevel, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+		case "front_matter":
+			var err error
+			if g.FrontMatter, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		case "translation_metric":
+			if !h.Args(&g.TranslationMetric) {
+				return nil, h.ArgErr()
+			}
+		default:
+			return nil, fmt.Errorf("unknown field: %q", h.Val())
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGemtextToMarkdown(t *testing.T) {
+	t.Parallel()
+
+	newNext := func(contentType, body string) caddyhttp.Handler {
+		return caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			rw.Header().Set("Content-Type", contentType)
+			rw.Header().Set("Etag", `"abc"`)
+			rw.WriteHeader(http.StatusOK)
+			if r.Method != http.MethodHead {
+				_, _ = io.WriteString(rw, body)
+			}
+			return nil
+		})
+	}
+
+	tests := []struct {
+		name           string
+		g              GemtextToMarkdown
+		method         string
+		contentType    string
+		in             string
+		expContentType string
+		exp            string
+	}{
+		{
+			name:           "markdown",
+			contentType:    gemtextMIME,
+			in:             "# Title\n=> /foo Foo\n* a\n",
+			expContentType: markdownContentType,
+			exp:            "# Title\n\n[Foo](/foo)\n\n- a\n",
+		},
+		{
+			name:           "front matter",
+			g:              GemtextToMarkdown{FrontMatter: true},
+			contentType:    gemtextMIME,
+			in:             "# \"Quoted\" Title\ntext\n",
+			expContentType: markdownContentType,
+			exp: "---\ntitle: \"\\\"Quoted\\\" Title\"\n---\n\n" +
+				"# \"Quoted\" Title\n\ntext\n",
+		},
+		{
+			name:           "front matter without title",
+			g:              GemtextToMarkdown{FrontMatter: true},
+			contentType:    gemtextMIME,
+			in:             "## Sub\n",
+			expContentType: markdownContentType,
+			exp:            "## Sub\n",
+		},
+		{
+			name:           "charset",
+			contentType:    gemtextMIME + "; charset=iso-8859-1",
+			in:             "Caf\xe9\n",
+			expContentType: markdownContentType,
+			exp:            "Café\n",
+		},
+		{
+			name:           "HEAD",
+			method:         "HEAD",
+			contentType:    gemtextMIME,
+			expContentType: markdownContentType,
+		},
+		{
+			name:           "not gemtext",
+			contentType:    "text/plain",
+			in:             "# Not gemtext\n",
+			expContentType: "text/plain",
+			exp:            "# Not gemtext\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			g := test.g
+			g.NoRegisterMIME = true
+			require.NoError(t, g.Provision(caddy.Context{}))
+			require.NoError(t, g.Validate())
+
+			method := test.method
+			if method == "" {
+				method = "GET"
+			}
+
+			var (
+				rw = httptest.NewRecorder()
+				r  = httptest.NewRequest(method, "/index.gmi", nil)
+			)
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			))
+
+			require.NoError(t, g.ServeHTTP(
+				rw, r, newNext(test.contentType, test.in),
+			))
+			assert.Equal(t, http.StatusOK, rw.Code)
+			assert.Equal(t, test.expContentType, rw.Header().Get("Content-Type"))
+			assert.Equal(t, test.exp, rw.Body.String())
+
+			if test.expContentType == markdownContentType {
+				t.Log("Checking that headers of the original document were removed")
+				assert.NotContains(t, rw.Header(), "Etag")
+			}
+		})
+	}
+}
+
+func TestGemtextToMarkdownParseCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		gemtext_to_markdown {
+			no_register_mime
+			allowed_link_schemes https gemini
+			title_level 2
+			front_matter on
+			translation_metric translation_seconds
+		}
+	`)}
+
+	handler, err := gemtextToMarkdownParseCaddyfile(h)
+	require.NoError(t, err)
+	assert.Equal(t, &GemtextToMarkdown{
+		NoRegisterMIME:     true,
+		AllowedLinkSchemes: []string{"https", "gemini"},
+		TitleLevel:         2,
+		FrontMatter:        true,
+		TranslationMetric:  "translation_seconds",
+	}, handler)
+}
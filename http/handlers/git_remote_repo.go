@@ -1,14 +1,36 @@
 package handlers
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/sosedoff/gitkit"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/semaphore"
+)
+
+// gitRemoteRepoRetryAfter is the value given in the Retry-After header when a
+// request is rejected due to too many concurrent requests.
+const gitRemoteRepoRetryAfter = 5 * time.Second
+
+// gitDumbPathRegexp matches the paths, relative to the repo, of the static
+// files which are fetched by clients using the dumb HTTP protocol.
+var gitDumbPathRegexp = regexp.MustCompile(
+	`^/(HEAD|info/refs|objects/info/(packs|alternates|http-alternates)|` +
+		`objects/[0-9a-f]{2}/[0-9a-f]{38,62}|` +
+		`objects/pack/pack-[0-9a-f]{40,64}\.(pack|idx))$`,
 )
 
 func init() {
@@ -20,8 +42,8 @@ func init() {
 }
 
 // GitRemoteRepo is an HTTP middleware module which will serve a git repo using
-// either the [dumb or smart][git_transport] HTTP protocols, allowing clients to
-// push to or pull from the repo.
+// the [smart][git_transport] HTTP protocol, and optionally the dumb one,
+// allowing clients to push to or pull from the repo.
 //
 // This module does _not_ deal with authentication or any other kind of access
 // control, take care not to leave your private repos publicly exposed.
@@ -33,6 +55,25 @@ type GitRemoteRepo struct {
 	// it doesn't already exist. Default is `{http.vars.root}` if set, or
 	// current working directory otherwise.
 	Path string `json:"path,omitempty"`
+
+	// The maximum number of requests which will be handled concurrently. Each
+	// request may spawn a git process, so this can be used to prevent the
+	// server from being overwhelmed. Default is 0, meaning no limit.
+	MaxConcurrent int64 `json:"max_concurrent,omitempty"`
+
+	// When MaxConcurrent requests are already being handled, further requests
+	// will wait up to this long for one of them to complete, after which they
+	// will be rejected with a 503. Default is 0, meaning requests are rejected
+	// immediately.
+	QueueTimeout caddy.Duration `json:"queue_timeout,omitempty"`
+
+	// If true then the static files fetched by clients using the dumb HTTP
+	// protocol will be served directly from the repo's directory. These
+	// requests aren't subject to MaxConcurrent. Default is false, meaning only
+	// the smart protocol is served.
+	DumbHTTP bool `json:"dumb_http,omitempty"`
+
+	sem *semaphore.Weighted
 }
 
 var _ caddyhttp.MiddlewareHandler = (*GitRemoteRepo)(nil)
@@ -49,16 +90,135 @@ func (g *GitRemoteRepo) Provision(ctx caddy.Context) error {
 		g.Path = "{http.vars.root}"
 	}
 
+	if g.MaxConcurrent > 0 {
+		g.sem = semaphore.NewWeighted(g.MaxConcurrent)
+	}
+
 	return nil
 }
 
 func (g *GitRemoteRepo) Validate() error {
+	if g.MaxConcurrent < 0 {
+		return errors.New("max_concurrent cannot be negative")
+	}
+
+	if g.QueueTimeout < 0 {
+		return errors.New("queue_timeout cannot be negative")
+	}
+
+	return nil
+}
+
+// acquire blocks until the request is allowed to proceed, returning a function
+// which must be called once the request is complete. Returns false if the
+// request should not proceed.
+func (g *GitRemoteRepo) acquire(ctx context.Context) (func(), bool) {
+	if g.sem == nil {
+		return func() {}, true
+	}
+
+	release := func() { g.sem.Release(1) }
+
+	if g.sem.TryAcquire(1) {
+		return release, true
+	} else if g.QueueTimeout == 0 {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(g.QueueTimeout))
+	defer cancel()
+
+	if err := g.sem.Acquire(ctx, 1); err != nil {
+		return nil, false
+	}
+
+	return release, true
+}
+
+// isDumbRequest returns true if the request is for one of the static files
+// fetched by the dumb HTTP protocol, rather than being part of the smart
+// protocol.
+func isDumbRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	} else if r.URL.Path == "/info/refs" && r.URL.Query().Has("service") {
+		return false
+	}
+	return gitDumbPathRegexp.MatchString(r.URL.Path)
+}
+
+// gitDumbContentType returns the Content-Type which a static file fetched by
+// the dumb HTTP protocol should be served with, matching git-http-backend.
+func gitDumbContentType(urlPath string) string {
+	switch {
+	case path.Ext(urlPath) == ".pack":
+		return "application/x-git-packed-objects"
+	case path.Ext(urlPath) == ".idx":
+		return "application/x-git-packed-objects-toc"
+	case path.Dir(urlPath) == "/info" || path.Dir(urlPath) == "/objects/info" ||
+		urlPath == "/HEAD":
+		return "text/plain"
+	default:
+		return "application/x-git-loose-object"
+	}
+}
+
+// serveDumb serves a static file from within the repo for the dumb HTTP
+// protocol. Conditional requests are supported, using an ETag derived from the
+// file's modification time and size.
+func serveDumb(rw http.ResponseWriter, r *http.Request, repoDir string) error {
+	f, err := os.Open(filepath.Join(repoDir, filepath.FromSlash(r.URL.Path)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return caddyhttp.Error(http.StatusNotFound, err)
+	} else if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, err)
+	} else if stat.IsDir() {
+		return caddyhttp.Error(
+			http.StatusNotFound, fmt.Errorf("%q is a directory", r.URL.Path),
+		)
+	}
+
+	// Same format as the file_server's ETags.
+	etag := `"` +
+		strconv.FormatInt(stat.ModTime().Unix(), 36) +
+		strconv.FormatInt(stat.Size(), 36) +
+		`"`
+
+	rw.Header().Set("Etag", etag)
+	rw.Header().Set("Content-Type", gitDumbContentType(r.URL.Path))
+	http.ServeContent(rw, r, "", stat.ModTime(), f)
 	return nil
 }
 
 func (g *GitRemoteRepo) ServeHTTP(
 	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
 ) error {
+	// Static files for the dumb protocol don't require any git process, and so
+	// aren't subject to MaxConcurrent.
+	if g.DumbHTTP && isDumbRequest(r) {
+		repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		return serveDumb(rw, r, repl.ReplaceAll(g.Path, "."))
+	}
+
+	release, ok := g.acquire(r.Context())
+	if !ok {
+		rw.Header().Set(
+			"Retry-After",
+			strconv.Itoa(int(gitRemoteRepoRetryAfter.Seconds())),
+		)
+		return caddyhttp.Error(
+			http.StatusServiceUnavailable,
+			errors.New("too many concurrent git requests"),
+		)
+	}
+	defer release()
+
 	// `gitkit.Server` only exposes the ability to work with a directory of
 	// repos, not just a single repo. To get around this we pass into
 	// `gitkit.Server` the parent directory of Path, and then to all HTTP
@@ -80,6 +240,19 @@ func (g *GitRemoteRepo) ServeHTTP(
 		AutoCreate: true,
 	})
 
+	service := r.URL.Query().Get("service")
+	if service == "" {
+		service = path.Base(r.URL.Path)
+	}
+
+	ctx, span := startSpan(
+		r.Context(), "git_remote_repo.serve",
+		attribute.String("git.repo", repoDirName),
+		attribute.String("git.service", service),
+	)
+	defer endSpan(span, nil)
+
+	r = r.WithContext(ctx)
 	r.URL.Path = caddyhttp.SanitizedPathJoin("/"+repoDirName, r.URL.Path)
 	srv.ServeHTTP(rw, r)
 	return nil
@@ -88,12 +261,48 @@ func (g *GitRemoteRepo) ServeHTTP(
 // gitRemoteRepoParseCaddyfile sets up the handler from Caddyfile tokens.
 // Syntax:
 //
-//	git_remote_repo [<matcher>] [<path>]
+//	git_remote_repo [<matcher>] [<path>] {
+//		max_concurrent <n>
+//		queue_timeout <duration>
+//		dumb_http on|off
+//	}
 func gitRemoteRepoParseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	h.Next() // consume directive name
 	g := new(GitRemoteRepo)
 	if h.NextArg() {
 		g.Path = h.Val()
 	}
+
+	for h.NextBlock(0) {
+		switch h.Val() {
+		case "max_concurrent":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if g.MaxConcurrent, err = strconv.ParseInt(h.Val(), 10, 64); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+
+		case "queue_timeout":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			d, err := caddy.ParseDuration(h.Val())
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as timeout: %w", h.Val(), err)
+			}
+			g.QueueTimeout = caddy.Duration(d)
+
+		case "dumb_http":
+			var err error
+			if g.DumbHTTP, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return g, nil
 }
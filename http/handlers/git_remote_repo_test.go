@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitRemoteRepoMaxConcurrent(t *testing.T) {
+	t.Parallel()
+
+	newGitRemoteRepo := func(t *testing.T, queueTimeout time.Duration) *GitRemoteRepo {
+		g := &GitRemoteRepo{
+			MaxConcurrent: 2,
+			QueueTimeout:  caddy.Duration(queueTimeout),
+		}
+		require.NoError(t, g.Provision(caddy.Context{}))
+		require.NoError(t, g.Validate())
+		return g
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		t.Parallel()
+
+		g := newGitRemoteRepo(t, 0)
+
+		var releases []func()
+		for range g.MaxConcurrent {
+			release, ok := g.acquire(context.Background())
+			require.True(t, ok)
+			releases = append(releases, release)
+		}
+
+		var (
+			rw  = httptest.NewRecorder()
+			r   = httptest.NewRequest("GET", "/info/refs?service=git-upload-pack", nil)
+			err = g.ServeHTTP(rw, r, nil)
+		)
+
+		var hErr caddyhttp.HandlerError
+		require.True(t, errors.As(err, &hErr))
+		assert.Equal(t, http.StatusServiceUnavailable, hErr.StatusCode)
+		assert.Equal(t, "5", rw.Header().Get("Retry-After"))
+
+		releases[0]()
+		_, ok := g.acquire(context.Background())
+		assert.True(t, ok)
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		t.Parallel()
+
+		g := newGitRemoteRepo(t, time.Minute)
+
+		var releases []func()
+		for range g.MaxConcurrent {
+			release, ok := g.acquire(context.Background())
+			require.True(t, ok)
+			releases = append(releases, release)
+		}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			releases[0]()
+		}()
+
+		_, ok := g.acquire(context.Background())
+		assert.True(t, ok)
+	})
+
+	t.Run("queue_timeout", func(t *testing.T) {
+		t.Parallel()
+
+		g := newGitRemoteRepo(t, 10*time.Millisecond)
+
+		for range g.MaxConcurrent {
+			_, ok := g.acquire(context.Background())
+			require.True(t, ok)
+		}
+
+		_, ok := g.acquire(context.Background())
+		assert.False(t, ok)
+	})
+}
+
+func TestGitRemoteRepoDumbConditional(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dir        = t.TempDir()
+		objectPath = "/objects/ab/" + strings.Repeat("c", 38)
+		modTime    = time.Now().Add(-time.Hour).Truncate(time.Second)
+	)
+
+	for _, p := range []string{"/HEAD", objectPath} {
+		fullPath := filepath.Join(dir, filepath.FromSlash(p))
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		require.NoError(t, os.WriteFile(fullPath, []byte("contents"), 0644))
+		require.NoError(t, os.Chtimes(fullPath, modTime, modTime))
+	}
+
+	g := &GitRemoteRepo{Path: dir, DumbHTTP: true}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	serve := func(
+		t *testing.T, method, path string, header http.Header,
+	) (
+		*httptest.ResponseRecorder, error,
+	) {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest(method, path, nil)
+		)
+
+		r.Header = header
+		r = r.WithContext(context.WithValue(
+			r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+		))
+
+		return rw, g.ServeHTTP(rw, r, nil)
+	}
+
+	t.Log("Checking that an object is served with validators")
+	rw, err := serve(t, "GET", objectPath, http.Header{})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "contents", rw.Body.String())
+	assert.Equal(t, "application/x-git-loose-object", rw.Header().Get("Content-Type"))
+	assert.Equal(t, modTime.UTC().Format(http.TimeFormat), rw.Header().Get("Last-Modified"))
+
+	etag := rw.Header().Get("Etag")
+	require.NotEmpty(t, etag)
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		header    http.Header
+		expStatus int
+	}{
+		{
+			name:      "matching etag",
+			method:    "GET",
+			path:      objectPath,
+			header:    http.Header{"If-None-Match": {etag}},
+			expStatus: http.StatusNotModified,
+		},
+		{
+			name:      "other etag",
+			method:    "GET",
+			path:      objectPath,
+			header:    http.Header{"If-None-Match": {`"other"`}},
+			expStatus: http.StatusOK,
+		},
+		{
+			name:   "not modified since",
+			method: "GET",
+			path:   "/HEAD",
+			header: http.Header{
+				"If-Modified-Since": {modTime.UTC().Format(http.TimeFormat)},
+			},
+			expStatus: http.StatusNotModified,
+		},
+		{
+			name:   "modified since",
+			method: "GET",
+			path:   "/HEAD",
+			header: http.Header{
+				"If-Modified-Since": {
+					modTime.Add(-time.Minute).UTC().Format(http.TimeFormat),
+				},
+			},
+			expStatus: http.StatusOK,
+		},
+		{
+			name:      "HEAD matching etag",
+			method:    "HEAD",
+			path:      objectPath,
+			header:    http.Header{"If-None-Match": {etag}},
+			expStatus: http.StatusNotModified,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			rw, err := serve(t, test.method, test.path, test.header)
+			require.NoError(t, err)
+			assert.Equal(t, test.expStatus, rw.Code)
+
+			if test.expStatus == http.StatusNotModified {
+				assert.Empty(t, rw.Body.String())
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+		_, err := serve(t, "GET", "/objects/info/packs", http.Header{})
+
+		var hErr caddyhttp.HandlerError
+		require.True(t, errors.As(err, &hErr))
+		assert.Equal(t, http.StatusNotFound, hErr.StatusCode)
+	})
+}
+
+func TestGitRemoteRepoDumbDisabled(t *testing.T) {
+	t.Parallel()
+
+	var (
+		dir        = filepath.Join(t.TempDir(), "repo.git")
+		objectPath = "/objects/ab/" + strings.Repeat("c", 38)
+	)
+
+	g := &GitRemoteRepo{Path: dir}
+	require.NoError(t, g.Provision(caddy.Context{}))
+	require.NoError(t, g.Validate())
+
+	tests := []struct {
+		name      string
+		path      string
+		expStatus int
+	}{
+		{name: "HEAD", path: "/HEAD", expStatus: http.StatusForbidden},
+		{name: "object", path: objectPath, expStatus: http.StatusForbidden},
+		{name: "info/refs", path: "/info/refs", expStatus: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				rw = httptest.NewRecorder()
+				r  = httptest.NewRequest("GET", test.path, nil)
+			)
+
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			))
+
+			require.NoError(t, g.ServeHTTP(rw, r, nil))
+			assert.Equal(t, test.expStatus, rw.Code)
+		})
+	}
+}
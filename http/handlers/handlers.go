@@ -1,2 +1,26 @@
 // Package handlers implements extra HTTP middlewares.
 package handlers
+
+import (
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+// parseOnOff parses the next argument as either `on` or `off`, returning true
+// for `on`.
+func parseOnOff(h httpcaddyfile.Helper) (bool, error) {
+	var v string
+	if !h.Args(&v) {
+		return false, h.ArgErr()
+	}
+
+	switch v {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("expected 'on' or 'off', got %q", v)
+	}
+}
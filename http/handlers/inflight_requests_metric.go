@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	caddy.RegisterModule(InflightRequestsMetric{})
+	httpcaddyfile.RegisterHandlerDirective("inflight_requests_metric", inflightRequestsMetricParseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder(
+		"inflight_requests_metric", httpcaddyfile.Before, "tracing",
+	)
+}
+
+// InflightRequestsMetric is an HTTP middleware module which will passthrough
+// all requests untouched, tracking the number of requests currently being
+// handled under a gauge metric defined as part of the
+// `mediocre_caddy_plugins.metrics` global configuration.
+type InflightRequestsMetric struct {
+	// Name refers to the name of a gauge defined as part of the
+	// `mediocre_caddy_plugins.metrics` global configuration.
+	Name string `json:"name"`
+
+	// Labels will be included as the labels of the gauge. The label keys must
+	// match 1:1 with the labels defined in the global config for the gauge.
+	//
+	// The label values may have placeholders in them, but as they are
+	// replaced before the request is handled only placeholders which are
+	// available at that point may be used, i.e. those relating to the request.
+	// The `{http.route_name}` placeholder is also available, but will only take
+	// the value of the `route_name` variable if it's set before this handler.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// MaxSeries limits the number of distinct label value combinations which
+	// this handler will create. See RequestResponseHistogramMetric.
+	MaxSeries int `json:"max_series,omitempty"`
+
+	// RouteName is made available to label values as the `{http.route_name}`
+	// placeholder. See RequestResponseHistogramMetric.
+	RouteName string `json:"route_name,omitempty"`
+
+	gauge           *prometheus.GaugeVec
+	hasPlaceholders bool
+	seriesLimiter   *seriesLimiter
+}
+
+var _ caddyhttp.MiddlewareHandler = (*InflightRequestsMetric)(nil)
+
+func (InflightRequestsMetric) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.inflight_requests_metric",
+		New: func() caddy.Module { return new(InflightRequestsMetric) },
+	}
+}
+
+func (m *InflightRequestsMetric) Provision(ctx caddy.Context) error {
+	var err error
+	if m.gauge, err = globalGauge(ctx, m.Name); err != nil {
+		return err
+	}
+
+	return m.provision(ctx)
+}
+
+// provision sets up everything but the gauge itself.
+func (m *InflightRequestsMetric) provision(ctx caddy.Context) error {
+	m.hasPlaceholders = hasLabelPlaceholders(m.Labels)
+
+	if m.MaxSeries < 0 {
+		return errors.New("max_series cannot be negative")
+	}
+
+	if m.MaxSeries > 0 && m.hasPlaceholders {
+		m.seriesLimiter = newMetricSeriesLimiter(ctx, m.Name, m.MaxSeries, m.Labels)
+	}
+
+	return nil
+}
+
+func (m *InflightRequestsMetric) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	labels := m.Labels
+	if m.hasPlaceholders {
+		labels = replaceLabelPlaceholders(
+			r.Context(), labels, m.RouteName, m.seriesLimiter,
+		)
+	}
+
+	gauge := m.gauge.With(prometheus.Labels(labels))
+	gauge.Inc()
+	defer gauge.Dec()
+
+	return next.ServeHTTP(rw, r)
+}
+
+// inflightRequestsMetricParseCaddyfile sets up the handler from Caddyfile
+// tokens. Syntax:
+//
+//	inflight_requests_metric <name> {
+//		// label can be specified multiple times, its value can have
+//		// request placeholders, as well as http.route_name.
+//		label name value
+//
+//		max_series <n>
+//
+//		route_name <name>
+//	}
+func inflightRequestsMetricParseCaddyfile(
+	h httpcaddyfile.Helper,
+) (
+	caddyhttp.MiddlewareHandler, error,
+) {
+	h.Next() // consume directive name
+
+	m := &InflightRequestsMetric{Labels: map[string]string{}}
+	if !h.Args(&m.Name) {
+		return nil, h.ArgErr()
+	}
+
+	for h.NextBlock(0) {
+		switch h.Val() {
+		case "label":
+			var k, v string
+			if !h.Args(&k, &v) {
+				return nil, h.ArgErr()
+			}
+			m.Labels[k] = v
+
+		case "max_series":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if m.MaxSeries, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+
+		case "route_name":
+			if !h.Args(&m.RouteName) {
+				return nil, h.ArgErr()
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown field: %q", h.Val())
+		}
+	}
+
+	return m, nil
+}
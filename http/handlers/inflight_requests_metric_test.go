@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflightRequestsMetric(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		inflight_requests_metric test_inflight {
+			label path {http.request.uri.path}
+		}
+	`)}
+
+	handler, err := inflightRequestsMetricParseCaddyfile(h)
+	require.NoError(t, err)
+
+	m := handler.(*InflightRequestsMetric)
+	m.gauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "test_inflight", Help: "Test."},
+		[]string{"path"},
+	)
+	require.NoError(t, m.provision(caddy.Context{}))
+
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+		next    = caddyhttp.HandlerFunc(func(http.ResponseWriter, *http.Request) error {
+			started <- struct{}{}
+			<-release
+			return errors.New("handler error")
+		})
+	)
+
+	serve := func(path string) error {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest("GET", path, nil)
+		)
+
+		r = r.WithContext(context.WithValue(
+			r.Context(), caddy.ReplacerCtxKey, caddyhttp.NewTestReplacer(r),
+		))
+
+		return m.ServeHTTP(rw, r, next)
+	}
+
+	inflight := func(path string) float64 {
+		return testutil.ToFloat64(m.gauge.WithLabelValues(path))
+	}
+
+	paths := []string{"/a", "/a", "/a", "/b"}
+
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Error(t, serve(path))
+		}()
+	}
+
+	for range paths {
+		<-started
+	}
+
+	t.Log("Checking that the gauge rises while requests are in flight")
+	assert.Equal(t, float64(3), inflight("/a"))
+	assert.Equal(t, float64(1), inflight("/b"))
+
+	t.Log("Checking that the gauge falls as requests complete")
+	release <- struct{}{}
+	release <- struct{}{}
+	assert.Eventually(t, func() bool {
+		return inflight("/a")+inflight("/b") == 2
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	wg.Wait()
+
+	t.Log("Checking that the gauge falls even if the handler errors")
+	assert.Equal(t, float64(0), inflight("/a"))
+	assert.Equal(t, float64(0), inflight("/b"))
+}
+
+func TestInflightRequestsMetricParseCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		inflight_requests_metric test_inflight {
+			label route {http.route_name}
+			max_series 10
+			route_name api
+		}
+	`)}
+
+	handler, err := inflightRequestsMetricParseCaddyfile(h)
+	require.NoError(t, err)
+	assert.Equal(t, &InflightRequestsMetric{
+		Name:      "test_inflight",
+		Labels:    map[string]string{"route": "{http.route_name}"},
+		MaxSeries: 10,
+		RouteName: "api",
+	}, handler)
+
+	t.Log("Checking that unknown fields are rejected")
+	_, err = inflightRequestsMetricParseCaddyfile(httpcaddyfile.Helper{
+		Dispenser: caddyfile.NewTestDispenser(`
+			inflight_requests_metric test_inflight {
+				match status 200
+			}
+		`),
+	})
+	assert.Error(t, err)
+}
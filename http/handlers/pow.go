@@ -1,20 +1,38 @@
 package handlers
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"dev.mediocregopher.com/mediocre-caddy-plugins.git/global"
 	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/pow"
+	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/toolkit"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 
 	_ "embed"
@@ -30,8 +48,138 @@ func init() {
 
 const (
 	powSolutionRequiredHeaderName = "X-POW-Solution-Required"
+
+	// Headers which are set by pow.js on a request made immediately after
+	// solving a challenge, reporting on how the solve went.
+	powHashRateHeaderName   = "X-POW-Hash-Rate"
+	powSolveTimeHeaderName  = "X-POW-Solve-Time"
+	powIterationsHeaderName = "X-POW-Iterations"
+
+	// Headers which may carry a hex-encoded seed and solution, in place of
+	// the cookies, when JSONChallenge is enabled.
+	powSeedHeaderName     = "X-POW-Seed"
+	powSolutionHeaderName = "X-POW-Solution"
+)
+
+// powJSONChallenge is the body of the challenge response served to clients
+// which accept JSON, when JSONChallenge is enabled.
+type powJSONChallenge struct {
+	Seed           string   `json:"seed"`
+	Target         uint32   `json:"target"`
+	Hash           pow.Hash `json:"hash"`
+	SeedCookie     string   `json:"seed_cookie"`
+	SolutionCookie string   `json:"solution_cookie"`
+	SeedHeader     string   `json:"seed_header"`
+	SolutionHeader string   `json:"solution_header"`
+}
+
+// Actions which can be taken when a client reports implausibly few iterations.
+const (
+	powLowIterationsActionLog         = "log"
+	powLowIterationsActionRechallenge = "rechallenge"
+)
+
+// Bounds on the ChallengeTimeout which can be configured.
+const (
+	powMinChallengeTimeout = 10 * time.Second
+	powMaxChallengeTimeout = 30 * 24 * time.Hour
+)
+
+// Constants related to the JS-free fallback.
+const (
+	// powChallengeAttemptCookieName is the cookie which is set on clients when
+	// they are first presented with a challenge, so that it can be detected if
+	// they come back without having solved it.
+	powChallengeAttemptCookieName = "__pow_challenge_attempt"
+
+	// powMinJSFreeFallbackTarget is the most difficult target which may be used
+	// for the JS-free fallback, since the server must solve those challenges
+	// itself.
+	powMinJSFreeFallbackTarget = 0x0FFFFFFF
+
+	powDefaultJSFreeFallbackTarget = 0x3FFFFFFF
+
+	// powJSFreeFallbackSolveTimeout bounds the amount of time the server will
+	// spend solving a JS-free fallback challenge.
+	powJSFreeFallbackSolveTimeout = 1 * time.Second
 )
 
+// Names of the metrics maintained by ProofOfWork.
+const (
+	powChallengesMetricName = metricsNamespace + "_pow_challenges_total"
+	powSolutionsMetricName  = metricsNamespace + "_pow_solutions_total"
+
+	powChallengesMetricHelp = "Number of proof-of-work challenges issued, by the reason the request's solution was not accepted"
+	powSolutionsMetricHelp  = "Number of valid proof-of-work solutions accepted"
+)
+
+// errPowSolutionNotGiven is produced by checkSolution when the request doesn't
+// carry a seed and solution.
+var errPowSolutionNotGiven = errors.New("seed and/or solution not given")
+
+// powDefaultChallengeHeaders are set on challenge responses, unless
+// overridden by ChallengeHeaders.
+var powDefaultChallengeHeaders = map[string]string{
+	"X-Robots-Tag":  "noindex",
+	"Cache-Control": "no-store",
+}
+
+// powWebCryptoHashNames map each solution hash to the name of the same
+// algorithm in the WebCrypto API, as used by the challenge page's JS.
+var powWebCryptoHashNames = map[pow.Hash]string{
+	pow.HashSHA256: "SHA-256",
+	pow.HashSHA512: "SHA-512",
+}
+
+// powCookieSameSiteModes map each CookieSameSite value to its SameSite mode.
+var powCookieSameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// powPassTokenCookieName is the cookie in which pass tokens are stored, when
+// PassToken is enabled.
+const powPassTokenCookieName = "__pow_pass_token"
+
+// powReturningCookieName is the cookie in which a signed marker is stored once
+// a client has passed a challenge, when InitialTarget is given.
+const powReturningCookieName = "__pow_returning"
+
+// powReturningLifetime is how long a client is considered to be returning for
+// after passing a challenge, when InitialTarget is given.
+const powReturningLifetime = 30 * 24 * time.Hour
+
+// powJSFreeFallbackHTML is served to clients which have been given a
+// server-solved challenge. The solution is set in cookies along with this
+// response, so all the client must do is refresh.
+const powJSFreeFallbackHTML = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta http-equiv="refresh" content="0">
+    <title>Now hold it right there...</title>
+  </head>
+  <body>Checking that you're human...</body>
+</html>
+`
+
+// powNoscriptTpl refreshes clients which don't support javascript, so that they
+// may be given the JS-free fallback.
+const powNoscriptTpl = `{{ if .JSFreeFallback }}` +
+	`<noscript><meta http-equiv="refresh" content="1"></noscript>` +
+	`{{ end }}`
+
+// powSlowClientHashRate is the number of hashes per second which a slow client
+// (e.g. an old phone) can be expected to perform while solving a challenge.
+const powSlowClientHashRate = 10_000
+
+// powExpectedSolveTime returns how long a slow client can be expected to take
+// to solve a challenge with the given target.
+func powExpectedSolveTime(target uint32) time.Duration {
+	secs := float64(pow.ExpectedIterations(target)) / powSlowClientHashRate
+	return time.Duration(secs * float64(time.Second))
+}
+
 var (
 	//go:embed pow.js
 	powJS string
@@ -58,22 +206,82 @@ type ProofOfWork struct {
 	// be shared with clients, but _must_ be shared amongst all Caddy servers
 	// which are serving the same domain.
 	//
-	// If not given then one will be generated on startup. Note that in this
-	// case restarting Caddy will result in all clients requiring a new PoW
-	// solution.
+	// Global placeholders, such as `{env.POW_SECRET}` or
+	// `{file./run/secrets/pow}`, will be expanded on startup, so that the
+	// secret itself need not be included in the config. It is an error for a
+	// placeholder to be unknown or to expand to an empty value.
+	//
+	// If not given then one will be generated on startup, unless SecretFile is
+	// given. Note that in this case restarting Caddy will result in all
+	// clients requiring a new PoW solution.
 	Secret string `json:"secret,omitempty"`
 
+	// SecretFile is the path to a file containing a hex-encoded secret, to be
+	// used in place of Secret. If the file doesn't exist then a secret will be
+	// generated and written to it with 0600 permissions, so that the secret
+	// remains stable across restarts without being included in the config.
+	//
+	// Cannot be given alongside Secret.
+	SecretFile string `json:"secret_file,omitempty"`
+
+	// If given then SecretFile will be checked for changes at this interval,
+	// and the secret replaced without requiring a restart. Challenges and pass
+	// tokens signed using the previous secret will continue to be accepted, as
+	// if it were one of the OldSecrets, until the secret changes again. If the
+	// file can't be read or is malformed then an error is logged and the
+	// current secret continues to be used.
+	//
+	// The template at TemplatePath is read for every challenge, and so changes
+	// to it are picked up without this.
+	SecretFileWatchInterval time.Duration `json:"secret_file_watch_interval,omitempty"`
+
+	// OldSecrets are previously used values of Secret. Challenges and pass
+	// tokens signed using them will still be accepted, but new ones will only
+	// be signed using Secret. This allows the secret to be rotated without
+	// every client being required to solve a new challenge: the new secret is
+	// deployed with the old one given here, which can then be removed once
+	// ChallengeTimeout (and PassTokenLifetime) have elapsed.
+	//
+	// Placeholders are expanded in the same way as for Secret. OldSecrets
+	// also apply to HostConfigs which don't have their own Secret.
+	OldSecrets []string `json:"old_secrets,omitempty"`
+
 	// Target is a uint32 indicating how difficult each challenge will be to
 	// solve. A _lower_ Target value is more difficult than a higher one.
 	//
 	// Defaults to 0x000FFFFF
 	Target uint32 `json:"target,omitempty"`
 
+	// Hash is the algorithm which clients must use to solve challenges, either
+	// `sha256` or `sha512`. SHA-256 is cheaper to compute on 32-bit devices,
+	// though note that this makes each solve attempt cheaper for all clients,
+	// and so Target may need to be lowered to compensate. Challenges embed the
+	// algorithm they were issued with, so changing it doesn't invalidate
+	// outstanding challenges.
+	//
+	// Custom templates must use the `.HashAlgorithm` field, which contains the
+	// WebCrypto name of the algorithm, e.g. `SHA-512`, when solving.
+	//
+	// Defaults to `sha512`.
+	Hash string `json:"hash,omitempty"`
+
+	// SignatureHash is the algorithm used to sign challenge seeds using the
+	// Secret, one of `md5`, `sha256`, or `sha512`. Seeds signed using any of
+	// these are accepted, so that this can be changed without invalidating
+	// outstanding challenges. All Caddy servers sharing the same Secret must
+	// support the chosen algorithm.
+	//
+	// Defaults to `md5`.
+	SignatureHash string `json:"signature_hash,omitempty"`
+
 	// ChallengeTimeout indicates how long before Challenges are considered
 	// expired and cannot be solved. Any solutions are also expired, and
 	// browsers will be redirected back to the challenge page to solve a new
 	// challenge.
 	//
+	// Must be between 10s and 720h (30 days). A value of 0 indicates the
+	// default should be used.
+	//
 	// Defaults to 12h.
 	ChallengeTimeout time.Duration `json:"challenge_timeout,omitempty"`
 
@@ -89,18 +297,330 @@ type ProofOfWork struct {
 	// Defaults to "__pow_challenge_solution".
 	ChallengeSolutionCookie string `json:"challenge_solution_cookie,omitempty"`
 
+	// CookiePath is the path which all cookies set by this handler will be
+	// scoped to. If only part of a site is protected then this can be used to
+	// prevent the cookies from being sent on requests to other parts.
+	//
+	// Defaults to "/".
+	CookiePath string `json:"cookie_path,omitempty"`
+
+	// CookieDomain is the domain which all cookies set by this handler will be
+	// scoped to, e.g. `example.com` in order for a solution to be shared by
+	// all of its subdomains. If not given then cookies are scoped to the host
+	// of the request.
+	CookieDomain string `json:"cookie_domain,omitempty"`
+
+	// CookieSameSite is the SameSite attribute of all cookies set by this
+	// handler, one of `lax`, `strict`, or `none`. If `none` then CookieSecure
+	// must be set, as browsers will otherwise reject the cookies.
+	//
+	// Defaults to `lax`.
+	CookieSameSite string `json:"cookie_same_site,omitempty"`
+
+	// If true then all cookies set by this handler will have the Secure
+	// attribute, and so will only be sent by browsers over HTTPS.
+	CookieSecure bool `json:"cookie_secure,omitempty"`
+
 	// Path to HTML template to render in the browser when it is being
 	// challenged. If not given then a simple default is shown.
 	//
 	// The template file should include the line
 	// `<script>{{ template "pow.js" . }}</script>` at the end of the `body`
 	// tag. This script will solve a challenge, set the solution to a cookie,
-	// and reload the page.
+	// and return to the originally requested URI, which is available to the
+	// template as `.OrigURI`.
 	TemplatePath string `json:"template"`
 
-	store  pow.Store
-	mgr    pow.Manager
-	logger *zap.Logger
+	// If greater than zero then the challenge page's javascript will use at
+	// most this many WebWorkers to solve the challenge in parallel. By default
+	// one worker is used per logical CPU core, as reported by the browser.
+	// Browsers which can't run WebWorkers solve the challenge on the page's
+	// main thread. Available to the template as `.WorkerCount`.
+	SolverWorkers int `json:"solver_workers,omitempty"`
+
+	// If true then requests whose Accept header includes `application/json`
+	// will be challenged with a 401 response containing the challenge as
+	// JSON, rather than with the HTML page, so that clients which can't
+	// execute the challenge's javascript can solve it natively. The response
+	// body takes the form:
+	//
+	//	{
+	//	  "seed": "<hex>",
+	//	  "target": 1048575,
+	//	  "hash": "sha512",
+	//	  "seed_cookie": "__pow_challenge_seed",
+	//	  "solution_cookie": "__pow_challenge_solution",
+	//	  "seed_header": "X-POW-Seed",
+	//	  "solution_header": "X-POW-Solution"
+	//	}
+	//
+	// A solution is found as described by the pow package's Challenge type,
+	// using the given hash. The seed and hex-encoded solution can then be
+	// given either in the named cookies, as browsers do, or in the named
+	// headers.
+	JSONChallenge bool `json:"json_challenge,omitempty"`
+
+	// JSFreeFallback, if true, enables an experimental fallback for clients
+	// which are not able to execute the challenge's javascript, but which do
+	// support cookies.
+	//
+	// When a client returns without a solution after being presented with a
+	// challenge, the server will generate a challenge with a very low
+	// difficulty (see JSFreeFallbackTarget), solve it itself, and set the
+	// solution into the client's cookies along with a meta-refresh.
+	//
+	// Note that this allows any client which supports cookies to pass the
+	// proof-of-work check without performing any work of its own, at the cost
+	// of server CPU. The custom template, if one is given, must include
+	// `{{ template "pow-noscript" . }}` in its `head` tag so that clients
+	// without javascript are refreshed into the fallback.
+	JSFreeFallback bool `json:"js_free_fallback,omitempty"`
+
+	// JSFreeFallbackTarget is the Target used for challenges generated by the
+	// JSFreeFallback. To bound the cost to the server it may not be lower (more
+	// difficult) than 0x0FFFFFFF.
+	//
+	// Defaults to 0x3FFFFFFF.
+	JSFreeFallbackTarget uint32 `json:"js_free_fallback_target,omitempty"`
+
+	// LowIterationsRatio, if greater than zero, causes solve reports from
+	// clients whose reported number of iterations is less than this fraction
+	// of the expected number of iterations for the target to be flagged.
+	// Implausibly low iteration counts may indicate that a solution was
+	// precomputed or shared between clients.
+	//
+	// The number of iterations needed to find a solution varies wildly, so
+	// this should be small. Roughly this fraction of honest clients will also
+	// be flagged.
+	LowIterationsRatio float64 `json:"low_iterations_ratio,omitempty"`
+
+	// LowIterationsAction determines what happens when a solve report is
+	// flagged by LowIterationsRatio. `log` (the default) logs a warning, while
+	// `rechallenge` additionally revokes the solution's seed and clears the
+	// client's solution and pass token, so that it must solve a new challenge.
+	// Pass tokens issued prior to the report are not revoked.
+	LowIterationsAction string `json:"low_iterations_action,omitempty"`
+
+	// PassToken, if true, causes clients which present a valid solution to be
+	// given a self-contained pass token cookie, signed using the Secret. Pass
+	// tokens are checked without consulting the solution store, and so will
+	// be accepted by any Caddy server sharing the same Secret, even without
+	// shared storage.
+	//
+	// Note that pass tokens cannot be revoked prior to their expiry.
+	PassToken bool `json:"pass_token,omitempty"`
+
+	// PassTokenLifetime indicates how long pass tokens are valid for once
+	// issued.
+	//
+	// Defaults to the ChallengeTimeout.
+	PassTokenLifetime time.Duration `json:"pass_token_lifetime,omitempty"`
+
+	// ForwardPassHeader, if given, causes a pass token to be set in this
+	// request header on each request which has passed the challenge, before
+	// it is passed on to the next handler. Any value given by the client is
+	// removed. This allows an upstream Caddy server, sharing the same Secret
+	// and having TrustedPassHeader set, to accept requests which have passed
+	// the challenge here without needing access to their cookies.
+	//
+	// Forwarded pass tokens are valid for the PassTokenLifetime.
+	ForwardPassHeader string `json:"forward_pass_header,omitempty"`
+
+	// TrustedPassHeader, if given, causes requests which carry a valid pass
+	// token in this request header, as set by a server with ForwardPassHeader,
+	// to be let through without their solution being checked.
+	TrustedPassHeader string `json:"trusted_pass_header,omitempty"`
+
+	// ChallengeHeaders are extra headers which will be set on responses which
+	// present a challenge. These are merged with the defaults of
+	// `X-Robots-Tag: noindex` and `Cache-Control: no-store`, so that search
+	// engines don't index the challenge page and browsers don't cache it. A
+	// header given with an empty value will not be set at all, allowing a
+	// default to be removed.
+	ChallengeHeaders map[string]string `json:"challenge_headers,omitempty"`
+
+	// TrustHeader is the name of a request header, set by an upstream
+	// reputation system, containing a numeric trust score for the request.
+	// When given, the score is mapped onto a challenge difficulty using
+	// TrustTiers. If the header is absent or not a number then DefaultTarget
+	// is used.
+	TrustHeader string `json:"trust_header,omitempty"`
+
+	// DefaultTarget is the Target used when TrustHeader is given but a request
+	// has no usable trust score, i.e. the header is absent or not a number.
+	// This is distinct from Target, which remains the baseline used for
+	// requests whose score doesn't fall within any of the TrustTiers.
+	//
+	// Defaults to Target.
+	DefaultTarget uint32 `json:"default_target,omitempty"`
+
+	// TrustTiers map ranges of trust scores onto challenge difficulties. The
+	// tier with the highest MinScore which is not greater than the request's
+	// score is used. If no tier applies then Target is used.
+	//
+	// Note that challenge solutions are accepted regardless of the tier they
+	// were issued for.
+	TrustTiers []ProofOfWorkTrustTier `json:"trust_tiers,omitempty"`
+
+	// PathTargets override the challenge difficulty for requests whose path
+	// has a particular prefix, e.g. to make an expensive endpoint harder to
+	// access than the rest of a site. If multiple prefixes match then the
+	// longest is used. PathTargets take precedence over TrustTiers and
+	// DefaultTarget, although requests within a trust tier having
+	// NoChallenge are still let through.
+	//
+	// As with TrustTiers, challenge solutions are accepted regardless of the
+	// path they were issued for. PathTargets do not apply to requests
+	// matching a HostConfig.
+	PathTargets []ProofOfWorkPathTarget `json:"path_targets,omitempty"`
+
+	// InitialTarget, if given, is the Target used for clients which haven't
+	// passed a challenge within the last 30 days, so that first-time visitors
+	// can be given an easier challenge than returning ones. Clients which pass
+	// a challenge are given a signed cookie marking them as returning, after
+	// which they are challenged using whichever target would otherwise apply.
+	//
+	// InitialTarget is only used if it is easier (i.e. greater) than the
+	// target which would otherwise apply, and does not apply to requests
+	// matching a HostConfig.
+	InitialTarget uint32 `json:"initial_target,omitempty"`
+
+	// If true then requests carrying an Authorization header are not
+	// challenged. The header's credentials are not validated, so this should
+	// only be used on routes where an authentication handler will reject
+	// requests with invalid credentials.
+	SkipAuthorized bool `json:"skip_authorized,omitempty"`
+
+	// If given then requests for which this value, after placeholder
+	// expansion, is not empty are not challenged, e.g.
+	// `{http.auth.user.id}`. Note that by default this handler is ordered
+	// before authentication handlers, so placeholders set by them will not be
+	// available unless the order is changed.
+	SkipPlaceholder string `json:"skip_placeholder,omitempty"`
+
+	// Allow is a list of IPs and CIDR ranges, e.g. `10.0.0.0/8`, whose
+	// requests are passed through without being challenged. The client IP is
+	// determined in the same way as for Caddy's `client_ip` matcher, i.e.
+	// taking the server's `trusted_proxies` into account. IPv4-mapped IPv6
+	// addresses are treated as their IPv4 equivalents.
+	Allow []string `json:"allow,omitempty"`
+
+	// HostConfigs override the Secret, Target, and ChallengeTimeout for
+	// requests whose host matches a pattern, allowing each host served by a
+	// single handler to have an independent proof-of-work policy. The first
+	// matching HostConfig is used. If none match then the top-level
+	// configuration is used.
+	//
+	// TrustTiers and DefaultTarget do not apply to requests matching a
+	// HostConfig.
+	HostConfigs []ProofOfWorkHostConfig `json:"host_configs,omitempty"`
+
+	// If given then requests which are passed on to the next handler without
+	// having solved a challenge, i.e. those skipped due to SkipAuthorized or
+	// SkipPlaceholder, or those in a trust tier with NoChallenge, will have
+	// their context cancelled once this duration has elapsed. This prevents
+	// clients which aren't challenged from holding connections open
+	// indefinitely. Requests with a valid solution or pass token are
+	// unaffected.
+	MaxUnverifiedDuration time.Duration `json:"max_unverified_duration,omitempty"`
+
+	// If greater than zero then each solution will only be accepted this many
+	// times, after which the client will be challenged again. Every request
+	// carrying the solution counts as a use, other than the solve report made
+	// by pow.js, so unless PassToken is enabled this limits the number of
+	// requests a client can make per solution.
+	MaxSolutionUses int `json:"max_solution_uses,omitempty"`
+
+	// If given then solutions will be stored in a Redis server, rather than
+	// in memory, so that a solution checked by one Caddy instance will be
+	// recognized by all others which share the same Secret and Redis server.
+	RedisStore *ProofOfWorkRedisStore `json:"redis_store,omitempty"`
+
+	store            pow.Store
+	cookieSameSite   http.SameSite
+	secret           []byte
+	swappableMgrs    []*powSwappableManager
+	stopWatch        context.CancelFunc
+	watchDone        chan struct{}
+	mgr              pow.Manager
+	defaultMgr       pow.Manager
+	fallbackMgr      pow.Manager
+	oldSecrets       [][]byte
+	allowPrefixes    []netip.Prefix
+	challengesMetric *prometheus.CounterVec
+	solutionsMetric  prometheus.Counter
+	trustTierMgrs    []pow.Manager
+	pathTargetMgrs   []pow.Manager
+	initialMgr       pow.Manager
+	returningMgr     pow.Manager
+	challengeHeaders http.Header
+	logger           *zap.Logger
+}
+
+// ProofOfWorkPathTarget describes the challenge difficulty which applies to
+// requests whose path starts with PathPrefix.
+type ProofOfWorkPathTarget struct {
+	PathPrefix string `json:"path_prefix"`
+	Target     uint32 `json:"target"`
+}
+
+// ProofOfWorkRedisStore describes a Redis server in which proof-of-work
+// solutions are stored.
+type ProofOfWorkRedisStore struct {
+	// Required `host:port` address of the Redis server.
+	Addr string `json:"addr"`
+
+	// Optional password to authenticate with. May contain placeholders, e.g.
+	// `{env.REDIS_PASSWORD}`.
+	Password string `json:"password,omitempty"`
+
+	// Optional database number to use.
+	DB int `json:"db,omitempty"`
+}
+
+// newStore returns a Store using the configured backend, with keys prefixed by
+// the given prefix if the backend is shared.
+func (p *ProofOfWork) newStore(keyPrefix string) pow.Store {
+	if p.RedisStore == nil {
+		return pow.NewMemoryStore(nil)
+	}
+
+	repl := caddy.NewReplacer()
+	return pow.NewRedisStore(pow.RedisStoreParams{
+		Addr:      repl.ReplaceAll(p.RedisStore.Addr, ""),
+		Password:  repl.ReplaceAll(p.RedisStore.Password, ""),
+		DB:        p.RedisStore.DB,
+		KeyPrefix: keyPrefix,
+	})
+}
+
+// ProofOfWorkTrustTier describes the challenge difficulty which applies to
+// requests whose trust score is at least MinScore.
+type ProofOfWorkTrustTier struct {
+	MinScore float64 `json:"min_score"`
+
+	// Target is the challenge Target used for requests in this tier.
+	Target uint32 `json:"target,omitempty"`
+
+	// If true then requests in this tier are not challenged at all.
+	NoChallenge bool `json:"no_challenge,omitempty"`
+}
+
+// ProofOfWorkHostConfig describes the proof-of-work parameters used for
+// requests whose host matches Host. Any parameter which is not given is
+// inherited from the top-level configuration.
+type ProofOfWorkHostConfig struct {
+	// Host is a pattern, in the syntax of [path.Match], which is matched
+	// against the host of the request, without port, e.g. `*.example.com`.
+	Host string `json:"host"`
+
+	Secret           string        `json:"secret,omitempty"`
+	Target           uint32        `json:"target,omitempty"`
+	ChallengeTimeout time.Duration `json:"challenge_timeout,omitempty"`
+
+	store       pow.Store
+	mgr         pow.Manager
+	fallbackMgr pow.Manager
 }
 
 var _ caddyhttp.MiddlewareHandler = (*ProofOfWork)(nil)
@@ -113,11 +633,43 @@ func (ProofOfWork) CaddyModule() caddy.ModuleInfo {
 }
 
 func (p *ProofOfWork) Provision(ctx caddy.Context) error {
-	secret := []byte(p.Secret)
-	if len(secret) == 0 {
-		secret = make([]byte, 32)
-		if _, err := rand.Read(secret); err != nil {
-			return fmt.Errorf("generating secret value: %w", err)
+	p.logger = ctx.Logger()
+
+	var err error
+	switch {
+	case p.Secret != "" && p.SecretFile != "":
+		return errors.New("secret and secret_file cannot both be given")
+	case p.Secret != "":
+		if p.secret, err = expandPowSecret(p.Secret); err != nil {
+			return err
+		}
+	case p.SecretFile != "":
+		if p.secret, err = loadOrCreatePowSecret(p.SecretFile); err != nil {
+			return err
+		}
+	default:
+		if p.secret, err = generatePowSecret(); err != nil {
+			return err
+		}
+	}
+
+	if p.Hash != "" && !slices.Contains(pow.SolutionHashes, pow.Hash(p.Hash)) {
+		return fmt.Errorf("unsupported hash %q", p.Hash)
+	} else if p.SignatureHash != "" && !slices.Contains(pow.SignatureHashes, pow.Hash(p.SignatureHash)) {
+		return fmt.Errorf("unsupported signature_hash %q", p.SignatureHash)
+	}
+
+	p.allowPrefixes = make([]netip.Prefix, len(p.Allow))
+	for i, allow := range p.Allow {
+		if p.allowPrefixes[i], err = parseIPPrefix(allow); err != nil {
+			return fmt.Errorf("parsing allow entry %q: %w", allow, err)
+		}
+	}
+
+	p.oldSecrets = make([][]byte, len(p.OldSecrets))
+	for i, oldSecret := range p.OldSecrets {
+		if p.oldSecrets[i], err = expandPowSecret(oldSecret); err != nil {
+			return fmt.Errorf("old secret %d: %w", i, err)
 		}
 	}
 
@@ -125,88 +677,1289 @@ func (p *ProofOfWork) Provision(ctx caddy.Context) error {
 		p.Target = 0x000FFFFF
 	}
 
-	if p.ChallengeSeedCookie == "" {
-		p.ChallengeSeedCookie = "__pow_challenge_seed"
+	if p.ChallengeTimeout == 0 {
+		p.ChallengeTimeout = 12 * time.Hour
+	}
+
+	// Clients need time to both solve the challenge and then make use of the
+	// solution, so give them a healthy margin over the expected solve time.
+	if expSolveTime := powExpectedSolveTime(p.Target); p.ChallengeTimeout < 10*expSolveTime {
+		p.logger.Warn(
+			"Challenge timeout may be too short for slow clients to solve challenges and navigate before expiry",
+			zap.Duration("challengeTimeout", p.ChallengeTimeout),
+			zap.Duration("expectedSolveTime", expSolveTime),
+			zap.Uint32("target", p.Target),
+		)
+	}
+
+	if p.PassTokenLifetime == 0 {
+		p.PassTokenLifetime = p.ChallengeTimeout
+	}
+
+	if p.ChallengeSeedCookie == "" {
+		p.ChallengeSeedCookie = "__pow_challenge_seed"
+	}
+
+	if p.CookiePath == "" {
+		p.CookiePath = "/"
+	}
+
+	if p.CookieSameSite == "" {
+		p.CookieSameSite = "lax"
+	}
+
+	var ok bool
+	if p.cookieSameSite, ok = powCookieSameSiteModes[p.CookieSameSite]; !ok {
+		return fmt.Errorf("unknown cookie_same_site %q", p.CookieSameSite)
+	}
+
+	if p.ChallengeSolutionCookie == "" {
+		p.ChallengeSolutionCookie = "__pow_challenge_solution"
+	}
+
+	p.challengeHeaders = http.Header{}
+	for name, value := range powDefaultChallengeHeaders {
+		p.challengeHeaders.Set(name, value)
+	}
+	for name, value := range p.ChallengeHeaders {
+		if value == "" {
+			p.challengeHeaders.Del(name)
+		} else {
+			p.challengeHeaders.Set(name, value)
+		}
+	}
+
+	if err := p.provisionMetrics(ctx); err != nil {
+		return err
+	}
+
+	p.store = p.newStore("")
+	p.mgr = p.newManager(func(secret []byte, oldSecrets [][]byte) pow.Manager {
+		return pow.NewManager(p.store, secret, &pow.ManagerOpts{
+			Target:           p.Target,
+			ChallengeTimeout: p.ChallengeTimeout,
+			OldSecrets:       oldSecrets,
+			Hash:             pow.Hash(p.Hash),
+			SignatureHash:    pow.Hash(p.SignatureHash),
+			MaxSolutionUses:  p.MaxSolutionUses,
+			OnStoreError: func(err error) {
+				p.logger.Error("Failed to store proof-of-work solution", zap.Error(err))
+			},
+		})
+	})
+
+	// Like the trust tiers, solutions to challenges issued by the default
+	// manager are accepted by the primary manager.
+	p.defaultMgr = p.mgr
+	if p.DefaultTarget != 0 && p.DefaultTarget != p.Target {
+		p.defaultMgr = p.newPowManager(p.DefaultTarget)
+	}
+
+	// Solutions to challenges issued by any tier are accepted by the primary
+	// manager, since the target is embedded in the seed.
+	slices.SortFunc(p.TrustTiers, func(a, b ProofOfWorkTrustTier) int {
+		return cmp.Compare(a.MinScore, b.MinScore)
+	})
+	p.trustTierMgrs = make([]pow.Manager, len(p.TrustTiers))
+	for i, tier := range p.TrustTiers {
+		if tier.NoChallenge {
+			continue
+		}
+		p.trustTierMgrs[i] = p.newPowManager(tier.Target)
+	}
+
+	p.pathTargetMgrs = make([]pow.Manager, len(p.PathTargets))
+	for i, pathTarget := range p.PathTargets {
+		p.pathTargetMgrs[i] = p.newPowManager(pathTarget.Target)
+	}
+
+	if p.InitialTarget != 0 {
+		p.initialMgr = p.newPowManager(p.InitialTarget)
+
+		// Returning markers are signed in the same way as pass tokens, and so
+		// must use a distinct secret in order not to be accepted as them.
+		p.returningMgr = p.newManager(func(secret []byte, oldSecrets [][]byte) pow.Manager {
+			return pow.NewManager(
+				p.store,
+				powReturningSecret(secret),
+				&pow.ManagerOpts{OldSecrets: powReturningSecrets(oldSecrets)},
+			)
+		})
+	}
+
+	if p.JSFreeFallback {
+		if p.JSFreeFallbackTarget == 0 {
+			p.JSFreeFallbackTarget = powDefaultJSFreeFallbackTarget
+		}
+
+		// The target is embedded in the seed, so challenges generated by the
+		// fallback manager are still accepted by the primary one.
+		p.fallbackMgr = p.newPowManager(p.JSFreeFallbackTarget)
+	}
+
+	for i := range p.HostConfigs {
+		if err := p.provisionHostConfig(&p.HostConfigs[i]); err != nil {
+			return fmt.Errorf(
+				"provisioning host config %q: %w", p.HostConfigs[i].Host, err,
+			)
+		}
+	}
+
+	if p.SecretFile != "" && p.SecretFileWatchInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.stopWatch = cancel
+		p.watchDone = make(chan struct{})
+		go p.watchSecretFile(ctx)
+	}
+
+	return nil
+}
+
+// powManagerBuilder returns a Manager which signs using the given secret and
+// accepts the given old secrets.
+type powManagerBuilder func(secret []byte, oldSecrets [][]byte) pow.Manager
+
+// newManager returns a Manager built using the current secret. If
+// SecretFileWatchInterval is given then the Manager will be rebuilt whenever
+// the secret is reloaded.
+func (p *ProofOfWork) newManager(build powManagerBuilder) pow.Manager {
+	if p.SecretFileWatchInterval <= 0 {
+		return build(p.secret, p.oldSecrets)
+	}
+
+	m := &powSwappableManager{build: build}
+	m.swap(p.secret, p.oldSecrets)
+	p.swappableMgrs = append(p.swappableMgrs, m)
+	return m
+}
+
+// newPowManager returns a Manager built using the current secret, which issues
+// challenges with the given target but otherwise uses the default options.
+func (p *ProofOfWork) newPowManager(target uint32) pow.Manager {
+	return p.newManager(func(secret []byte, oldSecrets [][]byte) pow.Manager {
+		return pow.NewManager(p.store, secret, &pow.ManagerOpts{
+			Target:           target,
+			ChallengeTimeout: p.ChallengeTimeout,
+			OldSecrets:       oldSecrets,
+			Hash:             pow.Hash(p.Hash),
+			SignatureHash:    pow.Hash(p.SignatureHash),
+		})
+	})
+}
+
+// watchSecretFile reloads the SecretFile every SecretFileWatchInterval until
+// the context is cancelled.
+func (p *ProofOfWork) watchSecretFile(ctx context.Context) {
+	defer close(p.watchDone)
+
+	ticker := time.NewTicker(p.SecretFileWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.reloadSecretFile()
+		}
+	}
+}
+
+// reloadSecretFile reads the SecretFile and, if the secret has changed,
+// rebuilds all Managers which use it. The previous secret remains accepted
+// until the next change.
+func (p *ProofOfWork) reloadSecretFile() {
+	secret, err := readPowSecretFile(p.SecretFile)
+	if err != nil {
+		p.logger.Error(
+			"Failed to reload proof-of-work secret file, the current secret will continue to be used",
+			zap.String("path", p.SecretFile),
+			zap.Error(err),
+		)
+		return
+	} else if bytes.Equal(secret, p.secret) {
+		return
+	}
+
+	oldSecrets := append([][]byte{p.secret}, p.oldSecrets...)
+	for _, m := range p.swappableMgrs {
+		m.swap(secret, oldSecrets)
+	}
+
+	p.secret = secret
+	p.logger.Info(
+		"Reloaded proof-of-work secret file", zap.String("path", p.SecretFile),
+	)
+}
+
+// powSwappableManager is a pow.Manager which delegates to an underlying
+// Manager, which is rebuilt whenever the secret changes.
+type powSwappableManager struct {
+	build powManagerBuilder
+	mgr   atomic.Pointer[pow.Manager]
+}
+
+var _ pow.Manager = (*powSwappableManager)(nil)
+
+func (m *powSwappableManager) swap(secret []byte, oldSecrets [][]byte) {
+	mgr := m.build(secret, oldSecrets)
+	m.mgr.Store(&mgr)
+}
+
+func (m *powSwappableManager) get() pow.Manager {
+	return *m.mgr.Load()
+}
+
+func (m *powSwappableManager) NewChallenge() pow.Challenge {
+	return m.get().NewChallenge()
+}
+
+func (m *powSwappableManager) CheckSolution(seed, solution []byte) error {
+	return m.get().CheckSolution(seed, solution)
+}
+
+func (m *powSwappableManager) PeekSolution(seed, solution []byte) error {
+	return m.get().PeekSolution(seed, solution)
+}
+
+func (m *powSwappableManager) RevokeSeed(seed []byte) error {
+	return m.get().RevokeSeed(seed)
+}
+
+func (m *powSwappableManager) NewPassToken(lifetime time.Duration) []byte {
+	return m.get().NewPassToken(lifetime)
+}
+
+func (m *powSwappableManager) CheckPassToken(token []byte) error {
+	return m.get().CheckPassToken(token)
+}
+
+// expandPowSecret expands any placeholders in a configured secret, erroring if
+// any are unknown or empty.
+func expandPowSecret(secretCfg string) ([]byte, error) {
+	secretStr, err := caddy.NewReplacer().ReplaceOrErr(secretCfg, true, true)
+	if err != nil {
+		return nil, fmt.Errorf("expanding placeholders in secret: %w", err)
+	}
+	return []byte(secretStr), nil
+}
+
+// provisionMetrics registers the handler's metrics with Caddy's metrics
+// registry, if there is one.
+func (p *ProofOfWork) provisionMetrics(ctx caddy.Context) error {
+	reg := ctx.GetMetricsRegistry()
+	if reg == nil {
+		return p.registerMetrics(prometheus.NewRegistry())
+	}
+
+	if err := p.registerMetrics(reg); err != nil {
+		return err
+	}
+
+	for _, desc := range []global.MetricDescription{
+		{
+			Name:   powChallengesMetricName,
+			Type:   "counter",
+			Help:   powChallengesMetricHelp,
+			Labels: []string{"reason"},
+		},
+		{
+			Name: powSolutionsMetricName,
+			Type: "counter",
+			Help: powSolutionsMetricHelp,
+		},
+	} {
+		if err := describeMetric(ctx, desc); err != nil {
+			return fmt.Errorf("describing counter %q: %w", desc.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// registerMetrics registers the handler's metrics with the given registerer,
+// reusing existing metrics if they've already been registered by another
+// ProofOfWork handler.
+func (p *ProofOfWork) registerMetrics(reg prometheus.Registerer) error {
+	register := func(
+		name, help string, labels []string,
+	) (
+		*prometheus.CounterVec, error,
+	) {
+		counter := prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: name, Help: help}, labels,
+		)
+
+		err := reg.Register(counter)
+		if alreadyErr := (prometheus.AlreadyRegisteredError{}); errors.As(err, &alreadyErr) {
+			var ok bool
+			if counter, ok = alreadyErr.ExistingCollector.(*prometheus.CounterVec); !ok {
+				return nil, fmt.Errorf(
+					"metric %q already registered with a different type", name,
+				)
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("registering counter %q: %w", name, err)
+		}
+
+		return counter, nil
+	}
+
+	var err error
+	if p.challengesMetric, err = register(
+		powChallengesMetricName, powChallengesMetricHelp, []string{"reason"},
+	); err != nil {
+		return err
+	}
+
+	solutionsMetric, err := register(
+		powSolutionsMetricName, powSolutionsMetricHelp, nil,
+	)
+	if err != nil {
+		return err
+	}
+	p.solutionsMetric = solutionsMetric.WithLabelValues()
+
+	return nil
+}
+
+// powChallengeReason returns the `reason` label of the challenges metric for a
+// challenge issued due to the given error from checkSolution.
+func powChallengeReason(err error) string {
+	switch {
+	case errors.Is(err, errPowSolutionNotGiven):
+		return "missing"
+	case errors.Is(err, pow.ErrMalformedSeed):
+		return "malformed"
+	case errors.Is(err, pow.ErrExpiredSeed):
+		return "expired"
+	case errors.Is(err, pow.ErrInvalidSolution):
+		return "invalid"
+	case errors.Is(err, pow.ErrSolutionReused):
+		return "reused"
+	case errors.Is(err, pow.ErrSeedRevoked):
+		return "revoked"
+	default:
+		return "other"
+	}
+}
+
+// parseIPPrefix parses either a CIDR range or a single IP, which is returned as
+// a prefix containing only that IP. IPv4-mapped IPv6 addresses and ranges are
+// converted into their IPv4 equivalents.
+func parseIPPrefix(str string) (netip.Prefix, error) {
+	if !strings.Contains(str, "/") {
+		addr, err := netip.ParseAddr(str)
+		if err != nil {
+			return netip.Prefix{}, err
+		} else if addr.Zone() != "" {
+			return netip.Prefix{}, errors.New("zones are not supported")
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(str)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+
+	return prefix.Masked(), nil
+}
+
+// clientIP returns the IP of the client which made the request, as determined
+// by Caddy, falling back to the remote address of the request.
+func clientIP(r *http.Request) (netip.Addr, bool) {
+	ipStr, _ := caddyhttp.GetVar(r.Context(), caddyhttp.ClientIPVarKey).(string)
+	if ipStr == "" {
+		ipStr = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ipStr); err == nil {
+			ipStr = host
+		}
+	}
+
+	addr, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.WithZone("").Unmap(), true
+}
+
+// powReturningSecret derives the secret used to sign returning markers from
+// the given secret.
+func powReturningSecret(secret []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("returning"))
+	return h.Sum(nil)
+}
+
+func powReturningSecrets(secrets [][]byte) [][]byte {
+	returningSecrets := make([][]byte, len(secrets))
+	for i, secret := range secrets {
+		returningSecrets[i] = powReturningSecret(secret)
+	}
+	return returningSecrets
+}
+
+// generatePowSecret returns a new random secret.
+func generatePowSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating secret value: %w", err)
+	}
+	return secret, nil
+}
+
+// loadOrCreatePowSecret reads a hex-encoded secret from the file at the given
+// path, first generating one and writing it there if the file doesn't exist.
+func loadOrCreatePowSecret(path string) ([]byte, error) {
+	secretHex, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		secret, err := generatePowSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		// O_EXCL ensures that a file written concurrently by some other
+		// process isn't overwritten.
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("creating secret file %q: %w", path, err)
+		}
+		defer f.Close()
+
+		if _, err := io.WriteString(f, hex.EncodeToString(secret)+"\n"); err != nil {
+			return nil, fmt.Errorf("writing secret file %q: %w", path, err)
+		} else if err := f.Close(); err != nil {
+			return nil, fmt.Errorf("closing secret file %q: %w", path, err)
+		}
+
+		return secret, nil
+
+	} else if err != nil {
+		return nil, fmt.Errorf("reading secret file %q: %w", path, err)
+	}
+
+	return decodePowSecretFile(path, secretHex)
+}
+
+// readPowSecretFile reads a hex-encoded secret from the file at the given
+// path.
+func readPowSecretFile(path string) ([]byte, error) {
+	secretHex, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret file %q: %w", path, err)
+	}
+	return decodePowSecretFile(path, secretHex)
+}
+
+// decodePowSecretFile decodes the hex-encoded contents of the secret file at
+// the given path.
+func decodePowSecretFile(path string, secretHex []byte) ([]byte, error) {
+	secret, err := hex.DecodeString(strings.TrimSpace(string(secretHex)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding secret file %q as hex: %w", path, err)
+	} else if len(secret) == 0 {
+		return nil, fmt.Errorf("secret file %q is empty", path)
+	}
+
+	return secret, nil
+}
+
+// provisionHostConfig fills in any parameters of the HostConfig which weren't
+// given from the top-level configuration, and sets up its Managers.
+func (p *ProofOfWork) provisionHostConfig(hostCfg *ProofOfWorkHostConfig) error {
+	// Hosts with their own secret don't accept the top-level old secrets, nor
+	// are they affected by the SecretFile being reloaded.
+	newManager := p.newManager
+	if hostCfg.Secret != "" {
+		secret, err := expandPowSecret(hostCfg.Secret)
+		if err != nil {
+			return err
+		}
+		newManager = func(build powManagerBuilder) pow.Manager {
+			return build(secret, nil)
+		}
+	}
+
+	hostCfg.Host = strings.ToLower(hostCfg.Host)
+
+	if hostCfg.Target == 0 {
+		hostCfg.Target = p.Target
+	}
+
+	if hostCfg.ChallengeTimeout == 0 {
+		hostCfg.ChallengeTimeout = p.ChallengeTimeout
+	}
+
+	// Each host has its own store, as otherwise a solution which has been
+	// stored by one host's Manager would be accepted by all others.
+	hostCfg.store = p.newStore("host:" + hostCfg.Host + ":")
+	hostCfg.mgr = newManager(func(secret []byte, oldSecrets [][]byte) pow.Manager {
+		return pow.NewManager(hostCfg.store, secret, &pow.ManagerOpts{
+			Target:           hostCfg.Target,
+			ChallengeTimeout: hostCfg.ChallengeTimeout,
+			OldSecrets:       oldSecrets,
+			Hash:             pow.Hash(p.Hash),
+			SignatureHash:    pow.Hash(p.SignatureHash),
+			MaxSolutionUses:  p.MaxSolutionUses,
+			OnStoreError: func(err error) {
+				p.logger.Error(
+					"Failed to store proof-of-work solution",
+					zap.String("host", hostCfg.Host),
+					zap.Error(err),
+				)
+			},
+		})
+	})
+
+	if p.JSFreeFallback {
+		hostCfg.fallbackMgr = newManager(func(secret []byte, oldSecrets [][]byte) pow.Manager {
+			return pow.NewManager(hostCfg.store, secret, &pow.ManagerOpts{
+				Target:           p.JSFreeFallbackTarget,
+				ChallengeTimeout: hostCfg.ChallengeTimeout,
+				OldSecrets:       oldSecrets,
+				Hash:             pow.Hash(p.Hash),
+				SignatureHash:    pow.Hash(p.SignatureHash),
+			})
+		})
+	}
+
+	return nil
+}
+
+// validatePowChallengeTimeout returns an error if the given ChallengeTimeout
+// is out of bounds. A value of 0 is valid, indicating a default.
+func validatePowChallengeTimeout(timeout time.Duration) error {
+	switch {
+	case timeout < 0:
+		return fmt.Errorf("challenge_timeout cannot be negative")
+	case timeout == 0:
+		// default will be used
+	case timeout < powMinChallengeTimeout:
+		return fmt.Errorf(
+			"challenge_timeout cannot be less than %v", powMinChallengeTimeout,
+		)
+	case timeout > powMaxChallengeTimeout:
+		return fmt.Errorf(
+			"challenge_timeout cannot be greater than %v", powMaxChallengeTimeout,
+		)
+	}
+	return nil
+}
+
+func (p *ProofOfWork) Validate() error {
+	if err := validatePowChallengeTimeout(p.ChallengeTimeout); err != nil {
+		return err
+	}
+
+	if p.SecretFileWatchInterval < 0 {
+		return errors.New("secret_file_watch_interval cannot be negative")
+	} else if p.SecretFileWatchInterval > 0 && p.SecretFile == "" {
+		return errors.New("secret_file_watch_interval requires secret_file")
+	}
+
+	for _, hostCfg := range p.HostConfigs {
+		if hostCfg.Host == "" {
+			return fmt.Errorf("host config must have a host pattern")
+		} else if _, err := path.Match(hostCfg.Host, ""); err != nil {
+			return fmt.Errorf("invalid host pattern %q: %w", hostCfg.Host, err)
+		} else if err := validatePowChallengeTimeout(hostCfg.ChallengeTimeout); err != nil {
+			return fmt.Errorf("host config %q: %w", hostCfg.Host, err)
+		}
+	}
+
+	if p.CookiePath != "" && !strings.HasPrefix(p.CookiePath, "/") {
+		return fmt.Errorf("cookie_path must start with '/'")
+	}
+
+	if p.CookieSameSite == "none" && !p.CookieSecure {
+		return errors.New("cookie_same_site none requires cookie_secure")
+	}
+
+	if p.PassTokenLifetime < 0 {
+		return fmt.Errorf("pass_token_lifetime cannot be negative")
+	}
+
+	if p.ForwardPassHeader != "" && strings.EqualFold(
+		p.ForwardPassHeader, p.TrustedPassHeader,
+	) {
+		return errors.New(
+			"forward_pass_header and trusted_pass_header cannot be the same",
+		)
+	}
+
+	if p.MaxUnverifiedDuration < 0 {
+		return fmt.Errorf("max_unverified_duration cannot be negative")
+	}
+
+	if p.MaxSolutionUses < 0 {
+		return fmt.Errorf("max_solution_uses cannot be negative")
+	}
+
+	if p.SolverWorkers < 0 {
+		return fmt.Errorf("solver_workers cannot be negative")
+	}
+
+	if p.RedisStore != nil && p.RedisStore.Addr == "" {
+		return fmt.Errorf("redis store must have an addr")
+	}
+
+	if len(p.TrustTiers) > 0 && p.TrustHeader == "" {
+		return fmt.Errorf("trust_header is required when trust tiers are given")
+	}
+
+	if p.DefaultTarget != 0 && p.TrustHeader == "" {
+		return fmt.Errorf("trust_header is required when default_target is given")
+	}
+
+	for _, tier := range p.TrustTiers {
+		if !tier.NoChallenge && tier.Target == 0 {
+			return fmt.Errorf(
+				"trust tier with min score %v must have a target", tier.MinScore,
+			)
+		}
+	}
+
+	if p.InitialTarget != 0 && p.InitialTarget <= p.Target {
+		return errors.New("initial_target must be easier (greater) than target")
+	}
+
+	for _, pathTarget := range p.PathTargets {
+		if !strings.HasPrefix(pathTarget.PathPrefix, "/") {
+			return fmt.Errorf(
+				"path target prefix %q must start with '/'", pathTarget.PathPrefix,
+			)
+		} else if pathTarget.Target == 0 {
+			return fmt.Errorf(
+				"path target with prefix %q must have a target",
+				pathTarget.PathPrefix,
+			)
+		}
+	}
+
+	if p.LowIterationsRatio < 0 || p.LowIterationsRatio >= 1 {
+		return fmt.Errorf("low_iterations_ratio must be in the range [0, 1)")
+	}
+
+	switch p.LowIterationsAction {
+	case "", powLowIterationsActionLog, powLowIterationsActionRechallenge:
+	default:
+		return fmt.Errorf(
+			"invalid low_iterations_action %q", p.LowIterationsAction,
+		)
+	}
+
+	if p.JSFreeFallbackTarget != 0 &&
+		p.JSFreeFallbackTarget < powMinJSFreeFallbackTarget {
+		return fmt.Errorf(
+			"js_free_fallback_target cannot be lower than %#08x",
+			powMinJSFreeFallbackTarget,
+		)
+	}
+
+	return nil
+}
+
+func (p *ProofOfWork) Cleanup() error {
+	if p.stopWatch != nil {
+		p.stopWatch()
+		<-p.watchDone
+	}
+
+	// Cleanup is called even if Provision failed, in which case the store may
+	// not have been created.
+	if p.store != nil {
+		if err := p.store.Close(); err != nil {
+			return fmt.Errorf("closing the storage component: %w", err)
+		}
+	}
+
+	for _, hostCfg := range p.HostConfigs {
+		if hostCfg.store == nil {
+			continue
+		} else if err := hostCfg.store.Close(); err != nil {
+			return fmt.Errorf(
+				"closing the storage component of host config %q: %w",
+				hostCfg.Host, err,
+			)
+		}
+	}
+
+	return nil
+}
+
+func (p *ProofOfWork) loadTemplate(path string) (*template.Template, error) {
+	powTpl, err := template.New("pow.js").Parse(powJS)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pow.js: %w", err)
+	}
+
+	var (
+		powHTMLBody = powHTML
+		powHTMLName = "pow.html"
+	)
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading file: %w", err)
+		}
+
+		powHTMLBody = string(b)
+		powHTMLName = path
+	}
+
+	if powTpl, err = powTpl.New("pow-noscript").Parse(powNoscriptTpl); err != nil {
+		return nil, fmt.Errorf("parsing pow-noscript: %w", err)
+	}
+
+	if powTpl, err = powTpl.New("").Parse(powHTMLBody); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", powHTMLName, err)
+	}
+
+	return powTpl, nil
+}
+
+// powMaxCookieValues is the maximum number of values of any one cookie which
+// will be considered. Clients may send multiple cookies of the same name if the
+// cookies were set with different paths, e.g. after cookie_path is changed.
+const powMaxCookieValues = 4
+
+// cookieValues returns the hex-decoded values of all cookies of the given name,
+// up to powMaxCookieValues. Values which can't be decoded are skipped.
+func cookieValues(r *http.Request, name string) [][]byte {
+	var values [][]byte
+	for _, cookie := range r.Cookies() {
+		if cookie.Name != name {
+			continue
+		}
+
+		b, err := hex.DecodeString(cookie.Value)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+
+		if values = append(values, b); len(values) == powMaxCookieValues {
+			break
+		}
+	}
+	return values
+}
+
+// headerValues is like cookieValues, but for the values of a header.
+func headerValues(r *http.Request, name string) [][]byte {
+	var values [][]byte
+	for _, value := range r.Header.Values(name) {
+		b, err := hex.DecodeString(value)
+		if err != nil || len(b) == 0 {
+			continue
+		}
+
+		if values = append(values, b); len(values) == powMaxCookieValues {
+			break
+		}
+	}
+	return values
+}
+
+// acceptsJSON returns true if the request's Accept header includes
+// `application/json`.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil || mediaType != "application/json" {
+				continue
+			}
+
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+
+			return true
+		}
+	}
+	return false
+}
+
+// checkSolution checks the seeds and solutions given by the request, returning
+// the seed for which a valid solution was given. Solve reports don't count as a
+// use of the solution, as pow.js makes them before navigating using it.
+func (p *ProofOfWork) checkSolution(
+	mgr pow.Manager, r *http.Request,
+) (
+	[]byte, error,
+) {
+	var (
+		seeds     = cookieValues(r, p.ChallengeSeedCookie)
+		solutions = cookieValues(r, p.ChallengeSolutionCookie)
+	)
+
+	if p.JSONChallenge {
+		seeds = append(seeds, headerValues(r, powSeedHeaderName)...)
+		solutions = append(solutions, headerValues(r, powSolutionHeaderName)...)
+	}
+
+	if len(seeds) == 0 || len(solutions) == 0 {
+		return nil, errPowSolutionNotGiven
+	}
+
+	check := mgr.CheckSolution
+	if isPowSolveReport(r) {
+		check = mgr.PeekSolution
+	}
+
+	var err error
+	for _, seed := range seeds {
+		for _, solution := range solutions {
+			if err = check(seed, solution); err == nil {
+				return seed, nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+func (p *ProofOfWork) checkPassToken(mgr pow.Manager, r *http.Request) error {
+	tokens := cookieValues(r, powPassTokenCookieName)
+	if len(tokens) == 0 {
+		return errors.New("pass token not given")
+	}
+
+	var err error
+	for _, token := range tokens {
+		if err = mgr.CheckPassToken(token); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (p *ProofOfWork) checkTrustedPassHeader(
+	mgr pow.Manager, r *http.Request,
+) error {
+	token, err := hex.DecodeString(r.Header.Get(p.TrustedPassHeader))
+	if err != nil || len(token) == 0 {
+		return errors.New("trusted pass header not given")
+	}
+
+	return mgr.CheckPassToken(token)
+}
+
+// isReturning returns true if the request carries a valid returning marker.
+func (p *ProofOfWork) isReturning(r *http.Request) bool {
+	for _, marker := range cookieValues(r, powReturningCookieName) {
+		if p.returningMgr.CheckPassToken(marker) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ProofOfWork) setReturning(rw http.ResponseWriter) {
+	marker := p.returningMgr.NewPassToken(powReturningLifetime)
+	c := p.newCookie(powReturningCookieName, hex.EncodeToString(marker))
+	c.MaxAge = int(powReturningLifetime.Seconds())
+	c.HttpOnly = true
+	http.SetCookie(rw, c)
+}
+
+func (p *ProofOfWork) setPassToken(mgr pow.Manager, rw http.ResponseWriter) {
+	token := mgr.NewPassToken(p.PassTokenLifetime)
+	c := p.newCookie(powPassTokenCookieName, hex.EncodeToString(token))
+	c.MaxAge = int(p.PassTokenLifetime.Seconds())
+	c.HttpOnly = true
+	http.SetCookie(rw, c)
+}
+
+// newCookie returns a cookie with the given name and value, and with the
+// configured cookie attributes.
+func (p *ProofOfWork) newCookie(name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     p.CookiePath,
+		Domain:   p.CookieDomain,
+		Secure:   p.CookieSecure,
+		SameSite: p.cookieSameSite,
+	}
+}
+
+// deleteCookie instructs the client to delete the cookie with the given name.
+func (p *ProofOfWork) deleteCookie(rw http.ResponseWriter, name string) {
+	c := p.newCookie(name, "")
+	c.MaxAge = -1
+	http.SetCookie(rw, c)
+}
+
+// cookieAttributes returns the configured cookie attributes in the form used
+// by `document.cookie`, so that cookies set by the challenge page's JS match
+// those set by the handler.
+func (p *ProofOfWork) cookieAttributes() string {
+	_, attrs, _ := strings.Cut(p.newCookie("_", "").String(), "; ")
+	return attrs
+}
+
+// hostConfig returns the first HostConfig whose pattern matches the host of
+// the request, or nil if none do.
+func (p *ProofOfWork) hostConfig(r *http.Request) *ProofOfWorkHostConfig {
+	if len(p.HostConfigs) == 0 {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.ToLower(host)
+
+	for i := range p.HostConfigs {
+		if ok, _ := path.Match(p.HostConfigs[i].Host, host); ok {
+			return &p.HostConfigs[i]
+		}
+	}
+
+	return nil
+}
+
+// trustTier returns the index of the TrustTier which applies to the request,
+// or -1 if none do. It returns false if TrustHeader is given but the request
+// doesn't have a usable trust score.
+func (p *ProofOfWork) trustTier(r *http.Request) (int, bool) {
+	if p.TrustHeader == "" {
+		return -1, true
+	}
+
+	score, err := strconv.ParseFloat(r.Header.Get(p.TrustHeader), 64)
+	if err != nil {
+		return -1, false
+	}
+
+	tier := -1
+	for i := range p.TrustTiers {
+		if p.TrustTiers[i].MinScore <= score {
+			tier = i
+		}
+	}
+
+	return tier, true
+}
+
+// pathTarget returns the index of the PathTarget which applies to the request,
+// or -1 if none do.
+func (p *ProofOfWork) pathTarget(r *http.Request) int {
+	i := -1
+	for j, pathTarget := range p.PathTargets {
+		if strings.HasPrefix(r.URL.Path, pathTarget.PathPrefix) &&
+			(i < 0 || len(pathTarget.PathPrefix) > len(p.PathTargets[i].PathPrefix)) {
+			i = j
+		}
+	}
+	return i
+}
+
+// powJSFreeFallbackAttemptMaxAge is how long a client has to come back after
+// being challenged in order to be given the JS-free fallback.
+const powJSFreeFallbackAttemptMaxAge = 5 * time.Minute
+
+// serveJSFreeFallback serves a challenge which has been solved by the server to
+// the client, if the client has previously been presented with a challenge and
+// come back without a solution. Returns false if it did not do so.
+func (p *ProofOfWork) serveJSFreeFallback(
+	fallbackMgr pow.Manager, rw http.ResponseWriter, r *http.Request,
+) bool {
+	if _, err := r.Cookie(powChallengeAttemptCookieName); err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(
+		r.Context(), powJSFreeFallbackSolveTimeout,
+	)
+	defer cancel()
+
+	c := fallbackMgr.NewChallenge()
+	solution, err := pow.SolveContext(ctx, c)
+	if err != nil {
+		p.logger.Warn("Failed to solve JS-free fallback challenge", zap.Error(err))
+		return false
+	}
+
+	p.logger.Info(
+		"Serving JS-free fallback challenge solution",
+		zap.String("userAgent", r.UserAgent()),
+		zap.String("url", r.URL.String()),
+	)
+
+	for name, value := range map[string]string{
+		p.ChallengeSeedCookie:     hex.EncodeToString(c.Seed),
+		p.ChallengeSolutionCookie: hex.EncodeToString(solution),
+	} {
+		http.SetCookie(rw, p.newCookie(name, value))
+	}
+
+	p.deleteCookie(rw, powChallengeAttemptCookieName)
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = io.WriteString(rw, powJSFreeFallbackHTML)
+	return true
+}
+
+// isPowSolveReport returns true if the request is a solve report, which pow.js
+// makes to the challenged URL using a HEAD request once it has solved a
+// challenge.
+func isPowSolveReport(r *http.Request) bool {
+	return r.Method == http.MethodHead &&
+		r.Header.Get(powHashRateHeaderName) != ""
+}
+
+// handleSolveReport logs the statistics which pow.js reports after solving the
+// challenge with the given seed, which must already have been checked. It
+// returns true if the client should be rechallenged due to LowIterationsAction,
+// in which case the seed will have been revoked.
+func (p *ProofOfWork) handleSolveReport(
+	mgr pow.Manager, seed []byte, r *http.Request,
+) bool {
+	// The target may differ from that of newly issued challenges, e.g. if the
+	// configuration has changed since the challenge was issued.
+	target, err := pow.SeedTarget(seed)
+	if err != nil {
+		p.logger.Error("Failed to parse target from seed", zap.Error(err))
+		return false
+	}
+
+	fields := []zap.Field{
+		zap.String("userAgent", r.UserAgent()),
+		zap.String("url", r.URL.String()),
+		zap.Uint32("target", target),
+		zap.Uint64("expectedIterations", pow.ExpectedIterations(target)),
+	}
+
+	hashRateStr := r.Header.Get(powHashRateHeaderName)
+	if hashRate, err := strconv.ParseFloat(hashRateStr, 64); err == nil {
+		fields = append(fields, zap.Float64("hashRate", hashRate))
+	}
+
+	solveTimeStr := r.Header.Get(powSolveTimeHeaderName)
+	if solveTime, err := time.ParseDuration(solveTimeStr); err == nil {
+		fields = append(fields, zap.Duration("solveTime", solveTime))
+	}
+
+	iterationsStr := r.Header.Get(powIterationsHeaderName)
+	iterations, err := strconv.ParseUint(iterationsStr, 10, 64)
+	if err == nil {
+		fields = append(fields, zap.Uint64("iterations", iterations))
+	}
+
+	if err != nil || !p.isLowIterations(target, iterations) {
+		p.logger.Info("Proof-of-work challenge solved by client", fields...)
+		return false
+	}
+
+	p.logger.Warn(
+		"Proof-of-work challenge solved by client with implausibly few iterations",
+		append(fields, zap.String("action", p.LowIterationsAction))...,
+	)
+
+	if p.LowIterationsAction != powLowIterationsActionRechallenge {
+		return false
+	}
+
+	// Clearing the client's cookies isn't enough on its own, since a client
+	// may simply ignore that, so the solution itself must be rejected too.
+	if err := mgr.RevokeSeed(seed); err != nil {
+		p.logger.Error("Failed to revoke proof-of-work seed", zap.Error(err))
+	}
+
+	return true
+}
+
+// isLowIterations returns true if the number of iterations a client reported
+// taking to solve a challenge is implausibly low for the given target, as
+// determined by LowIterationsRatio.
+func (p *ProofOfWork) isLowIterations(target uint32, iterations uint64) bool {
+	if p.LowIterationsRatio <= 0 {
+		return false
+	}
+	expected := float64(pow.ExpectedIterations(target))
+	return float64(iterations) < p.LowIterationsRatio*expected
+}
+
+// isAllowed returns true if the client IP of the request is within Allow.
+func (p *ProofOfWork) isAllowed(r *http.Request) bool {
+	if len(p.allowPrefixes) == 0 {
+		return false
+	}
+
+	addr, ok := clientIP(r)
+	if !ok {
+		return false
+	}
+
+	for _, prefix := range p.allowPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAuthenticated returns true if the request should not be challenged due to
+// SkipAuthorized or SkipPlaceholder.
+func (p *ProofOfWork) isAuthenticated(r *http.Request) bool {
+	if p.SkipAuthorized && r.Header.Get("Authorization") != "" {
+		return true
 	}
 
-	if p.ChallengeSolutionCookie == "" {
-		p.ChallengeSolutionCookie = "__pow_challenge_solution"
+	if p.SkipPlaceholder != "" {
+		repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+		return repl.ReplaceAll(p.SkipPlaceholder, "") != ""
 	}
 
-	p.store = pow.NewMemoryStore(nil)
-	p.mgr = pow.NewManager(p.store, secret, &pow.ManagerOpts{
-		Target:           p.Target,
-		ChallengeTimeout: p.ChallengeTimeout,
-	})
+	return false
+}
 
-	p.logger = ctx.Logger()
+// serveUnverified passes a request which hasn't solved a challenge on to the
+// next handler, limiting its duration if MaxUnverifiedDuration is given.
+func (p *ProofOfWork) serveUnverified(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	if p.MaxUnverifiedDuration == 0 {
+		return next.ServeHTTP(rw, r)
+	}
 
-	return nil
+	ctx, cancel := context.WithTimeout(r.Context(), p.MaxUnverifiedDuration)
+	defer cancel()
+
+	return next.ServeHTTP(rw, r.WithContext(ctx))
 }
 
-func (p *ProofOfWork) Cleanup() error {
-	if err := p.store.Close(); err != nil {
-		return fmt.Errorf("closing the storage component: %w", err)
+func (p *ProofOfWork) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	// Only a pass token set by this handler should be forwarded.
+	if p.ForwardPassHeader != "" {
+		r.Header.Del(p.ForwardPassHeader)
 	}
-	return nil
-}
 
-func (p *ProofOfWork) loadTemplate(path string) (*template.Template, error) {
-	powTpl, err := template.New("pow.js").Parse(powJS)
-	if err != nil {
-		return nil, fmt.Errorf("parsing pow.js: %w", err)
+	if p.isAllowed(r) {
+		return next.ServeHTTP(rw, r)
+	}
+
+	if p.isAuthenticated(r) {
+		return p.serveUnverified(rw, r, next)
 	}
 
+	// checkMgr is used to check solutions and pass tokens, while mgr is used
+	// to issue new challenges.
 	var (
-		powHTMLBody = powHTML
-		powHTMLName = "pow.html"
+		checkMgr    = p.mgr
+		mgr         = p.mgr
+		fallbackMgr = p.fallbackMgr
+		target      = p.Target
 	)
 
-	if path != "" {
-		b, err := os.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("reading file: %w", err)
+	hostCfg := p.hostConfig(r)
+	if hostCfg != nil {
+		checkMgr, mgr, fallbackMgr = hostCfg.mgr, hostCfg.mgr, hostCfg.fallbackMgr
+		target = hostCfg.Target
+	} else if tier, ok := p.trustTier(r); !ok {
+		mgr = p.defaultMgr
+		if p.DefaultTarget != 0 {
+			target = p.DefaultTarget
+		}
+	} else if tier >= 0 {
+		if p.TrustTiers[tier].NoChallenge {
+			return p.serveUnverified(rw, r, next)
 		}
+		mgr = p.trustTierMgrs[tier]
+		target = p.TrustTiers[tier].Target
+	}
 
-		powHTMLBody = string(b)
-		powHTMLName = path
+	if i := p.pathTarget(r); hostCfg == nil && i >= 0 {
+		mgr = p.pathTargetMgrs[i]
+		target = p.PathTargets[i].Target
 	}
 
-	if powTpl, err = powTpl.New("").Parse(powHTMLBody); err != nil {
-		return nil, fmt.Errorf("parsing %q: %w", powHTMLName, err)
+	// Clients which haven't passed a challenge before are given an easier
+	// one, if configured.
+	var isNew bool
+	if p.InitialTarget != 0 && hostCfg == nil {
+		isNew = !p.isReturning(r)
+		if isNew && p.InitialTarget > target {
+			mgr, target = p.initialMgr, p.InitialTarget
+		}
 	}
 
-	return powTpl, nil
-}
+	// If the client has a valid pass token then there's no need to check its
+	// solution, and therefore no need to consult the store. The same goes for
+	// a pass token forwarded by a trusted server, in which case that server is
+	// responsible for the client's cookies.
+	var (
+		hasPassToken = p.PassToken && p.checkPassToken(checkMgr, r) == nil
+
+		hasTrustedPassHeader = p.TrustedPassHeader != "" &&
+			p.checkTrustedPassHeader(checkMgr, r) == nil
+	)
 
-func (p *ProofOfWork) checkSolution(r *http.Request) error {
+	// seed is only set if a solution was checked, in which case it's the seed
+	// which was solved.
 	var (
-		getCookieBytes = func(name string) []byte {
-			cookie, err := r.Cookie(name)
-			if err != nil {
-				return nil
-			}
+		seed []byte
+		err  error
+	)
+	if !hasPassToken && !hasTrustedPassHeader {
+		if seed, err = p.checkSolution(checkMgr, r); err == nil {
+			p.solutionsMetric.Inc()
+		}
+	}
 
-			b, _ := hex.DecodeString(cookie.Value)
-			return b
+	if err == nil {
+		// Solve reports are answered by this handler rather than passed on. A
+		// client which is rechallenged due to its report must not be given a
+		// pass token, or it would never need to solve a new challenge.
+		var isSolveReport, rechallenge bool
+		if seed != nil && isPowSolveReport(r) {
+			isSolveReport = true
+			rechallenge = p.handleSolveReport(checkMgr, seed, r)
 		}
 
-		seed     = getCookieBytes(p.ChallengeSeedCookie)
-		solution = getCookieBytes(p.ChallengeSolutionCookie)
-	)
+		if rechallenge {
+			for _, name := range []string{
+				p.ChallengeSeedCookie,
+				p.ChallengeSolutionCookie,
+				powPassTokenCookieName,
+			} {
+				p.deleteCookie(rw, name)
+			}
+		} else {
+			if p.PassToken && !hasPassToken && !hasTrustedPassHeader {
+				p.setPassToken(checkMgr, rw)
+			}
 
-	if len(seed) == 0 || len(solution) == 0 {
-		return errors.New("seed and/or solution not given")
-	}
+			if isNew {
+				p.setReturning(rw)
+			}
+		}
 
-	return p.mgr.CheckSolution(seed, solution)
-}
+		if isSolveReport {
+			rw.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+
+		if p.ForwardPassHeader != "" {
+			token := checkMgr.NewPassToken(p.PassTokenLifetime)
+			r.Header.Set(p.ForwardPassHeader, hex.EncodeToString(token))
+		}
 
-func (p *ProofOfWork) ServeHTTP(
-	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
-) error {
-	err := p.checkSolution(r)
-	if err == nil {
 		return next.ServeHTTP(rw, r)
 	}
 
@@ -217,7 +1970,30 @@ func (p *ProofOfWork) ServeHTTP(
 		zap.Error(err),
 	)
 
+	p.challengesMetric.WithLabelValues(powChallengeReason(err)).Inc()
+
 	rw.Header().Set(powSolutionRequiredHeaderName, "true")
+	for name, values := range p.challengeHeaders {
+		rw.Header()[name] = values
+	}
+
+	if p.JSONChallenge {
+		rw.Header().Add("Vary", "Accept")
+		if acceptsJSON(r) {
+			return p.serveJSONChallenge(mgr, rw)
+		}
+	}
+
+	if p.JSFreeFallback {
+		if p.serveJSFreeFallback(fallbackMgr, rw, r) {
+			return nil
+		}
+
+		c := p.newCookie(powChallengeAttemptCookieName, "1")
+		c.MaxAge = int(powJSFreeFallbackAttemptMaxAge.Seconds())
+		c.HttpOnly = true
+		http.SetCookie(rw, c)
+	}
 
 	tplPath := ""
 	if p.TemplatePath != "" {
@@ -230,24 +2006,91 @@ func (p *ProofOfWork) ServeHTTP(
 		return fmt.Errorf("loading template from %q: %w", tplPath, err)
 	}
 
-	c := p.mgr.NewChallenge()
+	c := mgr.NewChallenge()
 
 	tplData := struct {
 		Seed                    string
 		Target                  uint32
+		HashAlgorithm           string
 		ChallengeSeedCookie     string
 		ChallengeSolutionCookie string
+		CookiePath              string
+		CookieAttributes        string
+		OrigURI                 string
+		WorkerCount             int
+		HashRateHeader          string
+		SolveTimeHeader         string
+		IterationsHeader        string
+		JSFreeFallback          bool
 	}{
 		Seed:                    hex.EncodeToString(c.Seed),
 		Target:                  c.Target,
+		HashAlgorithm:           powWebCryptoHashNames[c.Hash],
 		ChallengeSeedCookie:     p.ChallengeSeedCookie,
 		ChallengeSolutionCookie: p.ChallengeSolutionCookie,
+		CookiePath:              p.CookiePath,
+		CookieAttributes:        p.cookieAttributes(),
+		OrigURI:                 origURI(r),
+		WorkerCount:             p.SolverWorkers,
+		HashRateHeader:          powHashRateHeaderName,
+		SolveTimeHeader:         powSolveTimeHeaderName,
+		IterationsHeader:        powIterationsHeaderName,
+		JSFreeFallback:          p.JSFreeFallback,
+	}
+
+	// The template is rendered into a buffer first, so that if execution fails
+	// partway through the client doesn't receive a partial page.
+	buf, bufDone := toolkit.GetBuffer()
+	defer bufDone()
+
+	if err := powTpl.Execute(buf, tplData); err != nil {
+		return caddyhttp.Error(
+			http.StatusInternalServerError,
+			fmt.Errorf("executing PoW template failed: %w", err),
+		)
+	}
+
+	_, _ = buf.WriteTo(rw)
+	return nil
+}
+
+// origURI returns the URI of the original request, including its query, prior
+// to any internal rewrites, so that the challenge page can return to it once
+// solved.
+func origURI(r *http.Request) string {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		if uri, ok := repl.GetString("http.request.orig_uri"); ok && uri != "" {
+			return uri
+		}
 	}
+	return r.RequestURI
+}
 
-	if err := powTpl.Execute(rw, tplData); err != nil {
-		return fmt.Errorf("executing PoW template failed: %w", err)
+// serveJSONChallenge serves a new challenge as a powJSONChallenge.
+func (p *ProofOfWork) serveJSONChallenge(
+	mgr pow.Manager, rw http.ResponseWriter,
+) error {
+	c := mgr.NewChallenge()
+
+	body, err := json.Marshal(powJSONChallenge{
+		Seed:           hex.EncodeToString(c.Seed),
+		Target:         c.Target,
+		Hash:           c.Hash,
+		SeedCookie:     p.ChallengeSeedCookie,
+		SolutionCookie: p.ChallengeSolutionCookie,
+		SeedHeader:     powSeedHeaderName,
+		SolutionHeader: powSolutionHeaderName,
+	})
+	if err != nil {
+		return caddyhttp.Error(
+			http.StatusInternalServerError,
+			fmt.Errorf("encoding challenge as JSON: %w", err),
+		)
 	}
 
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusUnauthorized)
+	_, _ = rw.Write(body)
 	return nil
 }
 
@@ -256,11 +2099,51 @@ func (p *ProofOfWork) ServeHTTP(
 //	proof_of_work [matcher] {
 //		# all parameters are optional
 //		secret "some secret value"
+//		secret_file <path>
+//		secret_file_watch_interval <duration>
+//		old_secret "previous secret value" # repeatable
 //		target 0x00FFFFFF
+//		hash sha256|sha512
+//		signature_hash md5|sha256|sha512
 //		challenge_timeout 12h
 //		challenge_seed_cookie "__pow_challenge_seed"
 //		challenge_solution_cookie "__pow_challenge_solution"
+//		cookie_path "/"
+//		cookie_domain example.com
+//		cookie_same_site lax|strict|none
+//		cookie_secure on|off
 //		template_path "{http.vars.root}/tpl.html"
+//		solver_workers <n>
+//		json_challenge on|off
+//		js_free_fallback on|off
+//		js_free_fallback_target 0x3FFFFFFF
+//		low_iterations_ratio 0.001
+//		low_iterations_action log|rechallenge
+//		pass_token on|off
+//		pass_token_lifetime 12h
+//		forward_pass_header <header name>
+//		trusted_pass_header <header name>
+//		challenge_header <name> <value> # repeatable
+//		trust_header X-Trust-Score
+//		default_target 0x00FFFFFF
+//		initial_target 0x00FFFFFF
+//		trust_tier <min_score> <target>|none # repeatable
+//		target_for <path prefix> <target> # repeatable
+//		skip_authorized on|off
+//		skip_placeholder "{http.auth.user.id}"
+//		allow <ip|cidr>... # repeatable
+//		max_unverified_duration 30s
+//		max_solution_uses 1
+//		store redis {
+//			addr localhost:6379
+//			password "{env.REDIS_PASSWORD}"
+//			db 0
+//		}
+//		host_config <host pattern> { # repeatable
+//			secret "some other secret value"
+//			target 0x0000FFFF
+//			challenge_timeout 1h
+//		}
 //	}
 func proofOfWorkParseCaddyfile(
 	h httpcaddyfile.Helper,
@@ -276,6 +2159,28 @@ func proofOfWorkParseCaddyfile(
 				return nil, h.ArgErr()
 			}
 
+		case "secret_file":
+			if !h.Args(&p.SecretFile) {
+				return nil, h.ArgErr()
+			}
+
+		case "secret_file_watch_interval":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if p.SecretFileWatchInterval, err = time.ParseDuration(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as duration: %w", h.Val(), err)
+			}
+
+		case "old_secret":
+			var oldSecret string
+			if !h.Args(&oldSecret) {
+				return nil, h.ArgErr()
+			}
+			p.OldSecrets = append(p.OldSecrets, oldSecret)
+
 		case "target":
 			if !h.NextArg() {
 				return nil, h.ArgErr()
@@ -288,6 +2193,16 @@ func proofOfWorkParseCaddyfile(
 
 			p.Target = uint32(target)
 
+		case "hash":
+			if !h.Args(&p.Hash) {
+				return nil, h.ArgErr()
+			}
+
+		case "signature_hash":
+			if !h.Args(&p.SignatureHash) {
+				return nil, h.ArgErr()
+			}
+
 		case "challenge_timeout":
 			if !h.NextArg() {
 				return nil, h.ArgErr()
@@ -308,10 +2223,307 @@ func proofOfWorkParseCaddyfile(
 				return nil, h.ArgErr()
 			}
 
+		case "cookie_path":
+			if !h.Args(&p.CookiePath) {
+				return nil, h.ArgErr()
+			}
+
+		case "cookie_domain":
+			if !h.Args(&p.CookieDomain) {
+				return nil, h.ArgErr()
+			}
+
+		case "cookie_same_site":
+			if !h.Args(&p.CookieSameSite) {
+				return nil, h.ArgErr()
+			}
+
+		case "cookie_secure":
+			var err error
+			if p.CookieSecure, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+
 		case "template":
 			if !h.Args(&p.TemplatePath) {
 				return nil, h.ArgErr()
 			}
+
+		case "solver_workers":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if p.SolverWorkers, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+
+		case "json_challenge":
+			var err error
+			if p.JSONChallenge, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+
+		case "js_free_fallback":
+			var err error
+			if p.JSFreeFallback, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+
+		case "js_free_fallback_target":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			target, err := strconv.ParseUint(h.Val(), 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as a uint32: %w", h.Val(), err)
+			}
+
+			p.JSFreeFallbackTarget = uint32(target)
+
+		case "low_iterations_ratio":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if p.LowIterationsRatio, err = strconv.ParseFloat(h.Val(), 64); err != nil {
+				return nil, fmt.Errorf("parsing %q as a ratio: %w", h.Val(), err)
+			}
+
+		case "low_iterations_action":
+			if !h.Args(&p.LowIterationsAction) {
+				return nil, h.ArgErr()
+			}
+
+		case "pass_token":
+			var err error
+			if p.PassToken, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+
+		case "challenge_header":
+			var name, value string
+			if !h.Args(&name, &value) {
+				return nil, h.ArgErr()
+			}
+
+			if p.ChallengeHeaders == nil {
+				p.ChallengeHeaders = map[string]string{}
+			}
+			p.ChallengeHeaders[name] = value
+
+		case "trust_header":
+			if !h.Args(&p.TrustHeader) {
+				return nil, h.ArgErr()
+			}
+
+		case "default_target":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			target, err := strconv.ParseUint(h.Val(), 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as a uint32: %w", h.Val(), err)
+			}
+
+			p.DefaultTarget = uint32(target)
+
+		case "initial_target":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			target, err := strconv.ParseUint(h.Val(), 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as a uint32: %w", h.Val(), err)
+			}
+
+			p.InitialTarget = uint32(target)
+
+		case "skip_authorized":
+			var err error
+			if p.SkipAuthorized, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+
+		case "skip_placeholder":
+			if !h.Args(&p.SkipPlaceholder) {
+				return nil, h.ArgErr()
+			}
+
+		case "allow":
+			args := h.RemainingArgs()
+			if len(args) == 0 {
+				return nil, h.ArgErr()
+			}
+			p.Allow = append(p.Allow, args...)
+
+		case "store":
+			var backend string
+			if !h.Args(&backend) {
+				return nil, h.ArgErr()
+			} else if backend != "redis" {
+				return nil, h.Errf("unknown store %q", backend)
+			}
+
+			p.RedisStore = new(ProofOfWorkRedisStore)
+			for nesting := h.Nesting(); h.NextBlock(nesting); {
+				switch h.Val() {
+				case "addr":
+					if !h.Args(&p.RedisStore.Addr) {
+						return nil, h.ArgErr()
+					}
+
+				case "password":
+					if !h.Args(&p.RedisStore.Password) {
+						return nil, h.ArgErr()
+					}
+
+				case "db":
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+
+					var err error
+					if p.RedisStore.DB, err = strconv.Atoi(h.Val()); err != nil {
+						return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+					}
+
+				default:
+					return nil, h.Errf("unknown redis store parameter %q", h.Val())
+				}
+			}
+
+		case "host_config":
+			hostCfg := ProofOfWorkHostConfig{}
+			if !h.Args(&hostCfg.Host) {
+				return nil, h.ArgErr()
+			}
+			hostCfg.Host = strings.ToLower(hostCfg.Host)
+
+			for nesting := h.Nesting(); h.NextBlock(nesting); {
+				switch h.Val() {
+				case "secret":
+					if !h.Args(&hostCfg.Secret) {
+						return nil, h.ArgErr()
+					}
+
+				case "target":
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+
+					target, err := strconv.ParseUint(h.Val(), 0, 32)
+					if err != nil {
+						return nil, fmt.Errorf("parsing %q as a uint32: %w", h.Val(), err)
+					}
+
+					hostCfg.Target = uint32(target)
+
+				case "challenge_timeout":
+					if !h.NextArg() {
+						return nil, h.ArgErr()
+					}
+
+					var err error
+					if hostCfg.ChallengeTimeout, err = time.ParseDuration(h.Val()); err != nil {
+						return nil, fmt.Errorf("parsing %q as timeout: %w", h.Val(), err)
+					}
+
+				default:
+					return nil, h.Errf("unknown host_config parameter %q", h.Val())
+				}
+			}
+
+			p.HostConfigs = append(p.HostConfigs, hostCfg)
+
+		case "trust_tier":
+			var minScoreStr, targetStr string
+			if !h.Args(&minScoreStr, &targetStr) {
+				return nil, h.ArgErr()
+			}
+
+			var (
+				tier ProofOfWorkTrustTier
+				err  error
+			)
+
+			if tier.MinScore, err = strconv.ParseFloat(minScoreStr, 64); err != nil {
+				return nil, fmt.Errorf("parsing %q as a score: %w", minScoreStr, err)
+			}
+
+			if targetStr == "none" {
+				tier.NoChallenge = true
+			} else {
+				target, err := strconv.ParseUint(targetStr, 0, 32)
+				if err != nil {
+					return nil, fmt.Errorf("parsing %q as a uint32: %w", targetStr, err)
+				}
+				tier.Target = uint32(target)
+			}
+
+			p.TrustTiers = append(p.TrustTiers, tier)
+
+		case "target_for":
+			var (
+				pathTarget ProofOfWorkPathTarget
+				targetStr  string
+			)
+			if !h.Args(&pathTarget.PathPrefix, &targetStr) {
+				return nil, h.ArgErr()
+			}
+
+			target, err := strconv.ParseUint(targetStr, 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %q as a uint32: %w", targetStr, err)
+			}
+			pathTarget.Target = uint32(target)
+
+			p.PathTargets = append(p.PathTargets, pathTarget)
+
+		case "forward_pass_header":
+			if !h.Args(&p.ForwardPassHeader) {
+				return nil, h.ArgErr()
+			}
+
+		case "trusted_pass_header":
+			if !h.Args(&p.TrustedPassHeader) {
+				return nil, h.ArgErr()
+			}
+
+		case "pass_token_lifetime":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if p.PassTokenLifetime, err = time.ParseDuration(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as lifetime: %w", h.Val(), err)
+			}
+
+		case "max_unverified_duration":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if p.MaxUnverifiedDuration, err = time.ParseDuration(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as duration: %w", h.Val(), err)
+			}
+
+		case "max_solution_uses":
+			if !h.NextArg() {
+				return nil, h.ArgErr()
+			}
+
+			var err error
+			if p.MaxSolutionUses, err = strconv.Atoi(h.Val()); err != nil {
+				return nil, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
 		}
 	}
 
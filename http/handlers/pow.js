@@ -7,29 +7,198 @@ const toHexString = bytes =>
 const seedStr = "{{ .Seed }}";
 const seed = fromHexString(seedStr);
 const target = "{{ .Target }}";
+const hashAlgorithm = "{{ .HashAlgorithm }}";
+const origURI = "{{ .OrigURI }}";
 
-const fullBuf = new ArrayBuffer(seed.byteLength*2);
+const workerCount = Math.min(
+  navigator.hardwareConcurrency || 1,
+  Number("{{ .WorkerCount }}") || Infinity,
+);
 
-const fullBufSeed = new Uint8Array(fullBuf, 0, seed.byteLength);
-seed.forEach((v, i) => fullBufSeed[i] = v);
+// How many attempts are made between each check-in with the main thread.
+const batchSize = 256;
 
-const randBuf = new Uint8Array(fullBuf, seed.byteLength);
+// Makes up to batchSize attempts at finding a solution, returning whether one
+// was found, in which case it is left in randBuf, and the number of attempts
+// made. This is run both inline and within workers, and so must not reference
+// anything outside of itself.
+async function tryBatch(fullBuf, randBuf, target, hashAlgorithm, batchSize) {
+  for (let i = 1; i <= batchSize; i++) {
+    crypto.getRandomValues(randBuf);
+    const digest = await crypto.subtle.digest(hashAlgorithm, fullBuf);
+    if (new DataView(digest).getUint32(0) < target) {
+      return { found: true, iterations: i };
+    }
+  }
+  return { found: false, iterations: batchSize };
+}
+
+const newBufs = () => {
+  const fullBuf = new Uint8Array(seed.byteLength*2);
+  fullBuf.set(seed);
+  return [fullBuf, fullBuf.subarray(seed.byteLength)];
+};
+
+// Entry point of each worker. A worker is sent a single message containing the
+// challenge, after which it posts messages of the form:
+//
+//   { type: 'progress', iterations }
+//   { type: 'solution', iterations, solution }
+//   { type: 'error', message }
+//
+// where iterations is the number of attempts made since the previous message.
+function workerMain(self) {
+  self.onmessage = async (e) => {
+    const { seed, target, hashAlgorithm, batchSize } = e.data;
+    const fullBuf = new Uint8Array(seed.byteLength*2);
+    fullBuf.set(seed);
+    const randBuf = fullBuf.subarray(seed.byteLength);
+
+    try {
+      while (true) {
+        const { found, iterations } = await tryBatch(
+          fullBuf, randBuf, target, hashAlgorithm, batchSize,
+        );
+        if (found) {
+          self.postMessage({ type: 'solution', iterations, solution: randBuf });
+          return;
+        }
+        self.postMessage({ type: 'progress', iterations });
+      }
+    } catch (err) {
+      self.postMessage({ type: 'error', message: String(err) });
+    }
+  };
+}
+
+const workerSrc =
+  tryBatch.toString() + '\n(' + workerMain.toString() + ')(self);\n';
+
+// Solves the challenge on the main thread.
+const solveInline = async () => {
+  const [fullBuf, randBuf] = newBufs();
+  let iterations = 0;
 
-(async () => {
   while (true) {
-    crypto.getRandomValues(randBuf);
-    const digest = await crypto.subtle.digest('SHA-512', fullBuf);
-    const digestView = new DataView(digest);
-    if (digestView.getUint32(0) < target) {
-      const solutionStr = toHexString(randBuf);
-      document.cookie = `{{ .ChallengeSeedCookie }}=${seedStr}; Path=/`;
-      document.cookie = `{{ .ChallengeSolutionCookie }}=${solutionStr}; Path=/`;
-      window.location.reload();
-
-      // In safari reloading the page doesn't seem to stop async functions which
-      // are already in progress. Which is crazy. But anyway, return to stop the
-      // function.
-      return;
+    const res = await tryBatch(
+      fullBuf, randBuf, Number(target), hashAlgorithm, batchSize,
+    );
+    iterations += res.iterations;
+    if (res.found) return { solution: randBuf, iterations };
+  }
+};
+
+// Solves the challenge using workerCount workers, all trying random solutions
+// against the same challenge, resolving with the first solution found.
+const solveWorkers = () => new Promise((resolve, reject) => {
+  const url = URL.createObjectURL(
+    new Blob([workerSrc], { type: 'text/javascript' }),
+  );
+
+  const workers = [];
+  let done = false, iterations = 0;
+
+  const finish = () => {
+    done = true;
+    workers.forEach(w => w.terminate());
+    URL.revokeObjectURL(url);
+  };
+
+  const onMessage = (e) => {
+    if (done) return;
+
+    switch (e.data.type) {
+      case 'progress':
+        iterations += e.data.iterations;
+        break;
+      case 'solution':
+        iterations += e.data.iterations;
+        finish();
+        resolve({ solution: e.data.solution, iterations });
+        break;
+      default:
+        finish();
+        reject(new Error(e.data.message));
+    }
+  };
+
+  const onError = (e) => {
+    if (done) return;
+    finish();
+    reject(e);
+  };
+
+  try {
+    for (let i = 0; i < workerCount; i++) {
+      const w = new Worker(url);
+      w.onmessage = onMessage;
+      w.onerror = onError;
+      workers.push(w);
+      w.postMessage({ seed, target: Number(target), hashAlgorithm, batchSize });
+    }
+  } catch (err) {
+    onError(err);
+  }
+});
+
+// Uses workers where possible, falling back to solving inline if they aren't
+// available, e.g. due to a Content-Security-Policy.
+const solve = async () => {
+  if (
+    typeof Worker !== 'undefined' &&
+    typeof Blob !== 'undefined' &&
+    typeof URL.createObjectURL === 'function'
+  ) {
+    try {
+      return await solveWorkers();
+    } catch (e) {
+      // fall back to solving inline
     }
   }
+  return solveInline();
+};
+
+// Reports how long the solve took, and how fast this client is able to hash, so
+// that operators can tune the difficulty of challenges.
+const reportSolve = async (iterations, solveMS) => {
+  try {
+    await fetch(window.location.href, {
+      method: 'HEAD',
+      credentials: 'same-origin',
+      headers: {
+        '{{ .HashRateHeader }}': (iterations / (solveMS / 1000)).toFixed(2),
+        '{{ .SolveTimeHeader }}': `${Math.round(solveMS)}ms`,
+        '{{ .IterationsHeader }}': String(iterations),
+      },
+    });
+  } catch (e) {
+    // Reporting is best-effort, it shouldn't hold up the client.
+  }
+};
+
+(async () => {
+  const start = performance.now();
+  const { solution, iterations } = await solve();
+  const solveMS = performance.now() - start;
+
+  const solutionStr = toHexString(solution);
+  document.cookie = `{{ .ChallengeSeedCookie }}=${seedStr}; {{ .CookieAttributes }}`;
+  document.cookie = `{{ .ChallengeSolutionCookie }}=${solutionStr}; {{ .CookieAttributes }}`;
+  await reportSolve(iterations, solveMS);
+
+  // Return to the original URI explicitly, rather than reloading, so that its
+  // query is preserved even if the challenge was served at a rewritten URI.
+  // Only same-origin URIs are followed. Navigating to the current URL wouldn't
+  // reload the page if it has a fragment, so reload in that case.
+  const origURL = new URL(origURI, window.location.href);
+  origURL.hash = window.location.hash;
+  if (
+    origURI &&
+    origURL.origin === window.location.origin &&
+    origURL.href !== window.location.href
+  ) {
+    window.location.replace(origURL.href);
+  } else {
+    window.location.reload();
+  }
 })();
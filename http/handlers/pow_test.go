@@ -0,0 +1,1678 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"dev.mediocregopher.com/mediocre-caddy-plugins.git/internal/pow"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// powTeapotNext is used as the next handler in tests, so that requests which
+// are let through by the ProofOfWork can be told apart from challenges.
+var powTeapotNext = caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+	rw.WriteHeader(http.StatusTeapot)
+	return nil
+})
+
+// newTestProofOfWork provisions and validates the given ProofOfWork, cleaning
+// it up once the test is done.
+func newTestProofOfWork(t *testing.T, p *ProofOfWork) *ProofOfWork {
+	t.Helper()
+	require.NoError(t, p.Provision(caddy.Context{}))
+	require.NoError(t, p.Validate())
+	t.Cleanup(func() { p.Cleanup() })
+	return p
+}
+
+// powRequestURI returns an option for servePoW which sets the request's URI.
+func powRequestURI(uri string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.RequestURI = uri
+		r.URL.Path, r.URL.RawQuery, _ = strings.Cut(uri, "?")
+	}
+}
+
+// powRequestHeader returns an option for servePoW which sets a header on the
+// request.
+func powRequestHeader(name, value string) func(*http.Request) {
+	return func(r *http.Request) { r.Header.Set(name, value) }
+}
+
+// powRequestCookies returns an option for servePoW which adds cookies to the
+// request.
+func powRequestCookies(cookies ...*http.Cookie) func(*http.Request) {
+	return func(r *http.Request) {
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+	}
+}
+
+// servePoW serves a GET request for `/` using p, with powTeapotNext as the
+// next handler. If c is given then the request carries its seed and the given
+// solution in cookies. opts can be used to modify the request prior to it
+// being served.
+func servePoW(
+	t *testing.T,
+	p *ProofOfWork,
+	c *pow.Challenge,
+	solution []byte,
+	opts ...func(*http.Request),
+) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var (
+		rw = httptest.NewRecorder()
+		r  = httptest.NewRequest("GET", "/", nil)
+	)
+
+	if c != nil {
+		r.AddCookie(&http.Cookie{Name: p.ChallengeSeedCookie, Value: hex.EncodeToString(c.Seed)})
+		r.AddCookie(&http.Cookie{Name: p.ChallengeSolutionCookie, Value: hex.EncodeToString(solution)})
+	}
+
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	require.NoError(t, p.ServeHTTP(rw, r, powTeapotNext))
+	return rw
+}
+
+func TestProofOfWorkValidate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("challenge_timeout", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			timeout time.Duration
+			valid   bool
+		}{
+			{-1 * time.Second, false},
+			{0, true},
+			{powMinChallengeTimeout - 1, false},
+			{powMinChallengeTimeout, true},
+			{12 * time.Hour, true},
+			{powMaxChallengeTimeout, true},
+			{powMaxChallengeTimeout + 1, false},
+		}
+
+		for _, test := range tests {
+			t.Run(test.timeout.String(), func(t *testing.T) {
+				p := ProofOfWork{ChallengeTimeout: test.timeout}
+				if err := p.Validate(); test.valid {
+					assert.NoError(t, err)
+				} else {
+					assert.Error(t, err)
+				}
+			})
+		}
+	})
+
+	t.Run("max_solution_uses", func(t *testing.T) {
+		t.Parallel()
+		assert.NoError(t, (&ProofOfWork{MaxSolutionUses: 1}).Validate())
+		assert.Error(t, (&ProofOfWork{MaxSolutionUses: -1}).Validate())
+	})
+}
+
+func TestProofOfWorkSecret(t *testing.T) {
+	const secret = "shhhhh"
+	t.Setenv("POW_TEST_SECRET", secret)
+
+	provision := func(t *testing.T, secretCfg string) (*ProofOfWork, error) {
+		p := &ProofOfWork{Secret: secretCfg, Target: 0x0FFFFFFF}
+		err := p.Provision(caddy.Context{})
+		if err == nil {
+			t.Cleanup(func() { p.Cleanup() })
+		}
+		return p, err
+	}
+
+	t.Run("env", func(t *testing.T) {
+		p, err := provision(t, "{env.POW_TEST_SECRET}")
+		require.NoError(t, err)
+
+		var (
+			store    = pow.NewMemoryStore(nil)
+			mgr      = pow.NewManager(store, []byte(secret), nil)
+			c        = p.mgr.NewChallenge()
+			solution = pow.Solve(c)
+		)
+		t.Cleanup(func() { store.Close() })
+
+		assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+	})
+
+	t.Run("unknown_placeholder", func(t *testing.T) {
+		p, err := provision(t, "{env.POW_TEST_SECRET_UNSET}")
+		assert.Error(t, err)
+
+		t.Log("Checking that Cleanup can be called after a failed Provision")
+		assert.NoError(t, p.Cleanup())
+	})
+
+	t.Run("old_secrets", func(t *testing.T) {
+		p := &ProofOfWork{
+			Secret:     "new",
+			OldSecrets: []string{"{env.POW_TEST_SECRET}"},
+			Target:     0x0FFFFFFF,
+		}
+		require.NoError(t, p.Provision(caddy.Context{}))
+		t.Cleanup(func() { p.Cleanup() })
+
+		var (
+			store    = pow.NewMemoryStore(nil)
+			oldMgr   = pow.NewManager(store, []byte(secret), &pow.ManagerOpts{Target: 0x0FFFFFFF})
+			c        = oldMgr.NewChallenge()
+			solution = pow.Solve(c)
+		)
+		t.Cleanup(func() { store.Close() })
+
+		t.Log("Checking that a challenge signed with the old secret is accepted")
+		assert.NoError(t, p.mgr.CheckSolution(c.Seed, solution))
+
+		t.Log("Checking that new challenges aren't signed with the old secret")
+		c = p.mgr.NewChallenge()
+		assert.Error(t, oldMgr.CheckSolution(c.Seed, pow.Solve(c)))
+
+		t.Log("Checking that old secrets are parsed from the Caddyfile")
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+			proof_of_work {
+				secret new
+				old_secret old1
+				old_secret old2
+			}
+		`)}
+
+		handler, err := proofOfWorkParseCaddyfile(h)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"old1", "old2"}, handler.(*ProofOfWork).OldSecrets)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "pow_secret")
+
+		provisionFile := func(t *testing.T) *ProofOfWork {
+			p := &ProofOfWork{SecretFile: path, Target: 0x0FFFFFFF}
+			require.NoError(t, p.Provision(caddy.Context{}))
+			t.Cleanup(func() { p.Cleanup() })
+			return p
+		}
+
+		t.Log("Checking that the secret file is created")
+		p := provisionFile(t)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		t.Log("Checking that the secret is stable across provisions")
+		var (
+			c        = p.mgr.NewChallenge()
+			solution = pow.Solve(c)
+		)
+		assert.NoError(t, provisionFile(t).mgr.CheckSolution(c.Seed, solution))
+
+		t.Log("Checking that a malformed secret file is an error")
+		require.NoError(t, os.WriteFile(path, []byte("not hex"), 0600))
+		p = &ProofOfWork{SecretFile: path}
+		assert.Error(t, p.Provision(caddy.Context{}))
+
+		t.Log("Checking that secret and secret_file are mutually exclusive")
+		p = &ProofOfWork{Secret: secret, SecretFile: path}
+		assert.Error(t, p.Provision(caddy.Context{}))
+		assert.NoError(t, p.Cleanup())
+	})
+
+	t.Run("file_watch", func(t *testing.T) {
+		var (
+			path      = filepath.Join(t.TempDir(), "pow_secret")
+			oldSecret = []byte("old secret")
+			newSecret = []byte("new secret")
+			store     = pow.NewMemoryStore(nil)
+		)
+		t.Cleanup(func() { store.Close() })
+
+		writeSecret := func(t *testing.T, secretHex string) {
+			require.NoError(t, os.WriteFile(path, []byte(secretHex+"\n"), 0600))
+		}
+
+		// signedWith returns true if new challenges from the Manager are
+		// signed using the given secret.
+		signedWith := func(mgr pow.Manager, secret []byte) bool {
+			c := mgr.NewChallenge()
+			return pow.NewManager(store, secret, nil).CheckSolution(c.Seed, pow.Solve(c)) == nil
+		}
+
+		writeSecret(t, hex.EncodeToString(oldSecret))
+		p := &ProofOfWork{
+			SecretFile:              path,
+			SecretFileWatchInterval: 10 * time.Millisecond,
+			Target:                  0x0FFFFFFF,
+			HostConfigs: []ProofOfWorkHostConfig{
+				{Host: "inherit.example.com"},
+				{Host: "own.example.com", Secret: "own secret"},
+			},
+		}
+		newTestProofOfWork(t, p)
+
+		var (
+			oldC        = p.mgr.NewChallenge()
+			oldSolution = pow.Solve(oldC)
+		)
+
+		t.Log("Checking that a changed secret file is reloaded")
+		writeSecret(t, hex.EncodeToString(newSecret))
+		assert.Eventually(t, func() bool {
+			return signedWith(p.mgr, newSecret)
+		}, time.Second, 10*time.Millisecond)
+
+		t.Log("Checking that host configs without their own secret are reloaded")
+		assert.True(t, signedWith(p.HostConfigs[0].mgr, newSecret))
+		assert.True(t, signedWith(p.HostConfigs[1].mgr, []byte("own secret")))
+
+		t.Log("Checking that challenges signed with the previous secret are accepted")
+		assert.NoError(t, p.mgr.CheckSolution(oldC.Seed, oldSolution))
+
+		t.Log("Checking that a malformed secret file doesn't replace the secret")
+		writeSecret(t, "not hex")
+		time.Sleep(50 * time.Millisecond)
+		assert.True(t, signedWith(p.mgr, newSecret))
+
+		t.Log("Checking that the watch interval requires a secret file")
+		noFileP := &ProofOfWork{Secret: secret, SecretFileWatchInterval: time.Second}
+		require.NoError(t, noFileP.Provision(caddy.Context{}))
+		t.Cleanup(func() { noFileP.Cleanup() })
+		assert.Error(t, noFileP.Validate())
+	})
+}
+
+func TestProofOfWorkHash(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		hash, signatureHash string
+		expAlgorithm        string
+	}{
+		{"", "", "SHA-512"},
+		{"sha256", "sha256", "SHA-256"},
+		{"sha512", "sha512", "SHA-512"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.hash+"/"+test.signatureHash, func(t *testing.T) {
+			t.Parallel()
+
+			p := newTestProofOfWork(t, &ProofOfWork{
+				Target:        0x0FFFFFFF,
+				Hash:          test.hash,
+				SignatureHash: test.signatureHash,
+			})
+
+			t.Log("Checking that the challenge page uses the algorithm")
+			rw := servePoW(t, p, nil, nil)
+			assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			assert.Contains(t, rw.Body.String(), `const hashAlgorithm = "`+test.expAlgorithm+`"`)
+
+			t.Log("Checking that a solution using the algorithm is accepted")
+			c := p.mgr.NewChallenge()
+			assert.Equal(t, http.StatusTeapot, servePoW(t, p, &c, pow.Solve(c)).Code)
+		})
+	}
+
+	t.Log("Checking that unsupported hashes are rejected")
+	for _, p := range []*ProofOfWork{{Hash: "md5"}, {SignatureHash: "sha1"}} {
+		assert.Error(t, p.Provision(caddy.Context{}))
+	}
+
+	t.Log("Checking that hashes are parsed from the Caddyfile")
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		proof_of_work {
+			hash sha256
+			signature_hash sha512
+		}
+	`)}
+
+	handler, err := proofOfWorkParseCaddyfile(h)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256", handler.(*ProofOfWork).Hash)
+	assert.Equal(t, "sha512", handler.(*ProofOfWork).SignatureHash)
+}
+
+func TestProofOfWorkCookieAttributes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		p           ProofOfWork
+		expPath     string
+		expDomain   string
+		expSecure   bool
+		expSameSite http.SameSite
+		expJS       string
+	}{
+		{
+			name:        "defaults",
+			expPath:     "/",
+			expSameSite: http.SameSiteLaxMode,
+			expJS:       `Path=\/; SameSite=Lax`,
+		},
+		{
+			name:        "path",
+			p:           ProofOfWork{CookiePath: "/app"},
+			expPath:     "/app",
+			expSameSite: http.SameSiteLaxMode,
+			expJS:       `Path=\/app; SameSite=Lax`,
+		},
+		{
+			name:        "domain",
+			p:           ProofOfWork{CookieDomain: "example.com"},
+			expPath:     "/",
+			expDomain:   "example.com",
+			expSameSite: http.SameSiteLaxMode,
+			expJS:       `Path=\/; Domain=example.com; SameSite=Lax`,
+		},
+		{
+			name:        "strict",
+			p:           ProofOfWork{CookieSameSite: "strict"},
+			expPath:     "/",
+			expSameSite: http.SameSiteStrictMode,
+			expJS:       `Path=\/; SameSite=Strict`,
+		},
+		{
+			name:        "secure none",
+			p:           ProofOfWork{CookieSameSite: "none", CookieSecure: true},
+			expPath:     "/",
+			expSecure:   true,
+			expSameSite: http.SameSiteNoneMode,
+			expJS:       `Path=\/; Secure; SameSite=None`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &test.p
+			p.Target = 0x0FFFFFFF
+			p.PassToken = true
+			newTestProofOfWork(t, p)
+
+			t.Log("Checking that cookies set by the challenge page's JS have the attributes")
+			rw := servePoW(t, p, nil, nil, powRequestURI("/app"))
+			assert.Contains(t, rw.Body.String(), "=${seedStr}; "+test.expJS+"`")
+
+			t.Log("Checking that cookies set by the handler have the attributes")
+			c := p.mgr.NewChallenge()
+			rw = servePoW(t, p, &c, pow.Solve(c), powRequestURI("/app"))
+			require.Equal(t, http.StatusTeapot, rw.Code)
+
+			cookies := rw.Result().Cookies()
+			require.Len(t, cookies, 1)
+			assert.Equal(t, powPassTokenCookieName, cookies[0].Name)
+			assert.Equal(t, test.expPath, cookies[0].Path)
+			assert.Equal(t, test.expDomain, cookies[0].Domain)
+			assert.Equal(t, test.expSecure, cookies[0].Secure)
+			assert.Equal(t, test.expSameSite, cookies[0].SameSite)
+			assert.True(t, cookies[0].HttpOnly)
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		p := &ProofOfWork{CookieSameSite: "bogus"}
+		assert.Error(t, p.Provision(caddy.Context{}))
+
+		p = &ProofOfWork{CookieSameSite: "none"}
+		require.NoError(t, p.Provision(caddy.Context{}))
+		t.Cleanup(func() { p.Cleanup() })
+		assert.Error(t, p.Validate())
+	})
+
+	t.Run("caddyfile", func(t *testing.T) {
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+			proof_of_work {
+				cookie_path /app
+				cookie_domain example.com
+				cookie_same_site none
+				cookie_secure on
+			}
+		`)}
+
+		handler, err := proofOfWorkParseCaddyfile(h)
+		require.NoError(t, err)
+
+		p := handler.(*ProofOfWork)
+		assert.Equal(t, "/app", p.CookiePath)
+		assert.Equal(t, "example.com", p.CookieDomain)
+		assert.Equal(t, "none", p.CookieSameSite)
+		assert.True(t, p.CookieSecure)
+	})
+}
+
+func TestProofOfWorkJSONChallenge(t *testing.T) {
+	t.Parallel()
+
+	newPoW := func(t *testing.T, jsonChallenge bool) *ProofOfWork {
+		return newTestProofOfWork(t, &ProofOfWork{
+			Target: 0x0FFFFFFF, JSONChallenge: jsonChallenge,
+		})
+	}
+
+	serve := func(
+		t *testing.T, p *ProofOfWork, accept string, header http.Header,
+	) *httptest.ResponseRecorder {
+		return servePoW(t, p, nil, nil, func(r *http.Request) {
+			for name, values := range header {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+			if accept != "" {
+				r.Header.Set("Accept", accept)
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		p := newPoW(t, true)
+
+		t.Log("Checking that the challenge is served as JSON")
+		rw := serve(t, p, "application/json", nil)
+		assert.Equal(t, http.StatusUnauthorized, rw.Code)
+		assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", rw.Header().Get("Vary"))
+		assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+
+		var got powJSONChallenge
+		require.NoError(t, json.Unmarshal(rw.Body.Bytes(), &got))
+		assert.Equal(t, uint32(0x0FFFFFFF), got.Target)
+		assert.Equal(t, pow.HashSHA512, got.Hash)
+		assert.Equal(t, p.ChallengeSeedCookie, got.SeedCookie)
+		assert.Equal(t, p.ChallengeSolutionCookie, got.SolutionCookie)
+		assert.Equal(t, "X-POW-Seed", got.SeedHeader)
+		assert.Equal(t, "X-POW-Solution", got.SolutionHeader)
+
+		seed, err := hex.DecodeString(got.Seed)
+		require.NoError(t, err)
+		solution := pow.Solve(pow.Challenge{Seed: seed, Target: got.Target, Hash: got.Hash})
+
+		t.Log("Checking that the solution is accepted via headers")
+		rw = serve(t, p, "application/json", http.Header{
+			got.SeedHeader:     {got.Seed},
+			got.SolutionHeader: {hex.EncodeToString(solution)},
+		})
+		assert.Equal(t, http.StatusTeapot, rw.Code)
+
+		t.Log("Checking that an invalid solution in headers is challenged")
+		rw = serve(t, p, "application/json", http.Header{
+			got.SeedHeader:     {got.Seed},
+			got.SolutionHeader: {hex.EncodeToString(make([]byte, len(seed)+1))},
+		})
+		assert.Equal(t, http.StatusUnauthorized, rw.Code)
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		t.Parallel()
+		p := newPoW(t, true)
+
+		tests := []struct {
+			accept  string
+			expJSON bool
+		}{
+			{"", false},
+			{"text/html,application/xhtml+xml,*/*;q=0.8", false},
+			{"application/json", true},
+			{"text/plain, application/json;q=0.5", true},
+			{"application/json;q=0", false},
+		}
+
+		for _, test := range tests {
+			rw := serve(t, p, test.accept, nil)
+			if test.expJSON {
+				assert.Equal(t, http.StatusUnauthorized, rw.Code, "accept: %q", test.accept)
+			} else {
+				assert.Equal(t, http.StatusOK, rw.Code, "accept: %q", test.accept)
+				assert.Contains(t, rw.Body.String(), "<html", "accept: %q", test.accept)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		p := newPoW(t, false)
+
+		t.Log("Checking that the HTML challenge is served")
+		rw := serve(t, p, "application/json", nil)
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Empty(t, rw.Header().Get("Vary"))
+
+		t.Log("Checking that solutions aren't accepted via headers")
+		var (
+			c        = p.mgr.NewChallenge()
+			solution = pow.Solve(c)
+		)
+		rw = serve(t, p, "", http.Header{
+			"X-Pow-Seed":     {hex.EncodeToString(c.Seed)},
+			"X-Pow-Solution": {hex.EncodeToString(solution)},
+		})
+		assert.NotEqual(t, http.StatusTeapot, rw.Code)
+	})
+}
+
+func TestProofOfWorkOrigURI(t *testing.T) {
+	t.Parallel()
+
+	p := &ProofOfWork{}
+	require.NoError(t, p.Provision(caddy.Context{}))
+	t.Cleanup(func() { p.Cleanup() })
+
+	serve := func(t *testing.T, target string, repl *caddy.Replacer) string {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest("GET", target, nil)
+		)
+		r = r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl))
+		require.NoError(t, p.ServeHTTP(rw, r, nil))
+		assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+		return rw.Body.String()
+	}
+
+	t.Log("Checking that the original URI is taken from the replacer")
+	repl := caddy.NewReplacer()
+	repl.Set("http.request.orig_uri", "/article?page=3")
+	body := serve(t, "/rewritten", repl)
+	assert.Contains(t, body, `const origURI = "\/article?page=3";`)
+
+	t.Log("Checking that the request URI is used if the original URI isn't known")
+	body = serve(t, "/article?page=3&sort=asc", caddy.NewReplacer())
+	assert.Contains(t, body, `const origURI = "\/article?page=3\u0026sort=asc";`)
+}
+
+func TestProofOfWorkSolverWorkers(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, p *ProofOfWork) string {
+		return servePoW(t, newTestProofOfWork(t, p), nil, nil).Body.String()
+	}
+
+	t.Log("Checking that the worker count is uncapped by default")
+	assert.Contains(t, serve(t, &ProofOfWork{}), `Number("0") || Infinity`)
+
+	t.Log("Checking that the worker count is capped")
+	assert.Contains(t, serve(t, &ProofOfWork{SolverWorkers: 3}), `Number("3") || Infinity`)
+
+	t.Log("Checking that a negative worker count is invalid")
+	assert.Error(t, (&ProofOfWork{SolverWorkers: -1}).Validate())
+
+	t.Log("Checking that the worker count is parsed from the Caddyfile")
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		proof_of_work {
+			solver_workers 2
+		}
+	`)}
+
+	handler, err := proofOfWorkParseCaddyfile(h)
+	require.NoError(t, err)
+	assert.Equal(t, 2, handler.(*ProofOfWork).SolverWorkers)
+}
+
+func TestProofOfWorkJSFreeFallback(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{JSFreeFallback: true})
+
+	serve := func(t *testing.T, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		return servePoW(t, p, nil, nil, powRequestCookies(cookies...))
+	}
+
+	t.Log("Checking that first request is given a javascript challenge")
+	rw := serve(t, nil)
+	assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+	assert.Contains(t, rw.Body.String(), "<noscript>")
+
+	cookies := rw.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, powChallengeAttemptCookieName, cookies[0].Name)
+
+	t.Log("Checking that returning without a solution is given the fallback")
+	rw = serve(t, cookies)
+	assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+	assert.Contains(t, rw.Body.String(), `<meta http-equiv="refresh" content="0">`)
+
+	cookies = nil
+	for _, c := range rw.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			cookies = append(cookies, c)
+		}
+	}
+	require.Len(t, cookies, 2)
+
+	t.Log("Checking that the fallback solution is accepted")
+	rw = serve(t, cookies)
+	assert.Equal(t, http.StatusTeapot, rw.Code)
+}
+
+func TestProofOfWorkValidateJSFreeFallbackTarget(t *testing.T) {
+	t.Parallel()
+
+	p := ProofOfWork{JSFreeFallbackTarget: powMinJSFreeFallbackTarget - 1}
+	assert.Error(t, p.Validate())
+
+	p.JSFreeFallbackTarget = powMinJSFreeFallbackTarget
+	assert.NoError(t, p.Validate())
+}
+
+func TestProofOfWorkPassToken(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{Target: 0x0FFFFFFF, PassToken: true})
+
+	serve := func(t *testing.T, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		return servePoW(t, p, nil, nil, powRequestCookies(cookies...))
+	}
+
+	var (
+		c        = p.mgr.NewChallenge()
+		solution = pow.Solve(c)
+	)
+
+	t.Log("Checking that a valid solution is given a pass token")
+	rw := servePoW(t, p, &c, solution)
+	assert.Equal(t, http.StatusTeapot, rw.Code)
+
+	cookies := rw.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, powPassTokenCookieName, cookies[0].Name)
+
+	t.Log("Checking that the pass token alone is accepted, without a new one being issued")
+	rw = serve(t, cookies[0])
+	assert.Equal(t, http.StatusTeapot, rw.Code)
+	assert.Empty(t, rw.Result().Cookies())
+
+	t.Log("Checking that a tampered pass token is not accepted")
+	cookies[0].Value = "00" + cookies[0].Value[2:]
+	rw = serve(t, cookies[0])
+	assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+}
+
+func TestProofOfWorkPassHeader(t *testing.T) {
+	t.Parallel()
+
+	const header = "X-Pow-Pass"
+
+	newProofOfWork := func(t *testing.T, secret string, p *ProofOfWork) *ProofOfWork {
+		p.Target, p.Secret = 0x0FFFFFFF, secret
+		return newTestProofOfWork(t, p)
+	}
+
+	var (
+		edge      = newProofOfWork(t, "secret", &ProofOfWork{ForwardPassHeader: header})
+		origin    = newProofOfWork(t, "secret", &ProofOfWork{TrustedPassHeader: header})
+		other     = newProofOfWork(t, "other", &ProofOfWork{ForwardPassHeader: header})
+		forwarded string
+		edgeNext  = caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			forwarded = r.Header.Get(header)
+			rw.WriteHeader(http.StatusTeapot)
+			return nil
+		})
+	)
+
+	serveEdge := func(t *testing.T, p *ProofOfWork, passHeader string) string {
+		var (
+			rw       = httptest.NewRecorder()
+			r        = httptest.NewRequest("GET", "/", nil)
+			c        = p.mgr.NewChallenge()
+			solution = pow.Solve(c)
+		)
+		r.Header.Set(header, passHeader)
+		r.AddCookie(&http.Cookie{Name: p.ChallengeSeedCookie, Value: hex.EncodeToString(c.Seed)})
+		r.AddCookie(&http.Cookie{Name: p.ChallengeSolutionCookie, Value: hex.EncodeToString(solution)})
+
+		forwarded = ""
+		require.NoError(t, p.ServeHTTP(rw, r, edgeNext))
+		require.Equal(t, http.StatusTeapot, rw.Code)
+		return forwarded
+	}
+
+	serveOrigin := func(t *testing.T, passHeader string) *httptest.ResponseRecorder {
+		var opts []func(*http.Request)
+		if passHeader != "" {
+			opts = append(opts, powRequestHeader(header, passHeader))
+		}
+		return servePoW(t, origin, nil, nil, opts...)
+	}
+
+	t.Log("Checking that the edge replaces the client's header with a pass token")
+	passHeader := serveEdge(t, edge, "bogus")
+	assert.NotEqual(t, "bogus", passHeader)
+
+	t.Log("Checking that the origin accepts the forwarded pass token")
+	rw := serveOrigin(t, passHeader)
+	assert.Equal(t, http.StatusTeapot, rw.Code)
+	assert.Empty(t, rw.Result().Cookies())
+
+	t.Log("Checking that the origin rejects a missing or forged pass token")
+	for _, passHeader := range []string{
+		"",
+		"bogus",
+		"00" + passHeader[2:],
+		serveEdge(t, other, ""),
+	} {
+		rw := serveOrigin(t, passHeader)
+		assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{ForwardPassHeader: header, TrustedPassHeader: "x-pow-pass"}
+		assert.Error(t, p.Validate())
+
+		p.TrustedPassHeader = "X-Other-Pass"
+		assert.NoError(t, p.Validate())
+	})
+}
+
+func TestProofOfWorkMetrics(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{Target: 0x0FFFFFFF})
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, p.registerMetrics(reg))
+
+	var (
+		c         = p.mgr.NewChallenge()
+		solution  = pow.Solve(c)
+		malformed = pow.Challenge{Seed: make([]byte, len(c.Seed))}
+	)
+
+	t.Log("Simulating challenges and a success")
+	servePoW(t, p, nil, nil)
+	servePoW(t, p, &malformed, solution)
+	servePoW(t, p, &c, solution)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP mediocre_caddy_plugins_http_pow_challenges_total `+powChallengesMetricHelp+`
+# TYPE mediocre_caddy_plugins_http_pow_challenges_total counter
+mediocre_caddy_plugins_http_pow_challenges_total{reason="malformed"} 1
+mediocre_caddy_plugins_http_pow_challenges_total{reason="missing"} 1
+# HELP mediocre_caddy_plugins_http_pow_solutions_total `+powSolutionsMetricHelp+`
+# TYPE mediocre_caddy_plugins_http_pow_solutions_total counter
+mediocre_caddy_plugins_http_pow_solutions_total 1
+`)))
+
+	t.Run("reasons", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			err error
+			exp string
+		}{
+			{errPowSolutionNotGiven, "missing"},
+			{fmt.Errorf("parsing: %w", pow.ErrMalformedSeed), "malformed"},
+			{pow.ErrExpiredSeed, "expired"},
+			{pow.ErrInvalidSolution, "invalid"},
+			{pow.ErrSolutionReused, "reused"},
+			{pow.ErrSeedRevoked, "revoked"},
+			{errors.New("unknown"), "other"},
+		}
+
+		for _, test := range tests {
+			assert.Equal(t, test.exp, powChallengeReason(test.err), test.err.Error())
+		}
+	})
+}
+
+func TestProofOfWorkMaxSolutionUses(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{Target: 0x0FFFFFFF, MaxSolutionUses: 2})
+
+	var (
+		c        = p.mgr.NewChallenge()
+		solution = pow.Solve(c)
+	)
+
+	t.Log("Checking that the solution is accepted up to the limit")
+	for range 2 {
+		assert.Equal(t, http.StatusTeapot, servePoW(t, p, &c, solution).Code)
+	}
+
+	t.Log("Checking that the solution is rejected once the limit is exceeded")
+	rw := servePoW(t, p, &c, solution)
+	assert.NotEqual(t, http.StatusTeapot, rw.Code)
+	assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+}
+
+func TestProofOfWorkMaxSolutionUsesSolveReport(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{Target: 0x0FFFFFFF, MaxSolutionUses: 1})
+
+	var (
+		c        = p.mgr.NewChallenge()
+		solution = pow.Solve(c)
+		report   = func(r *http.Request) {
+			r.Method = "HEAD"
+			r.Header.Set(powHashRateHeaderName, "1000.00")
+		}
+	)
+
+	t.Log("Checking that the solve report doesn't use up the solution")
+	assert.Equal(t, http.StatusNoContent, servePoW(t, p, &c, solution, report).Code)
+	assert.Equal(t, http.StatusTeapot, servePoW(t, p, &c, solution).Code)
+
+	t.Log("Checking that a solve report is rejected once the solution is used up")
+	rw := servePoW(t, p, &c, solution, report)
+	assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+}
+
+func TestProofOfWorkRedisStore(t *testing.T) {
+	t.Parallel()
+
+	server := miniredis.RunT(t)
+
+	newProofOfWork := func(t *testing.T) *ProofOfWork {
+		return newTestProofOfWork(t, &ProofOfWork{
+			Secret:          "shhhhh",
+			Target:          0x0FFFFFFF,
+			MaxSolutionUses: 1,
+			RedisStore:      &ProofOfWorkRedisStore{Addr: server.Addr()},
+		})
+	}
+
+	var (
+		a, b     = newProofOfWork(t), newProofOfWork(t)
+		c        = a.mgr.NewChallenge()
+		solution = pow.Solve(c)
+	)
+
+	t.Log("Checking that the solution is accepted by the first instance")
+	assert.Equal(t, http.StatusTeapot, servePoW(t, a, &c, solution).Code)
+	assert.Len(t, server.Keys(), 2)
+
+	t.Log("Checking that the second instance sees the solution's use")
+	rw := servePoW(t, b, &c, solution)
+	assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+
+	t.Run("parse", func(t *testing.T) {
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+			proof_of_work {
+				store redis {
+					addr localhost:6379
+					password hunter2
+					db 2
+				}
+			}
+		`)}
+
+		handler, err := proofOfWorkParseCaddyfile(h)
+		require.NoError(t, err)
+		assert.Equal(t, &ProofOfWorkRedisStore{
+			Addr: "localhost:6379", Password: "hunter2", DB: 2,
+		}, handler.(*ProofOfWork).RedisStore)
+	})
+}
+
+func TestProofOfWorkChallengeHeaders(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{
+		ChallengeHeaders: map[string]string{
+			"Cache-Control": "no-cache",
+			"X-Robots-Tag":  "",
+			"X-Custom":      "foo",
+		},
+	})
+
+	rw := servePoW(t, p, nil, nil)
+	assert.Equal(t, "no-cache", rw.Header().Get("Cache-Control"))
+	assert.Equal(t, "foo", rw.Header().Get("X-Custom"))
+	assert.NotContains(t, rw.Header(), "X-Robots-Tag")
+}
+
+func TestProofOfWorkPathTargets(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{
+		Target:      0x000FFFFF,
+		TrustHeader: "X-Trust-Score",
+		TrustTiers: []ProofOfWorkTrustTier{
+			{MinScore: 90, NoChallenge: true},
+			{MinScore: 50, Target: 0x0FFFFFFF},
+		},
+		PathTargets: []ProofOfWorkPathTarget{
+			{PathPrefix: "/search", Target: 0x0000FFFF},
+			{PathPrefix: "/search/cheap", Target: 0x00FFFFFF},
+		},
+	})
+
+	targetStr := func(target uint32) string {
+		return `const target = "` + strconv.FormatUint(uint64(target), 10) + `"`
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		score     string
+		expTarget uint32 // 0 indicates no challenge
+	}{
+		{"no match", "/", "", 0x000FFFFF},
+		{"prefix", "/search?q=fish", "", 0x0000FFFF},
+		{"longest prefix", "/search/cheap/thing", "", 0x00FFFFFF},
+		{"overrides trust tier", "/search", "50", 0x0000FFFF},
+		{"trusted", "/search", "95", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := []func(*http.Request){powRequestURI(test.path)}
+			if test.score != "" {
+				opts = append(opts, powRequestHeader("X-Trust-Score", test.score))
+			}
+
+			rw := servePoW(t, p, nil, nil, opts...)
+
+			if test.expTarget == 0 {
+				assert.Equal(t, http.StatusTeapot, rw.Code)
+				return
+			}
+
+			assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			assert.Contains(t, rw.Body.String(), targetStr(test.expTarget))
+		})
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{PathTargets: []ProofOfWorkPathTarget{
+			{PathPrefix: "search", Target: 0x0000FFFF},
+		}}
+		assert.Error(t, p.Validate())
+
+		p.PathTargets[0] = ProofOfWorkPathTarget{PathPrefix: "/search"}
+		assert.Error(t, p.Validate())
+
+		p.PathTargets[0].Target = 0x0000FFFF
+		assert.NoError(t, p.Validate())
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+			proof_of_work {
+				target_for /search 0x0000FFFF
+				target_for /api 4096
+			}
+		`)}
+
+		handler, err := proofOfWorkParseCaddyfile(h)
+		require.NoError(t, err)
+		assert.Equal(t, []ProofOfWorkPathTarget{
+			{PathPrefix: "/search", Target: 0x0000FFFF},
+			{PathPrefix: "/api", Target: 4096},
+		}, handler.(*ProofOfWork).PathTargets)
+	})
+}
+
+func TestProofOfWorkInitialTarget(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{
+		Target:        0x000FFFFF,
+		InitialTarget: 0x0FFFFFFF,
+		PassToken:     true,
+	})
+
+	serve := func(t *testing.T, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		return servePoW(t, p, nil, nil, powRequestCookies(cookies...))
+	}
+
+	targetStr := func(target uint32) string {
+		return `const target = "` + strconv.FormatUint(uint64(target), 10) + `"`
+	}
+
+	cookie := func(rw *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rw.Result().Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+
+	t.Log("Checking that a new client is given the initial target")
+	rw := serve(t)
+	assert.Contains(t, rw.Body.String(), targetStr(0x0FFFFFFF))
+	assert.Nil(t, cookie(rw, powReturningCookieName))
+
+	t.Log("Checking that passing a challenge marks the client as returning")
+	var (
+		c        = p.initialMgr.NewChallenge()
+		solution = pow.Solve(c)
+	)
+	rw = servePoW(t, p, &c, solution)
+	assert.Equal(t, http.StatusTeapot, rw.Code)
+
+	marker := cookie(rw, powReturningCookieName)
+	require.NotNil(t, marker)
+
+	t.Log("Checking that a returning client is given the standard target")
+	rw = serve(t, marker)
+	assert.Contains(t, rw.Body.String(), targetStr(0x000FFFFF))
+
+	t.Log("Checking that a returning client which passes isn't marked again")
+	rw = serve(t, marker, &http.Cookie{
+		Name:  powPassTokenCookieName,
+		Value: hex.EncodeToString(p.mgr.NewPassToken(time.Minute)),
+	})
+	assert.Equal(t, http.StatusTeapot, rw.Code)
+	assert.Nil(t, cookie(rw, powReturningCookieName))
+
+	t.Log("Checking that a tampered marker is not accepted")
+	tampered := *marker
+	tampered.Value = "00" + tampered.Value[2:]
+	rw = serve(t, &tampered)
+	assert.Contains(t, rw.Body.String(), targetStr(0x0FFFFFFF))
+
+	t.Log("Checking that a marker is not accepted as a pass token")
+	rw = serve(t, &http.Cookie{Name: powPassTokenCookieName, Value: marker.Value})
+	assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{Target: 0x0FFFFFFF, InitialTarget: 0x000FFFFF}
+		assert.Error(t, p.Validate())
+
+		p.InitialTarget = 0x1FFFFFFF
+		assert.NoError(t, p.Validate())
+	})
+}
+
+func TestProofOfWorkTrustTiers(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{
+		Target:      0x000FFFFF,
+		TrustHeader: "X-Trust-Score",
+		TrustTiers: []ProofOfWorkTrustTier{
+			{MinScore: 90, NoChallenge: true},
+			{MinScore: 50, Target: 0x0FFFFFFF},
+		},
+	})
+
+	targetStr := func(target uint32) string {
+		return `const target = "` + strconv.FormatUint(uint64(target), 10) + `"`
+	}
+
+	tests := []struct {
+		name      string
+		score     string
+		expTarget uint32 // 0 indicates no challenge
+	}{
+		{"no header", "", 0x000FFFFF},
+		{"invalid", "bogus", 0x000FFFFF},
+		{"below all tiers", "10", 0x000FFFFF},
+		{"middle tier", "50", 0x0FFFFFFF},
+		{"trusted", "95.5", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var opts []func(*http.Request)
+			if test.score != "" {
+				opts = append(opts, powRequestHeader("X-Trust-Score", test.score))
+			}
+
+			rw := servePoW(t, p, nil, nil, opts...)
+
+			if test.expTarget == 0 {
+				assert.Equal(t, http.StatusTeapot, rw.Code)
+				return
+			}
+
+			assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			assert.Contains(t, rw.Body.String(), targetStr(test.expTarget))
+		})
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{TrustTiers: []ProofOfWorkTrustTier{{MinScore: 1}}}
+		assert.Error(t, p.Validate())
+
+		p.TrustHeader = "X-Trust-Score"
+		assert.Error(t, p.Validate())
+
+		p.TrustTiers[0].Target = 0x0FFFFFFF
+		assert.NoError(t, p.Validate())
+	})
+}
+
+func TestProofOfWorkCookiePath(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{Target: 0x0FFFFFFF, CookiePath: "/app"})
+
+	serve := func(t *testing.T, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		return servePoW(
+			t, p, nil, nil, powRequestURI("/app/"), powRequestCookies(cookies...),
+		)
+	}
+
+	t.Log("Checking that the challenge sets cookies with the configured path")
+	rw := serve(t)
+	assert.Contains(t, rw.Body.String(), `; Path=\/app`)
+
+	t.Log("Checking that a valid solution is found amongst multiple cookies")
+	var (
+		c        = p.mgr.NewChallenge()
+		solution = pow.Solve(c)
+		stale    = p.mgr.NewChallenge()
+	)
+
+	rw = serve(
+		t,
+		&http.Cookie{Name: p.ChallengeSeedCookie, Value: hex.EncodeToString(stale.Seed)},
+		&http.Cookie{Name: p.ChallengeSolutionCookie, Value: "00"},
+		&http.Cookie{Name: p.ChallengeSeedCookie, Value: hex.EncodeToString(c.Seed)},
+		&http.Cookie{Name: p.ChallengeSolutionCookie, Value: hex.EncodeToString(solution)},
+	)
+	assert.Equal(t, http.StatusTeapot, rw.Code)
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{CookiePath: "app"}
+		assert.Error(t, p.Validate())
+	})
+}
+
+func TestProofOfWorkDefaultTarget(t *testing.T) {
+	t.Parallel()
+
+	targetStr := func(target uint32) string {
+		return `const target = "` + strconv.FormatUint(uint64(target), 10) + `"`
+	}
+
+	tests := []struct {
+		name          string
+		defaultTarget uint32
+		score         string
+		expTarget     uint32
+	}{
+		{"no header", 0x00FFFFFF, "", 0x00FFFFFF},
+		{"invalid", 0x00FFFFFF, "bogus", 0x00FFFFFF},
+		{"below all tiers", 0x00FFFFFF, "10", 0x000FFFFF},
+		{"tier", 0x00FFFFFF, "50", 0x0FFFFFFF},
+		{"unset no header", 0, "", 0x000FFFFF},
+		{"unset invalid", 0, "bogus", 0x000FFFFF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newTestProofOfWork(t, &ProofOfWork{
+				Target:        0x000FFFFF,
+				DefaultTarget: test.defaultTarget,
+				TrustHeader:   "X-Trust-Score",
+				TrustTiers: []ProofOfWorkTrustTier{
+					{MinScore: 50, Target: 0x0FFFFFFF},
+				},
+			})
+
+			var opts []func(*http.Request)
+			if test.score != "" {
+				opts = append(opts, powRequestHeader("X-Trust-Score", test.score))
+			}
+
+			rw := servePoW(t, p, nil, nil, opts...)
+			assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			assert.Contains(t, rw.Body.String(), targetStr(test.expTarget))
+		})
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{DefaultTarget: 0x00FFFFFF}
+		assert.Error(t, p.Validate())
+
+		p.TrustHeader = "X-Trust-Score"
+		assert.NoError(t, p.Validate())
+	})
+}
+
+func TestProofOfWorkHostConfigs(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{
+		Target: 0x0FFFFFFF,
+		HostConfigs: []ProofOfWorkHostConfig{
+			{Host: "a.example.com", Secret: "a secret", Target: 0x0EFFFFFF},
+			{Host: "*.b.example.com", Secret: "b secret"},
+		},
+	})
+
+	serve := func(t *testing.T, host string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		return servePoW(t, p, nil, nil, func(r *http.Request) {
+			r.Host = host
+		}, powRequestCookies(cookies...))
+	}
+
+	solve := func(mgr pow.Manager) []*http.Cookie {
+		c := mgr.NewChallenge()
+		return []*http.Cookie{
+			{Name: p.ChallengeSeedCookie, Value: hex.EncodeToString(c.Seed)},
+			{Name: p.ChallengeSolutionCookie, Value: hex.EncodeToString(pow.Solve(c))},
+		}
+	}
+
+	targetStr := func(target uint32) string {
+		return `const target = "` + strconv.FormatUint(uint64(target), 10) + `"`
+	}
+
+	t.Log("Checking that the matching host config's target is used")
+	assert.Contains(t, serve(t, "a.example.com:443").Body.String(), targetStr(0x0EFFFFFF))
+	assert.Contains(t, serve(t, "x.b.example.com").Body.String(), targetStr(0x0FFFFFFF))
+	assert.Contains(t, serve(t, "other.com").Body.String(), targetStr(0x0FFFFFFF))
+
+	var (
+		aSolution   = solve(p.HostConfigs[0].mgr)
+		topSolution = solve(p.mgr)
+	)
+
+	tests := []struct {
+		name     string
+		host     string
+		cookies  []*http.Cookie
+		expValid bool
+	}{
+		{"host solution on host", "A.example.com", aSolution, true},
+		{"host solution on other host", "x.b.example.com", aSolution, false},
+		{"host solution on top-level", "other.com", aSolution, false},
+		{"top-level solution on top-level", "other.com", topSolution, true},
+		{"top-level solution on host", "a.example.com", topSolution, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rw := serve(t, test.host, test.cookies...)
+			if test.expValid {
+				assert.Equal(t, http.StatusTeapot, rw.Code)
+			} else {
+				assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			}
+		})
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{HostConfigs: []ProofOfWorkHostConfig{{Host: "["}}}
+		assert.Error(t, p.Validate())
+
+		p.HostConfigs[0] = ProofOfWorkHostConfig{
+			Host: "a.example.com", ChallengeTimeout: time.Second,
+		}
+		assert.Error(t, p.Validate())
+
+		p.HostConfigs[0].ChallengeTimeout = time.Hour
+		assert.NoError(t, p.Validate())
+	})
+}
+
+func TestProofOfWorkSolveReport(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{
+		Target:              0x000FFFFF,
+		LowIterationsRatio:  0.01,
+		LowIterationsAction: powLowIterationsActionRechallenge,
+	})
+
+	report := func(
+		t *testing.T, method string, mgr pow.Manager,
+	) *httptest.ResponseRecorder {
+		c := mgr.NewChallenge()
+		return servePoW(t, p, &c, pow.Solve(c), func(r *http.Request) {
+			r.Method = method
+			r.Header.Set(powHashRateHeaderName, "1000.00")
+			r.Header.Set(powIterationsHeaderName, "1")
+		})
+	}
+
+	t.Log("Checking that HEAD reports are answered by the handler")
+	assert.Equal(t, http.StatusNoContent, report(t, "HEAD", p.mgr).Code)
+
+	t.Log("Checking that other methods are passed on despite the headers")
+	for _, method := range []string{"GET", "POST"} {
+		assert.Equal(t, http.StatusTeapot, report(t, method, p.mgr).Code)
+	}
+
+	t.Log("Checking that the target is taken from the solved seed")
+	rw := report(t, "HEAD", p.newPowManager(0x0FFFFFFF))
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+	for _, cookie := range rw.Result().Cookies() {
+		assert.GreaterOrEqual(t, cookie.MaxAge, 0, "cookie %q cleared", cookie.Name)
+	}
+}
+
+func TestProofOfWorkLowIterations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		action         string
+		iterations     string
+		expRechallenge bool
+	}{
+		{"log", powLowIterationsActionLog, "1", false},
+		{"rechallenge", powLowIterationsActionRechallenge, "1", true},
+		{"rechallenge plausible", powLowIterationsActionRechallenge, "4096", false},
+		{"rechallenge missing", powLowIterationsActionRechallenge, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newTestProofOfWork(t, &ProofOfWork{
+				Target:              0x000FFFFF,
+				PassToken:           true,
+				LowIterationsRatio:  0.01,
+				LowIterationsAction: test.action,
+			})
+
+			var (
+				c        = p.mgr.NewChallenge()
+				solution = pow.Solve(c)
+			)
+
+			rw := servePoW(t, p, &c, solution, func(r *http.Request) {
+				r.Method = "HEAD"
+				r.Header.Set(powHashRateHeaderName, "1000.00")
+				if test.iterations != "" {
+					r.Header.Set(powIterationsHeaderName, test.iterations)
+				}
+			})
+			assert.Equal(t, http.StatusNoContent, rw.Code)
+
+			var cleared, set []string
+			for _, cookie := range rw.Result().Cookies() {
+				if cookie.MaxAge < 0 {
+					cleared = append(cleared, cookie.Name)
+				} else {
+					set = append(set, cookie.Name)
+				}
+			}
+
+			// A client which ignores the cleared cookies and replays its
+			// solution should still be rechallenged.
+			rw = servePoW(t, p, &c, solution)
+
+			if test.expRechallenge {
+				assert.ElementsMatch(t, []string{
+					p.ChallengeSeedCookie,
+					p.ChallengeSolutionCookie,
+					powPassTokenCookieName,
+				}, cleared)
+
+				t.Log("Checking that no pass token is issued when rechallenging")
+				assert.Empty(t, set)
+
+				t.Log("Checking that the solution is no longer accepted")
+				assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			} else {
+				assert.Empty(t, cleared)
+				assert.Equal(t, []string{powPassTokenCookieName}, set)
+				assert.Equal(t, http.StatusTeapot, rw.Code)
+			}
+		})
+	}
+
+	t.Run("validate", func(t *testing.T) {
+		p := ProofOfWork{LowIterationsRatio: 1}
+		assert.Error(t, p.Validate())
+
+		p = ProofOfWork{LowIterationsAction: "bogus"}
+		assert.Error(t, p.Validate())
+	})
+}
+
+func TestProofOfWorkSkipAuthenticated(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		p             ProofOfWork
+		authorization string
+		userID        string
+		expSkip       bool
+	}{
+		{"disabled", ProofOfWork{}, "Basic Ym9iOmh1bnRlcjI=", "bob", false},
+		{"authorized", ProofOfWork{SkipAuthorized: true}, "Basic Ym9iOmh1bnRlcjI=", "", true},
+		{"not authorized", ProofOfWork{SkipAuthorized: true}, "", "", false},
+		{"placeholder", ProofOfWork{SkipPlaceholder: "{http.auth.user.id}"}, "", "bob", true},
+		{"placeholder empty", ProofOfWork{SkipPlaceholder: "{http.auth.user.id}"}, "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newTestProofOfWork(t, &test.p)
+
+			rw := servePoW(t, p, nil, nil, func(r *http.Request) {
+				if test.authorization != "" {
+					r.Header.Set("Authorization", test.authorization)
+				}
+
+				if test.userID != "" {
+					repl := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+					repl.Set("http.auth.user.id", test.userID)
+				}
+			})
+			if test.expSkip {
+				assert.Equal(t, http.StatusTeapot, rw.Code)
+			} else {
+				assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			}
+		})
+	}
+}
+
+func TestProofOfWorkAllow(t *testing.T) {
+	t.Parallel()
+
+	allow := []string{
+		"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::ffff:172.16.0.0/108",
+	}
+
+	tests := []struct {
+		name       string
+		allow      []string
+		remoteAddr string
+		clientIP   string
+		expSkip    bool
+	}{
+		{"empty allowlist", nil, "10.1.2.3:1234", "", false},
+		{"matching cidr", allow, "10.1.2.3:1234", "", true},
+		{"matching ip", allow, "192.0.2.1:1234", "", true},
+		{"non-matching ip", allow, "192.0.2.2:1234", "", false},
+		{"matching ipv6", allow, "[2001:db8::1]:1234", "", true},
+		{"ipv4-mapped client", allow, "[::ffff:10.1.2.3]:1234", "", true},
+		{"ipv4-mapped cidr", allow, "172.16.5.5:1234", "", true},
+		{"client ip var", allow, "192.0.2.2:1234", "10.1.2.3", true},
+		{"client ip var non-matching", allow, "10.1.2.3:1234", "192.0.2.2", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := newTestProofOfWork(t, &ProofOfWork{Allow: test.allow})
+
+			rw := servePoW(t, p, nil, nil, func(r *http.Request) {
+				r.RemoteAddr = test.remoteAddr
+				if test.clientIP != "" {
+					*r = *r.WithContext(context.WithValue(
+						r.Context(), caddyhttp.VarsCtxKey, map[string]any{
+							caddyhttp.ClientIPVarKey: test.clientIP,
+						},
+					))
+				}
+			})
+			if test.expSkip {
+				assert.Equal(t, http.StatusTeapot, rw.Code)
+			} else {
+				assert.Equal(t, "true", rw.Header().Get(powSolutionRequiredHeaderName))
+			}
+		})
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Parallel()
+		for _, allow := range []string{"bogus", "10.0.0.0/33", "10.0.0.1/", "fe80::1%eth0"} {
+			p := ProofOfWork{Allow: []string{allow}}
+			assert.Error(t, p.Provision(caddy.Context{}), allow)
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		t.Parallel()
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+			proof_of_work {
+				allow 10.0.0.0/8 192.0.2.1
+				allow 2001:db8::/32
+			}
+		`)}
+
+		handler, err := proofOfWorkParseCaddyfile(h)
+		require.NoError(t, err)
+		assert.Equal(
+			t,
+			[]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"},
+			handler.(*ProofOfWork).Allow,
+		)
+	})
+}
+
+func TestProofOfWorkMaxUnverifiedDuration(t *testing.T) {
+	t.Parallel()
+
+	p := newTestProofOfWork(t, &ProofOfWork{
+		Target:                0x0FFFFFFF,
+		SkipAuthorized:        true,
+		MaxUnverifiedDuration: 10 * time.Millisecond,
+	})
+
+	var (
+		c        = p.mgr.NewChallenge()
+		solution = pow.Solve(c)
+	)
+
+	// next waits for the request's context to be cancelled, or for longer than
+	// the MaxUnverifiedDuration, whichever comes first.
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-time.After(100 * time.Millisecond):
+			rw.WriteHeader(http.StatusTeapot)
+			return nil
+		}
+	})
+
+	tests := []struct {
+		name     string
+		authz    bool
+		solution bool
+		expErr   error
+	}{
+		{name: "unverified", authz: true, expErr: context.DeadlineExceeded},
+		{name: "verified", solution: true},
+		{name: "verified and authorized", authz: true, solution: true, expErr: context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				rw = httptest.NewRecorder()
+				r  = httptest.NewRequest("GET", "/", nil)
+			)
+
+			if test.authz {
+				r.Header.Set("Authorization", "Basic Ym9iOmh1bnRlcjI=")
+			}
+
+			if test.solution {
+				r.AddCookie(&http.Cookie{Name: p.ChallengeSeedCookie, Value: hex.EncodeToString(c.Seed)})
+				r.AddCookie(&http.Cookie{Name: p.ChallengeSolutionCookie, Value: hex.EncodeToString(solution)})
+			}
+
+			r = r.WithContext(context.WithValue(
+				r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			))
+
+			err := p.ServeHTTP(rw, r, next)
+			if test.expErr != nil {
+				assert.ErrorIs(t, err, test.expErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusTeapot, rw.Code)
+		})
+	}
+}
+
+func TestProofOfWorkTemplateError(t *testing.T) {
+	t.Parallel()
+
+	tplPath := filepath.Join(t.TempDir(), "pow.html")
+	require.NoError(t, os.WriteFile(
+		tplPath,
+		[]byte(`<p>Partially rendered</p>{{ .Bogus }}<script>{{ template "pow.js" . }}</script>`),
+		0644,
+	))
+
+	p := newTestProofOfWork(t, &ProofOfWork{TemplatePath: tplPath})
+
+	var (
+		rw = httptest.NewRecorder()
+		r  = httptest.NewRequest("GET", "/", nil)
+	)
+
+	r = r.WithContext(context.WithValue(
+		r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+	))
+
+	err := p.ServeHTTP(rw, r, nil)
+
+	var hErr caddyhttp.HandlerError
+	require.True(t, errors.As(err, &hErr))
+	assert.Equal(t, http.StatusInternalServerError, hErr.StatusCode)
+
+	t.Log("Checking that nothing was written to the client")
+	assert.Empty(t, rw.Body.String())
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	caddy.RegisterModule(RequestCountMetric{})
+	httpcaddyfile.RegisterHandlerDirective("request_count_metric", requestCountMetricParseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder(
+		"request_count_metric", httpcaddyfile.Before, "tracing",
+	)
+}
+
+// RequestCountMetric is an HTTP middleware module which will passthrough all
+// requests untouched, counting them under a counter metric defined as part of
+// the `mediocre_caddy_plugins.metrics` global configuration.
+//
+// Errors returned by later handlers are counted using their status code, or
+// 500 if they don't have one.
+type RequestCountMetric struct {
+	RequestResponseHistogramMetric
+
+	counter *prometheus.CounterVec
+}
+
+var _ caddyhttp.MiddlewareHandler = (*RequestCountMetric)(nil)
+
+func (RequestCountMetric) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.request_count_metric",
+		New: func() caddy.Module { return new(RequestCountMetric) },
+	}
+}
+
+func (m *RequestCountMetric) Provision(ctx caddy.Context) error {
+	var err error
+	if m.counter, err = globalCounter(ctx, m.Name); err != nil {
+		return err
+	}
+
+	return m.provision(ctx)
+}
+
+func (m *RequestCountMetric) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	var (
+		rec     = caddyhttp.NewResponseRecorder(rw, nil, nil)
+		err     = next.ServeHTTP(rec, r)
+		status  = rec.Status()
+		headers = rec.Header()
+	)
+
+	if hErr := (caddyhttp.HandlerError{}); errors.As(err, &hErr) {
+		status = hErr.StatusCode
+	} else if err != nil {
+		status = http.StatusInternalServerError
+	} else if status == 0 {
+		// Nothing was written, in which case Caddy will respond with a 200.
+		status = http.StatusOK
+	}
+
+	if labels, ok := m.matchLabels(r.Context(), status, headers); ok {
+		m.counter.With(labels).Inc()
+	}
+
+	return err
+}
+
+func requestCountMetricParseCaddyfile(
+	h httpcaddyfile.Helper,
+) (
+	caddyhttp.MiddlewareHandler, error,
+) {
+	var (
+		m   = new(RequestCountMetric)
+		err error
+	)
+
+	m.RequestResponseHistogramMetric, err = requestResponseHistogramMetricParseCaddyfile(h, nil)
+	return m, err
+}
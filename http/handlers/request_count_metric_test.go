@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestCountMetric(t *testing.T) {
+	t.Parallel()
+
+	newMetric := func(t *testing.T, config string) *RequestCountMetric {
+		h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(config)}
+		handler, err := requestCountMetricParseCaddyfile(h)
+		require.NoError(t, err)
+
+		m := handler.(*RequestCountMetric)
+		m.counter = prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "test_requests_total", Help: "Test."},
+			[]string{"status"},
+		)
+		require.NoError(t, m.provision(caddy.Context{}))
+		return m
+	}
+
+	serve := func(
+		t *testing.T, m *RequestCountMetric, next caddyhttp.HandlerFunc,
+	) error {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest("GET", "/", nil)
+		)
+
+		r = r.WithContext(context.WithValue(
+			r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+		))
+
+		return m.ServeHTTP(rw, r, next)
+	}
+
+	writeStatus := func(status int) caddyhttp.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) error {
+			rw.WriteHeader(status)
+			return nil
+		}
+	}
+
+	var (
+		all = newMetric(t, `
+			request_count_metric test_requests_total {
+				label status {http.response.status_code}
+			}
+		`)
+		errs = newMetric(t, `
+			request_count_metric test_requests_total {
+				label status {http.response.status_code}
+				match status 5xx
+			}
+		`)
+	)
+
+	for _, m := range []*RequestCountMetric{all, errs} {
+		for _, status := range []int{
+			http.StatusOK,
+			http.StatusOK,
+			http.StatusNotFound,
+			http.StatusServiceUnavailable,
+		} {
+			require.NoError(t, serve(t, m, writeStatus(status)))
+		}
+
+		t.Log("Checking that errors are counted using their status code")
+		assert.Error(t, serve(t, m, func(http.ResponseWriter, *http.Request) error {
+			return caddyhttp.Error(http.StatusForbidden, errors.New("forbidden"))
+		}))
+		assert.Error(t, serve(t, m, func(http.ResponseWriter, *http.Request) error {
+			return errors.New("unexpected")
+		}))
+	}
+
+	tests := []struct {
+		status       string
+		exp, expErrs float64
+	}{
+		{"200", 2, 0},
+		{"403", 1, 0},
+		{"404", 1, 0},
+		{"500", 1, 1},
+		{"503", 1, 1},
+	}
+
+	for _, test := range tests {
+		assert.Equal(
+			t,
+			test.exp,
+			testutil.ToFloat64(all.counter.WithLabelValues(test.status)),
+			"status %s", test.status,
+		)
+
+		t.Log("Checking that only responses matching the matcher are counted")
+		assert.Equal(
+			t,
+			test.expErrs,
+			testutil.ToFloat64(errs.counter.WithLabelValues(test.status)),
+			"status %s", test.status,
+		)
+	}
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(RequestMetrics{})
+	httpcaddyfile.RegisterHandlerDirective("request_metrics", requestMetricsParseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder(
+		"request_metrics", httpcaddyfile.Before, "tracing",
+	)
+}
+
+// RequestMetrics is an HTTP middleware module which will passthrough all
+// requests untouched, recording both their timing and the size of their
+// response body under any number of histogram metrics.
+//
+// This is equivalent to using multiple `request_timing_metric` and
+// `response_size_metric` handlers, but only wraps the request once.
+type RequestMetrics struct {
+	// Timing metrics, under which the time taken to handle each request will
+	// be recorded.
+	Timing []RequestResponseHistogramMetric `json:"timing,omitempty"`
+
+	// Size metrics, under which the size of the body of each response will be
+	// recorded.
+	Size []RequestResponseHistogramMetric `json:"size,omitempty"`
+}
+
+var _ caddyhttp.MiddlewareHandler = (*RequestMetrics)(nil)
+
+func (RequestMetrics) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.request_metrics",
+		New: func() caddy.Module { return new(RequestMetrics) },
+	}
+}
+
+func (m *RequestMetrics) Provision(ctx caddy.Context) error {
+	for i := range m.Timing {
+		if err := m.Timing[i].Provision(ctx); err != nil {
+			return fmt.Errorf("provisioning timing metric %q: %w", m.Timing[i].Name, err)
+		}
+	}
+
+	for i := range m.Size {
+		if err := m.Size[i].Provision(ctx); err != nil {
+			return fmt.Errorf("provisioning size metric %q: %w", m.Size[i].Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *RequestMetrics) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	var (
+		rec     = caddyhttp.NewResponseRecorder(rw, nil, nil)
+		start   = time.Now()
+		err     = next.ServeHTTP(rec, r)
+		took    = time.Since(start)
+		status  = rec.Status()
+		headers = rec.Header()
+	)
+
+	if hErr := (caddyhttp.HandlerError{}); errors.As(err, &hErr) {
+		status = hErr.StatusCode
+	}
+
+	for i := range m.Timing {
+		m.Timing[i].observe(r.Context(), status, headers, took.Seconds())
+	}
+
+	for i := range m.Size {
+		m.Size[i].observe(r.Context(), status, headers, float64(rec.Size()))
+	}
+
+	return err
+}
+
+// requestMetricsParseCaddyfile sets up the handler from Caddyfile tokens.
+// Syntax:
+//
+//	request_metrics {
+//		# timing and size can each be given multiple times, and their blocks
+//		# take the same form as request_timing_metric and
+//		# response_size_metric.
+//		timing "global_metric_name" {
+//			...
+//		}
+//
+//		size "global_metric_name" {
+//			...
+//		}
+//	}
+func requestMetricsParseCaddyfile(
+	h httpcaddyfile.Helper,
+) (
+	caddyhttp.MiddlewareHandler, error,
+) {
+	h.Next() // consume directive name
+	m := new(RequestMetrics)
+	for h.NextBlock(0) {
+		into := &m.Timing
+		switch h.Val() {
+		case "timing":
+		case "size":
+			into = &m.Size
+		default:
+			return nil, fmt.Errorf("unknown field: %q", h.Val())
+		}
+
+		segment := h
+		segment.Dispenser = h.NewFromNextSegment()
+
+		metric, err := requestResponseHistogramMetricParseCaddyfile(segment, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		*into = append(*into, metric)
+	}
+
+	return m, nil
+}
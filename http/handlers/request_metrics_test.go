@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestMetricsParseCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		request_metrics {
+			timing "request_seconds" {
+				label vhost example.com
+			}
+			size "response_bytes" {
+				label status {http.response.status_code}
+				match status 2xx
+			}
+			timing "other_seconds"
+		}
+	`)}
+
+	handler, err := requestMetricsParseCaddyfile(h)
+	require.NoError(t, err)
+
+	m := handler.(*RequestMetrics)
+	require.Len(t, m.Timing, 2)
+	require.Len(t, m.Size, 1)
+
+	assert.Equal(t, "request_seconds", m.Timing[0].Name)
+	assert.Equal(t, map[string]string{"vhost": "example.com"}, m.Timing[0].Labels)
+	assert.Equal(t, "other_seconds", m.Timing[1].Name)
+	assert.Empty(t, m.Timing[1].Labels)
+
+	assert.Equal(t, "response_bytes", m.Size[0].Name)
+	assert.NotNil(t, m.Size[0].Matcher)
+}
+
+// newBenchmarkMetric returns a RequestResponseHistogramMetric which has been
+// provisioned without the global app.
+func newBenchmarkMetric() RequestResponseHistogramMetric {
+	return RequestResponseHistogramMetric{
+		Labels: map[string]string{"status": "{http.response.status_code}"},
+		histogram: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "benchmark"},
+			[]string{"status"},
+		),
+		hasPlaceholders: true,
+	}
+}
+
+func BenchmarkRequestMetrics(b *testing.B) {
+	next := caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+		_, err := rw.Write([]byte("hello"))
+		return err
+	})
+
+	run := func(b *testing.B, handler caddyhttp.Handler) {
+		for i := 0; i < b.N; i++ {
+			var (
+				rw  = httptest.NewRecorder()
+				r   = httptest.NewRequest("GET", "/", nil)
+				ctx = context.WithValue(
+					r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+				)
+			)
+			r = r.WithContext(ctx)
+			if err := handler.ServeHTTP(rw, r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("stacked", func(b *testing.B) {
+		var (
+			timing = &RequestTimingMetric{newBenchmarkMetric()}
+			size   = &ResponseSizeMetric{RequestResponseHistogramMetric: newBenchmarkMetric()}
+		)
+
+		run(b, caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			return timing.ServeHTTP(rw, r, caddyhttp.HandlerFunc(
+				func(rw http.ResponseWriter, r *http.Request) error {
+					return size.ServeHTTP(rw, r, next)
+				},
+			))
+		}))
+	})
+
+	b.Run("combined", func(b *testing.B) {
+		m := &RequestMetrics{
+			Timing: []RequestResponseHistogramMetric{newBenchmarkMetric()},
+			Size:   []RequestResponseHistogramMetric{newBenchmarkMetric()},
+		}
+
+		run(b, caddyhttp.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) error {
+			return m.ServeHTTP(rw, r, next)
+		}))
+	})
+}
@@ -2,26 +2,281 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"dev.mediocregopher.com/mediocre-caddy-plugins.git/global"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 	"golang.org/x/exp/maps"
 )
 
 const metricsNamespace = "mediocre_caddy_plugins_http"
 
+// globalMetrics returns the metrics configured in the
+// `mediocre_caddy_plugins.metrics` global configuration.
+func globalMetrics(ctx caddy.Context) (*global.Metrics, error) {
+	appI, err := ctx.AppIfConfigured("mediocre_caddy_plugins")
+	if err != nil {
+		return nil, err
+	}
+	return &appI.(*global.App).Metrics, nil
+}
+
+// globalHistogram returns the histogram of the given name which has been
+// configured in the `mediocre_caddy_plugins.metrics` global configuration.
+func globalHistogram(
+	ctx caddy.Context, name string,
+) (
+	*prometheus.HistogramVec, error,
+) {
+	metrics, err := globalMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	histogram, ok := metrics.HistogramByName(name)
+	if !ok {
+		return nil, fmt.Errorf("histogram %q not configured globally", name)
+	}
+
+	return histogram, nil
+}
+
+// globalCounter returns the counter of the given name which has been configured
+// in the `mediocre_caddy_plugins.metrics` global configuration.
+func globalCounter(
+	ctx caddy.Context, name string,
+) (
+	*prometheus.CounterVec, error,
+) {
+	metrics, err := globalMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counter, ok := metrics.CounterByName(name)
+	if !ok {
+		return nil, fmt.Errorf("counter %q not configured globally", name)
+	}
+
+	return counter, nil
+}
+
+// globalGauge returns the gauge of the given name which has been configured in
+// the `mediocre_caddy_plugins.metrics` global configuration.
+func globalGauge(
+	ctx caddy.Context, name string,
+) (
+	*prometheus.GaugeVec, error,
+) {
+	metrics, err := globalMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	gauge, ok := metrics.GaugeByName(name)
+	if !ok {
+		return nil, fmt.Errorf("gauge %q not configured globally", name)
+	}
+
+	return gauge, nil
+}
+
+// describeMetric records the description of a metric which has been registered
+// by a handler with the `mediocre_caddy_plugins` app, so that it's included in
+// the app's listing of metrics. The app is loaded if it hasn't been configured.
+func describeMetric(ctx caddy.Context, desc global.MetricDescription) error {
+	appI, err := ctx.App("mediocre_caddy_plugins")
+	if err != nil {
+		return err
+	}
+
+	appI.(*global.App).Metrics.Describe(desc)
+	return nil
+}
+
+// translationObserver returns an Observer for the histogram of the given name,
+// which must have been configured globally with a single `handler` label, into
+// which the time taken by the given handler to translate documents can be
+// observed. If the name is empty then a no-op Observer is returned.
+func translationObserver(
+	ctx caddy.Context, name, handler string,
+) (
+	prometheus.Observer, error,
+) {
+	if name == "" {
+		return prometheus.ObserverFunc(func(float64) {}), nil
+	}
+
+	histogram, err := globalHistogram(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	observer, err := histogram.GetMetricWith(prometheus.Labels{
+		"handler": handler,
+	})
+	if err != nil {
+		return nil, fmt.Errorf(
+			"histogram %q must have only the label 'handler': %w", name, err,
+		)
+	}
+
+	return observer, nil
+}
+
+// routeNamePlaceholder is the placeholder made available to the label values of
+// a RequestResponseHistogramMetric, containing the name of the route which
+// handled the request.
+const routeNamePlaceholder = "http.route_name"
+
+// routeNameVar is the name of the variable, as set by the `vars` handler,
+// which routeNamePlaceholder takes its value from if the metric has no
+// RouteName.
+const routeNameVar = "route_name"
+
+// metricOverflowLabelValue is the value given to all labels of a series which
+// would have exceeded the MaxSeries of a RequestResponseHistogramMetric.
+const metricOverflowLabelValue = "__overflow__"
+
+// seriesLimiter tracks the distinct label value combinations which have been
+// observed for a metric, and maps any combinations past the limit onto a
+// single overflow series. seriesLimiter is thread-safe.
+type seriesLimiter struct {
+	max        int
+	keys       []string
+	onOverflow func(prometheus.Labels)
+
+	l        sync.Mutex
+	seen     map[string]struct{}
+	overflow bool
+}
+
+func newSeriesLimiter(
+	max int, keys []string, onOverflow func(prometheus.Labels),
+) *seriesLimiter {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	return &seriesLimiter{
+		max:        max,
+		keys:       keys,
+		onOverflow: onOverflow,
+		seen:       map[string]struct{}{},
+	}
+}
+
+// limit returns the given labels as-is if they belong to a series which has
+// been seen already, or if the limit has not yet been reached. Otherwise it
+// returns the labels of the overflow series.
+func (s *seriesLimiter) limit(labels prometheus.Labels) prometheus.Labels {
+	values := make([]string, len(s.keys))
+	for i, k := range s.keys {
+		values[i] = labels[k]
+	}
+	key := strings.Join(values, "\xff")
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if _, ok := s.seen[key]; ok {
+		return labels
+	} else if len(s.seen) < s.max {
+		s.seen[key] = struct{}{}
+		return labels
+	}
+
+	// Only report the first overflow, to avoid flooding the logs.
+	if !s.overflow {
+		s.overflow = true
+		s.onOverflow(labels)
+	}
+
+	overflowLabels := make(prometheus.Labels, len(s.keys))
+	for _, k := range s.keys {
+		overflowLabels[k] = metricOverflowLabelValue
+	}
+	return overflowLabels
+}
+
+// hasLabelPlaceholders returns whether any of the given label values contain a
+// placeholder.
+func hasLabelPlaceholders(labels map[string]string) bool {
+	for _, v := range labels {
+		if strings.Contains(v, "{") && strings.Contains(v, "}") {
+			return true
+		}
+	}
+	return false
+}
+
+// newMetricSeriesLimiter returns a seriesLimiter for the metric of the given
+// name, which logs a warning the first time that it overflows.
+func newMetricSeriesLimiter(
+	ctx caddy.Context, name string, maxSeries int, labels map[string]string,
+) *seriesLimiter {
+	logger := ctx.Logger()
+	return newSeriesLimiter(
+		maxSeries, maps.Keys(labels),
+		func(labels prometheus.Labels) {
+			logger.Warn(
+				"Metric has reached its maximum number of series, further series will be recorded as overflow",
+				zap.String("metric", name),
+				zap.Int("maxSeries", maxSeries),
+				zap.Any("labels", labels),
+			)
+		},
+	)
+}
+
+// replaceLabelPlaceholders returns a copy of the given labels with the
+// placeholders in their values replaced, including routeNamePlaceholder, which
+// takes the value of routeName if given. If limiter is not nil then it is
+// applied to the result.
+func replaceLabelPlaceholders(
+	ctx context.Context,
+	labels map[string]string,
+	routeName string,
+	limiter *seriesLimiter,
+) map[string]string {
+	labels = maps.Clone(labels)
+
+	repl := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+
+	if routeName == "" {
+		if v := caddyhttp.GetVar(ctx, routeNameVar); v != nil {
+			routeName = fmt.Sprint(v)
+		}
+	}
+	repl.Set(routeNamePlaceholder, routeName)
+
+	for k, v := range labels {
+		labels[k] = repl.ReplaceAll(v, "")
+	}
+
+	if limiter != nil {
+		labels = limiter.limit(labels)
+	}
+
+	return labels
+}
+
 // RequestResponseHistogramMetric contains common fields and logic for metrics
-// which record HTTP request/response data into a hisogram.
+// which record HTTP request/response data into a hisogram. It is also used by
+// RequestCountMetric, which records into a counter instead.
 type RequestResponseHistogramMetric struct {
-	// Name refers to the name of a histogram defined as part of the
+	// Name refers to the name of a histogram (or counter, in the case of
+	// RequestCountMetric) defined as part of the
 	// `mediocre_caddy_plugins.metrics` global configuration. It is using this
-	// histogram which values will be observed.
+	// metric which values will be observed.
 	Name string `json:"name"`
 
 	// Labels will be included as the labels on all measurements made to the
@@ -34,27 +289,96 @@ type RequestResponseHistogramMetric struct {
 	// ResponseMatcher. The default is to always observe the value.
 	Matcher *caddyhttp.ResponseMatcher `json:"match,omitempty"`
 
+	// If true then responses which don't match the Matcher will be counted
+	// under a counter metric named after the histogram, with an
+	// `_excluded_total` suffix, and having the same labels as the histogram.
+	CountExcluded bool `json:"count_excluded,omitempty"`
+
+	// MaxSeries limits the number of distinct label value combinations which
+	// this handler will create, protecting against label values containing
+	// placeholders (e.g. a request path) causing an explosion of series. Once
+	// the limit is reached any new combinations will be observed under a
+	// single series, having all label values set to `__overflow__`.
+	//
+	// The limit is tracked per-handler, so handlers sharing the same
+	// histogram each have their own limit. It only applies if a label value
+	// contains a placeholder. The default is no limit.
+	MaxSeries int `json:"max_series,omitempty"`
+
+	// RouteName is made available to label values as the `{http.route_name}`
+	// placeholder. If not given then the placeholder takes the value of the
+	// `route_name` variable, e.g. as set using the `vars` handler within the
+	// route which handled the request. Caddy doesn't otherwise expose the
+	// name of the route which handled a request.
+	RouteName string `json:"route_name,omitempty"`
+
 	histogram       *prometheus.HistogramVec
+	excluded        *prometheus.CounterVec
 	hasPlaceholders bool
+	seriesLimiter   *seriesLimiter
 }
 
 func (m *RequestResponseHistogramMetric) Provision(ctx caddy.Context) error {
-	for _, v := range m.Labels {
-		if strings.Contains(v, "{") && strings.Contains(v, "}") {
-			m.hasPlaceholders = true
-			break
-		}
+	var err error
+	if m.histogram, err = globalHistogram(ctx, m.Name); err != nil {
+		return err
 	}
 
-	appI, err := ctx.AppIfConfigured("mediocre_caddy_plugins")
-	if err != nil {
-		return err
+	return m.provision(ctx)
+}
+
+// provision sets up everything but the metric itself, which is the
+// responsibility of the caller.
+func (m *RequestResponseHistogramMetric) provision(ctx caddy.Context) error {
+	m.hasPlaceholders = hasLabelPlaceholders(m.Labels)
+
+	if m.MaxSeries < 0 {
+		return errors.New("max_series cannot be negative")
 	}
-	app := appI.(*global.App)
 
-	var ok bool
-	if m.histogram, ok = app.Metrics.HistogramByName(m.Name); !ok {
-		return fmt.Errorf("histogram %q not configured globally", m.Name)
+	if m.MaxSeries > 0 && m.hasPlaceholders {
+		m.seriesLimiter = newMetricSeriesLimiter(ctx, m.Name, m.MaxSeries, m.Labels)
+	}
+
+	if m.CountExcluded {
+		excludedName := m.Name + "_excluded_total"
+		excluded := prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: excludedName,
+				Help: fmt.Sprintf(
+					"Number of responses excluded from %s by the matcher",
+					m.Name,
+				),
+			},
+			maps.Keys(m.Labels),
+		)
+
+		// Multiple handlers may share the same histogram, and therefore the
+		// same counter.
+		err := ctx.GetMetricsRegistry().Register(excluded)
+		if alreadyErr := (prometheus.AlreadyRegisteredError{}); errors.As(err, &alreadyErr) {
+			var ok bool
+			excluded, ok = alreadyErr.ExistingCollector.(*prometheus.CounterVec)
+			if !ok {
+				return fmt.Errorf(
+					"metric %q already registered with a different type",
+					excludedName,
+				)
+			}
+		} else if err != nil {
+			return fmt.Errorf("registering excluded counter: %w", err)
+		}
+
+		m.excluded = excluded
+
+		if err := describeMetric(ctx, global.MetricDescription{
+			Name:   excludedName,
+			Type:   "counter",
+			Help:   fmt.Sprintf("Number of responses excluded from %s by the matcher", m.Name),
+			Labels: maps.Keys(m.Labels),
+		}); err != nil {
+			return fmt.Errorf("describing excluded counter: %w", err)
+		}
 	}
 
 	return nil
@@ -66,26 +390,46 @@ func (m *RequestResponseHistogramMetric) observe(
 	headers http.Header,
 	val float64,
 ) {
-	if m.Matcher != nil && !m.Matcher.Match(status, headers) {
-		return
+	if labels, ok := m.matchLabels(ctx, status, headers); ok {
+		m.histogram.With(labels).Observe(val)
+	}
+}
+
+// matchLabels returns the labels which a value should be recorded with for the
+// given response, with placeholders replaced. If the response doesn't match the
+// Matcher then false is returned, and the response is counted as excluded if
+// CountExcluded is set.
+func (m *RequestResponseHistogramMetric) matchLabels(
+	ctx context.Context,
+	status int,
+	headers http.Header,
+) (
+	prometheus.Labels, bool,
+) {
+	matched := m.Matcher == nil || m.Matcher.Match(status, headers)
+	if !matched && m.excluded == nil {
+		return nil, false
 	}
 
 	labels := m.Labels
 	if m.hasPlaceholders {
-		labels = maps.Clone(labels)
-
 		repl := ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
 		for field, value := range headers {
 			repl.Set("http.response.header."+field, strings.Join(value, ","))
 		}
 		repl.Set("http.response.status_code", status)
 
-		for k, v := range labels {
-			labels[k] = repl.ReplaceAll(v, "")
-		}
+		labels = replaceLabelPlaceholders(
+			ctx, labels, m.RouteName, m.seriesLimiter,
+		)
+	}
+
+	if !matched {
+		m.excluded.With(prometheus.Labels(labels)).Inc()
+		return nil, false
 	}
 
-	m.histogram.With(prometheus.Labels(labels)).Observe(val)
+	return prometheus.Labels(labels), true
 }
 
 // requestResponseHistogramMetricParseCaddyfile sets up the handler helper from
@@ -98,12 +442,22 @@ func (m *RequestResponseHistogramMetric) observe(
 //		// placeholders, including the special placeholders:
 //		//	http.response.header.*
 //		//	http.response.status_code
+//		//	http.route_name
 //		label name value
 //
 //		match <response matcher>
+//
+//		count_excluded on|off
+//
+//		max_series <n>
+//
+//		route_name <name>
 //	}
+//
+// Fields which aren't recognized are passed to parseField, if given, which
+// should return false if it doesn't recognize the field either.
 func requestResponseHistogramMetricParseCaddyfile(
-	h httpcaddyfile.Helper,
+	h httpcaddyfile.Helper, parseField func(field string) (bool, error),
 ) (
 	RequestResponseHistogramMetric, error,
 ) {
@@ -143,7 +497,35 @@ func requestResponseHistogramMetricParseCaddyfile(
 			matcher := responseMatchers["match"]
 			m.Matcher = &matcher
 
+		case "count_excluded":
+			var err error
+			if m.CountExcluded, err = parseOnOff(h); err != nil {
+				return zero, err
+			}
+
+		case "max_series":
+			if !h.NextArg() {
+				return zero, h.ArgErr()
+			}
+
+			var err error
+			if m.MaxSeries, err = strconv.Atoi(h.Val()); err != nil {
+				return zero, fmt.Errorf("parsing %q as an integer: %w", h.Val(), err)
+			}
+
+		case "route_name":
+			if !h.Args(&m.RouteName) {
+				return zero, h.ArgErr()
+			}
+
 		default:
+			if parseField != nil {
+				if ok, err := parseField(h.Val()); err != nil {
+					return zero, err
+				} else if ok {
+					continue
+				}
+			}
 			return zero, fmt.Errorf("unknown field: %q", h.Val())
 		}
 	}
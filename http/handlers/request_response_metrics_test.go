@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesLimiter(t *testing.T) {
+	t.Parallel()
+
+	var (
+		overflowed []prometheus.Labels
+		limiter    = newSeriesLimiter(
+			2, []string{"path", "status"},
+			func(labels prometheus.Labels) {
+				overflowed = append(overflowed, labels)
+			},
+		)
+		overflow = prometheus.Labels{
+			"path":   metricOverflowLabelValue,
+			"status": metricOverflowLabelValue,
+		}
+		a = prometheus.Labels{"path": "/a", "status": "200"}
+		b = prometheus.Labels{"path": "/b", "status": "200"}
+		c = prometheus.Labels{"path": "/c", "status": "200"}
+		d = prometheus.Labels{"path": "/d", "status": "404"}
+	)
+
+	assert.Equal(t, a, limiter.limit(a))
+	assert.Equal(t, b, limiter.limit(b))
+	assert.Equal(t, a, limiter.limit(a))
+	assert.Equal(t, overflow, limiter.limit(c))
+	assert.Equal(t, overflow, limiter.limit(d))
+	assert.Equal(t, b, limiter.limit(b))
+
+	assert.Equal(t, []prometheus.Labels{c}, overflowed)
+}
+
+func TestRequestResponseHistogramMetricRouteName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		routeName string
+		vars      map[string]any
+		exp       string
+	}{
+		{"option", "api", map[string]any{routeNameVar: "ignored"}, "api"},
+		{"var", "", map[string]any{routeNameVar: "static"}, "static"},
+		{"none", "", map[string]any{}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := RequestResponseHistogramMetric{
+				Labels:    map[string]string{"route": "{http.route_name}"},
+				RouteName: test.routeName,
+				histogram: prometheus.NewHistogramVec(
+					prometheus.HistogramOpts{
+						Name:    "test_seconds",
+						Help:    "Test.",
+						Buckets: []float64{1},
+					},
+					[]string{"route"},
+				),
+				hasPlaceholders: true,
+			}
+
+			ctx := context.WithValue(
+				context.Background(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+			)
+			ctx = context.WithValue(ctx, caddyhttp.VarsCtxKey, test.vars)
+
+			m.observe(ctx, http.StatusOK, http.Header{}, 0.5)
+
+			require.NoError(t, testutil.CollectAndCompare(
+				m.histogram, strings.NewReader(`
+# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="`+test.exp+`",le="1"} 1
+test_seconds_bucket{route="`+test.exp+`",le="+Inf"} 1
+test_seconds_sum{route="`+test.exp+`"} 0.5
+test_seconds_count{route="`+test.exp+`"} 1
+`),
+			))
+		})
+	}
+}
@@ -65,6 +65,6 @@ func requestTimingMetricParseCaddyfile(
 		err error
 	)
 
-	m.RequestResponseHistogramMetric, err = requestResponseHistogramMetricParseCaddyfile(h)
+	m.RequestResponseHistogramMetric, err = requestResponseHistogramMetricParseCaddyfile(h, nil)
 	return m, err
 }
@@ -1,8 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
@@ -22,9 +26,23 @@ func init() {
 // `mediocre_caddy_plugins_http_response_bytes` histogram metric.
 type ResponseSizeMetric struct {
 	RequestResponseHistogramMetric
+
+	// If given then the size of streamed responses, e.g. server-sent events,
+	// will be observed incrementally. Each time the response is flushed, if
+	// at least this much time has passed since the last observation, the
+	// number of bytes written since the last observation is observed. Any
+	// remaining bytes are observed once the response is complete.
+	//
+	// This means that the histogram's sum continues to reflect the total
+	// number of bytes written, but that a single streamed response may be
+	// counted more than once.
+	StreamInterval time.Duration `json:"stream_interval,omitempty"`
 }
 
-var _ caddyhttp.MiddlewareHandler = (*ResponseSizeMetric)(nil)
+var (
+	_ caddyhttp.MiddlewareHandler = (*ResponseSizeMetric)(nil)
+	_ caddy.Validator             = (*ResponseSizeMetric)(nil)
+)
 
 func (ResponseSizeMetric) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -33,9 +51,20 @@ func (ResponseSizeMetric) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
+func (m *ResponseSizeMetric) Validate() error {
+	if m.StreamInterval < 0 {
+		return errors.New("stream_interval cannot be negative")
+	}
+	return nil
+}
+
 func (m *ResponseSizeMetric) ServeHTTP(
 	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
 ) error {
+	if m.StreamInterval > 0 {
+		return m.serveStream(rw, r, next)
+	}
+
 	var (
 		rec     = caddyhttp.NewResponseRecorder(rw, nil, nil)
 		err     = next.ServeHTTP(rec, r)
@@ -52,6 +81,101 @@ func (m *ResponseSizeMetric) ServeHTTP(
 	return err
 }
 
+func (m *ResponseSizeMetric) serveStream(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	w := &responseSizeStreamWriter{
+		ResponseWriterWrapper: &caddyhttp.ResponseWriterWrapper{
+			ResponseWriter: rw,
+		},
+		m:            m,
+		ctx:          r.Context(),
+		lastObserved: time.Now(),
+	}
+
+	err := next.ServeHTTP(w, r)
+
+	status := w.status
+	if hErr := (caddyhttp.HandlerError{}); errors.As(err, &hErr) {
+		status = hErr.StatusCode
+	}
+
+	// If nothing has been observed yet then the whole response is observed,
+	// even if empty, as it would have been were it not streamed.
+	if unobserved := w.size - w.observedSize; unobserved > 0 || !w.observed {
+		m.observe(r.Context(), status, w.Header(), float64(unobserved))
+	}
+
+	return err
+}
+
+// responseSizeStreamWriter wraps a ResponseWriter in order to observe the size
+// of a streamed response incrementally, each time the response is flushed.
+type responseSizeStreamWriter struct {
+	*caddyhttp.ResponseWriterWrapper
+
+	m   *ResponseSizeMetric
+	ctx context.Context
+
+	status             int
+	size, observedSize int
+	observed           bool
+	lastObserved       time.Time
+}
+
+var _ http.Flusher = (*responseSizeStreamWriter)(nil)
+
+func (w *responseSizeStreamWriter) WriteHeader(status int) {
+	// Informational responses may precede the final one.
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriterWrapper.WriteHeader(status)
+}
+
+func (w *responseSizeStreamWriter) written(n int) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.size += n
+}
+
+func (w *responseSizeStreamWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriterWrapper.Write(b)
+	w.written(n)
+	return n, err
+}
+
+func (w *responseSizeStreamWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := w.ResponseWriterWrapper.ReadFrom(r)
+	w.written(int(n))
+	return n, err
+}
+
+func (w *responseSizeStreamWriter) Flush() {
+	// Flushing is best-effort, as it is for http.ResponseController.
+	_ = http.NewResponseController(w.ResponseWriterWrapper).Flush()
+
+	if w.size == w.observedSize ||
+		time.Since(w.lastObserved) < w.m.StreamInterval {
+		return
+	}
+
+	w.m.observe(
+		w.ctx, w.status, w.Header(), float64(w.size-w.observedSize),
+	)
+	w.observedSize = w.size
+	w.observed = true
+	w.lastObserved = time.Now()
+}
+
+// responseSizeMetricParseCaddyfile sets up the handler from Caddyfile tokens.
+// Syntax:
+//
+//	response_size_metric "global_metric_name" {
+//		# All fields of request_timing_metric are supported, as well as:
+//		stream_interval <duration>
+//	}
 func responseSizeMetricParseCaddyfile(
 	h httpcaddyfile.Helper,
 ) (
@@ -62,6 +186,23 @@ func responseSizeMetricParseCaddyfile(
 		err error
 	)
 
-	m.RequestResponseHistogramMetric, err = requestResponseHistogramMetricParseCaddyfile(h)
+	m.RequestResponseHistogramMetric, err = requestResponseHistogramMetricParseCaddyfile(
+		h,
+		func(field string) (bool, error) {
+			if field != "stream_interval" {
+				return false, nil
+			} else if !h.NextArg() {
+				return false, h.ArgErr()
+			}
+
+			var err error
+			if m.StreamInterval, err = time.ParseDuration(h.Val()); err != nil {
+				return false, fmt.Errorf(
+					"parsing %q as duration: %w", h.Val(), err,
+				)
+			}
+			return true, nil
+		},
+	)
 	return m, err
 }
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseSizeMetricStream(t *testing.T) {
+	t.Parallel()
+
+	newMetric := func(streamInterval time.Duration) *ResponseSizeMetric {
+		return &ResponseSizeMetric{
+			RequestResponseHistogramMetric: RequestResponseHistogramMetric{
+				histogram: prometheus.NewHistogramVec(
+					prometheus.HistogramOpts{
+						Name:    "test_bytes",
+						Help:    "Test.",
+						Buckets: []float64{1024},
+					},
+					nil,
+				),
+			},
+			StreamInterval: streamInterval,
+		}
+	}
+
+	// sumAndCount returns the sum and count of the metric's histogram.
+	sumAndCount := func(t *testing.T, m *ResponseSizeMetric) (float64, uint64) {
+		reg := prometheus.NewRegistry()
+		require.NoError(t, reg.Register(m.histogram))
+
+		families, err := reg.Gather()
+		require.NoError(t, err)
+		if len(families) == 0 {
+			return 0, 0
+		}
+
+		h := families[0].GetMetric()[0].GetHistogram()
+		return h.GetSampleSum(), h.GetSampleCount()
+	}
+
+	serve := func(
+		t *testing.T, m *ResponseSizeMetric, next caddyhttp.HandlerFunc,
+	) *httptest.ResponseRecorder {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest("GET", "/", nil)
+		)
+
+		r = r.WithContext(context.WithValue(
+			r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+		))
+
+		require.NoError(t, m.ServeHTTP(rw, r, next))
+		return rw
+	}
+
+	t.Run("chunked", func(t *testing.T) {
+		t.Parallel()
+
+		m := newMetric(time.Millisecond)
+
+		rw := serve(t, m, func(rw http.ResponseWriter, r *http.Request) error {
+			rc := http.NewResponseController(rw)
+
+			rw.Header().Set("Content-Type", "text/event-stream")
+			rw.WriteHeader(http.StatusOK)
+
+			t.Log("Checking that each flushed chunk is observed")
+			for i, chunk := range []string{"data: one\n\n", "data: two\n\n"} {
+				time.Sleep(2 * time.Millisecond)
+				_, err := io.WriteString(rw, chunk)
+				require.NoError(t, err)
+				require.NoError(t, rc.Flush())
+
+				sum, count := sumAndCount(t, m)
+				assert.Equal(t, float64(11*(i+1)), sum)
+				assert.Equal(t, uint64(i+1), count)
+			}
+
+			t.Log("Checking that chunks flushed within the interval aren't observed")
+			_, err := io.WriteString(rw, "data: three\n\n")
+			require.NoError(t, err)
+			require.NoError(t, rc.Flush())
+
+			sum, count := sumAndCount(t, m)
+			assert.Equal(t, float64(22), sum)
+			assert.Equal(t, uint64(2), count)
+
+			_, err = io.WriteString(rw, "data: four\n\n")
+			return err
+		})
+
+		assert.True(t, rw.Flushed)
+		assert.Equal(t, "data: one\n\ndata: two\n\ndata: three\n\ndata: four\n\n", rw.Body.String())
+
+		t.Log("Checking that the remainder is observed once the response is complete")
+		sum, count := sumAndCount(t, m)
+		assert.Equal(t, float64(47), sum)
+		assert.Equal(t, uint64(3), count)
+	})
+
+	t.Run("not flushed", func(t *testing.T) {
+		t.Parallel()
+
+		m := newMetric(time.Millisecond)
+		serve(t, m, func(rw http.ResponseWriter, r *http.Request) error {
+			_, err := io.WriteString(rw, "hello")
+			return err
+		})
+
+		sum, count := sumAndCount(t, m)
+		assert.Equal(t, float64(5), sum)
+		assert.Equal(t, uint64(1), count)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		t.Parallel()
+
+		m := newMetric(time.Millisecond)
+		serve(t, m, func(rw http.ResponseWriter, r *http.Request) error {
+			rw.WriteHeader(http.StatusNoContent)
+			return nil
+		})
+
+		sum, count := sumAndCount(t, m)
+		assert.Equal(t, float64(0), sum)
+		assert.Equal(t, uint64(1), count)
+	})
+}
+
+func TestResponseSizeMetricParseCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		response_size_metric "custom_response_bytes" {
+			label vhost example.com
+			stream_interval 10s
+		}
+	`)}
+
+	handler, err := responseSizeMetricParseCaddyfile(h)
+	require.NoError(t, err)
+
+	m := handler.(*ResponseSizeMetric)
+	assert.Equal(t, "custom_response_bytes", m.Name)
+	assert.Equal(t, map[string]string{"vhost": "example.com"}, m.Labels)
+	assert.Equal(t, 10*time.Second, m.StreamInterval)
+
+	t.Log("Checking that unknown fields are still rejected")
+	h = httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		response_size_metric "custom_response_bytes" {
+			bogus 10s
+		}
+	`)}
+
+	_, err = responseSizeMetricParseCaddyfile(h)
+	assert.Error(t, err)
+}
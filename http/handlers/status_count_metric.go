@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"dev.mediocregopher.com/mediocre-caddy-plugins.git/global"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// statusCountMetricDefaultName is the name of the counter used by
+	// StatusCountMetric if none is given.
+	statusCountMetricDefaultName = metricsNamespace + "_responses_total"
+
+	statusCountMetricHelp = "Number of responses, by status code"
+)
+
+func init() {
+	caddy.RegisterModule(StatusCountMetric{})
+	httpcaddyfile.RegisterHandlerDirective("status_count_metric", statusCountMetricParseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder(
+		"status_count_metric", httpcaddyfile.Before, "tracing",
+	)
+}
+
+// statusClass returns the class of the given status code, e.g. `2xx`, or
+// `other` if the status code is not within the range of valid classes.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// StatusCountMetric is an HTTP middleware module which will passthrough all
+// requests untouched, counting their responses under a counter metric which
+// has a `status_class` label, e.g. `2xx`, and optionally a `status` label
+// containing the exact status code.
+//
+// Unlike the histogram metrics, the counter does not need to be defined in the
+// `mediocre_caddy_plugins.metrics` global configuration. Handlers which share
+// the same counter name must also share the same ExactStatus setting.
+type StatusCountMetric struct {
+	// Name of the counter metric. Defaults to
+	// `mediocre_caddy_plugins_http_responses_total`.
+	Name string `json:"name,omitempty"`
+
+	// If true then the counter will also have a `status` label, containing the
+	// exact status code of each response.
+	ExactStatus bool `json:"exact_status,omitempty"`
+
+	counter *prometheus.CounterVec
+}
+
+var _ caddyhttp.MiddlewareHandler = (*StatusCountMetric)(nil)
+
+func (StatusCountMetric) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.status_count_metric",
+		New: func() caddy.Module { return new(StatusCountMetric) },
+	}
+}
+
+func (m *StatusCountMetric) Provision(ctx caddy.Context) error {
+	if m.Name == "" {
+		m.Name = statusCountMetricDefaultName
+	}
+
+	if err := m.register(ctx.GetMetricsRegistry()); err != nil {
+		return err
+	}
+
+	if err := describeMetric(ctx, global.MetricDescription{
+		Name:   m.Name,
+		Type:   "counter",
+		Help:   statusCountMetricHelp,
+		Labels: m.labels(),
+	}); err != nil {
+		return fmt.Errorf("describing counter %q: %w", m.Name, err)
+	}
+
+	return nil
+}
+
+// labels returns the names of the labels of the counter.
+func (m *StatusCountMetric) labels() []string {
+	labels := []string{"status_class"}
+	if m.ExactStatus {
+		labels = append(labels, "status")
+	}
+	return labels
+}
+
+// register registers the counter with the given registerer, reusing an
+// existing counter if one with an identical definition is already registered.
+func (m *StatusCountMetric) register(reg prometheus.Registerer) error {
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: m.Name,
+			Help: statusCountMetricHelp,
+		},
+		m.labels(),
+	)
+
+	// Multiple handlers may share the same counter.
+	err := reg.Register(counter)
+	if alreadyErr := (prometheus.AlreadyRegisteredError{}); errors.As(err, &alreadyErr) {
+		var ok bool
+		if counter, ok = alreadyErr.ExistingCollector.(*prometheus.CounterVec); !ok {
+			return fmt.Errorf(
+				"metric %q already registered with a different type", m.Name,
+			)
+		}
+	} else if err != nil {
+		return fmt.Errorf("registering counter %q: %w", m.Name, err)
+	}
+
+	m.counter = counter
+	return nil
+}
+
+func (m *StatusCountMetric) ServeHTTP(
+	rw http.ResponseWriter, r *http.Request, next caddyhttp.Handler,
+) error {
+	var (
+		rec    = caddyhttp.NewResponseRecorder(rw, nil, nil)
+		err    = next.ServeHTTP(rec, r)
+		status = rec.Status()
+	)
+
+	if hErr := (caddyhttp.HandlerError{}); errors.As(err, &hErr) {
+		status = hErr.StatusCode
+	} else if err != nil {
+		status = http.StatusInternalServerError
+	} else if status == 0 {
+		// Nothing was written, in which case Caddy will respond with a 200.
+		status = http.StatusOK
+	}
+
+	labels := prometheus.Labels{"status_class": statusClass(status)}
+	if m.ExactStatus {
+		labels["status"] = strconv.Itoa(status)
+	}
+
+	m.counter.With(labels).Inc()
+
+	return err
+}
+
+// statusCountMetricParseCaddyfile sets up the handler from Caddyfile tokens.
+// Syntax:
+//
+//	status_count_metric [<matcher>] [<name>] {
+//		exact_status on|off
+//	}
+func statusCountMetricParseCaddyfile(
+	h httpcaddyfile.Helper,
+) (
+	caddyhttp.MiddlewareHandler, error,
+) {
+	h.Next() // consume directive name
+	m := new(StatusCountMetric)
+	if h.NextArg() {
+		m.Name = h.Val()
+	}
+
+	for h.NextBlock(0) {
+		switch h.Val() {
+		case "exact_status":
+			var err error
+			if m.ExactStatus, err = parseOnOff(h); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown field: %q", h.Val())
+		}
+	}
+
+	return m, nil
+}
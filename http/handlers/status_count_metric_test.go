@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusClass(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		status int
+		exp    string
+	}{
+		{0, "other"},
+		{99, "other"},
+		{100, "1xx"},
+		{101, "1xx"},
+		{200, "2xx"},
+		{204, "2xx"},
+		{301, "3xx"},
+		{304, "3xx"},
+		{404, "4xx"},
+		{429, "4xx"},
+		{500, "5xx"},
+		{503, "5xx"},
+		{600, "other"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.exp, statusClass(test.status), "status %d", test.status)
+	}
+}
+
+func TestStatusCountMetric(t *testing.T) {
+	t.Parallel()
+
+	m := &StatusCountMetric{Name: "test_responses_total", ExactStatus: true}
+	require.NoError(t, m.register(prometheus.NewRegistry()))
+
+	serve := func(t *testing.T, next caddyhttp.HandlerFunc) error {
+		var (
+			rw = httptest.NewRecorder()
+			r  = httptest.NewRequest("GET", "/", nil)
+		)
+
+		r = r.WithContext(context.WithValue(
+			r.Context(), caddy.ReplacerCtxKey, caddy.NewReplacer(),
+		))
+
+		return m.ServeHTTP(rw, r, next)
+	}
+
+	writeStatus := func(status int) caddyhttp.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) error {
+			rw.WriteHeader(status)
+			return nil
+		}
+	}
+
+	for _, status := range []int{
+		http.StatusContinue,
+		http.StatusOK,
+		http.StatusOK,
+		http.StatusMovedPermanently,
+		http.StatusNotFound,
+		http.StatusServiceUnavailable,
+	} {
+		require.NoError(t, serve(t, writeStatus(status)))
+	}
+
+	t.Log("Checking that writing a body without a status counts as a 200")
+	require.NoError(t, serve(t, func(rw http.ResponseWriter, r *http.Request) error {
+		_, err := rw.Write([]byte("hello"))
+		return err
+	}))
+
+	t.Log("Checking that errors are counted using their status code")
+	assert.Error(t, serve(t, func(rw http.ResponseWriter, r *http.Request) error {
+		return caddyhttp.Error(http.StatusForbidden, errors.New("forbidden"))
+	}))
+	assert.Error(t, serve(t, func(rw http.ResponseWriter, r *http.Request) error {
+		return errors.New("unexpected")
+	}))
+
+	tests := []struct {
+		class, status string
+		exp           float64
+	}{
+		{"1xx", "100", 1},
+		{"2xx", "200", 3},
+		{"3xx", "301", 1},
+		{"4xx", "403", 1},
+		{"4xx", "404", 1},
+		{"5xx", "500", 1},
+		{"5xx", "503", 1},
+	}
+
+	for _, test := range tests {
+		assert.Equal(
+			t,
+			test.exp,
+			testutil.ToFloat64(m.counter.WithLabelValues(test.class, test.status)),
+			"status %s", test.status,
+		)
+	}
+
+	t.Log("Checking that a second handler shares the same counter")
+	other := &StatusCountMetric{Name: "test_responses_total", ExactStatus: true}
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(m.counter))
+	require.NoError(t, other.register(reg))
+	assert.Same(t, m.counter, other.counter)
+
+	t.Log("Checking that a conflicting definition is rejected")
+	assert.Error(t, (&StatusCountMetric{Name: "test_responses_total"}).register(reg))
+}
+
+func TestStatusCountMetricParseCaddyfile(t *testing.T) {
+	t.Parallel()
+
+	h := httpcaddyfile.Helper{Dispenser: caddyfile.NewTestDispenser(`
+		status_count_metric "http_responses_total" {
+			exact_status on
+		}
+	`)}
+
+	handler, err := statusCountMetricParseCaddyfile(h)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		&StatusCountMetric{Name: "http_responses_total", ExactStatus: true},
+		handler,
+	)
+}
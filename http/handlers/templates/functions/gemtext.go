@@ -44,13 +44,27 @@ type Gemtext struct {
 	//
 	//	<a href="https://some.gateway/x/geminiprotocol.net">Check it out!</a>
 	GatewayURL string `json:"gateway_url,omitempty"`
+
+	// If true then headings are rendered with an `id` attribute, matching the
+	// `Slug` of the heading as returned by `gemtextHeadings`, so that they can
+	// be linked to.
+	HeadingIDs bool `json:"heading_ids,omitempty"`
+
+	// If true then links to fragments within the same document, e.g.
+	// `=> #section`, will be checked against the IDs given to the document's
+	// headings. Fragments which match a heading once slugified are rewritten
+	// to use its ID, and fragments which don't match any heading are given a
+	// `dangling-fragment` class and listed in the `DanglingFragments` field of
+	// the result.
+	CheckFragmentLinks bool `json:"check_fragment_links,omitempty"`
 }
 
 var _ templates.CustomFunctions = (*Gemtext)(nil)
 
 func (f *Gemtext) CustomTemplateFunctions() template.FuncMap {
 	return template.FuncMap{
-		"gemtext": f.funcGemtext,
+		"gemtext":         f.funcGemtext,
+		"gemtextHeadings": f.funcGemtextHeadings,
 	}
 }
 
@@ -68,7 +82,10 @@ func sanitizeText(str string) string {
 func (g *Gemtext) funcGemtext(input any) (gemtext.HTML, error) {
 	var (
 		r          = strings.NewReader(caddy.ToString(input))
-		translator gemtext.HTMLTranslator
+		translator = gemtext.HTMLTranslator{
+			HeadingIDs:         g.HeadingIDs,
+			CheckFragmentLinks: g.CheckFragmentLinks,
+		}
 	)
 
 	if g.GatewayURL != "" {
@@ -79,7 +96,9 @@ func (g *Gemtext) funcGemtext(input any) (gemtext.HTML, error) {
 			}
 
 			_, err := fmt.Fprintf(
-				w, "<p><a href=\"%s\">%s</a></p>\n", urlStr, label,
+				w,
+				"<p><a href=\"%s\">%s</a></p>\n",
+				html.EscapeString(urlStr), label,
 			)
 			return err
 		}
@@ -88,6 +107,10 @@ func (g *Gemtext) funcGemtext(input any) (gemtext.HTML, error) {
 	return translator.Translate(r)
 }
 
+func (g *Gemtext) funcGemtextHeadings(input any) ([]gemtext.Heading, error) {
+	return gemtext.Headings(strings.NewReader(caddy.ToString(input)))
+}
+
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler.
 func (g *Gemtext) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	d.Next() // consume directive name
@@ -102,6 +125,18 @@ func (g *Gemtext) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				return fmt.Errorf("invalid gateway url: %w", err)
 			}
 
+		case "heading_ids":
+			var err error
+			if g.HeadingIDs, err = unmarshalOnOff(d); err != nil {
+				return err
+			}
+
+		case "check_fragment_links":
+			var err error
+			if g.CheckFragmentLinks, err = unmarshalOnOff(d); err != nil {
+				return err
+			}
+
 		default:
 			return fmt.Errorf("unknown directive %q", v)
 		}
@@ -109,3 +144,20 @@ func (g *Gemtext) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 
 	return nil
 }
+
+// unmarshalOnOff parses the next argument as either `on` or `off`.
+func unmarshalOnOff(d *caddyfile.Dispenser) (bool, error) {
+	var onOff string
+	if !d.Args(&onOff) {
+		return false, d.ArgErr()
+	}
+
+	switch onOff {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	default:
+		return false, fmt.Errorf("expected 'on' or 'off', got %q", onOff)
+	}
+}
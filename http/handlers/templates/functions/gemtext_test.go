@@ -0,0 +1,79 @@
+package functions
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGemtextHeadings(t *testing.T) {
+	t.Parallel()
+
+	var (
+		f   = Gemtext{HeadingIDs: true}
+		tpl = template.Must(template.New("").Funcs(f.CustomTemplateFunctions()).Parse(
+			`{{ range gemtextHeadings . }}` +
+				`{{ .Level }} {{ .Slug }} {{ .Text }}` + "\n" +
+				`{{ end }}` +
+				`{{ (gemtext .).Body }}`,
+		))
+		doc = "# Intro\n## Fish & Chips\ntext\n## Intro\n"
+	)
+
+	var got strings.Builder
+	require.NoError(t, tpl.Execute(&got, doc))
+
+	assert.Equal(t,
+		"1 intro Intro\n"+
+			"2 fish-chips Fish &amp; Chips\n"+
+			"2 intro-2 Intro\n"+
+			`<h1 id="intro">Intro</h1>`+"\n"+
+			`<h2 id="fish-chips">Fish &amp; Chips</h2>`+"\n"+
+			"<p>text</p>\n"+
+			`<h2 id="intro-2">Intro</h2>`+"\n",
+		got.String(),
+	)
+
+	t.Log("Checking that headings aren't given ids by default")
+	f = Gemtext{}
+	tpl = template.Must(template.New("").Funcs(f.CustomTemplateFunctions()).Parse(
+		`{{ (gemtext .).Body }}`,
+	))
+
+	got.Reset()
+	require.NoError(t, tpl.Execute(&got, doc))
+	assert.Equal(t, "<h1>Intro</h1>\n", strings.SplitAfter(got.String(), "\n")[0])
+}
+
+func TestGemtextCheckFragmentLinks(t *testing.T) {
+	t.Parallel()
+
+	var f Gemtext
+	require.NoError(t, f.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`
+		gemtext_function {
+			heading_ids on
+			check_fragment_links on
+		}
+	`)))
+	assert.True(t, f.HeadingIDs)
+	assert.True(t, f.CheckFragmentLinks)
+
+	tpl := template.Must(template.New("").Funcs(f.CustomTemplateFunctions()).Parse(
+		`{{ $html := gemtext . }}{{ $html.Body }}{{ $html.DanglingFragments }}`,
+	))
+
+	var got strings.Builder
+	require.NoError(t, tpl.Execute(&got, "=> #Intro Intro\n=> #outro Outro\n# Intro\n"))
+
+	assert.Equal(t,
+		`<p><a href="#intro">Intro</a></p>`+"\n"+
+			`<p><a class="dangling-fragment" href="#outro">Outro</a></p>`+"\n"+
+			`<h1 id="intro">Intro</h1>`+"\n"+
+			"[outro]",
+		got.String(),
+	)
+}
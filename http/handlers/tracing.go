@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "dev.mediocregopher.com/mediocre-caddy-plugins.git/http/handlers"
+
+// startSpan starts a span as a child of the span in the given Context, if any.
+// The span is created using the same TracerProvider as its parent, so if Caddy
+// has not been configured with tracing then there will be no parent and the
+// returned span will be a no-op.
+func startSpan(
+	ctx context.Context, name string, attrs ...attribute.KeyValue,
+) (
+	context.Context, trace.Span,
+) {
+	return trace.SpanFromContext(ctx).
+		TracerProvider().
+		Tracer(tracerName).
+		Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, first recording the error on it if one is given.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
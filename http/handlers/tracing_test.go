@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestStartSpan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("no tracer", func(t *testing.T) {
+		_, span := startSpan(context.Background(), "test")
+		defer endSpan(span, nil)
+		assert.False(t, span.IsRecording())
+		assert.False(t, span.SpanContext().IsValid())
+	})
+
+	t.Run("child", func(t *testing.T) {
+		var (
+			recorder = tracetest.NewSpanRecorder()
+			provider = sdktrace.NewTracerProvider(
+				sdktrace.WithSpanProcessor(recorder),
+			)
+			ctx, parent = provider.Tracer("test").Start(context.Background(), "parent")
+		)
+
+		_, span := startSpan(ctx, "child", attribute.String("foo", "bar"))
+		endSpan(span, errors.New("oops"))
+		parent.End()
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 2)
+
+		child := spans[0]
+		assert.Equal(t, "child", child.Name())
+		assert.Equal(t, parent.SpanContext().SpanID(), child.Parent().SpanID())
+		assert.Contains(t, child.Attributes(), attribute.String("foo", "bar"))
+		assert.Equal(t, codes.Error, child.Status().Code)
+		assert.Equal(t, trace.SpanKindInternal, child.SpanKind())
+	})
+}
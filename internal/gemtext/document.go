@@ -0,0 +1,172 @@
+package gemtext
+
+import (
+	"io"
+	"strings"
+)
+
+// Values which Node.Type may take.
+const (
+	NodeTypeText         = "text"
+	NodeTypeHeading      = "heading"
+	NodeTypeLink         = "link"
+	NodeTypeList         = "list"
+	NodeTypeQuote        = "quote"
+	NodeTypePreformatted = "preformatted"
+)
+
+// Node is a single element of a gemtext Document. Which fields are set depends
+// on the Type of the Node. None of the fields are HTML escaped.
+type Node struct {
+	Type string `json:"type"`
+
+	// Level is the level of a heading: 1, 2, or 3.
+	Level int `json:"level,omitempty"`
+
+	// Text is the text of a text line, heading, or quote, or the contents of a
+	// preformatted block, with its lines separated by newlines.
+	Text string `json:"text,omitempty"`
+
+	// URL and Label describe a link. Label will be empty if the link has none.
+	URL   string `json:"url,omitempty"`
+	Label string `json:"label,omitempty"`
+
+	// Items are the items of a list, which is made up of consecutive list item
+	// lines.
+	Items []string `json:"items,omitempty"`
+
+	// AltText is the alt text of a preformatted block, if any.
+	AltText string `json:"alt_text,omitempty"`
+}
+
+// Document is a structured representation of a gemtext file, suitable for
+// encoding as JSON. Title is determined in the same way as for HTML (see
+// DocumentTranslator.TitleLevel).
+type Document struct {
+	Title string `json:"title"`
+	Nodes []Node `json:"nodes"`
+}
+
+// DocumentTranslator is used to translate a gemtext file into a Document, for
+// clients which wish to render gemtext themselves.
+type DocumentTranslator struct {
+	// AllowedLinkSchemes are the URL schemes which links may have. Links whose
+	// URL has a scheme not in this list (e.g. `javascript:`) will be translated
+	// into text Nodes, containing only their label. Relative URLs, which have
+	// no scheme, are always allowed.
+	//
+	// Defaults to DefaultAllowedLinkSchemes.
+	AllowedLinkSchemes []string
+
+	// TitleLevel is the level of the heading, 1, 2, or 3, which is used as the
+	// Title of the translated document. The first heading of this level in
+	// the document is used.
+	//
+	// Defaults to 1.
+	TitleLevel int
+}
+
+// Translate will read a gemtext file from the Reader and return it as a
+// Document. Lines which contain only whitespace, outside of preformatted
+// blocks, are omitted.
+func (t DocumentTranslator) Translate(src io.Reader) (Document, error) {
+	var (
+		sc    = newLineScanner(src)
+		doc   = Document{Nodes: []Node{}}
+		list  *Node
+		pre   *Node
+		lines []string
+	)
+
+	titleLevel := t.TitleLevel
+	if titleLevel == 0 {
+		titleLevel = 1
+	}
+
+	endList := func() {
+		if list != nil {
+			doc.Nodes = append(doc.Nodes, *list)
+			list = nil
+		}
+	}
+
+	endPre := func() {
+		if pre != nil {
+			pre.Text = strings.Join(lines, "\n")
+			doc.Nodes = append(doc.Nodes, *pre)
+			pre, lines = nil, nil
+		}
+	}
+
+	for sc.Scan() {
+		l := sc.Line()
+
+		switch l.kind {
+		case lineKindPreToggle:
+			if pre == nil {
+				endList()
+				pre = &Node{Type: NodeTypePreformatted, AltText: l.text}
+			} else {
+				endPre()
+			}
+			continue
+
+		case lineKindPre:
+			lines = append(lines, l.raw)
+			continue
+		}
+
+		if len(strings.TrimSpace(l.raw)) == 0 {
+			continue
+		}
+
+		if l.kind == lineKindListItem {
+			if list == nil {
+				list = &Node{Type: NodeTypeList}
+			}
+			list.Items = append(list.Items, l.text)
+			continue
+		}
+
+		endList()
+
+		var node Node
+		switch l.kind {
+		case lineKindLink:
+			var label string
+			if l.hasLabel {
+				label = l.text
+			}
+
+			if isAllowedLinkURL(l.url, t.AllowedLinkSchemes) {
+				node = Node{Type: NodeTypeLink, URL: l.url, Label: label}
+			} else {
+				node = Node{Type: NodeTypeText, Text: l.text}
+			}
+
+		case lineKindHeading:
+			if l.level == titleLevel && doc.Title == "" {
+				doc.Title = l.text
+			}
+			node = Node{Type: NodeTypeHeading, Level: l.level, Text: l.text}
+
+		case lineKindQuote:
+			node = Node{Type: NodeTypeQuote, Text: l.text}
+
+		default:
+			node = Node{Type: NodeTypeText, Text: strings.TrimSpace(l.raw)}
+		}
+
+		doc.Nodes = append(doc.Nodes, node)
+	}
+
+	if err := sc.Err(); err != nil {
+		return Document{}, err
+	}
+
+	// Close any nodes which were left open by the document ending.
+	endList()
+	endPre()
+
+	return doc, nil
+}
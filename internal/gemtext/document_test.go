@@ -0,0 +1,121 @@
+package gemtext
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentTranslator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		exp  Document
+	}{
+		{
+			name: "empty",
+			in:   "\n  \n",
+			exp:  Document{Nodes: []Node{}},
+		},
+		{
+			name: "text",
+			in:   "  Fish & chips  \n\nMushy peas",
+			exp: Document{Nodes: []Node{
+				{Type: NodeTypeText, Text: "Fish & chips"},
+				{Type: NodeTypeText, Text: "Mushy peas"},
+			}},
+		},
+		{
+			name: "headings",
+			in:   "## Sub\n# Title\n### Subsub\n# Other",
+			exp: Document{Title: "Title", Nodes: []Node{
+				{Type: NodeTypeHeading, Level: 2, Text: "Sub"},
+				{Type: NodeTypeHeading, Level: 1, Text: "Title"},
+				{Type: NodeTypeHeading, Level: 3, Text: "Subsub"},
+				{Type: NodeTypeHeading, Level: 1, Text: "Other"},
+			}},
+		},
+		{
+			name: "links",
+			in: "=> /foo Foo\n" +
+				"=> gemini://example.com\n" +
+				"=> javascript:alert(1) Evil\n",
+			exp: Document{Nodes: []Node{
+				{Type: NodeTypeLink, URL: "/foo", Label: "Foo"},
+				{Type: NodeTypeLink, URL: "gemini://example.com"},
+				{Type: NodeTypeText, Text: "Evil"},
+			}},
+		},
+		{
+			name: "lists",
+			in:   "* one\n* two\ntext\n* three",
+			exp: Document{Nodes: []Node{
+				{Type: NodeTypeList, Items: []string{"one", "two"}},
+				{Type: NodeTypeText, Text: "text"},
+				{Type: NodeTypeList, Items: []string{"three"}},
+			}},
+		},
+		{
+			name: "quotes",
+			in:   "> one\n>two",
+			exp: Document{Nodes: []Node{
+				{Type: NodeTypeQuote, Text: "one"},
+				{Type: NodeTypeQuote, Text: "two"},
+			}},
+		},
+		{
+			name: "preformatted",
+			in:   "* item\n``` shell\n# comment\n\n  => not a link\n```\n```\nunterminated",
+			exp: Document{Nodes: []Node{
+				{Type: NodeTypeList, Items: []string{"item"}},
+				{
+					Type:    NodeTypePreformatted,
+					AltText: "shell",
+					Text:    "# comment\n\n  => not a link",
+				},
+				{Type: NodeTypePreformatted, Text: "unterminated"},
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := DocumentTranslator{}.Translate(strings.NewReader(test.in))
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got)
+		})
+	}
+
+	t.Run("title level", func(t *testing.T) {
+		t.Parallel()
+		got, err := DocumentTranslator{TitleLevel: 2}.Translate(
+			strings.NewReader("# Site\n## Page\n"),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "Page", got.Title)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		got, err := DocumentTranslator{}.Translate(
+			strings.NewReader("# Title\n=> /foo\n"),
+		)
+		require.NoError(t, err)
+
+		b, err := json.Marshal(got)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+			"title": "Title",
+			"nodes": [
+				{"type": "heading", "level": 1, "text": "Title"},
+				{"type": "link", "url": "/foo"}
+			]
+		}`, string(b))
+	})
+}
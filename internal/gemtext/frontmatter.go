@@ -0,0 +1,68 @@
+package gemtext
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontmatterDelim is the line which opens and closes a frontmatter block.
+const frontmatterDelim = "---"
+
+// readFrontmatter reads a YAML frontmatter block from the start of the
+// document, if it has one, returning its parsed fields along with a Reader for
+// the rest of the document.
+//
+// A document has a frontmatter block if its first line is "---", and a later
+// line is also "---". If it doesn't then nil is returned, along with a Reader
+// for the whole document.
+func readFrontmatter(src io.Reader) (map[string]any, io.Reader, error) {
+	var (
+		r       = bufio.NewReader(src)
+		read    strings.Builder
+		block   strings.Builder
+		opening = true
+	)
+
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, err
+		}
+
+		read.WriteString(l)
+
+		trimmed := strings.TrimRight(l, "\r\n")
+		if opening {
+			trimmed = strings.TrimPrefix(trimmed, "\uFEFF")
+		}
+
+		switch {
+		case opening && trimmed != frontmatterDelim:
+			return nil, io.MultiReader(strings.NewReader(read.String()), r), nil
+
+		case opening:
+			opening = false
+
+		case trimmed == frontmatterDelim:
+			meta := map[string]any{}
+			if err := yaml.Unmarshal([]byte(block.String()), &meta); err != nil {
+				return nil, nil, fmt.Errorf("parsing frontmatter: %w", err)
+			}
+			return meta, r, nil
+
+		default:
+			block.WriteString(l)
+		}
+
+		// The block was never closed, so the document doesn't have frontmatter
+		// after all.
+		if err != nil {
+			return nil, strings.NewReader(read.String()), nil
+		}
+	}
+}
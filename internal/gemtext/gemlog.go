@@ -1,17 +1,47 @@
 package gemtext
 
 import (
-	"bufio"
+	"cmp"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"html"
 	"io"
+	"io/fs"
 	"net/url"
+	"path"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gorilla/feeds"
+	"github.com/tilinna/clock"
 )
 
+// Values which FeedTranslator.EntryContent may take.
+const (
+	FeedEntryContentNone    = ""
+	FeedEntryContentGemtext = "gemtext"
+	FeedEntryContentHTML    = "html"
+)
+
+// Values which FeedTranslator.Order may take.
+const (
+	FeedOrderAppearance = "appearance"
+	FeedOrderDateAsc    = "date_asc"
+	FeedOrderDateDesc   = "date_desc"
+	FeedOrderTitle      = "title"
+)
+
+// DefaultFeedDateFormat is the layout, as used by time.Parse, of the date stamp
+// which the label of each entry in a gemlog begins with. It is always accepted
+// by FeedTranslator, in addition to any FeedTranslator.DateFormats.
+const DefaultFeedDateFormat = "2006-01-02"
+
+// defaultMaxEntryContentSize is the default value of
+// FeedTranslator.MaxEntryContentSize.
+const defaultMaxEntryContentSize = 4096
+
 // feedItemSeparators are different separator characters that someone might use
 // to separate the date string from the link description in a gemlog.
 var feedItemSeparators = "-:|"
@@ -19,6 +49,14 @@ var feedItemSeparators = "-:|"
 // FeedTranslator is used to translate a gemtext file, interpreted as a
 // [gemlog], into an RSS, Atom, or JSON feed.
 //
+// Each link line whose label begins with a date, e.g.
+// `=> post.gmi 2024-01-02 - Title`, is an entry in the feed. Link lines without
+// a label are never entries, even if their URL begins with a date.
+//
+// The title of the feed is taken from the first level 1 heading, e.g.
+// `# My Gemlog`. Other headings, and any headings after the first, are
+// ignored.
+//
 // [gemlog]: https://geminiprotocol.net/docs/companion/subscription.gmi
 type FeedTranslator struct {
 
@@ -29,18 +67,318 @@ type FeedTranslator struct {
 	// Optional strings to use in the top-level 'author' field of the resulting
 	// feed.
 	AuthorName, AuthorEmail string
+
+	// Optional description of the feed, used as the `description` of RSS and
+	// JSON feeds and the `subtitle` of Atom feeds.
+	Description string
+
+	// Optional URLs of a small icon and a larger logo representing the feed,
+	// resolved relative to BaseURL. In Atom feeds these are the `icon` and
+	// `logo`, and in JSON feeds the `favicon` and `icon`. RSS feeds only
+	// support a single image, for which Logo is used, or Icon if Logo is not
+	// given.
+	Icon, Logo string
+
+	// AlternateLinks are additional top-level links to include in the feed,
+	// e.g. to the HTML or gemini versions of the gemlog, each with its own
+	// `rel` and `type`. They are only included in Atom feeds, as RSS and JSON
+	// feeds only support a single top-level link.
+	AlternateLinks []feeds.Link
+
+	// SelfURL, if given, is the URL of the feed itself, which is included as a
+	// top-level link with rel "self" (or the `feed_url` of JSON feeds).
+	SelfURL *url.URL
+
+	// Hubs are the URLs of [WebSub] hubs which the feed is published to. They
+	// are included as top-level links with rel "hub" (or the `hubs` of JSON
+	// feeds). WebSub also requires SelfURL to be given.
+	//
+	// [WebSub]: https://www.w3.org/TR/websub/
+	Hubs []string
+
+	// If true then any quoted (`>` prefix) or indented lines which directly
+	// follow an entry's link line will be used as the summary of that entry.
+	//
+	// Entries are never given categories (e.g. Atom's `category`), as gemlogs
+	// have no conventional syntax for tagging entries to derive them from.
+	ParseSummary bool
+
+	// DateFormats are additional layouts, as used by time.Parse, which the
+	// date stamp at the beginning of an entry's label may have. They are tried
+	// in order, followed by DefaultFeedDateFormat. A date stamp is expected to
+	// be the same length as its layout, e.g. "2006/01/02" or
+	// "2006-01-02 15:04".
+	//
+	// If the matching layout includes a time of day then that is used as the
+	// entry's updated time, otherwise noon UTC on the indicated day is used.
+	DateFormats []string
+
+	// EntryContent determines whether each entry's section of the gemlog is
+	// included as the content of the entry. An entry's section is its link
+	// line, plus all lines following it up until the next link line. It can be
+	// one of:
+	//
+	//	FeedEntryContentNone: No content is included (the default).
+	//	FeedEntryContentGemtext: The raw gemtext, wrapped in a <pre> tag.
+	//	FeedEntryContentHTML: The gemtext translated using HTMLTranslator.
+	EntryContent string
+
+	// MaxEntryContentSize is the maximum number of bytes of gemtext which will
+	// be included from an entry's section, when EntryContent is set. Sections
+	// are truncated to the last full line which fits.
+	//
+	// Defaults to 4096.
+	MaxEntryContentSize int
+
+	// ResolveEntry, if given, is used to open the gemtext document which an
+	// entry links to, so that it can be translated to HTML and included as the
+	// content of the entry. This takes precedence over EntryContent.
+	//
+	// It is only called for entries whose link is relative and ends in
+	// `.gmi`, and is given the cleaned path of the link once resolved against
+	// BaseURL, e.g. "/gemlog/post.gmi". If it returns an error wrapping
+	// fs.ErrNotExist then the entry's content is left as-is.
+	ResolveEntry func(path string) (io.ReadCloser, error)
+
+	// If true then entries whose date is after the current day will be
+	// excluded from the feed, e.g. those which have been scheduled for future
+	// publication.
+	ExcludeFuture bool
+
+	// Order determines the order of the entries in the feed. It can be one of:
+	//
+	//	FeedOrderAppearance: The order the entries appear in the gemlog (the
+	//	default).
+	//	FeedOrderDateAsc: Oldest entries first.
+	//	FeedOrderDateDesc: Newest entries first.
+	//	FeedOrderTitle: Alphabetically by title, ignoring case.
+	//
+	// Entries which are otherwise equal retain the order they appear in the
+	// gemlog.
+	Order string
+
+	// MaxItems, if greater than zero, limits the number of entries in the
+	// feed. The most recently updated entries are kept, and are then ordered
+	// according to Order.
+	MaxItems int
+
+	// EarlyStop, if true, causes parsing of the gemlog to stop once MaxItems
+	// entries have been found, rather than the whole document being read.
+	// This assumes that the gemlog lists its entries newest-first, as is
+	// conventional; if it does not then newer entries may be missing from
+	// the feed. It has no effect if MaxItems is not given.
+	EarlyStop bool
+
+	// Clock is used for controlling the view of time.
+	//
+	// Defaults to clock.Realtime().
+	Clock clock.Clock
+}
+
+// entrySection accumulates the lines of the section of a gemlog belonging to a
+// single entry.
+type entrySection struct {
+	item    *feeds.Item
+	b       strings.Builder
+	maxSize int
+	full    bool
+}
+
+func (s *entrySection) writeLine(line string) {
+	if s.full || s.b.Len()+len(line) > s.maxSize {
+		s.full = true
+		return
+	}
+	s.b.WriteString(line)
+}
+
+func (t FeedTranslator) entryContent(section string) (string, error) {
+	switch t.EntryContent {
+	case FeedEntryContentGemtext:
+		return "<pre>" + html.EscapeString(section) + "</pre>", nil
+
+	case FeedEntryContentHTML:
+		translated, err := HTMLTranslator{}.Translate(strings.NewReader(section))
+		if err != nil {
+			return "", err
+		}
+		return translated.Body, nil
+
+	default:
+		return "", fmt.Errorf("unknown entry content type %q", t.EntryContent)
+	}
+}
+
+// resolvedEntryContent returns the gemtext document at the given path, as
+// opened by ResolveEntry, translated to HTML. false is returned if there is no
+// document at the path.
+func (t FeedTranslator) resolvedEntryContent(
+	entryPath string,
+) (
+	string, bool, error,
+) {
+	f, err := t.ResolveEntry(entryPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("opening %q: %w", entryPath, err)
+	}
+	defer f.Close()
+
+	translated, err := HTMLTranslator{}.Translate(f)
+	if err != nil {
+		return "", false, fmt.Errorf("translating %q: %w", entryPath, err)
+	}
+
+	return translated.Body, true, nil
+}
+
+// parseSummaryLine returns the text of the given line if it is a summary line,
+// i.e. if it is quoted or indented.
+func parseSummaryLine(l line) (string, bool) {
+	switch {
+	case l.kind == lineKindQuote:
+		return l.text, true
+	case l.kind == lineKindText &&
+		(strings.HasPrefix(l.raw, " ") || strings.HasPrefix(l.raw, "\t")):
+		text := strings.TrimSpace(l.raw)
+		return text, text != ""
+	default:
+		return "", false
+	}
+}
+
+func (t FeedTranslator) sortItems(items []*feeds.Item) error {
+	var cmpFn func(a, b *feeds.Item) int
+	switch t.Order {
+	case "", FeedOrderAppearance:
+		return nil
+	case FeedOrderDateAsc:
+		cmpFn = func(a, b *feeds.Item) int { return a.Updated.Compare(b.Updated) }
+	case FeedOrderDateDesc:
+		cmpFn = func(a, b *feeds.Item) int { return b.Updated.Compare(a.Updated) }
+	case FeedOrderTitle:
+		cmpFn = func(a, b *feeds.Item) int {
+			return strings.Compare(
+				strings.ToLower(a.Title), strings.ToLower(b.Title),
+			)
+		}
+	default:
+		return fmt.Errorf("unknown order %q", t.Order)
+	}
+
+	slices.SortStableFunc(items, cmpFn)
+	return nil
+}
+
+// newestItems returns the n items with the most recent Updated times, in the
+// order they appear in items. Of items with equal times, those appearing first
+// are preferred.
+func newestItems(items []*feeds.Item, n int) []*feeds.Item {
+	byDate := slices.Clone(items)
+	slices.SortStableFunc(byDate, func(a, b *feeds.Item) int {
+		return b.Updated.Compare(a.Updated)
+	})
+
+	keep := make(map[*feeds.Item]bool, n)
+	for _, item := range byDate[:n] {
+		keep[item] = true
+	}
+
+	return slices.DeleteFunc(items, func(item *feeds.Item) bool {
+		return !keep[item]
+	})
+}
+
+// layoutHasClock returns whether the given time.Parse layout includes a time of
+// day.
+func layoutHasClock(layout string) bool {
+	var (
+		t        = time.Date(2001, 2, 3, 16, 5, 6, 0, time.UTC)
+		midnight = time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	)
+	return t.Format(layout) != midnight.Format(layout)
+}
+
+// parseEntryDate parses the date stamp at the beginning of an entry's label,
+// returning the updated time it indicates along with the rest of the label.
+// false is returned if the label doesn't begin with a date stamp.
+func (t FeedTranslator) parseEntryDate(label string) (time.Time, string, bool) {
+	for _, layout := range slices.Concat(t.DateFormats, []string{DefaultFeedDateFormat}) {
+		if len(label) < len(layout) {
+			continue
+		}
+
+		date, err := time.Parse(layout, label[:len(layout)])
+		if err != nil {
+			continue
+		}
+
+		if !layoutHasClock(layout) {
+			// "An entry's required "updated" element is noon UTC on the day
+			// indicated by the 10 character date stamp at the beginning of the
+			// corresponding link line's label."
+			date = time.Date(
+				date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC,
+			)
+		}
+
+		return date, label[len(layout):], true
+	}
+
+	return time.Time{}, "", false
 }
 
 func (t FeedTranslator) toFeed(src io.Reader) (*feeds.Feed, error) {
 	var (
-		r          = bufio.NewReader(src)
+		sc         = newLineScanner(src)
 		baseURLStr = t.BaseURL.String()
 		feed       = &feeds.Feed{
 			Link: &feeds.Link{Href: baseURLStr},
 			Id:   baseURLStr,
 		}
+
+		// lastItem is the most recently parsed item, so long as nothing other
+		// than summary lines have been seen since it was parsed.
+		lastItem *feeds.Item
+
+		// section is the section of the most recently parsed item, used when
+		// EntryContent is set.
+		section *entrySection
+
+		// entryPaths are the paths of the local documents which items link
+		// to, used when ResolveEntry is set.
+		entryPaths = map[*feeds.Item]string{}
 	)
 
+	clk := t.Clock
+	if clk == nil {
+		clk = clock.Realtime()
+	}
+	now := clk.Now().UTC()
+
+	maxEntryContentSize := t.MaxEntryContentSize
+	if maxEntryContentSize == 0 {
+		maxEntryContentSize = defaultMaxEntryContentSize
+	}
+
+	endSection := func() error {
+		if section == nil {
+			return nil
+		}
+
+		content, err := t.entryContent(section.b.String())
+		if err != nil {
+			return fmt.Errorf(
+				"generating content for entry %q: %w", section.item.Id, err,
+			)
+		}
+
+		section.item.Content = content
+		section = nil
+		return nil
+	}
+
 	if t.AuthorName != "" || t.AuthorEmail != "" {
 		feed.Author = &feeds.Author{
 			Name:  t.AuthorName,
@@ -48,40 +386,70 @@ func (t FeedTranslator) toFeed(src io.Reader) (*feeds.Feed, error) {
 		}
 	}
 
-loop:
-	for {
-		line, err := r.ReadString('\n')
+	feed.Description = t.Description
 
-		switch {
-		case errors.Is(err, io.EOF):
-			break loop
+	for sc.Scan() {
+		l := sc.Line()
 
-		case err != nil:
-			return nil, fmt.Errorf("reading next line: %w", err)
+		// The next link line is where the section and summary of the last
+		// entry end, so stopping here ensures that they're complete.
+		if l.kind == lineKindLink &&
+			t.EarlyStop &&
+			t.MaxItems > 0 &&
+			len(feed.Items) >= t.MaxItems {
+			break
+		}
 
-		case strings.HasPrefix(line, "#"):
-			feed.Title = strings.TrimSpace(line[1:])
+		if l.kind == lineKindLink {
+			if err := endSection(); err != nil {
+				return nil, err
+			}
+		} else if section != nil {
+			section.writeLine(l.raw + "\n")
+		}
 
-		case strings.HasPrefix(line, "=>"):
-			parsedLink := parseLinkLine(line)
+		if t.ParseSummary && lastItem != nil {
+			if summary, ok := parseSummaryLine(l); ok {
+				if lastItem.Description != "" {
+					lastItem.Description += " "
+				}
+				lastItem.Description += summary
+				continue
+			}
+		}
+
+		lastItem = nil
+
+		switch l.kind {
+		case lineKindHeading:
+			if l.level == 1 && feed.Title == "" {
+				feed.Title = l.text
+			}
 
-			if len(parsedLink.label) < 10 {
+		case lineKindLink:
+			// An entry's label must begin with its date, so a link without a
+			// label can never be an entry, even if its URL happens to begin
+			// with something that looks like a date.
+			if !l.hasLabel {
 				continue
 			}
 
-			date, err := time.Parse("2006-01-02", parsedLink.label[:10])
-			if err != nil {
+			updatedAt, title, ok := t.parseEntryDate(l.text)
+			if !ok {
 				continue
 			}
 
-			// "An entry's required "updated" element is noon UTC on the day
-			// indicated by the 10 character date stamp at the beginning of the
-			// corresponding link line's label."
-			updatedAt := time.Date(
-				date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, time.UTC,
+			// Entries are only excluded if their day is in the future, even if
+			// they have a time of day.
+			day := time.Date(
+				updatedAt.Year(), updatedAt.Month(), updatedAt.Day(),
+				0, 0, 0, 0, time.UTC,
 			)
+			if t.ExcludeFuture && day.After(now) {
+				continue
+			}
 
-			title := strings.TrimSpace(parsedLink.label[10:])
+			title = strings.TrimSpace(title)
 			for {
 				prevTitle := title
 				title = strings.TrimLeft(title, feedItemSeparators)
@@ -91,19 +459,36 @@ loop:
 				}
 			}
 
-			url, err := url.Parse(parsedLink.url)
+			url, err := url.Parse(l.url)
 			if err != nil {
 				continue
 			}
 
 			absURL := t.BaseURL.ResolveReference(url)
 
-			feed.Items = append(feed.Items, &feeds.Item{
+			lastItem = &feeds.Item{
 				Title:   title,
 				Link:    &feeds.Link{Href: absURL.String(), Rel: "alternate"},
 				Id:      absURL.String(),
 				Updated: updatedAt,
-			})
+			}
+
+			feed.Items = append(feed.Items, lastItem)
+
+			if t.ResolveEntry != nil &&
+				url.Scheme == "" &&
+				url.Host == "" &&
+				strings.HasSuffix(absURL.Path, ".gmi") {
+				entryPaths[lastItem] = path.Clean("/" + absURL.Path)
+			}
+
+			if t.EntryContent != FeedEntryContentNone {
+				section = &entrySection{
+					item:    lastItem,
+					maxSize: maxEntryContentSize,
+				}
+				section.writeLine(l.raw + "\n")
+			}
 
 			if updatedAt.After(feed.Updated) {
 				feed.Updated = updatedAt
@@ -111,16 +496,75 @@ loop:
 		}
 	}
 
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := endSection(); err != nil {
+		return nil, err
+	}
+
+	if t.MaxItems > 0 && len(feed.Items) > t.MaxItems {
+		feed.Items = newestItems(feed.Items, t.MaxItems)
+	}
+
+	if err := t.sortItems(feed.Items); err != nil {
+		return nil, err
+	}
+
+	// Linked documents are only resolved for the items which remain, as there
+	// may be many more items than that in the gemlog.
+	for _, item := range feed.Items {
+		entryPath, ok := entryPaths[item]
+		if !ok {
+			continue
+		}
+
+		content, ok, err := t.resolvedEntryContent(entryPath)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"generating content for entry %q: %w", item.Id, err,
+			)
+		} else if ok {
+			item.Content = content
+		}
+	}
+
+	if image := cmp.Or(t.Logo, t.Icon); image != "" {
+		// RSS requires an image to have a title and link, which should be the
+		// same as those of the feed itself.
+		feed.Image = &feeds.Image{
+			Url:   t.resolveURL(image),
+			Title: feed.Title,
+			Link:  feed.Link.Href,
+		}
+	}
+
 	if feed.Updated.IsZero() {
 		// "If no entries can be extracted from the document ... the feed's
 		// "updated" element should be set equal to the time the document was
 		// fetched."
-		feed.Updated = time.Now().UTC()
+		feed.Updated = now
 	}
 
 	return feed, nil
 }
 
+// resolveURL resolves the given URL relative to BaseURL, or returns it as-is if
+// it can't be parsed.
+func (t FeedTranslator) resolveURL(urlStr string) string {
+	if urlStr == "" {
+		return ""
+	}
+
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return urlStr
+	}
+
+	return t.BaseURL.ResolveReference(u).String()
+}
+
 func (t FeedTranslator) translate(
 	out io.Writer, in io.Reader, fn func(*feeds.Feed) (string, error),
 ) error {
@@ -141,17 +585,128 @@ func (t FeedTranslator) translate(
 	return nil
 }
 
+// rssAtomLink is an Atom link included in an RSS feed, as RSS doesn't have its
+// own equivalent.
+type rssAtomLink struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+}
+
+// rssChannel extends feeds.RssFeed with Atom links, which the feeds package
+// doesn't support. Items shadows the field of feeds.RssFeed, so that the links
+// come before the items.
+type rssChannel struct {
+	*feeds.RssFeed
+	AtomLinks []rssAtomLink
+	Items     []*feeds.RssItem `xml:"item"`
+}
+
+// rssFeed is the equivalent of feeds.RssFeedXml for an rssChannel, with the
+// addition of the Atom namespace.
+type rssFeed struct {
+	XMLName          xml.Name `xml:"rss"`
+	Version          string   `xml:"version,attr"`
+	ContentNamespace string   `xml:"xmlns:content,attr"`
+	AtomNamespace    string   `xml:"xmlns:atom,attr,omitempty"`
+	Channel          *rssChannel
+}
+
+func (f *rssFeed) FeedXml() any {
+	return f
+}
+
+func (t FeedTranslator) toRSS(feed *feeds.Feed) (string, error) {
+	rss := (&feeds.Rss{Feed: feed}).RssFeed()
+	channel := &rssChannel{RssFeed: rss, Items: rss.Items}
+	if t.SelfURL != nil {
+		channel.AtomLinks = append(channel.AtomLinks, rssAtomLink{
+			Href: t.SelfURL.String(),
+			Rel:  "self",
+			Type: "application/rss+xml",
+		})
+	}
+	for _, hub := range t.Hubs {
+		channel.AtomLinks = append(channel.AtomLinks, rssAtomLink{
+			Href: hub,
+			Rel:  "hub",
+		})
+	}
+
+	xmlFeed := &rssFeed{
+		Version:          "2.0",
+		ContentNamespace: "http://purl.org/rss/1.0/modules/content/",
+		Channel:          channel,
+	}
+	if len(channel.AtomLinks) > 0 {
+		xmlFeed.AtomNamespace = "http://www.w3.org/2005/Atom"
+	}
+
+	return feeds.ToXML(xmlFeed)
+}
+
 // ToRSS translates the input gemtext document into an RSS feed.
 func (t FeedTranslator) ToRSS(to io.Writer, from io.Reader) error {
-	return t.translate(to, from, (*feeds.Feed).ToRss)
+	return t.translate(to, from, t.toRSS)
+}
+
+// atomFeed extends feeds.AtomFeed with additional top-level links, e.g.
+// alternates, self, and hubs, which the feeds package doesn't support. The icon
+// and logo, which the feeds package also doesn't populate, are set directly on
+// the embedded feeds.AtomFeed.
+type atomFeed struct {
+	*feeds.AtomFeed
+	Links []feeds.AtomLink
+}
+
+func (f *atomFeed) FeedXml() any {
+	return f
+}
+
+func (t FeedTranslator) toAtom(feed *feeds.Feed) (string, error) {
+	atom := &atomFeed{AtomFeed: (&feeds.Atom{Feed: feed}).AtomFeed()}
+	atom.Icon = t.resolveURL(t.Icon)
+	atom.Logo = t.resolveURL(t.Logo)
+	for _, link := range t.AlternateLinks {
+		atom.Links = append(atom.Links, feeds.AtomLink{
+			Href: link.Href,
+			Rel:  link.Rel,
+			Type: link.Type,
+		})
+	}
+	if t.SelfURL != nil {
+		atom.Links = append(atom.Links, feeds.AtomLink{
+			Href: t.SelfURL.String(),
+			Rel:  "self",
+			Type: "application/atom+xml",
+		})
+	}
+	for _, hub := range t.Hubs {
+		atom.Links = append(atom.Links, feeds.AtomLink{Href: hub, Rel: "hub"})
+	}
+	return feeds.ToXML(atom)
 }
 
 // ToAtom translates the input gemtext document into an Atom feed.
 func (t FeedTranslator) ToAtom(to io.Writer, from io.Reader) error {
-	return t.translate(to, from, (*feeds.Feed).ToAtom)
+	return t.translate(to, from, t.toAtom)
+}
+
+func (t FeedTranslator) toJSON(feed *feeds.Feed) (string, error) {
+	json := (&feeds.JSON{Feed: feed}).JSONFeed()
+	json.Favicon = t.resolveURL(t.Icon)
+	json.Icon = t.resolveURL(t.Logo)
+	if t.SelfURL != nil {
+		json.FeedUrl = t.SelfURL.String()
+	}
+	for _, hub := range t.Hubs {
+		json.Hubs = append(json.Hubs, &feeds.JSONHub{Type: "WebSub", Url: hub})
+	}
+	return json.ToJSON()
 }
 
 // ToJSON translates the input gemtext document into an JSON feed.
 func (t FeedTranslator) ToJSON(to io.Writer, from io.Reader) error {
-	return t.translate(to, from, (*feeds.Feed).ToJSON)
+	return t.translate(to, from, t.toJSON)
 }
@@ -0,0 +1,718 @@
+package gemtext
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tilinna/clock"
+)
+
+func TestFeedTranslator(t *testing.T) {
+	t.Parallel()
+
+	baseURL, err := url.Parse("https://example.com/gemlog/")
+	require.NoError(t, err)
+
+	t.Run("summary", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> 2024-01-01-one.gmi 2024-01-01 - First Post
+> The first summary.
+
+=> 2024-01-02-two.gmi 2024-01-02 - Second Post
+  An indented summary
+  which spans two lines.
+=> 2024-01-03-three.gmi 2024-01-03 - Third Post
+
+> A quote which isn't a summary.
+`
+
+		tests := []struct {
+			name         string
+			parseSummary bool
+			exp          []string
+		}{
+			{"disabled", false, []string{"", "", ""}},
+			{
+				"enabled", true, []string{
+					"The first summary.",
+					"An indented summary which spans two lines.",
+					"",
+				},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				translator := FeedTranslator{
+					BaseURL:      baseURL,
+					ParseSummary: test.parseSummary,
+				}
+
+				feed, err := translator.toFeed(strings.NewReader(doc))
+				require.NoError(t, err)
+				require.Len(t, feed.Items, len(test.exp))
+
+				for i := range test.exp {
+					assert.Equal(t, test.exp[i], feed.Items[i].Description)
+				}
+			})
+		}
+	})
+
+	t.Run("title", func(t *testing.T) {
+		t.Parallel()
+
+		tests := []struct {
+			name, doc, exp string
+		}{
+			{"first level 1", "# My Gemlog\n# Archive\n", "My Gemlog"},
+			{"other levels ignored", "## Posts\n# My Gemlog\n### Old\n", "My Gemlog"},
+			{"preformatted ignored", "```\n# Not it\n```\n# My Gemlog\n", "My Gemlog"},
+			{"none", "## Posts\n", ""},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				translator := FeedTranslator{BaseURL: baseURL}
+				feed, err := translator.toFeed(strings.NewReader(test.doc))
+				require.NoError(t, err)
+				assert.Equal(t, test.exp, feed.Title)
+			})
+		}
+	})
+
+	t.Run("no_entries", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> /about.gmi About me
+`
+
+		var (
+			now        = time.Date(2024, 1, 2, 6, 0, 0, 0, time.FixedZone("", 3600))
+			translator = FeedTranslator{
+				BaseURL: baseURL,
+				Clock:   clock.NewMock(now),
+			}
+		)
+
+		feed, err := translator.toFeed(strings.NewReader(doc))
+		require.NoError(t, err)
+		assert.Empty(t, feed.Items)
+		assert.Equal(t, now.UTC(), feed.Updated)
+		assert.Equal(t, time.UTC, feed.Updated.Location())
+	})
+
+	t.Run("exclude_future", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> 2024-01-01-past.gmi 2024-01-01 - Past Post
+=> 2024-01-02-today.gmi 2024-01-02 - Today's Post
+=> 2024-01-03-future.gmi 2024-01-03 - Future Post
+`
+
+		clock := clock.NewMock(time.Date(2024, 1, 2, 6, 0, 0, 0, time.UTC))
+
+		tests := []struct {
+			name          string
+			excludeFuture bool
+			exp           []string
+		}{
+			{
+				"disabled", false,
+				[]string{"Past Post", "Today's Post", "Future Post"},
+			},
+			{
+				"enabled", true,
+				[]string{"Past Post", "Today's Post"},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				translator := FeedTranslator{
+					BaseURL:       baseURL,
+					ExcludeFuture: test.excludeFuture,
+					Clock:         clock,
+				}
+
+				feed, err := translator.toFeed(strings.NewReader(doc))
+				require.NoError(t, err)
+
+				var got []string
+				for _, item := range feed.Items {
+					got = append(got, item.Title)
+				}
+				assert.Equal(t, test.exp, got)
+			})
+		}
+	})
+
+	t.Run("date formats", func(t *testing.T) {
+		t.Parallel()
+
+		translator := FeedTranslator{
+			BaseURL:     baseURL,
+			DateFormats: []string{"2006/01/02", "2006-01-02 15:04"},
+		}
+
+		tests := []struct {
+			name, label string
+			expUpdated  time.Time
+			expTitle    string
+		}{
+			{
+				"default", "2024-01-02 - Title",
+				time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), "Title",
+			},
+			{
+				"slashes", "2024/01/02 - Title",
+				time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), "Title",
+			},
+			{
+				"with time", "2024-01-02 14:30 - Title",
+				time.Date(2024, 1, 2, 14, 30, 0, 0, time.UTC), "Title",
+			},
+			{
+				"with time at midnight", "2024-01-02 00:00 | Title",
+				time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), "Title",
+			},
+			{
+				"no date", "Not a dated post", time.Time{}, "",
+			},
+			{
+				"unparseable date", "2024.01.02 - Title", time.Time{}, "",
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				doc := "# My Gemlog\n=> post.gmi " + test.label + "\n"
+
+				feed, err := translator.toFeed(strings.NewReader(doc))
+				require.NoError(t, err)
+
+				if test.expUpdated.IsZero() {
+					t.Log("Checking that the link is not an entry")
+					assert.Empty(t, feed.Items)
+					return
+				}
+
+				require.Len(t, feed.Items, 1)
+				assert.Equal(t, test.expTitle, feed.Items[0].Title)
+				assert.Equal(t, test.expUpdated, feed.Items[0].Updated)
+			})
+		}
+
+		t.Log("Checking that only the default layout is accepted by default")
+		feed, err := FeedTranslator{BaseURL: baseURL}.toFeed(strings.NewReader(
+			"# My Gemlog\n=> post.gmi 2024/01/02 - Title\n",
+		))
+		require.NoError(t, err)
+		assert.Empty(t, feed.Items)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> 2024-01-02-b.gmi 2024-01-02 - beta
+=> 2024-01-03-c.gmi 2024-01-03 - Charlie
+=> 2024-01-01-a.gmi 2024-01-01 - Alpha
+=> 2024-01-02-d.gmi 2024-01-02 - Delta
+`
+
+		tests := []struct {
+			order string
+			exp   []string
+		}{
+			{"", []string{"beta", "Charlie", "Alpha", "Delta"}},
+			{FeedOrderAppearance, []string{"beta", "Charlie", "Alpha", "Delta"}},
+			{FeedOrderDateAsc, []string{"Alpha", "beta", "Delta", "Charlie"}},
+			{FeedOrderDateDesc, []string{"Charlie", "beta", "Delta", "Alpha"}},
+			{FeedOrderTitle, []string{"Alpha", "beta", "Charlie", "Delta"}},
+		}
+
+		for _, test := range tests {
+			t.Run(test.order, func(t *testing.T) {
+				translator := FeedTranslator{
+					BaseURL: baseURL,
+					Order:   test.order,
+				}
+
+				feed, err := translator.toFeed(strings.NewReader(doc))
+				require.NoError(t, err)
+
+				var got []string
+				for _, item := range feed.Items {
+					got = append(got, item.Title)
+				}
+				assert.Equal(t, test.exp, got)
+			})
+		}
+
+		t.Run("unknown", func(t *testing.T) {
+			translator := FeedTranslator{BaseURL: baseURL, Order: "bogus"}
+			_, err := translator.toFeed(strings.NewReader(doc))
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("entry_content", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> 2024-01-01-one.gmi 2024-01-01 - First Post
+* A list item
+
+Some words about <this> post.
+=> 2024-01-02-two.gmi 2024-01-02 - Second Post
+=> /about.gmi About me
+I'm not part of any entry.
+=> 2024-01-03-three.gmi 2024-01-03 - Third Post
+A line which fits.
+A line which does not fit.
+`
+
+		tests := []struct {
+			name         string
+			entryContent string
+			maxSize      int
+			exp          []string
+		}{
+			{"none", FeedEntryContentNone, 0, []string{"", "", ""}},
+			{
+				"gemtext", FeedEntryContentGemtext, 0, []string{
+					"<pre>=&gt; 2024-01-01-one.gmi 2024-01-01 - First Post\n" +
+						"* A list item\n\n" +
+						"Some words about &lt;this&gt; post.\n</pre>",
+					"<pre>=&gt; 2024-01-02-two.gmi 2024-01-02 - Second Post\n</pre>",
+					"<pre>=&gt; 2024-01-03-three.gmi 2024-01-03 - Third Post\n" +
+						"A line which fits.\n" +
+						"A line which does not fit.\n</pre>",
+				},
+			},
+			{
+				"html", FeedEntryContentHTML, 0, []string{
+					"<p><a href=\"2024-01-01-one.gmi\">2024-01-01 - First Post</a></p>\n" +
+						"<ul>\n<li>A list item</li>\n</ul>\n" +
+						"<p>Some words about <this> post.</p>\n",
+					"<p><a href=\"2024-01-02-two.gmi\">2024-01-02 - Second Post</a></p>\n",
+					"<p><a href=\"2024-01-03-three.gmi\">2024-01-03 - Third Post</a></p>\n" +
+						"<p>A line which fits.</p>\n" +
+						"<p>A line which does not fit.</p>\n",
+				},
+			},
+			{
+				"truncated", FeedEntryContentGemtext, 70, []string{
+					"<pre>=&gt; 2024-01-01-one.gmi 2024-01-01 - First Post\n" +
+						"* A list item\n\n</pre>",
+					"<pre>=&gt; 2024-01-02-two.gmi 2024-01-02 - Second Post\n</pre>",
+					"<pre>=&gt; 2024-01-03-three.gmi 2024-01-03 - Third Post\n" +
+						"A line which fits.\n</pre>",
+				},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				translator := FeedTranslator{
+					BaseURL:             baseURL,
+					EntryContent:        test.entryContent,
+					MaxEntryContentSize: test.maxSize,
+				}
+
+				feed, err := translator.toFeed(strings.NewReader(doc))
+				require.NoError(t, err)
+				require.Len(t, feed.Items, len(test.exp))
+
+				for i := range test.exp {
+					assert.Equal(t, test.exp[i], feed.Items[i].Content)
+				}
+			})
+		}
+	})
+
+	t.Run("resolve entry", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> post.gmi 2024-01-05 - Local Post
+=> missing.gmi 2024-01-04 - Missing Post
+=> https://example.org/remote.gmi 2024-01-03 - Remote Post
+=> notes.txt 2024-01-02 - Not Gemtext
+=> ../../secret.gmi 2024-01-01 - Escaping Post
+`
+
+		fsys := fstest.MapFS{
+			"gemlog/post.gmi": {Data: []byte("# Local Post\nHello!\n")},
+			"secret.gmi":      {Data: []byte("Secret\n")},
+		}
+
+		newTranslator := func(resolved *[]string) FeedTranslator {
+			return FeedTranslator{
+				BaseURL: baseURL,
+				ResolveEntry: func(path string) (io.ReadCloser, error) {
+					*resolved = append(*resolved, path)
+					return fsys.Open(strings.TrimPrefix(path, "/"))
+				},
+			}
+		}
+
+		var resolved []string
+		feed, err := newTranslator(&resolved).toFeed(strings.NewReader(doc))
+		require.NoError(t, err)
+		require.Len(t, feed.Items, 5)
+
+		assert.Equal(t, "<h1>Local Post</h1>\n<p>Hello!</p>\n", feed.Items[0].Content)
+		assert.Empty(t, feed.Items[1].Content)
+		assert.Empty(t, feed.Items[2].Content)
+		assert.Empty(t, feed.Items[3].Content)
+
+		t.Log("Checking that remote and non-gemtext links are not resolved")
+		assert.Equal(t, []string{
+			"/gemlog/post.gmi", "/gemlog/missing.gmi", "/secret.gmi",
+		}, resolved)
+
+		t.Log("Checking that paths are kept within the root")
+		assert.Equal(t, "<p>Secret</p>\n", feed.Items[4].Content)
+
+		t.Log("Checking that only entries within max_items are resolved")
+		resolved = nil
+		translator := newTranslator(&resolved)
+		translator.MaxItems = 1
+		_, err = translator.toFeed(strings.NewReader(doc))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/gemlog/post.gmi"}, resolved)
+
+		t.Log("Checking that errors other than not existing are returned")
+		_, err = FeedTranslator{
+			BaseURL: baseURL,
+			ResolveEntry: func(string) (io.ReadCloser, error) {
+				return nil, errors.New("permission denied")
+			},
+		}.toFeed(strings.NewReader(doc))
+		assert.Error(t, err)
+	})
+
+	t.Run("no label", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> 2024-01-01-one.gmi
+=> 2024-01-02-two.gmi 2024-01-02 - Second Post
+=> 2024-01-03 2024-01-03
+`
+
+		feed, err := FeedTranslator{BaseURL: baseURL}.toFeed(
+			strings.NewReader(doc),
+		)
+		require.NoError(t, err)
+		require.Len(t, feed.Items, 2)
+		assert.Equal(t, "Second Post", feed.Items[0].Title)
+		assert.Equal(t, "https://example.com/gemlog/2024-01-03", feed.Items[1].Id)
+	})
+
+	t.Run("max items", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = `# My Gemlog
+
+=> 2024-01-03-three.gmi 2024-01-03 - Third Post
+=> 2024-01-02-two.gmi 2024-01-02 - Second Post
+> The second summary.
+=> 2024-01-01-one.gmi 2024-01-01 - First Post
+`
+
+		// If parsing continues past the third link line then the reader will
+		// return an error.
+		newSrc := func() io.Reader {
+			return io.MultiReader(
+				strings.NewReader(doc),
+				iotest.ErrReader(errors.New("read too far")),
+			)
+		}
+
+		t.Run("no early stop", func(t *testing.T) {
+			feed, err := FeedTranslator{
+				BaseURL:  baseURL,
+				Order:    FeedOrderDateAsc,
+				MaxItems: 2,
+			}.toFeed(strings.NewReader(doc))
+			require.NoError(t, err)
+			require.Len(t, feed.Items, 2)
+			assert.Equal(t, "Second Post", feed.Items[0].Title)
+			assert.Equal(t, "Third Post", feed.Items[1].Title)
+
+			_, err = FeedTranslator{
+				BaseURL:  baseURL,
+				MaxItems: 2,
+			}.toFeed(newSrc())
+			assert.Error(t, err)
+		})
+
+		t.Run("newest kept", func(t *testing.T) {
+			const doc = `# My Gemlog
+
+=> 2024-01-02-two.gmi 2024-01-02 - Second Post
+=> 2024-01-05-five.gmi 2024-01-05 - Fifth Post
+=> 2024-01-01-one.gmi 2024-01-01 - First Post
+=> 2024-01-04-four.gmi 2024-01-04 - Fourth Post
+=> 2024-01-03-three.gmi 2024-01-03 - Third Post
+`
+
+			for _, test := range []struct {
+				order string
+				exp   []string
+			}{
+				{FeedOrderAppearance, []string{"Fifth Post", "Fourth Post", "Third Post"}},
+				{FeedOrderDateAsc, []string{"Third Post", "Fourth Post", "Fifth Post"}},
+				{FeedOrderDateDesc, []string{"Fifth Post", "Fourth Post", "Third Post"}},
+				{FeedOrderTitle, []string{"Fifth Post", "Fourth Post", "Third Post"}},
+			} {
+				t.Logf("Checking that only the newest items are kept with order %q", test.order)
+				feed, err := FeedTranslator{
+					BaseURL:  baseURL,
+					Order:    test.order,
+					MaxItems: 3,
+				}.toFeed(strings.NewReader(doc))
+				require.NoError(t, err)
+
+				var titles []string
+				for _, item := range feed.Items {
+					titles = append(titles, item.Title)
+				}
+				assert.Equal(t, test.exp, titles)
+			}
+
+			t.Log("Checking that all items are kept when there is no limit")
+			feed, err := FeedTranslator{BaseURL: baseURL}.toFeed(strings.NewReader(doc))
+			require.NoError(t, err)
+			assert.Len(t, feed.Items, 5)
+		})
+
+		t.Run("early stop", func(t *testing.T) {
+			feed, err := FeedTranslator{
+				BaseURL:      baseURL,
+				ParseSummary: true,
+				MaxItems:     2,
+				EarlyStop:    true,
+			}.toFeed(newSrc())
+			require.NoError(t, err)
+			require.Len(t, feed.Items, 2)
+			assert.Equal(t, "Third Post", feed.Items[0].Title)
+			assert.Equal(t, "Second Post", feed.Items[1].Title)
+			assert.Equal(t, "The second summary.", feed.Items[1].Description)
+		})
+	})
+
+	t.Run("alternate links", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = "# My Gemlog\n=> 2024-01-01-one.gmi 2024-01-01 - First Post\n"
+
+		translator := FeedTranslator{
+			BaseURL: baseURL,
+			AlternateLinks: []feeds.Link{
+				{Href: "https://example.com/gemlog.html", Rel: "alternate", Type: "text/html"},
+				{Href: "gemini://example.com/gemlog/", Rel: "alternate", Type: "text/gemini"},
+			},
+		}
+
+		t.Log("Checking that alternate links are included in Atom feeds")
+		var out strings.Builder
+		require.NoError(t, translator.ToAtom(&out, strings.NewReader(doc)))
+		assert.Contains(t, out.String(), `<feed xmlns="http://www.w3.org/2005/Atom">`)
+		assert.Contains(t, out.String(), `<link href="https://example.com/gemlog/"></link>`)
+		assert.Contains(t, out.String(), `<link href="https://example.com/gemlog.html" rel="alternate" type="text/html"></link>`)
+		assert.Contains(t, out.String(), `<link href="gemini://example.com/gemlog/" rel="alternate" type="text/gemini"></link>`)
+		assert.Contains(t, out.String(), "<title>First Post</title>")
+
+		t.Log("Checking that other formats are unaffected")
+		out.Reset()
+		require.NoError(t, translator.ToRSS(&out, strings.NewReader(doc)))
+		assert.NotContains(t, out.String(), "gemlog.html")
+	})
+	t.Run("description and images", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = "# My Gemlog\n=> 2024-01-01-one.gmi 2024-01-01 - First Post\n"
+
+		translator := FeedTranslator{
+			BaseURL:     baseURL,
+			Description: "Thoughts & things",
+			Icon:        "/favicon.ico",
+			Logo:        "https://cdn.example.com/logo.png",
+		}
+
+		tests := []struct {
+			name          string
+			translate     func(FeedTranslator, io.Writer, io.Reader) error
+			exp, expUnset []string
+		}{
+			{
+				name:      "rss",
+				translate: FeedTranslator.ToRSS,
+				exp: []string{
+					"<description>Thoughts &amp; things</description>",
+					"<image>\n      <url>https://cdn.example.com/logo.png</url>\n      <title>My Gemlog</title>\n      <link>https://example.com/gemlog/</link>\n    </image>",
+				},
+				expUnset: []string{"<image>"},
+			},
+			{
+				name:      "atom",
+				translate: FeedTranslator.ToAtom,
+				exp: []string{
+					"<subtitle>Thoughts &amp; things</subtitle>",
+					"<icon>https://example.com/favicon.ico</icon>",
+					"<logo>https://cdn.example.com/logo.png</logo>",
+				},
+				expUnset: []string{"<subtitle>", "<icon>", "<logo>"},
+			},
+			{
+				name:      "json",
+				translate: FeedTranslator.ToJSON,
+				exp: []string{
+					`"description": "Thoughts \u0026 things"`,
+					`"favicon": "https://example.com/favicon.ico"`,
+					`"icon": "https://cdn.example.com/logo.png"`,
+				},
+				expUnset: []string{`"description"`, `"favicon"`, `"icon"`},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				var out strings.Builder
+				require.NoError(t, test.translate(
+					translator, &out, strings.NewReader(doc),
+				))
+				for _, exp := range test.exp {
+					assert.Contains(t, out.String(), exp)
+				}
+
+				t.Log("Checking that the fields are omitted when unset")
+				out.Reset()
+				require.NoError(t, test.translate(
+					FeedTranslator{BaseURL: baseURL}, &out, strings.NewReader(doc),
+				))
+				for _, notExp := range test.expUnset {
+					assert.NotContains(t, out.String(), notExp)
+				}
+			})
+		}
+
+		t.Log("Checking that the icon is used for RSS when there is no logo")
+		var out strings.Builder
+		require.NoError(t, FeedTranslator{
+			BaseURL: baseURL,
+			Icon:    "/favicon.ico",
+		}.ToRSS(&out, strings.NewReader(doc)))
+		assert.Contains(t, out.String(), "<url>https://example.com/favicon.ico</url>")
+	})
+
+	t.Run("self and hubs", func(t *testing.T) {
+		t.Parallel()
+
+		const doc = "# My Gemlog\n=> 2024-01-01-one.gmi 2024-01-01 - First Post\n"
+
+		selfURL, err := url.Parse("https://example.com/gemlog/feed")
+		require.NoError(t, err)
+
+		translator := FeedTranslator{
+			BaseURL: baseURL,
+			SelfURL: selfURL,
+			Hubs:    []string{"https://hub.example.com/", "https://hub.example.org/"},
+		}
+
+		tests := []struct {
+			name          string
+			translate     func(FeedTranslator, io.Writer, io.Reader) error
+			exp, expUnset []string
+		}{
+			{
+				name:      "rss",
+				translate: FeedTranslator.ToRSS,
+				exp: []string{
+					`xmlns:atom="http://www.w3.org/2005/Atom"`,
+					`<atom:link href="https://example.com/gemlog/feed" rel="self" type="application/rss+xml"></atom:link>` +
+						"\n    " +
+						`<atom:link href="https://hub.example.com/" rel="hub"></atom:link>` +
+						"\n    " +
+						`<atom:link href="https://hub.example.org/" rel="hub"></atom:link>` +
+						"\n    <item>",
+				},
+				expUnset: []string{"xmlns:atom", "<atom:link"},
+			},
+			{
+				name:      "atom",
+				translate: FeedTranslator.ToAtom,
+				exp: []string{
+					`<link href="https://example.com/gemlog/feed" rel="self" type="application/atom+xml"></link>`,
+					`<link href="https://hub.example.com/" rel="hub"></link>`,
+					`<link href="https://hub.example.org/" rel="hub"></link>`,
+				},
+				expUnset: []string{`rel="self"`, `rel="hub"`},
+			},
+			{
+				name:      "json",
+				translate: FeedTranslator.ToJSON,
+				exp: []string{
+					`"feed_url": "https://example.com/gemlog/feed"`,
+					`"hubs": [
+    {
+      "type": "WebSub",
+      "url": "https://hub.example.com/"
+    },
+    {
+      "type": "WebSub",
+      "url": "https://hub.example.org/"
+    }
+  ]`,
+				},
+				expUnset: []string{`"feed_url"`, `"hubs"`},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				var out strings.Builder
+				require.NoError(t, test.translate(
+					translator, &out, strings.NewReader(doc),
+				))
+				for _, exp := range test.exp {
+					assert.Contains(t, out.String(), exp)
+				}
+				assert.Contains(t, out.String(), "First Post")
+
+				t.Log("Checking that the links are omitted when unset")
+				out.Reset()
+				require.NoError(t, test.translate(
+					FeedTranslator{BaseURL: baseURL}, &out, strings.NewReader(doc),
+				))
+				for _, notExp := range test.expUnset {
+					assert.NotContains(t, out.String(), notExp)
+				}
+			})
+		}
+	})
+}
@@ -2,46 +2,370 @@
 package gemtext
 
 import (
-	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	"html"
 	"io"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
+// DefaultAllowedLinkSchemes are the URL schemes which links may have when
+// HTMLTranslator.AllowedLinkSchemes is not given. Relative URLs, which have no
+// scheme, are always allowed.
+var DefaultAllowedLinkSchemes = []string{"http", "https", "gemini", "mailto"}
+
+// Values which HTMLTranslator.EmptyLinkLabel may take.
+const (
+	EmptyLinkLabelURL  = "url"
+	EmptyLinkLabelHost = "host"
+)
+
 // HTMLTranslator is used to translate a gemtext file into equivalent HTML DOM
 // elements.
 type HTMLTranslator struct {
 	// RenderHeading, if given can be used to override how headings are
 	// rendered. The level indicates which heading level is being rendered: 1,
-	// 2, or 3.
-	RenderHeading func(w io.Writer, level int, text string) error
+	// 2, or 3. The id is the heading's Slug, which can be used as its `id`
+	// attribute, and text is HTML escaped.
+	RenderHeading func(w io.Writer, level int, id, text string) error
 
 	// RenderLink, if given, can be used to override how links are rendered.
+	// The url will have had any invalid characters percent-encoded, but will
+	// not be HTML escaped.
 	RenderLink func(w io.Writer, url, label string) error
+
+	// RenderPreformatted, if given, can be used to override how preformatted
+	// blocks are rendered. The altText is the text following the opening
+	// "```" of the block, if any, and content is the full contents of the
+	// block, with each line terminated by a newline. Neither will be HTML
+	// escaped.
+	RenderPreformatted func(w io.Writer, altText, content string) error
+
+	// AllowedLinkSchemes are the URL schemes which links may have. Links whose
+	// URL has a scheme not in this list (e.g. `javascript:`) will be rendered
+	// as plain text, with only their label included. Relative URLs, which have
+	// no scheme, are always allowed.
+	//
+	// Defaults to DefaultAllowedLinkSchemes.
+	AllowedLinkSchemes []string
+
+	// EmptyLinkLabel determines the label used for links which don't have
+	// one. Can be EmptyLinkLabelURL, to use the full URL, or
+	// EmptyLinkLabelHost, to use only the host of the URL (or the full URL if
+	// it has no host, e.g. it's relative).
+	//
+	// Defaults to EmptyLinkLabelURL.
+	EmptyLinkLabel string
+
+	// HeadingIDs, if true, causes headings to be rendered with an `id`
+	// attribute, so that they can be linked to. The IDs will match the Slugs
+	// returned by Headings for the same document. This has no effect if
+	// RenderHeading is given.
+	HeadingIDs bool
+
+	// Tables enables a non-standard extension to gemtext, wherein consecutive
+	// lines starting with a pipe, e.g. `| a | b |`, are rendered as an HTML
+	// table. If the first row is followed by a separator row, e.g.
+	// `|---|---|`, then it is rendered as the table's header.
+	Tables bool
+
+	// OrderLists enables non-standard extensions to gemtext lists. List items
+	// may be indented using leading whitespace, e.g. `  * b`, in which case
+	// they are rendered as a list nested within the preceding item, with each
+	// tab counting as four spaces. Lines starting with a number followed by a
+	// period and a space, e.g. `1. a`, are rendered as items of an ordered
+	// list, which may also be indented.
+	//
+	// Lists without any indented or numbered items are rendered identically
+	// whether or not this is set.
+	OrderLists bool
+
+	// Minify, if true, causes the newlines which would normally be written
+	// between elements to be omitted, producing more compact output. Newlines
+	// within preformatted blocks are always retained.
+	Minify bool
+
+	// PreserveIndent, if true, causes the leading whitespace of text lines to
+	// be retained as non-breaking spaces, rather than being trimmed, with each
+	// tab counting as four spaces.
+	PreserveIndent bool
+
+	// TitleLevel is the level of the heading, 1, 2, or 3, which is used as the
+	// Title of the translated document. The first heading of this level in
+	// the document is used.
+	//
+	// Defaults to 1.
+	TitleLevel int
+
+	// QuoteAttribution, if true, enables detection of attributions on quotes.
+	// If the last of a group of consecutive quote lines starts with `--` or
+	// `—` then the group is rendered as a single `<blockquote>` within a
+	// `<figure>`, with the attribution as a `<cite>` within its
+	// `<figcaption>`. Quotes without an attribution are rendered as normal.
+	QuoteAttribution bool
+
+	// CheckFragmentLinks, if true, causes links to fragments within the same
+	// document, e.g. `=> #section`, to be checked against the Slugs of the
+	// document's headings. A fragment which doesn't match a Slug directly,
+	// but does once slugified (e.g. `=> #Some%20Section`), is rewritten to link
+	// to that Slug. Fragments which don't match any heading are left as-is,
+	// rendered with a `dangling-fragment` class, and listed in the
+	// DanglingFragments of the result.
+	//
+	// This requires the document to be read fully into memory prior to being
+	// translated.
+	CheckFragmentLinks bool
+
+	// Frontmatter, if true, causes a YAML frontmatter block at the start of
+	// the document to be parsed into the Meta of the result, rather than being
+	// translated as part of the body. The block must be opened by a `---` line
+	// at the very start of the document, and closed by a later `---` line;
+	// documents which don't start with such a block are unaffected.
+	//
+	// If the frontmatter has a `title` field then it is used as the Title of
+	// the result, in place of any heading.
+	Frontmatter bool
+
+	// DescriptionLength, if greater than zero, is the maximum number of
+	// characters in the Description of the translated document. Longer
+	// descriptions are truncated, at a word boundary where possible, and end
+	// with an ellipsis.
+	DescriptionLength int
+}
+
+func (t HTMLTranslator) isAllowedLinkURL(urlStr string) bool {
+	return isAllowedLinkURL(urlStr, t.AllowedLinkSchemes)
+}
+
+// isAllowedLinkURL returns true if the URL is relative or has one of the
+// allowed schemes, which default to DefaultAllowedLinkSchemes if nil.
+func isAllowedLinkURL(urlStr string, allowed []string) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	} else if u.Scheme == "" {
+		return true
+	}
+
+	if allowed == nil {
+		allowed = DefaultAllowedLinkSchemes
+	}
+
+	for _, scheme := range allowed {
+		if strings.EqualFold(scheme, u.Scheme) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // HTML contains the result of a translation from gemtext. The Body will be the
 // translated body itself, and Title will correspond to the first primary header
-// of the gemtext file, if there was one.
+// of the gemtext file (see HTMLTranslator.TitleLevel), if there was one.
+//
+// Empty will be true if the gemtext file contained nothing but whitespace and
+// empty preformatted blocks.
+//
+// Description will be the first text line following the Title, or the first
+// text line of the document if it has no Title, HTML escaped. This is suitable
+// for use as a page's meta description (see HTMLTranslator.DescriptionLength).
+//
+// Headings lists all headings of the document, in order. Their Slugs match the
+// `id` attributes of the rendered headings, if HTMLTranslator.HeadingIDs was
+// set, and so can be used to render a table of contents.
+//
+// DanglingFragments lists the fragments of same-document links which didn't
+// match any heading, if HTMLTranslator.CheckFragmentLinks was set.
+//
+// Meta contains the fields of the document's frontmatter, if
+// HTMLTranslator.Frontmatter was set and the document had any. Its values are
+// not HTML escaped.
 type HTML struct {
-	Title string
-	Body  string
+	Title             string
+	Description       string
+	Body              string
+	Empty             bool
+	Headings          []Heading
+	DanglingFragments []string
+	Meta              map[string]any
+}
+
+// Heading describes a single heading within a gemtext document.
+type Heading struct {
+	// Level is the level of the heading: 1, 2, or 3.
+	Level int
+
+	// Text is the text of the heading, HTML escaped.
+	Text string
+
+	// Slug is a unique identifier for the heading within the document,
+	// suitable for use as an anchor.
+	Slug string
+}
+
+// Headings reads a gemtext file from the Reader and returns all headings found
+// within it, in order. This can be used to generate a table of contents.
+func Headings(src io.Reader) ([]Heading, error) {
+	var (
+		sc       = newLineScanner(src)
+		slugger  headingSlugger
+		headings []Heading
+	)
+
+	for sc.Scan() {
+		l := sc.Line()
+		if l.kind != lineKindHeading {
+			continue
+		}
+
+		headings = append(headings, Heading{
+			Level: l.level,
+			Text:  html.EscapeString(l.text),
+			Slug:  slugger.slug(l.text),
+		})
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return headings, nil
+}
+
+// openList describes a list which is open during translation.
+type openList struct {
+	tag    string // "ul" or "ol"
+	indent int
+}
+
+// listItem describes a single item of a list.
+type listItem struct {
+	tag    string // "ul" or "ol"
+	indent int
+	start  int // number of an ordered item
+	text   string
+}
+
+// listItem returns the line as a listItem, if it is one. Indented and
+// numbered items are only recognized if OrderLists is set.
+func (t HTMLTranslator) listItem(l line) (listItem, bool) {
+	if l.kind == lineKindListItem {
+		return listItem{tag: "ul", text: l.text}, true
+	} else if !t.OrderLists || l.kind != lineKindText {
+		return listItem{}, false
+	}
+
+	var (
+		trimmed = strings.TrimLeft(l.raw, " \t")
+		indent  = indentWidth(l.raw)
+	)
+
+	if rest, ok := strings.CutPrefix(trimmed, "*"); ok {
+		return listItem{
+			tag: "ul", indent: indent, text: strings.TrimSpace(rest),
+		}, true
+	}
+
+	digits := len(trimmed) - len(strings.TrimLeft(trimmed, "0123456789"))
+	if digits == 0 || !strings.HasPrefix(trimmed[digits:], ". ") {
+		return listItem{}, false
+	}
+
+	start, err := strconv.Atoi(trimmed[:digits])
+	if err != nil {
+		return listItem{}, false
+	}
+
+	return listItem{
+		tag:    "ol",
+		indent: indent,
+		start:  start,
+		text:   strings.TrimSpace(trimmed[digits+2:]),
+	}, true
 }
 
 // Translate will read a gemtext file from the Reader and return it as an HTML
 // document.
 func (t HTMLTranslator) Translate(src io.Reader) (HTML, error) {
+	body := new(bytes.Buffer)
+	translated, err := t.TranslateTo(body, src)
+	if err != nil {
+		return HTML{}, err
+	}
+
+	translated.Body = body.String()
+	return translated, nil
+}
+
+// TranslateTo is like Translate, but the body of the HTML document is written
+// to the given Writer as it is translated, rather than being buffered into
+// the Body field, which is left empty.
+//
+// Writes to dst are not buffered, and so dst should generally be some kind of
+// buffer itself. If an error is returned then some of the body may have
+// already been written to dst.
+func (t HTMLTranslator) TranslateTo(dst io.Writer, src io.Reader) (HTML, error) {
+	var meta map[string]any
+	if t.Frontmatter {
+		var err error
+		if meta, src, err = readFrontmatter(src); err != nil {
+			return HTML{}, err
+		}
+	}
+
+	// slugs is only populated if CheckFragmentLinks is set, in which case the
+	// headings must be known before any links are rendered.
+	var slugs map[string]bool
+	if t.CheckFragmentLinks {
+		b, err := io.ReadAll(src)
+		if err != nil {
+			return HTML{}, fmt.Errorf("reading document: %w", err)
+		}
+
+		headings, err := Headings(bytes.NewReader(b))
+		if err != nil {
+			return HTML{}, fmt.Errorf("reading headings: %w", err)
+		}
+
+		slugs = make(map[string]bool, len(headings))
+		for _, heading := range headings {
+			slugs[heading.Slug] = true
+		}
+
+		src = bytes.NewReader(b)
+	}
+
 	var (
-		r         = bufio.NewReader(src)
-		w         = new(bytes.Buffer)
+		sc        = newLineScanner(src)
 		title     string
-		pft, list bool
+		desc      string
+		firstText string
+		pft       bool
+		preAlt    string
+		pre       strings.Builder
+		lists     []openList
+		empty     = true
+		slugger   headingSlugger
+		headings  []Heading
+		table     [][]string
+		quote     []string
+		dangling  []string
 		writeErr  error
+		nl        = "\n"
 	)
 
+	titleLevel := t.TitleLevel
+	if titleLevel == 0 {
+		titleLevel = 1
+	}
+
+	if t.Minify {
+		nl = ""
+	}
+
 	sanitizeText := func(str string) string {
 		return html.EscapeString(strings.TrimSpace(str))
 	}
@@ -50,115 +374,318 @@ func (t HTMLTranslator) Translate(src io.Reader) (HTML, error) {
 		if writeErr != nil {
 			return
 		}
-		_, writeErr = fmt.Fprint(w, str)
+		_, writeErr = fmt.Fprint(dst, str)
 	}
 
 	writef := func(fmtStr string, args ...any) {
 		if writeErr != nil {
 			return
 		}
-		_, writeErr = fmt.Fprintf(w, fmtStr, args...)
+		_, writeErr = fmt.Fprintf(dst, fmtStr, args...)
+	}
+
+	writeTableRow := func(cellTag string, cells []string) {
+		write("<tr>")
+		for _, cell := range cells {
+			writef("<%s>%s</%s>", cellTag, sanitizeText(cell), cellTag)
+		}
+		write("</tr>" + nl)
+	}
+
+	endTable := func() {
+		if len(table) == 0 {
+			return
+		}
+
+		write("<table>" + nl)
+
+		rows := table
+		if len(rows) > 1 && isTableSeparatorRow(rows[1]) {
+			write("<thead>" + nl)
+			writeTableRow("th", rows[0])
+			write("</thead>" + nl)
+			rows = rows[2:]
+		}
+
+		if len(rows) > 0 {
+			write("<tbody>" + nl)
+			for _, row := range rows {
+				writeTableRow("td", row)
+			}
+			write("</tbody>" + nl)
+		}
+
+		write("</table>" + nl)
+		table = nil
+	}
+
+	// closeList closes the innermost open list, along with its open item.
+	closeList := func() {
+		top := lists[len(lists)-1]
+		write("</li>" + nl)
+		writef("</%s>"+nl, top.tag)
+		lists = lists[:len(lists)-1]
+	}
+
+	endLists := func() {
+		for len(lists) > 0 {
+			closeList()
+		}
+	}
+
+	// writeListItem writes an item, opening and closing lists as necessary
+	// such that it is nested according to its indent. The item is left open,
+	// so that a nested list may be written within it.
+	writeListItem := func(item listItem) {
+		for len(lists) > 0 {
+			top := lists[len(lists)-1]
+			if item.indent > top.indent ||
+				(item.indent == top.indent && item.tag == top.tag) {
+				break
+			}
+			closeList()
+		}
+
+		if len(lists) == 0 || item.indent > lists[len(lists)-1].indent {
+			if len(lists) > 0 {
+				write(nl)
+			}
+			if item.tag == "ol" && item.start != 1 {
+				writef("<ol start=\"%d\">"+nl, item.start)
+			} else {
+				writef("<%s>"+nl, item.tag)
+			}
+			lists = append(lists, openList{tag: item.tag, indent: item.indent})
+		} else {
+			write("</li>" + nl)
+		}
+
+		writef("<li>%s", html.EscapeString(item.text))
+	}
+
+	// endPre writes the preformatted block which has been collected, if any.
+	endPre := func() {
+		if !pft {
+			return
+		}
+
+		pft = false
+		content := pre.String()
+		pre.Reset()
+
+		if writeErr != nil {
+			return
+		} else if t.RenderPreformatted != nil {
+			writeErr = t.RenderPreformatted(dst, preAlt, content)
+			return
+		}
+
+		write("<pre>\n" + html.EscapeString(content) + "</pre>" + nl)
 	}
 
-loop:
-	for {
+	endQuote := func() {
+		if len(quote) == 0 {
+			return
+		}
+
+		lines, cite, ok := quote, "", false
+		if len(lines) > 1 {
+			cite, ok = quoteAttribution(lines[len(lines)-1])
+		}
+
+		if !ok {
+			for _, line := range lines {
+				writef("<blockquote>%s</blockquote>"+nl, html.EscapeString(line))
+			}
+			quote = nil
+			return
+		}
+
+		write("<figure>" + nl)
+		write("<blockquote>" + nl)
+		for _, line := range lines[:len(lines)-1] {
+			writef("<p>%s</p>"+nl, html.EscapeString(line))
+		}
+		write("</blockquote>" + nl)
+		writef("<figcaption><cite>%s</cite></figcaption>"+nl, html.EscapeString(cite))
+		write("</figure>" + nl)
+		quote = nil
+	}
+
+	for sc.Scan() {
 		if writeErr != nil {
 			return HTML{}, fmt.Errorf("writing line: %w", writeErr)
 		}
 
-		line, err := r.ReadString('\n')
+		l := sc.Line()
 
-		switch {
-		case errors.Is(err, io.EOF):
-			break loop
+		isTableRow := t.Tables &&
+			l.kind == lineKindText &&
+			strings.HasPrefix(l.raw, "|")
 
-		case err != nil:
-			return HTML{}, fmt.Errorf("reading next line: %w", err)
+		if !isTableRow {
+			endTable()
+		}
+
+		isQuote := t.QuoteAttribution && l.kind == lineKindQuote
 
-		case strings.HasPrefix(line, "```"):
+		if !isQuote {
+			endQuote()
+		}
+
+		switch l.kind {
+		case lineKindPreToggle:
 			if !pft {
-				write("<pre>\n")
-				pft = true
+				// The alt text is only passed to RenderPreformatted, it's
+				// never written as part of the body.
+				endLists()
+				pft, preAlt = true, l.text
 			} else {
-				write("</pre>\n")
-				pft = false
+				endPre()
 			}
 			continue
 
-		case pft:
-			write(html.EscapeString(line))
+		case lineKindPre:
+			if strings.TrimSpace(l.raw) != "" {
+				empty = false
+			}
+			pre.WriteString(l.raw)
+			pre.WriteByte('\n')
 			continue
+		}
+
+		if len(strings.TrimSpace(l.raw)) == 0 {
+			continue
+		}
+
+		empty = false
 
-		case len(strings.TrimSpace(line)) == 0:
+		if isTableRow {
+			endLists()
+			table = append(table, parseTableRow(l.raw))
+			continue
+		}
+
+		if isQuote {
+			endLists()
+			quote = append(quote, l.text)
 			continue
 		}
 
 		// list case is special, because it requires a prefix and suffix tag
-		if strings.HasPrefix(line, "*") {
-			if !list {
-				write("<ul>\n")
-			}
-			writef("<li>%s</li>\n", sanitizeText(line[1:]))
-			list = true
+		if item, ok := t.listItem(l); ok {
+			writeListItem(item)
 			continue
-		} else if list {
-			write("</ul>\n")
-			list = false
 		}
+		endLists()
+
+		switch l.kind {
+		case lineKindLink:
+			labelStr := l.text
+			if !l.hasLabel && t.EmptyLinkLabel == EmptyLinkLabelHost {
+				labelStr = linkHost(l.url)
+			}
 
-		switch {
-		case strings.HasPrefix(line, "=>"):
 			var (
-				parsedLink = parseLinkLine(line)
-				urlStr     = parsedLink.url
-				label      = sanitizeText(parsedLink.label)
+				urlStr = percentEncodeURL(l.url)
+				label  = html.EscapeString(labelStr)
 			)
 
-			if t.RenderLink == nil {
-				writef("<p><a href=\"%s\">%s</a></p>\n", urlStr, label)
-			} else {
-				writeErr = t.RenderLink(w, urlStr, label)
-			}
-
-		case strings.HasPrefix(line, "###"):
-			text := sanitizeText(line[3:])
-			if t.RenderHeading == nil {
-				writef("<h3>%s</h3>\n", text)
-			} else {
-				writeErr = t.RenderHeading(w, 3, text)
+			isDangling := false
+			if fragment, ok := strings.CutPrefix(l.url, "#"); ok && slugs != nil {
+				if slug, ok := resolveFragment(slugs, fragment); ok {
+					urlStr = "#" + slug
+				} else {
+					dangling = append(dangling, fragment)
+					isDangling = true
+				}
 			}
 
-		case strings.HasPrefix(line, "##"):
-			text := sanitizeText(line[2:])
-			if t.RenderHeading == nil {
-				writef("<h2>%s</h2>\n", text)
+			if !t.isAllowedLinkURL(urlStr) {
+				writef("<p>%s</p>"+nl, label)
+			} else if t.RenderLink == nil && isDangling {
+				writef(
+					"<p><a class=\"dangling-fragment\" href=\"%s\">%s</a></p>"+nl,
+					html.EscapeString(urlStr), label,
+				)
+			} else if t.RenderLink == nil {
+				writef(
+					"<p><a href=\"%s\">%s</a></p>"+nl,
+					html.EscapeString(urlStr), label,
+				)
 			} else {
-				writeErr = t.RenderHeading(w, 2, text)
+				writeErr = t.RenderLink(dst, urlStr, label)
 			}
 
-		case strings.HasPrefix(line, "#"):
-			text := sanitizeText(line[1:])
-			if title == "" {
+		case lineKindHeading:
+			text := html.EscapeString(l.text)
+			if l.level == titleLevel && title == "" {
 				title = text
 			}
 
-			if t.RenderHeading == nil {
-				writef("<h1>%s</h1>\n", text)
-			} else {
-				writeErr = t.RenderHeading(w, 1, text)
+			slug := slugger.slug(l.text)
+			headings = append(headings, Heading{
+				Level: l.level, Text: text, Slug: slug,
+			})
+
+			if t.RenderHeading == nil && t.HeadingIDs {
+				writef(
+					"<h%d id=\"%s\">%s</h%d>"+nl, l.level, slug, text, l.level,
+				)
+			} else if t.RenderHeading == nil {
+				writef("<h%d>%s</h%d>"+nl, l.level, text, l.level)
+			} else if writeErr == nil {
+				writeErr = t.RenderHeading(dst, l.level, slug, text)
 			}
 
-		case strings.HasPrefix(line, ">"):
-			writef("<blockquote>%s</blockquote>\n", sanitizeText(line[1:]))
+		case lineKindQuote:
+			writef("<blockquote>%s</blockquote>"+nl, html.EscapeString(l.text))
 
 		default:
-			line = strings.TrimSpace(line)
-			writef("<p>%s</p>\n", line)
+			text := strings.TrimSpace(l.raw)
+			if firstText == "" {
+				firstText = text
+			}
+			if title != "" && desc == "" {
+				desc = text
+			}
+
+			var indent string
+			if t.PreserveIndent {
+				indent = indentHTML(l.raw)
+			}
+			writef("<p>%s%s</p>"+nl, indent, strings.TrimSpace(l.raw))
 		}
 	}
 
+	if err := sc.Err(); err != nil {
+		return HTML{}, err
+	}
+
+	// Close any tags which were left open by the document ending.
+	endTable()
+	endQuote()
+	endLists()
+	endPre()
+
+	if writeErr != nil {
+		return HTML{}, fmt.Errorf("writing line: %w", writeErr)
+	}
+
+	if title == "" {
+		desc = firstText
+	}
+
+	if metaTitle, ok := meta["title"]; ok && metaTitle != nil {
+		title = html.EscapeString(fmt.Sprint(metaTitle))
+	}
+
 	return HTML{
-		Title: title,
-		Body:  w.String(),
+		Title:             title,
+		Description:       html.EscapeString(truncateText(desc, t.DescriptionLength)),
+		Empty:             empty,
+		Headings:          headings,
+		DanglingFragments: dangling,
+		Meta:              meta,
 	}, nil
 }
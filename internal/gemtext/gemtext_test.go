@@ -0,0 +1,1009 @@
+package gemtext
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLTranslator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		exp  HTML
+	}{
+		{
+			name: "heading in preformatted block",
+			in:   "```\n# Not a title\n```\n# Title\n",
+			exp: HTML{
+				Title: "Title",
+				Body:  "<pre>\n# Not a title\n</pre>\n<h1>Title</h1>\n",
+				Headings: []Heading{
+					{Level: 1, Text: "Title", Slug: "title"},
+				},
+			},
+		},
+		{
+			name: "heading in preformatted block with alt text",
+			in:   "``` shell\n# a comment\n```\n",
+			exp: HTML{
+				Body: "<pre>\n# a comment\n</pre>\n",
+			},
+		},
+		{
+			name: "unterminated preformatted block",
+			in:   "```\n# Not a title\n## Not a heading",
+			exp: HTML{
+				Body: "<pre>\n# Not a title\n## Not a heading\n</pre>\n",
+			},
+		},
+		{
+			name: "heading after unterminated preformatted block",
+			in:   "## Sub\n```\n# Not a title\n",
+			exp: HTML{
+				Body: "<h2>Sub</h2>\n<pre>\n# Not a title\n</pre>\n",
+				Headings: []Heading{
+					{Level: 2, Text: "Sub", Slug: "sub"},
+				},
+			},
+		},
+		{
+			name: "title without trailing newline",
+			in:   "# Title",
+			exp: HTML{
+				Title: "Title",
+				Body:  "<h1>Title</h1>\n",
+				Headings: []Heading{
+					{Level: 1, Text: "Title", Slug: "title"},
+				},
+			},
+		},
+		{
+			name: "list before preformatted block",
+			in:   "* one\n```\n* two\n```\n",
+			exp: HTML{
+				Body: "<ul>\n<li>one</li>\n</ul>\n<pre>\n* two\n</pre>\n",
+			},
+		},
+		{
+			name: "unterminated list",
+			in:   "* one\n* two",
+			exp: HTML{
+				Body: "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n",
+			},
+		},
+		{
+			name: "empty",
+			in:   "",
+			exp:  HTML{Empty: true},
+		},
+		{
+			name: "only whitespace",
+			in:   "\n  \n\t\n\n",
+			exp:  HTML{Empty: true},
+		},
+		{
+			name: "only empty preformatted block",
+			in:   "\n```\n\n```\n",
+			exp: HTML{
+				Body:  "<pre>\n\n</pre>\n",
+				Empty: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{}.Translate(strings.NewReader(test.in))
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got)
+		})
+	}
+}
+
+func TestHTMLTranslatorAllowedLinkSchemes(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		allowed []string
+		in      string
+		exp     string
+	}{
+		{
+			name: "relative",
+			in:   "=> /foo.gmi Foo",
+			exp:  "<p><a href=\"/foo.gmi\">Foo</a></p>\n",
+		},
+		{
+			name: "https",
+			in:   "=> https://example.com Example",
+			exp:  "<p><a href=\"https://example.com\">Example</a></p>\n",
+		},
+		{
+			name: "javascript",
+			in:   "=> javascript:alert(1) Click me",
+			exp:  "<p>Click me</p>\n",
+		},
+		{
+			name: "javascript mixed case",
+			in:   "=> JaVaScRiPt:alert(1) Click me",
+			exp:  "<p>Click me</p>\n",
+		},
+		{
+			name: "javascript no label",
+			in:   "=> javascript:alert(1)",
+			exp:  "<p>javascript:alert(1)</p>\n",
+		},
+		{
+			name: "data",
+			in:   "=> data:text/html;base64,PHNjcmlwdD4= Data",
+			exp:  "<p>Data</p>\n",
+		},
+		{
+			name:    "custom allowed",
+			allowed: []string{"gopher"},
+			in:      "=> gopher://example.com Gopher",
+			exp:     "<p><a href=\"gopher://example.com\">Gopher</a></p>\n",
+		},
+		{
+			name:    "custom disallowed",
+			allowed: []string{"gopher"},
+			in:      "=> https://example.com Example",
+			exp:     "<p>Example</p>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			translator := HTMLTranslator{AllowedLinkSchemes: test.allowed}
+			got, err := translator.Translate(strings.NewReader(test.in))
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+}
+
+func TestHTMLTranslatorLinkEscaping(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{
+			name: "valid",
+			in:   "=> https://example.com/a?b=c&d=e#f Example",
+			exp:  "<p><a href=\"https://example.com/a?b=c&amp;d=e#f\">Example</a></p>\n",
+		},
+		{
+			name: "already encoded",
+			in:   "=> /a%20b.gmi A B",
+			exp:  "<p><a href=\"/a%20b.gmi\">A B</a></p>\n",
+		},
+		{
+			name: "quote",
+			in:   "=> /foo\"onmouseover=\"alert(1) Foo",
+			exp:  "<p><a href=\"/foo%22onmouseover=%22alert(1)\">Foo</a></p>\n",
+		},
+		{
+			name: "angle brackets",
+			in:   "=> /<script> Foo",
+			exp:  "<p><a href=\"/%3Cscript%3E\">Foo</a></p>\n",
+		},
+		{
+			name: "control character",
+			in:   "=> /a\x01b Foo",
+			exp:  "<p><a href=\"/a%01b\">Foo</a></p>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{}.Translate(strings.NewReader(test.in))
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+
+	t.Run("space", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, "/a%20b%09c", percentEncodeURL("/a b\tc"))
+	})
+}
+
+func TestHTMLTranslatorTables(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		tables bool
+		in     string
+		exp    string
+	}{
+		{
+			name: "disabled",
+			in:   "| a | b |\n",
+			exp:  "<p>| a | b |</p>\n",
+		},
+		{
+			name:   "with header",
+			tables: true,
+			in:     "| a | b |\n|---|:-:|\n| 1 | 2 |\n| 3 | 4 |\n",
+			exp: "<table>\n" +
+				"<thead>\n<tr><th>a</th><th>b</th></tr>\n</thead>\n" +
+				"<tbody>\n" +
+				"<tr><td>1</td><td>2</td></tr>\n" +
+				"<tr><td>3</td><td>4</td></tr>\n" +
+				"</tbody>\n</table>\n",
+		},
+		{
+			name:   "without header",
+			tables: true,
+			in:     "| 1 | 2 |\n| 3 | 4 |",
+			exp: "<table>\n<tbody>\n" +
+				"<tr><td>1</td><td>2</td></tr>\n" +
+				"<tr><td>3</td><td>4</td></tr>\n" +
+				"</tbody>\n</table>\n",
+		},
+		{
+			name:   "header only",
+			tables: true,
+			in:     "| a | b |\n| --- | --- |\n",
+			exp: "<table>\n" +
+				"<thead>\n<tr><th>a</th><th>b</th></tr>\n</thead>\n" +
+				"</table>\n",
+		},
+		{
+			name:   "escaping",
+			tables: true,
+			in:     "| <b> | a&b |\n",
+			exp: "<table>\n<tbody>\n" +
+				"<tr><td>&lt;b&gt;</td><td>a&amp;b</td></tr>\n" +
+				"</tbody>\n</table>\n",
+		},
+		{
+			name:   "separated by blank line",
+			tables: true,
+			in:     "| 1 |\n\n| 2 |\n",
+			exp: "<table>\n<tbody>\n<tr><td>1</td></tr>\n</tbody>\n</table>\n" +
+				"<table>\n<tbody>\n<tr><td>2</td></tr>\n</tbody>\n</table>\n",
+		},
+		{
+			name:   "between list and paragraph",
+			tables: true,
+			in:     "* item\n| 1 |\ntext\n",
+			exp: "<ul>\n<li>item</li>\n</ul>\n" +
+				"<table>\n<tbody>\n<tr><td>1</td></tr>\n</tbody>\n</table>\n" +
+				"<p>text</p>\n",
+		},
+		{
+			name:   "in preformatted block",
+			tables: true,
+			in:     "```\n| 1 |\n```\n",
+			exp:    "<pre>\n| 1 |\n</pre>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{Tables: test.tables}.Translate(
+				strings.NewReader(test.in),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+}
+
+func TestHTMLTranslatorOrderLists(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		orderLists bool
+		in         string
+		exp        string
+	}{
+		{
+			name: "disabled",
+			in:   "* a\n  * b\n1. c\n",
+			exp: "<ul>\n<li>a</li>\n</ul>\n" +
+				"<p>* b</p>\n<p>1. c</p>\n",
+		},
+		{
+			name:       "flat",
+			orderLists: true,
+			in:         "* a\n* b\n",
+			exp:        "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n",
+		},
+		{
+			name:       "nested",
+			orderLists: true,
+			in:         "* a\n  * b\n  * c\n* d\n",
+			exp: "<ul>\n<li>a\n" +
+				"<ul>\n<li>b</li>\n<li>c</li>\n</ul>\n" +
+				"</li>\n<li>d</li>\n</ul>\n",
+		},
+		{
+			name:       "nested with tab",
+			orderLists: true,
+			in:         "* a\n\t* b\n",
+			exp: "<ul>\n<li>a\n" +
+				"<ul>\n<li>b</li>\n</ul>\n" +
+				"</li>\n</ul>\n",
+		},
+		{
+			name:       "ordered",
+			orderLists: true,
+			in:         "1. a\n2. <b>\n",
+			exp:        "<ol>\n<li>a</li>\n<li>&lt;b&gt;</li>\n</ol>\n",
+		},
+		{
+			name:       "ordered with start",
+			orderLists: true,
+			in:         "3. a\n4. b\n",
+			exp:        "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n",
+		},
+		{
+			name:       "not ordered",
+			orderLists: true,
+			in:         "1.5 is a number\n1.no space\n",
+			exp:        "<p>1.5 is a number</p>\n<p>1.no space</p>\n",
+		},
+		{
+			name:       "mixed",
+			orderLists: true,
+			in: "# Title\n" +
+				"1. one\n" +
+				"    * one a\n" +
+				"    * one b\n" +
+				"        1. deep\n" +
+				"2. two\n" +
+				"* other\n" +
+				"text\n" +
+				"  * indented first\n",
+			exp: "<h1>Title</h1>\n" +
+				"<ol>\n<li>one\n" +
+				"<ul>\n<li>one a</li>\n<li>one b\n" +
+				"<ol>\n<li>deep</li>\n</ol>\n" +
+				"</li>\n</ul>\n" +
+				"</li>\n<li>two</li>\n</ol>\n" +
+				"<ul>\n<li>other</li>\n</ul>\n" +
+				"<p>text</p>\n" +
+				"<ul>\n<li>indented first</li>\n</ul>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{OrderLists: test.orderLists}.Translate(
+				strings.NewReader(test.in),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+}
+
+func TestHTMLTranslatorTranslateTo(t *testing.T) {
+	t.Parallel()
+
+	const in = "Intro text\n# Title\n* a\n=> /foo Foo\n```\npre\n```\n"
+
+	var (
+		translator = HTMLTranslator{DescriptionLength: 5}
+		body       = new(strings.Builder)
+	)
+
+	exp, err := translator.Translate(strings.NewReader(in))
+	require.NoError(t, err)
+
+	got, err := translator.TranslateTo(body, strings.NewReader(in))
+	require.NoError(t, err)
+
+	t.Log("Checking that the body is written to the Writer")
+	assert.Equal(t, exp.Body, body.String())
+	assert.Empty(t, got.Body)
+
+	t.Log("Checking that everything else matches Translate")
+	got.Body = exp.Body
+	assert.Equal(t, exp, got)
+}
+
+func TestHTMLTranslatorRenderPreformatted(t *testing.T) {
+	t.Parallel()
+
+	renderPreformatted := func(w io.Writer, altText, content string) error {
+		_, err := fmt.Fprintf(w, "<code alt=%q>%s</code>\n", altText, content)
+		return err
+	}
+
+	tests := []struct {
+		name       string
+		in         string
+		expDefault string
+		expHook    string
+	}{
+		{
+			name:       "without alt text",
+			in:         "```\n<b>\n  x\n```\ntext\n",
+			expDefault: "<pre>\n&lt;b&gt;\n  x\n</pre>\n<p>text</p>\n",
+			expHook:    "<code alt=\"\"><b>\n  x\n</code>\n<p>text</p>\n",
+		},
+		{
+			name:       "with alt text",
+			in:         "``` go\nfunc main() {}\n```\n",
+			expDefault: "<pre>\nfunc main() {}\n</pre>\n",
+			expHook:    "<code alt=\"go\">func main() {}\n</code>\n",
+		},
+		{
+			name:       "unterminated",
+			in:         "```sh\necho hi\n",
+			expDefault: "<pre>\necho hi\n</pre>\n",
+			expHook:    "<code alt=\"sh\">echo hi\n</code>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := HTMLTranslator{}.Translate(strings.NewReader(test.in))
+			require.NoError(t, err)
+			assert.Equal(t, test.expDefault, got.Body)
+
+			got, err = HTMLTranslator{
+				RenderPreformatted: renderPreformatted,
+			}.Translate(strings.NewReader(test.in))
+			require.NoError(t, err)
+			assert.Equal(t, test.expHook, got.Body)
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := HTMLTranslator{
+			RenderPreformatted: func(io.Writer, string, string) error {
+				return errors.New("oops")
+			},
+		}.Translate(strings.NewReader("```\nfoo\n```\n"))
+		assert.ErrorContains(t, err, "oops")
+	})
+}
+
+func TestHTMLTranslatorFrontmatter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		frontmatter bool
+		in          string
+		exp         HTML
+		expErr      string
+	}{
+		{
+			name:        "present",
+			frontmatter: true,
+			in: "---\ntitle: Fish & Chips\ntags: [food, uk]\n---\n" +
+				"# Heading\ntext\n",
+			exp: HTML{
+				Title:       "Fish &amp; Chips",
+				Description: "text",
+				Body:        "<h1>Heading</h1>\n<p>text</p>\n",
+				Headings: []Heading{
+					{Level: 1, Text: "Heading", Slug: "heading"},
+				},
+				Meta: map[string]any{
+					"title": "Fish & Chips",
+					"tags":  []any{"food", "uk"},
+				},
+			},
+		},
+		{
+			name:        "present without title",
+			frontmatter: true,
+			in:          "\uFEFF---\r\nauthor: Me\r\n---\r\n# Heading\r\n",
+			exp: HTML{
+				Title: "Heading",
+				Body:  "<h1>Heading</h1>\n",
+				Headings: []Heading{
+					{Level: 1, Text: "Heading", Slug: "heading"},
+				},
+				Meta: map[string]any{"author": "Me"},
+			},
+		},
+		{
+			name:        "empty",
+			frontmatter: true,
+			in:          "---\n---\ntext\n",
+			exp: HTML{
+				Description: "text",
+				Body:        "<p>text</p>\n",
+				Meta:        map[string]any{},
+			},
+		},
+		{
+			name:        "absent",
+			frontmatter: true,
+			in:          "text\n---\ntitle: Not frontmatter\n---\n",
+			exp: HTML{
+				Description: "text",
+				Body: "<p>text</p>\n<p>---</p>\n" +
+					"<p>title: Not frontmatter</p>\n<p>---</p>\n",
+			},
+		},
+		{
+			name:        "unclosed",
+			frontmatter: true,
+			in:          "---\ntext\n",
+			exp: HTML{
+				Description: "---",
+				Body:        "<p>---</p>\n<p>text</p>\n",
+			},
+		},
+		{
+			name:        "malformed",
+			frontmatter: true,
+			in:          "---\ntitle: [unclosed\n---\ntext\n",
+			expErr:      "parsing frontmatter",
+		},
+		{
+			name: "disabled",
+			in:   "---\ntitle: Hi\n---\n",
+			exp: HTML{
+				Description: "---",
+				Body:        "<p>---</p>\n<p>title: Hi</p>\n<p>---</p>\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{Frontmatter: test.frontmatter}.Translate(
+				strings.NewReader(test.in),
+			)
+			if test.expErr != "" {
+				assert.ErrorContains(t, err, test.expErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got)
+		})
+	}
+}
+
+func TestHTMLTranslatorMinify(t *testing.T) {
+	t.Parallel()
+
+	const doc = "# Title\n" +
+		"Some text\n" +
+		"* one\n" +
+		"* two\n" +
+		"=> /foo Foo\n" +
+		"> A quote\n" +
+		"```\n" +
+		"pre\n" +
+		"  formatted\n" +
+		"```\n" +
+		"| a | b |\n" +
+		"|---|---|\n" +
+		"| 1 | 2 |\n"
+
+	got, err := HTMLTranslator{Tables: true, Minify: true}.Translate(
+		strings.NewReader(doc),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		"<h1>Title</h1>"+
+			"<p>Some text</p>"+
+			"<ul><li>one</li><li>two</li></ul>"+
+			"<p><a href=\"/foo\">Foo</a></p>"+
+			"<blockquote>A quote</blockquote>"+
+			"<pre>\npre\n  formatted\n</pre>"+
+			"<table><thead><tr><th>a</th><th>b</th></tr></thead>"+
+			"<tbody><tr><td>1</td><td>2</td></tr></tbody></table>",
+		got.Body,
+	)
+
+	t.Log("Checking that minified output only differs by whitespace")
+	pretty, err := HTMLTranslator{Tables: true}.Translate(
+		strings.NewReader(doc),
+	)
+	require.NoError(t, err)
+
+	stripNewlines := func(str string) string {
+		return strings.ReplaceAll(str, "\n", "")
+	}
+	assert.Equal(t, stripNewlines(pretty.Body), stripNewlines(got.Body))
+	assert.Equal(t, pretty.Title, got.Title)
+}
+
+func TestHTMLTranslatorPreserveIndent(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		preserveIndent bool
+		in             string
+		exp            string
+	}{
+		{
+			name: "disabled",
+			in:   "  indented\n",
+			exp:  "<p>indented</p>\n",
+		},
+		{
+			name:           "spaces",
+			preserveIndent: true,
+			in:             "not indented\n  two\n    four\n",
+			exp: "<p>not indented</p>\n" +
+				"<p>&nbsp;&nbsp;two</p>\n" +
+				"<p>&nbsp;&nbsp;&nbsp;&nbsp;four</p>\n",
+		},
+		{
+			name:           "tabs",
+			preserveIndent: true,
+			in:             "\t \ttabbed  \n",
+			exp:            "<p>" + strings.Repeat("&nbsp;", 9) + "tabbed</p>\n",
+		},
+		{
+			name:           "other line kinds",
+			preserveIndent: true,
+			in:             "*   item\n```\n  pre\n```\n",
+			exp:            "<ul>\n<li>item</li>\n</ul>\n<pre>\n  pre\n</pre>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{PreserveIndent: test.preserveIndent}.Translate(
+				strings.NewReader(test.in),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+}
+
+func TestHTMLTranslatorTitleLevel(t *testing.T) {
+	t.Parallel()
+
+	const doc = "# Site Name\n" +
+		"## Page & Title\n" +
+		"# Other\n" +
+		"## Section\n"
+
+	tests := []struct {
+		name       string
+		titleLevel int
+		exp        string
+	}{
+		{"default", 0, "Site Name"},
+		{"h1", 1, "Site Name"},
+		{"h2", 2, "Page &amp; Title"},
+		{"none found", 3, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{TitleLevel: test.titleLevel}.Translate(
+				strings.NewReader(doc),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Title)
+		})
+	}
+}
+
+func TestHTMLTranslatorDescription(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		in     string
+		maxLen int
+		exp    string
+	}{
+		{
+			name: "after title",
+			in:   "Preamble\n# Title\n=> /link Link\n* item\n\nFish & chips.\nMore.\n",
+			exp:  "Fish &amp; chips.",
+		},
+		{
+			name: "no title",
+			in:   "## Sub\n> quote\n  First line.  \nSecond line.\n",
+			exp:  "First line.",
+		},
+		{
+			name: "no text after title",
+			in:   "Preamble\n# Title\n",
+			exp:  "",
+		},
+		{
+			name: "preformatted",
+			in:   "# Title\n```\nnot text\n```\ntext\n",
+			exp:  "text",
+		},
+		{
+			name:   "truncated at word boundary",
+			in:     "# Title\nThe quick brown fox jumps.\n",
+			maxLen: 15,
+			exp:    "The quick…",
+		},
+		{
+			name:   "truncated mid-word",
+			in:     "# Title\nSupercalifragilistic\n",
+			maxLen: 6,
+			exp:    "Super…",
+		},
+		{
+			name:   "not truncated",
+			in:     "# Title\nShort & sweet\n",
+			maxLen: 13,
+			exp:    "Short &amp; sweet",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{DescriptionLength: test.maxLen}.Translate(
+				strings.NewReader(test.in),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Description)
+		})
+	}
+}
+
+func TestHTMLTranslatorQuoteAttribution(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		quoteAttribution bool
+		in               string
+		exp              string
+	}{
+		{
+			name: "disabled",
+			in:   "> To be\n> -- Someone\n",
+			exp:  "<blockquote>To be</blockquote>\n<blockquote>-- Someone</blockquote>\n",
+		},
+		{
+			name:             "double dash",
+			quoteAttribution: true,
+			in:               "> To be,\n> or not to be\n> -- Hamlet\nAfter\n",
+			exp: "<figure>\n<blockquote>\n" +
+				"<p>To be,</p>\n<p>or not to be</p>\n" +
+				"</blockquote>\n" +
+				"<figcaption><cite>Hamlet</cite></figcaption>\n" +
+				"</figure>\n" +
+				"<p>After</p>\n",
+		},
+		{
+			name:             "em dash",
+			quoteAttribution: true,
+			in:               "> A & B\n>— <Someone>",
+			exp: "<figure>\n<blockquote>\n" +
+				"<p>A &amp; B</p>\n" +
+				"</blockquote>\n" +
+				"<figcaption><cite>&lt;Someone&gt;</cite></figcaption>\n" +
+				"</figure>\n",
+		},
+		{
+			name:             "without attribution",
+			quoteAttribution: true,
+			in:               "> One\n> Two\n",
+			exp:              "<blockquote>One</blockquote>\n<blockquote>Two</blockquote>\n",
+		},
+		{
+			name:             "attribution only",
+			quoteAttribution: true,
+			in:               "> -- Someone\n",
+			exp:              "<blockquote>-- Someone</blockquote>\n",
+		},
+		{
+			name:             "empty attribution",
+			quoteAttribution: true,
+			in:               "> One\n> --\n",
+			exp:              "<blockquote>One</blockquote>\n<blockquote>--</blockquote>\n",
+		},
+		{
+			name:             "separated by blank line",
+			quoteAttribution: true,
+			in:               "> One\n\n> -- Someone\n",
+			exp:              "<blockquote>One</blockquote>\n<blockquote>-- Someone</blockquote>\n",
+		},
+		{
+			name:             "after list",
+			quoteAttribution: true,
+			in:               "* item\n> One\n> -- Someone\n",
+			exp: "<ul>\n<li>item</li>\n</ul>\n" +
+				"<figure>\n<blockquote>\n" +
+				"<p>One</p>\n" +
+				"</blockquote>\n" +
+				"<figcaption><cite>Someone</cite></figcaption>\n" +
+				"</figure>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{
+				QuoteAttribution: test.quoteAttribution,
+			}.Translate(
+				strings.NewReader(test.in),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+}
+
+func TestHTMLTranslatorCheckFragmentLinks(t *testing.T) {
+	t.Parallel()
+
+	const doc = "=> #fish-chips Jump\n" +
+		"=> #Fish%20&%20Chips Jump by name\n" +
+		"=> #nowhere Dangling\n" +
+		"=> other.gmi#nowhere Other document\n" +
+		"## Fish & Chips\n"
+
+	tests := []struct {
+		name               string
+		checkFragmentLinks bool
+		exp                string
+		expDangling        []string
+	}{
+		{
+			name: "disabled",
+			exp: `<p><a href="#fish-chips">Jump</a></p>` + "\n" +
+				`<p><a href="#Fish%20&amp;%20Chips">Jump by name</a></p>` + "\n" +
+				`<p><a href="#nowhere">Dangling</a></p>` + "\n" +
+				`<p><a href="other.gmi#nowhere">Other document</a></p>` + "\n" +
+				`<h2 id="fish-chips">Fish &amp; Chips</h2>` + "\n",
+		},
+		{
+			name:               "enabled",
+			checkFragmentLinks: true,
+			exp: `<p><a href="#fish-chips">Jump</a></p>` + "\n" +
+				`<p><a href="#fish-chips">Jump by name</a></p>` + "\n" +
+				`<p><a class="dangling-fragment" href="#nowhere">Dangling</a></p>` + "\n" +
+				`<p><a href="other.gmi#nowhere">Other document</a></p>` + "\n" +
+				`<h2 id="fish-chips">Fish &amp; Chips</h2>` + "\n",
+			expDangling: []string{"nowhere"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{
+				HeadingIDs:         true,
+				CheckFragmentLinks: test.checkFragmentLinks,
+			}.Translate(strings.NewReader(doc))
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+			assert.Equal(t, test.expDangling, got.DanglingFragments)
+		})
+	}
+}
+
+func TestHeadings(t *testing.T) {
+	t.Parallel()
+
+	const doc = "# Hello, World!\n" +
+		"## Getting <Started>\n" +
+		"```\n# Not a heading\n```\n" +
+		"### Café au lait\n" +
+		"## Getting Started\n" +
+		"# !!!\n" +
+		"## Straße & Øl\n" +
+		"## 日本語\n" +
+		"## Getting Started\n"
+
+	exp := []Heading{
+		{Level: 1, Text: "Hello, World!", Slug: "hello-world"},
+		{Level: 2, Text: "Getting &lt;Started&gt;", Slug: "getting-started"},
+		{Level: 3, Text: "Café au lait", Slug: "cafe-au-lait"},
+		{Level: 2, Text: "Getting Started", Slug: "getting-started-2"},
+		{Level: 1, Text: "!!!", Slug: "section"},
+		{Level: 2, Text: "Straße &amp; Øl", Slug: "strasse-ol"},
+		{Level: 2, Text: "日本語", Slug: "日本語"},
+		{Level: 2, Text: "Getting Started", Slug: "getting-started-3"},
+	}
+
+	got, err := Headings(strings.NewReader(doc))
+	require.NoError(t, err)
+	assert.Equal(t, exp, got)
+
+	t.Run("ids", func(t *testing.T) {
+		translated, err := HTMLTranslator{HeadingIDs: true}.Translate(
+			strings.NewReader(doc),
+		)
+		require.NoError(t, err)
+
+		for _, heading := range exp {
+			assert.Contains(t, translated.Body, `id="`+heading.Slug+`"`)
+		}
+
+		t.Log("Checking that the translated headings match")
+		assert.Equal(t, exp, translated.Headings)
+	})
+
+	t.Run("render heading", func(t *testing.T) {
+		translated, err := HTMLTranslator{
+			RenderHeading: func(w io.Writer, level int, id, text string) error {
+				_, err := fmt.Fprintf(w, "%d:%s:%s\n", level, id, text)
+				return err
+			},
+		}.Translate(strings.NewReader(doc))
+		require.NoError(t, err)
+
+		t.Log("Checking that the hook is given each heading's id")
+		for _, heading := range exp {
+			assert.Contains(t, translated.Body, fmt.Sprintf(
+				"%d:%s:%s\n", heading.Level, heading.Slug, heading.Text,
+			))
+		}
+	})
+}
+
+func TestHTMLTranslatorEmptyLinkLabel(t *testing.T) {
+	t.Parallel()
+
+	const doc = "=> https://example.com/foo\n" +
+		"=> /bar.gmi\n" +
+		"=> https://example.com/baz Baz\n"
+
+	tests := []struct {
+		emptyLinkLabel string
+		exp            string
+	}{
+		{
+			"",
+			"<p><a href=\"https://example.com/foo\">https://example.com/foo</a></p>\n" +
+				"<p><a href=\"/bar.gmi\">/bar.gmi</a></p>\n" +
+				"<p><a href=\"https://example.com/baz\">Baz</a></p>\n",
+		},
+		{
+			EmptyLinkLabelHost,
+			"<p><a href=\"https://example.com/foo\">example.com</a></p>\n" +
+				"<p><a href=\"/bar.gmi\">/bar.gmi</a></p>\n" +
+				"<p><a href=\"https://example.com/baz\">Baz</a></p>\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.emptyLinkLabel, func(t *testing.T) {
+			t.Parallel()
+			got, err := HTMLTranslator{
+				EmptyLinkLabel: test.emptyLinkLabel,
+			}.Translate(strings.NewReader(doc))
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package gemtext
+
+import (
+	"io"
+	"strings"
+)
+
+// Markdown is the result of translating a gemtext file into Markdown. Title is
+// determined in the same way as for HTML (see MarkdownTranslator.TitleLevel),
+// but is not escaped.
+type Markdown struct {
+	Title string
+	Body  string
+}
+
+// MarkdownTranslator is used to translate a gemtext file into an equivalent
+// CommonMark document.
+//
+// Each line of gemtext becomes its own block in the Markdown document, except
+// for consecutive list items, which are grouped into a single list, and
+// preformatted blocks, which become fenced code blocks. Text is escaped such
+// that it will not be interpreted as Markdown syntax.
+type MarkdownTranslator struct {
+	// AllowedLinkSchemes are the URL schemes which links may have. Links whose
+	// URL has a scheme not in this list (e.g. `javascript:`) will be
+	// translated as plain text, with only their label included. Relative
+	// URLs, which have no scheme, are always allowed.
+	//
+	// Defaults to DefaultAllowedLinkSchemes.
+	AllowedLinkSchemes []string
+
+	// TitleLevel is the level of the heading, 1, 2, or 3, which is used as the
+	// Title of the translated document. The first heading of this level in
+	// the document is used.
+	//
+	// Defaults to 1.
+	TitleLevel int
+}
+
+// Translate will read a gemtext file from the Reader and return it as a
+// Markdown document. Lines which contain only whitespace, outside of
+// preformatted blocks, are omitted.
+func (t MarkdownTranslator) Translate(src io.Reader) (Markdown, error) {
+	var (
+		sc    = newLineScanner(src)
+		b     strings.Builder
+		title string
+		list  bool
+		fence string
+	)
+
+	titleLevel := t.TitleLevel
+	if titleLevel == 0 {
+		titleLevel = 1
+	}
+
+	// startBlock separates a new block from the previous one, if any.
+	startBlock := func() {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+	}
+
+	for sc.Scan() {
+		l := sc.Line()
+
+		switch l.kind {
+		case lineKindPreToggle:
+			if fence == "" {
+				// A backtick fence's info string may not contain backticks.
+				fence = "```"
+				if strings.Contains(l.text, "`") {
+					fence = "~~~"
+				}
+				startBlock()
+				list = false
+				b.WriteString(fence + l.text + "\n")
+			} else {
+				b.WriteString(fence + "\n")
+				fence = ""
+			}
+			continue
+
+		case lineKindPre:
+			b.WriteString(l.raw + "\n")
+			continue
+		}
+
+		if len(strings.TrimSpace(l.raw)) == 0 {
+			continue
+		}
+
+		if l.kind == lineKindListItem {
+			if !list {
+				startBlock()
+				list = true
+			}
+			b.WriteString("- " + escapeMarkdownLine(l.text) + "\n")
+			continue
+		}
+
+		list = false
+		startBlock()
+
+		switch l.kind {
+		case lineKindLink:
+			if isAllowedLinkURL(l.url, t.AllowedLinkSchemes) {
+				b.WriteString(
+					"[" + escapeMarkdown(l.text) + "](" +
+						markdownLinkDestination(l.url) + ")\n",
+				)
+			} else {
+				b.WriteString(escapeMarkdownLine(l.text) + "\n")
+			}
+
+		case lineKindHeading:
+			if l.level == titleLevel && title == "" {
+				title = l.text
+			}
+			b.WriteString(
+				strings.Repeat("#", l.level) + " " + escapeMarkdown(l.text) + "\n",
+			)
+
+		case lineKindQuote:
+			b.WriteString("> " + escapeMarkdownLine(l.text) + "\n")
+
+		default:
+			b.WriteString(escapeMarkdownLine(strings.TrimSpace(l.raw)) + "\n")
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return Markdown{}, err
+	}
+
+	// Close a preformatted block which was left open by the document ending.
+	if fence != "" {
+		b.WriteString(fence + "\n")
+	}
+
+	return Markdown{Title: title, Body: b.String()}, nil
+}
@@ -0,0 +1,62 @@
+package gemtext
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkdownTranslator(t *testing.T) {
+	t.Parallel()
+
+	src, err := os.Open("testdata/sample.gmi")
+	require.NoError(t, err)
+	defer src.Close()
+
+	exp, err := os.ReadFile("testdata/sample.md")
+	require.NoError(t, err)
+
+	got, err := MarkdownTranslator{}.Translate(src)
+	require.NoError(t, err)
+	assert.Equal(t, "A *Sample* Document", got.Title)
+	assert.Equal(t, string(exp), got.Body)
+}
+
+func TestMarkdownTranslatorEscaping(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{"entity", "&amp; & &#42;", "\\&amp; & \\&#42;\n"},
+		{"strikethrough", "~~gone~~", "\\~\\~gone\\~\\~\n"},
+		{"ordered list paren", "1) one", "1\\) one\n"},
+		{"long number", "1234567890. one", "1234567890. one\n"},
+		{"link backslash", `=> /a\b Label`, "[Label](/a%5Cb)\n"},
+		{"link space", "=> /a%20b", "[/a%20b](/a%20b)\n"},
+		{"fence with backtick alt", "```a`b\nx\n```", "~~~a`b\nx\n~~~\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := MarkdownTranslator{}.Translate(strings.NewReader(test.in))
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got.Body)
+		})
+	}
+
+	t.Run("title level", func(t *testing.T) {
+		t.Parallel()
+		got, err := MarkdownTranslator{TitleLevel: 2}.Translate(
+			strings.NewReader("# Site\n## Page\n## Other\n"),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "Page", got.Title)
+	})
+}
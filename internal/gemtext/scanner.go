@@ -0,0 +1,154 @@
+package gemtext
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// lineKind describes the type of a single line of a gemtext document.
+type lineKind int
+
+const (
+	lineKindText lineKind = iota
+	lineKindHeading
+	lineKindLink
+	lineKindListItem
+	lineKindQuote
+
+	// lineKindPreToggle is a "```" line, which opens or closes a preformatted
+	// block.
+	lineKindPreToggle
+
+	// lineKindPre is a line within a preformatted block.
+	lineKindPre
+)
+
+// line is a single classified line of a gemtext document.
+type line struct {
+	kind lineKind
+
+	// raw is the full line, without its line terminator.
+	raw string
+
+	// text is the content of the line, depending on its kind:
+	//
+	//	lineKindText, lineKindPre: Equal to raw.
+	//	lineKindHeading, lineKindListItem, lineKindQuote: The line with its
+	//	prefix removed, and whitespace trimmed.
+	//	lineKindLink: The link's label, which is the URL if there is no label.
+	//	lineKindPreToggle: The alt text following the "```", if any.
+	text string
+
+	// level is the level of a heading: 1, 2, or 3.
+	level int
+
+	// url is the URL of a link.
+	url string
+
+	// hasLabel is false if a link has no label, in which case text is equal to
+	// url.
+	hasLabel bool
+}
+
+// lineScanner reads a gemtext document line-by-line, classifying each line.
+// It handles a leading byte-order mark, CRLF line endings, a final line which
+// is not newline terminated, and tracks whether or not each line is within a
+// preformatted block.
+//
+// Usage is similar to bufio.Scanner.
+type lineScanner struct {
+	r       *bufio.Reader
+	started bool
+	eof     bool
+	pre     bool
+	line    line
+	err     error
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	return &lineScanner{r: bufio.NewReader(r)}
+}
+
+// Scan advances the lineScanner to the next line, which will then be available
+// via the Line method. It returns false once there are no more lines or an
+// error has been encountered, in which case Err should be checked.
+func (s *lineScanner) Scan() bool {
+	if s.eof || s.err != nil {
+		return false
+	}
+
+	raw, err := s.r.ReadString('\n')
+
+	// The final line of the document may not be newline terminated, in which
+	// case it still needs to be returned.
+	if errors.Is(err, io.EOF) {
+		s.eof = true
+		if raw == "" {
+			return false
+		}
+	} else if err != nil {
+		s.err = fmt.Errorf("reading next line: %w", err)
+		return false
+	}
+
+	if !s.started {
+		s.started = true
+		raw = strings.TrimPrefix(raw, "\uFEFF")
+	}
+
+	raw = strings.TrimSuffix(raw, "\n")
+	raw = strings.TrimSuffix(raw, "\r")
+
+	s.line = s.classify(raw)
+	return true
+}
+
+// Line returns the line most recently read by Scan.
+func (s *lineScanner) Line() line {
+	return s.line
+}
+
+// Err returns the first error encountered by Scan, if any.
+func (s *lineScanner) Err() error {
+	return s.err
+}
+
+func (s *lineScanner) classify(raw string) line {
+	l := line{raw: raw, text: raw}
+
+	// Lines within a preformatted block are never interpreted, so this must
+	// be checked before anything else.
+	switch {
+	case strings.HasPrefix(raw, "```"):
+		s.pre = !s.pre
+		l.kind, l.text = lineKindPreToggle, strings.TrimSpace(raw[3:])
+
+	case s.pre:
+		l.kind = lineKindPre
+
+	case strings.HasPrefix(raw, "=>"):
+		parsedLink := parseLinkLine(raw)
+		l.kind, l.url, l.text = lineKindLink, parsedLink.url, parsedLink.label
+		l.hasLabel = parsedLink.hasLabel
+
+	case strings.HasPrefix(raw, "###"):
+		l.kind, l.level, l.text = lineKindHeading, 3, strings.TrimSpace(raw[3:])
+
+	case strings.HasPrefix(raw, "##"):
+		l.kind, l.level, l.text = lineKindHeading, 2, strings.TrimSpace(raw[2:])
+
+	case strings.HasPrefix(raw, "#"):
+		l.kind, l.level, l.text = lineKindHeading, 1, strings.TrimSpace(raw[1:])
+
+	case strings.HasPrefix(raw, "*"):
+		l.kind, l.text = lineKindListItem, strings.TrimSpace(raw[1:])
+
+	case strings.HasPrefix(raw, ">"):
+		l.kind, l.text = lineKindQuote, strings.TrimSpace(raw[1:])
+	}
+
+	return l
+}
@@ -0,0 +1,97 @@
+package gemtext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineScanner(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		exp  []line
+	}{
+		{
+			name: "empty",
+			in:   "",
+		},
+		{
+			name: "text",
+			in:   "Hello\n  indented\n\n",
+			exp: []line{
+				{kind: lineKindText, raw: "Hello", text: "Hello"},
+				{kind: lineKindText, raw: "  indented", text: "  indented"},
+				{kind: lineKindText},
+			},
+		},
+		{
+			name: "headings",
+			in:   "# One\n## Two\n### Three\n#### Four\n",
+			exp: []line{
+				{kind: lineKindHeading, raw: "# One", text: "One", level: 1},
+				{kind: lineKindHeading, raw: "## Two", text: "Two", level: 2},
+				{kind: lineKindHeading, raw: "### Three", text: "Three", level: 3},
+				{kind: lineKindHeading, raw: "#### Four", text: "# Four", level: 3},
+			},
+		},
+		{
+			name: "links",
+			in:   "=> /foo Foo bar\n=>/bar\n",
+			exp: []line{
+				{kind: lineKindLink, raw: "=> /foo Foo bar", text: "Foo bar", url: "/foo", hasLabel: true},
+				{kind: lineKindLink, raw: "=>/bar", text: "/bar", url: "/bar"},
+			},
+		},
+		{
+			name: "list items and quotes",
+			in:   "* item\n>quote \n",
+			exp: []line{
+				{kind: lineKindListItem, raw: "* item", text: "item"},
+				{kind: lineKindQuote, raw: ">quote ", text: "quote"},
+			},
+		},
+		{
+			name: "preformatted block",
+			in:   "``` alt text\n# not a heading\n\n```\n# heading\n",
+			exp: []line{
+				{kind: lineKindPreToggle, raw: "``` alt text", text: "alt text"},
+				{kind: lineKindPre, raw: "# not a heading", text: "# not a heading"},
+				{kind: lineKindPre},
+				{kind: lineKindPreToggle, raw: "```"},
+				{kind: lineKindHeading, raw: "# heading", text: "heading", level: 1},
+			},
+		},
+		{
+			name: "crlf, bom, and no final newline",
+			in:   "\uFEFF# Title\r\n=> /foo\r\nlast",
+			exp: []line{
+				{kind: lineKindHeading, raw: "# Title", text: "Title", level: 1},
+				{kind: lineKindLink, raw: "=> /foo", text: "/foo", url: "/foo"},
+				{kind: lineKindText, raw: "last", text: "last"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				sc  = newLineScanner(strings.NewReader(test.in))
+				got []line
+			)
+
+			for sc.Scan() {
+				got = append(got, sc.Line())
+			}
+
+			require.NoError(t, sc.Err())
+			assert.Equal(t, test.exp, got)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package gemtext
+
+import (
+	"io"
+	"strings"
+)
+
+// Text is the result of translating a gemtext file into plain text. Title is
+// determined in the same way as for HTML (see TextTranslator.TitleLevel), but
+// is not escaped.
+type Text struct {
+	Title string
+	Body  string
+}
+
+// TextTranslator is used to translate a gemtext file into plain text, with all
+// gemtext syntax stripped, e.g. for search indexing or accessibility tooling.
+//
+// Headings and quotes become bare lines of text, and links become their label,
+// or their URL if they have no label. List items retain a leading "* ", and
+// preformatted blocks are retained verbatim, without their "```" lines. Blank
+// lines are retained as-is.
+type TextTranslator struct {
+	// TitleLevel is the level of the heading, 1, 2, or 3, which is used as the
+	// Title of the translated document. The first heading of this level in
+	// the document is used.
+	//
+	// Defaults to 1.
+	TitleLevel int
+}
+
+// Translate will read a gemtext file from the Reader and return it as plain
+// text.
+func (t TextTranslator) Translate(src io.Reader) (Text, error) {
+	var (
+		sc    = newLineScanner(src)
+		b     strings.Builder
+		title string
+	)
+
+	titleLevel := t.TitleLevel
+	if titleLevel == 0 {
+		titleLevel = 1
+	}
+
+	for sc.Scan() {
+		l := sc.Line()
+
+		switch l.kind {
+		case lineKindPreToggle:
+			continue
+
+		case lineKindPre:
+			b.WriteString(l.raw)
+
+		case lineKindHeading:
+			if l.level == titleLevel && title == "" {
+				title = l.text
+			}
+			b.WriteString(l.text)
+
+		case lineKindLink, lineKindQuote:
+			b.WriteString(l.text)
+
+		case lineKindListItem:
+			b.WriteString("* " + l.text)
+
+		default:
+			b.WriteString(strings.TrimSpace(l.raw))
+		}
+
+		b.WriteString("\n")
+	}
+
+	if err := sc.Err(); err != nil {
+		return Text{}, err
+	}
+
+	return Text{Title: title, Body: b.String()}, nil
+}
@@ -0,0 +1,72 @@
+package gemtext
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextTranslator(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		titleLevel int
+		in         string
+		exp        Text
+	}{
+		{
+			name: "links",
+			in: "=> /foo Foo\n" +
+				"=> gemini://example.com\n" +
+				"=>  https://example.com/bar   Bar & baz  \n",
+			exp: Text{
+				Body: "Foo\ngemini://example.com\nBar & baz\n",
+			},
+		},
+		{
+			name: "no headings",
+			in:   "  Some text  \n\n* one\n* two\n> quote\n",
+			exp: Text{
+				Body: "Some text\n\n* one\n* two\nquote\n",
+			},
+		},
+		{
+			name: "headings",
+			in:   "## Sub\n# Title\n### Subsub\n# Other\n",
+			exp: Text{
+				Title: "Title",
+				Body:  "Sub\nTitle\nSubsub\nOther\n",
+			},
+		},
+		{
+			name:       "title level",
+			titleLevel: 2,
+			in:         "# Site\n## Page\n",
+			exp: Text{
+				Title: "Page",
+				Body:  "Site\nPage\n",
+			},
+		},
+		{
+			name: "preformatted",
+			in:   "``` shell\n# not a heading\n  => not a link\n```\ntext",
+			exp: Text{
+				Body: "# not a heading\n  => not a link\ntext\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := TextTranslator{TitleLevel: test.titleLevel}.Translate(
+				strings.NewReader(test.in),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, got)
+		})
+	}
+}
@@ -1,22 +1,294 @@
 package gemtext
 
-import "strings"
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
 
 type parsedLink struct {
 	url   string
 	label string
+
+	// hasLabel is false if the link line had no label, in which case label is
+	// equal to url.
+	hasLabel bool
 }
 
 func parseLinkLine(line string) parsedLink {
 	line = strings.TrimSpace(line[2:])
 	var (
-		urlStr = line
-		label  = urlStr
+		urlStr   = line
+		label    = urlStr
+		hasLabel bool
 	)
 
 	if i := strings.IndexAny(urlStr, " \t"); i > -1 {
 		urlStr, label = urlStr[:i], strings.TrimSpace(urlStr[i:])
+		hasLabel = true
+	}
+
+	return parsedLink{url: urlStr, label: label, hasLabel: hasLabel}
+}
+
+// linkHost returns the host of the URL, or the URL itself if it has no host
+// (e.g. it's relative).
+func linkHost(urlStr string) string {
+	if u, err := url.Parse(urlStr); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return urlStr
+}
+
+// indentTabWidth is the number of spaces which a tab is counted as when
+// preserving indentation.
+const indentTabWidth = 4
+
+// indentHTML returns the leading spaces and tabs of the string as a sequence of
+// non-breaking spaces, so that they are retained when rendered as HTML. Tabs
+// are counted as indentTabWidth spaces.
+func indentHTML(str string) string {
+	return strings.Repeat("&nbsp;", indentWidth(str))
+}
+
+// indentWidth returns the width of the leading spaces and tabs of the string,
+// with tabs counted as indentTabWidth spaces.
+func indentWidth(str string) int {
+	var n int
+	for _, c := range str {
+		if c == ' ' {
+			n++
+		} else if c == '\t' {
+			n += indentTabWidth
+		} else {
+			break
+		}
+	}
+	return n
+}
+
+// percentEncodeURL percent-encodes any characters in the URL string which are
+// never valid within a URL, such as spaces, control characters, and quotes,
+// leaving the rest of the URL as-is.
+func percentEncodeURL(urlStr string) string {
+	var b strings.Builder
+	for i := 0; i < len(urlStr); i++ {
+		switch c := urlStr[i]; {
+		case c <= ' ', c == 0x7f, c == '"', c == '<', c == '>', c == '`':
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseTableRow splits a pipe-delimited line, e.g. `| a | b |`, into its
+// cells. The leading and trailing pipes are optional.
+func parseTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
 	}
+	return cells
+}
+
+// isTableSeparatorRow returns true if all of the given cells consist of
+// dashes, optionally with a leading and/or trailing colon, e.g. `|---|:-:|`.
+func isTableSeparatorRow(cells []string) bool {
+	for _, cell := range cells {
+		cell = strings.TrimPrefix(cell, ":")
+		cell = strings.TrimSuffix(cell, ":")
+		if cell == "" || strings.Trim(cell, "-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteAttribution returns the attribution given by a quote line's text, if the
+// text starts with `--` or an em-dash, e.g. `— Someone`.
+func quoteAttribution(text string) (string, bool) {
+	for _, prefix := range []string{"--", "—"} {
+		if rest, ok := strings.CutPrefix(text, prefix); ok {
+			rest = strings.TrimSpace(rest)
+			return rest, rest != ""
+		}
+	}
+	return "", false
+}
+
+// resolveFragment returns the Slug, from the given set, which a same-document
+// link's fragment refers to, either directly or once slugified.
+func resolveFragment(slugs map[string]bool, fragment string) (string, bool) {
+	if unescaped, err := url.PathUnescape(fragment); err == nil {
+		fragment = unescaped
+	}
+
+	if slugs[fragment] {
+		return fragment, true
+	} else if slug := new(headingSlugger).slug(fragment); slugs[slug] {
+		return slug, true
+	}
+
+	return "", false
+}
+
+// headingSlugger generates unique slugs for the headings of a document, for
+// use as anchors.
+type headingSlugger struct {
+	seen map[string]bool
+}
+
+// slugFoldings are the ASCII equivalents of letters which don't decompose into
+// an ASCII letter and combining marks.
+var slugFoldings = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d",
+	'þ': "th", 'ı': "i",
+}
+
+// slug returns a slug for the given heading text, consisting only of lowercase
+// letters, digits, and dashes. Letters are folded to ASCII where possible, e.g.
+// "é" becomes "e", while letters of scripts with no ASCII equivalent are kept
+// as-is. If the slug has already been returned for a previous heading then a
+// numeric suffix is added to make it unique.
+func (s *headingSlugger) slug(text string) string {
+	var (
+		b    strings.Builder
+		dash bool
+	)
+
+	for _, r := range norm.NFKD.String(strings.ToLower(text)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		} else if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			dash = true
+			continue
+		}
+
+		if dash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		dash = false
+
+		if folded, ok := slugFoldings[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	slug := b.String()
+	if slug == "" {
+		slug = "section"
+	}
+
+	if s.seen == nil {
+		s.seen = map[string]bool{}
+	}
+
+	base := slug
+	for n := 2; s.seen[slug]; n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+
+	s.seen[slug] = true
+	return slug
+}
+
+// truncateText truncates the string to at most maxLen characters, including a
+// trailing ellipsis, cutting at a word boundary where possible. If maxLen is
+// not greater than zero then the string is returned as-is.
+func truncateText(str string, maxLen int) string {
+	runes := []rune(str)
+	if maxLen <= 0 || len(runes) <= maxLen {
+		return str
+	}
+
+	cut := maxLen - 1
+	if !unicode.IsSpace(runes[cut]) {
+		for i := cut - 1; i > 0; i-- {
+			if unicode.IsSpace(runes[i]) {
+				cut = i
+				break
+			}
+		}
+	}
+
+	return strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace) + "…"
+}
+
+// escapeMarkdown backslash-escapes any characters of the string which could
+// otherwise be interpreted as inline Markdown syntax, e.g. emphasis or links.
+func escapeMarkdown(str string) string {
+	var b strings.Builder
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '\\', '`', '*', '_', '[', ']', '<', '>', '~':
+			b.WriteByte('\\')
+		case '&':
+			// Only an ampersand which could begin an entity reference, e.g.
+			// `&amp;`, needs escaping.
+			if i+1 < len(str) && (isASCIIAlnum(str[i+1]) || str[i+1] == '#') {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteByte(str[i])
+	}
+
+	// A trailing '#' could be interpreted as the closing sequence of a heading.
+	s := b.String()
+	if strings.HasSuffix(s, "#") {
+		s = s[:len(s)-1] + `\#`
+	}
+
+	return s
+}
+
+// escapeMarkdownLine is like escapeMarkdown, but additionally escapes the
+// string such that, when at the start of a line, it won't be interpreted as a
+// Markdown block, e.g. a heading or list item.
+func escapeMarkdownLine(str string) string {
+	str = escapeMarkdown(str)
+
+	switch {
+	case str == "":
+		return str
+	case strings.ContainsRune("#>-+=", rune(str[0])):
+		return `\` + str
+	}
+
+	// An ordered list item starts with up to 9 digits followed by '.' or ')'.
+	digits := len(str) - len(strings.TrimLeft(str, "0123456789"))
+	if digits > 0 && digits <= 9 && digits < len(str) &&
+		(str[digits] == '.' || str[digits] == ')') {
+		return str[:digits] + `\` + str[digits:]
+	}
+
+	return str
+}
+
+// markdownLinkDestination returns the URL in a form which can be used as the
+// destination of a Markdown link. Backslashes are percent-encoded, as they
+// would otherwise be interpreted as escapes, and URLs containing parentheses
+// are wrapped in angle brackets.
+func markdownLinkDestination(urlStr string) string {
+	urlStr = strings.ReplaceAll(percentEncodeURL(urlStr), `\`, "%5C")
+	if strings.ContainsAny(urlStr, "()") {
+		return "<" + urlStr + ">"
+	}
+	return urlStr
+}
 
-	return parsedLink{url: urlStr, label: label}
+func isASCIIAlnum(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9')
 }
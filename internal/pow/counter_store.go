@@ -0,0 +1,117 @@
+package pow
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tilinna/clock"
+)
+
+// CounterStore is used to track counters related to proof-of-work clients,
+// e.g. how many challenges a client has been issued, for the purposes of rate
+// limiting and attempt tracking. Like Store, implementations may be shared
+// between multiple Caddy instances, so that counts are consistent across a
+// cluster.
+type CounterStore interface {
+
+	// Incr increments the counter for the given key, returning its new value.
+	// If the counter doesn't exist, or has expired, then it is created with a
+	// value of 1 and will expire once the TTL has elapsed. Incrementing an
+	// existing counter does not extend its expiry.
+	Incr(key string, ttl time.Duration) (int64, error)
+
+	// Get returns the current value of the counter for the given key, or 0 if
+	// it doesn't exist or has expired.
+	Get(key string) (int64, error)
+
+	Close() error
+}
+
+type memCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+type inMemCounterStore struct {
+	opts *MemoryStoreOpts
+
+	m          map[string]memCounter
+	l          sync.Mutex
+	closeCh    chan struct{}
+	spinLoopCh chan struct{} // only used by tests
+}
+
+// NewMemoryCounterStore initializes and returns an in-memory CounterStore
+// implementation.
+func NewMemoryCounterStore(opts *MemoryStoreOpts) CounterStore {
+	s := &inMemCounterStore{
+		opts:       opts.withDefaults(),
+		m:          map[string]memCounter{},
+		closeCh:    make(chan struct{}),
+		spinLoopCh: make(chan struct{}, 1),
+	}
+	go s.spin(s.opts.Clock.NewTicker(inMemStoreGCPeriod))
+	return s
+}
+
+func (s *inMemCounterStore) spin(ticker *clock.Ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := s.opts.Clock.Now()
+
+			s.l.Lock()
+			for key, c := range s.m {
+				if !now.Before(c.expiresAt) {
+					delete(s.m, key)
+				}
+			}
+			s.l.Unlock()
+
+		case <-s.closeCh:
+			return
+		}
+
+		select {
+		case s.spinLoopCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *inMemCounterStore) Incr(key string, ttl time.Duration) (int64, error) {
+	now := s.opts.Clock.Now()
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	c, ok := s.m[key]
+	if !ok || !now.Before(c.expiresAt) {
+		c = memCounter{expiresAt: now.Add(ttl)}
+	}
+
+	c.value++
+	s.m[key] = c
+	return c.value, nil
+}
+
+func (s *inMemCounterStore) Get(key string) (int64, error) {
+	now := s.opts.Clock.Now()
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	c, ok := s.m[key]
+	if !ok || !now.Before(c.expiresAt) {
+		return 0, nil
+	}
+
+	return c.value, nil
+}
+
+func (s *inMemCounterStore) Close() error {
+	close(s.closeCh)
+	return nil
+}
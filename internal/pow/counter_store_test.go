@@ -0,0 +1,67 @@
+package pow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tilinna/clock"
+)
+
+func TestMemoryCounterStore(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock = clock.NewMock(time.Now().Truncate(time.Hour))
+		store = NewMemoryCounterStore(&MemoryStoreOpts{Clock: clock})
+	)
+	t.Cleanup(func() { store.Close() })
+
+	assertGet := func(t *testing.T, key string, exp int64) {
+		t.Helper()
+		got, err := store.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, exp, got)
+	}
+
+	assertIncr := func(t *testing.T, key string, exp int64) {
+		t.Helper()
+		got, err := store.Incr(key, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, exp, got)
+	}
+
+	t.Log("Checking that a missing counter is zero")
+	assertGet(t, "a", 0)
+
+	t.Log("Checking that counters are incremented independently")
+	assertIncr(t, "a", 1)
+	assertIncr(t, "a", 2)
+	assertIncr(t, "b", 1)
+	assertGet(t, "a", 2)
+	assertGet(t, "b", 1)
+
+	t.Log("Checking that incrementing doesn't extend the expiry")
+	clock.Add(59 * time.Second)
+	assertIncr(t, "a", 3)
+	clock.Add(time.Second)
+	assertGet(t, "a", 0)
+	assertGet(t, "b", 0)
+
+	t.Log("Checking that an expired counter starts again from one")
+	assertIncr(t, "a", 1)
+
+	t.Log("Checking that expired counters are garbage collected")
+	clock.Add(time.Minute)
+
+	s := store.(*inMemCounterStore)
+	assert.Eventually(t, func() bool {
+		clock.Add(inMemStoreGCPeriod)
+		<-s.spinLoopCh
+
+		s.l.Lock()
+		defer s.l.Unlock()
+		return len(s.m) == 0
+	}, time.Second, 10*time.Millisecond)
+}
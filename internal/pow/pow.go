@@ -3,15 +3,20 @@ package pow
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tilinna/clock"
@@ -69,22 +74,140 @@ func (c *challengeParams) UnmarshalBinary(b []byte) error {
 	return err
 }
 
+// Hash identifies a hash algorithm which can be used by a Manager.
+type Hash string
+
+// Hash algorithms which can be used by a Manager.
+const (
+	HashMD5    Hash = "md5"
+	HashSHA256 Hash = "sha256"
+	HashSHA512 Hash = "sha512"
+)
+
+var (
+	// SolutionHashes are the Hashes which may be used as ManagerOpts.Hash.
+	SolutionHashes = []Hash{HashSHA256, HashSHA512}
+
+	// SignatureHashes are the Hashes which may be used as
+	// ManagerOpts.SignatureHash.
+	SignatureHashes = []Hash{HashMD5, HashSHA256, HashSHA512}
+)
+
+// newFunc returns the constructor of the Hash's algorithm, or nil if the Hash
+// is unknown.
+func (h Hash) newFunc() func() hash.Hash {
+	switch h {
+	case HashMD5:
+		return md5.New
+	case HashSHA256:
+		return sha256.New
+	case HashSHA512:
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+// hashIDs identify each Hash within a seed's version byte. IDs are never
+// zero.
+var hashIDs = map[Hash]byte{
+	HashMD5:    1,
+	HashSHA256: 2,
+	HashSHA512: 3,
+}
+
+func hashFromID(id byte) (Hash, bool) {
+	for h, hID := range hashIDs {
+		if hID == id {
+			return h, true
+		}
+	}
+	return "", false
+}
+
+// seedHashes are the hash algorithms used by a seed.
+type seedHashes struct {
+	solution, signature Hash
+}
+
+// legacySeedHashes are the hash algorithms used by seeds with a version of 0,
+// which was the only version prior to the algorithms being configurable.
+var legacySeedHashes = seedHashes{solution: HashSHA512, signature: HashMD5}
+
+// seedVersionHashesFlag is set on the version byte of seeds which identify
+// their hash algorithms. The remaining bits hold the ID of the signature
+// algorithm, shifted by seedVersionSignatureShift, and the ID of the solution
+// algorithm.
+const (
+	seedVersionHashesFlag     = 0x80
+	seedVersionSignatureShift = 3
+	seedVersionIDMask         = 0x07
+)
+
+func (sh seedHashes) version() (byte, error) {
+	if sh == legacySeedHashes {
+		return 0, nil
+	}
+
+	solutionID, ok := hashIDs[sh.solution]
+	if !ok {
+		return 0, fmt.Errorf("unknown solution hash %q", sh.solution)
+	}
+
+	signatureID, ok := hashIDs[sh.signature]
+	if !ok {
+		return 0, fmt.Errorf("unknown signature hash %q", sh.signature)
+	}
+
+	return seedVersionHashesFlag |
+		signatureID<<seedVersionSignatureShift |
+		solutionID, nil
+}
+
+func seedHashesFromVersion(version byte) (seedHashes, bool) {
+	if version == 0 {
+		return legacySeedHashes, true
+	} else if version&seedVersionHashesFlag == 0 {
+		return seedHashes{}, false
+	}
+
+	var (
+		sh          seedHashes
+		ok1, ok2    bool
+		signatureID = (version >> seedVersionSignatureShift) & seedVersionIDMask
+	)
+	sh.solution, ok1 = hashFromID(version & seedVersionIDMask)
+	sh.signature, ok2 = hashFromID(signatureID)
+	return sh, ok1 && ok2
+}
+
 // The seed takes the form:
 //
 //	(version)+(signature of challengeParams)+(challengeParams)
 //
-// Version is currently always 0.
-func newSeed(c challengeParams, secret []byte) ([]byte, error) {
+// Version is 0 for seeds using the legacySeedHashes, otherwise it identifies
+// the seedHashes which were used, in which case it is also covered by the
+// signature so that a client can't switch to a different algorithm.
+func newSeed(
+	c challengeParams, hashes seedHashes, secret []byte,
+) (
+	[]byte, error,
+) {
+	version, err := hashes.version()
+	if err != nil {
+		return nil, err
+	}
+
 	buf := new(bytes.Buffer)
-	buf.WriteByte(0) // version
+	buf.WriteByte(version)
 
 	cb, err := c.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
-	h := hmac.New(md5.New, secret)
-	h.Write(cb)
+	h := hmac.New(hashes.signature.newFunc(), secret)
+	h.Write(seedSignedMsg(version, cb))
 	buf.Write(h.Sum(nil))
 
 	buf.Write(cb)
@@ -92,31 +215,121 @@ func newSeed(c challengeParams, secret []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-var errMalformedSeed = errors.New("malformed seed")
+// seedSignedMsg returns the message which is signed for a seed with the given
+// version and marshaled challengeParams.
+func seedSignedMsg(version byte, cb []byte) []byte {
+	if version == 0 {
+		return cb
+	}
+	return append([]byte{version}, cb...)
+}
 
-func challengeParamsFromSeed(seed, secret []byte) (challengeParams, error) {
-	h := hmac.New(md5.New, secret)
-	hSize := h.Size()
+// hmacMatchesAny returns true if the signature of the message matches that
+// produced using any of the secrets. Every secret is checked using a
+// constant-time comparison, regardless of whether an earlier one matched.
+func hmacMatchesAny(
+	newHash func() hash.Hash, sig, msg []byte, secrets [][]byte,
+) bool {
+	var ok bool
+	for _, secret := range secrets {
+		h := hmac.New(newHash, secret)
+		h.Write(msg)
+		ok = hmac.Equal(sig, h.Sum(nil)) || ok
+	}
+	return ok
+}
 
-	if len(seed) < hSize+1 || seed[0] != 0 {
-		return challengeParams{}, errMalformedSeed
+// splitSeed splits a seed into its version, signature, and marshaled
+// challengeParams, without checking the signature.
+func splitSeed(
+	seed []byte,
+) (
+	version byte, hashes seedHashes, sig, cb []byte, err error,
+) {
+	if len(seed) < 1 {
+		return 0, seedHashes{}, nil, nil, ErrMalformedSeed
 	}
-	seed = seed[1:]
 
-	sig, cb := seed[:hSize], seed[hSize:]
+	hashes, ok := seedHashesFromVersion(seed[0])
+	if !ok {
+		return 0, seedHashes{}, nil, nil, ErrMalformedSeed
+	}
+
+	hSize := hashes.signature.newFunc()().Size()
+	if len(seed) < hSize+1 {
+		return 0, seedHashes{}, nil, nil, ErrMalformedSeed
+	}
+	version, seed = seed[0], seed[1:]
+
+	return version, hashes, seed[:hSize], seed[hSize:], nil
+}
+
+// challengeParamsFromSeed parses the challengeParams, and the hash algorithms
+// used, from a seed, which must have been signed using one of the given
+// secrets.
+func challengeParamsFromSeed(
+	seed []byte, secrets ...[]byte,
+) (
+	challengeParams, seedHashes, error,
+) {
+	version, hashes, sig, cb, err := splitSeed(seed)
+	if err != nil {
+		return challengeParams{}, seedHashes{}, err
+	}
 
 	// check signature
-	h.Write(cb)
-	if !hmac.Equal(sig, h.Sum(nil)) {
-		return challengeParams{}, errMalformedSeed
+	newHash := hashes.signature.newFunc()
+	if !hmacMatchesAny(newHash, sig, seedSignedMsg(version, cb), secrets) {
+		return challengeParams{}, seedHashes{}, ErrMalformedSeed
 	}
 
 	var c challengeParams
 	if err := c.UnmarshalBinary(cb); err != nil {
-		return challengeParams{}, fmt.Errorf("unmarshaling challenge parameters: %w", err)
+		return challengeParams{}, seedHashes{}, fmt.Errorf(
+			"unmarshaling challenge parameters: %w", err,
+		)
+	}
+
+	return c, hashes, nil
+}
+
+// A pass token takes the form:
+//
+//	(version)+(signature of expiresAt)+(expiresAt)
+//
+// Version is currently always 1, so that pass tokens can never be mistaken for
+// seeds.
+const passTokenVersion = 1
+
+var errMalformedPassToken = errors.New("malformed pass token")
+
+func newPassToken(expiresAt int64, secret []byte) []byte {
+	eb := binary.BigEndian.AppendUint64(nil, uint64(expiresAt))
+
+	h := hmac.New(sha256.New, secret)
+	h.Write(eb)
+
+	token := []byte{passTokenVersion}
+	token = h.Sum(token)
+	return append(token, eb...)
+}
+
+// passTokenExpiresAt parses the expiry from a pass token, which must have been
+// signed using one of the given secrets.
+func passTokenExpiresAt(token []byte, secrets ...[]byte) (int64, error) {
+	const hSize = sha256.Size
+
+	if len(token) != 1+hSize+8 || token[0] != passTokenVersion {
+		return 0, errMalformedPassToken
 	}
 
-	return c, nil
+	sig, eb := token[1:1+hSize], token[1+hSize:]
+
+	if !hmacMatchesAny(sha256.New, sig, eb, secrets) {
+		return 0, errMalformedPassToken
+	}
+
+	return int64(binary.BigEndian.Uint64(eb)), nil
 }
 
 // Challenge is a set of fields presented to a client, with which they must
@@ -127,21 +340,29 @@ func challengeParamsFromSeed(seed, secret []byte) (challengeParams, error) {
 //   - Collect up to len(Seed) random bytes. These will be the potential
 //     solution.
 //
-//   - Calculate the sha512 of the concatenation of Seed and PotentialSolution.
+//   - Calculate the Hash of the concatenation of Seed and PotentialSolution.
 //
-//   - Parse the first 4 bytes of the sha512 result as a big-endian uint32.
+//   - Parse the first 4 bytes of the Hash result as a big-endian uint32.
 //
 //   - If the resulting number is _less_ than target, the solution has been
 //     found. Otherwise go back to step 1 and try again.
 type Challenge struct {
 	Seed   []byte
 	Target uint32
+
+	// Hash is the algorithm used to generate the solution. An empty value is
+	// equivalent to HashSHA512.
+	Hash Hash
 }
 
 // Errors which may be produced by a Manager.
 var (
-	ErrInvalidSolution = errors.New("invalid solution")
-	ErrExpiredSeed     = errors.New("expired seed")
+	ErrInvalidSolution  = errors.New("invalid solution")
+	ErrMalformedSeed    = errors.New("malformed seed")
+	ErrExpiredSeed      = errors.New("expired seed")
+	ErrExpiredPassToken = errors.New("expired pass token")
+	ErrSolutionReused   = errors.New("solution used too many times")
+	ErrSeedRevoked      = errors.New("seed revoked")
 )
 
 // Manager is used to both produce proof-of-work challenges and check their
@@ -149,9 +370,62 @@ var (
 type Manager interface {
 	NewChallenge() Challenge
 
-	// Will produce ErrInvalidSolution if the solution is invalid, or
-	// ErrExpiredSeed if the seed has expired.
+	// Will produce ErrInvalidSolution if the solution is invalid,
+	// ErrMalformedSeed if the seed wasn't produced by NewChallenge,
+	// ErrExpiredSeed if the seed has expired, ErrSeedRevoked if the seed has
+	// been revoked, or ErrSolutionReused if the solution has already been used
+	// MaxSolutionUses times.
 	CheckSolution(seed, solution []byte) error
+
+	// PeekSolution is like CheckSolution, but doesn't count as a use of the
+	// solution towards MaxSolutionUses. It still produces ErrSolutionReused if
+	// the solution has already been used MaxSolutionUses times.
+	PeekSolution(seed, solution []byte) error
+
+	// RevokeSeed causes CheckSolution to reject all solutions for the given
+	// seed, which must have been produced by NewChallenge, until it expires.
+	// Pass tokens which have already been issued are not affected.
+	RevokeSeed(seed []byte) error
+
+	// NewPassToken returns a self-contained token, signed using the secret,
+	// which is valid for the given lifetime. It is intended to be given to
+	// clients which have presented a valid solution, so that subsequent
+	// requests can be checked using CheckPassToken without consulting the
+	// Store.
+	//
+	// Pass tokens cannot be revoked prior to their expiry.
+	NewPassToken(lifetime time.Duration) []byte
+
+	// Will produce ErrExpiredPassToken if the token has expired.
+	CheckPassToken(token []byte) error
+}
+
+// ManagerStats describes counters which are maintained by a Manager over its
+// lifetime.
+type ManagerStats struct {
+	// ChallengesIssued is the number of times NewChallenge has been called.
+	ChallengesIssued uint64
+
+	// SolutionsChecked is the number of times CheckSolution or PeekSolution
+	// has been called.
+	// It is equal to the sum of all outcome counters below.
+	SolutionsChecked uint64
+
+	// Outcomes of CheckSolution calls.
+	SolutionsValid     uint64
+	SolutionsInvalid   uint64
+	SolutionsExpired   uint64
+	SolutionsMalformed uint64
+	SolutionsReused    uint64
+	SolutionsRevoked   uint64
+	SolutionsErrored   uint64
+}
+
+// StatsReporter is an optional interface which may be implemented by a
+// Manager, allowing for in-process introspection of its activity. The Manager
+// returned by NewManager implements StatsReporter.
+type StatsReporter interface {
+	Stats() ManagerStats
 }
 
 // ManagerParams are used to initialize a new Manager instance. All fields are
@@ -184,6 +458,42 @@ type ManagerOpts struct {
 	//
 	// Defaults to clock.Realtime().
 	Clock clock.Clock
+
+	// OnStoreError, if given, will be called when the Store fails to record a
+	// valid solution or its uses. Such failures do not cause CheckSolution to
+	// fail, since the solution itself is still valid.
+	OnStoreError func(error)
+
+	// MaxSolutionUses, if greater than zero, is the number of times that
+	// CheckSolution will accept any particular seed/solution combination.
+	// Subsequent checks will produce ErrSolutionReused.
+	//
+	// Defaults to zero, meaning solutions may be used any number of times
+	// until their seed expires.
+	MaxSolutionUses int
+
+	// OldSecrets are previously used secrets, which Challenges and pass tokens
+	// will still be accepted from, but which will not be used to sign new
+	// ones. This allows the secret to be rotated without invalidating every
+	// outstanding Challenge: the new secret is given to NewManager with the
+	// old one given here, and the old one can be removed once the
+	// ChallengeTimeout (and any pass token lifetime) has elapsed.
+	OldSecrets [][]byte
+
+	// Hash is the algorithm which will be used to solve Challenges, and must
+	// be one of SolutionHashes. The algorithm is embedded in each Challenge's
+	// Seed, and so changing it doesn't invalidate outstanding Challenges.
+	//
+	// Defaults to HashSHA512.
+	Hash Hash
+
+	// SignatureHash is the algorithm which will be used to sign each
+	// Challenge's Seed, and must be one of SignatureHashes. As with Hash, the
+	// algorithm is embedded in the Seed, and so Seeds signed using any of the
+	// SignatureHashes are accepted.
+	//
+	// Defaults to HashMD5.
+	SignatureHash Hash
 }
 
 func (o *ManagerOpts) withDefaults() *ManagerOpts {
@@ -203,14 +513,35 @@ func (o *ManagerOpts) withDefaults() *ManagerOpts {
 		o.Clock = clock.Realtime()
 	}
 
+	if o.Hash == "" {
+		o.Hash = HashSHA512
+	}
+
+	if o.SignatureHash == "" {
+		o.SignatureHash = HashMD5
+	}
+
 	return o
 }
 
+type managerStats struct {
+	challengesIssued   atomic.Uint64
+	solutionsValid     atomic.Uint64
+	solutionsInvalid   atomic.Uint64
+	solutionsExpired   atomic.Uint64
+	solutionsMalformed atomic.Uint64
+	solutionsReused    atomic.Uint64
+	solutionsRevoked   atomic.Uint64
+	solutionsErrored   atomic.Uint64
+}
+
 type manager struct {
 	store               Store
 	secret              []byte
+	checkSecrets        [][]byte // secret followed by OldSecrets
 	opts                *ManagerOpts
 	solutionCheckerPool sync.Pool
+	stats               managerStats
 }
 
 // NewManager initializes and returns a Manager instance using the given
@@ -218,18 +549,55 @@ type manager struct {
 //
 // The secret is used to sign the seed values and should never be shared with
 // clients.
+//
+// NewManager will panic if ManagerOpts.Hash or ManagerOpts.SignatureHash is
+// not one of the supported Hashes.
 func NewManager(store Store, secret []byte, opts *ManagerOpts) Manager {
+	opts = opts.withDefaults()
+
+	if !slices.Contains(SolutionHashes, opts.Hash) {
+		panic(fmt.Sprintf("unsupported solution hash %q", opts.Hash))
+	} else if !slices.Contains(SignatureHashes, opts.SignatureHash) {
+		panic(fmt.Sprintf("unsupported signature hash %q", opts.SignatureHash))
+	}
+
 	return &manager{
-		store,
-		secret,
-		opts.withDefaults(),
-		sync.Pool{
-			New: func() any { return SolutionChecker{} },
+		store:        store,
+		secret:       secret,
+		checkSecrets: append([][]byte{secret}, opts.OldSecrets...),
+		opts:         opts,
+		solutionCheckerPool: sync.Pool{
+			New: func() any { return new(SolutionChecker) },
 		},
 	}
 }
 
+func (m *manager) Stats() ManagerStats {
+	stats := ManagerStats{
+		ChallengesIssued:   m.stats.challengesIssued.Load(),
+		SolutionsValid:     m.stats.solutionsValid.Load(),
+		SolutionsInvalid:   m.stats.solutionsInvalid.Load(),
+		SolutionsExpired:   m.stats.solutionsExpired.Load(),
+		SolutionsMalformed: m.stats.solutionsMalformed.Load(),
+		SolutionsReused:    m.stats.solutionsReused.Load(),
+		SolutionsRevoked:   m.stats.solutionsRevoked.Load(),
+		SolutionsErrored:   m.stats.solutionsErrored.Load(),
+	}
+
+	stats.SolutionsChecked = stats.SolutionsValid +
+		stats.SolutionsInvalid +
+		stats.SolutionsExpired +
+		stats.SolutionsMalformed +
+		stats.SolutionsReused +
+		stats.SolutionsRevoked +
+		stats.SolutionsErrored
+
+	return stats
+}
+
 func (m *manager) NewChallenge() Challenge {
+	m.stats.challengesIssued.Add(1)
+
 	c := challengeParams{
 		target:    m.opts.Target,
 		expiresAt: m.opts.Clock.Now().Add(m.opts.ChallengeTimeout).Unix(),
@@ -240,7 +608,10 @@ func (m *manager) NewChallenge() Challenge {
 		panic(err)
 	}
 
-	seed, err := newSeed(c, m.secret)
+	seed, err := newSeed(c, seedHashes{
+		solution:  m.opts.Hash,
+		signature: m.opts.SignatureHash,
+	}, m.secret)
 	if err != nil {
 		panic(err)
 	}
@@ -248,6 +619,7 @@ func (m *manager) NewChallenge() Challenge {
 	return Challenge{
 		Seed:   seed,
 		Target: c.target,
+		Hash:   m.opts.Hash,
 	}
 }
 
@@ -257,14 +629,23 @@ func (m *manager) NewChallenge() Challenge {
 //
 // SolutionChecker is not thread-safe.
 type SolutionChecker struct {
-	h   hash.Hash // sha512
-	sum []byte
+	hash Hash
+	h    hash.Hash
+	sum  []byte
 }
 
 // Check returns true if the given bytes are a solution to the given Challenge.
-func (s SolutionChecker) Check(challenge Challenge, solution []byte) bool {
-	if s.h == nil {
-		s.h = sha512.New()
+func (s *SolutionChecker) Check(challenge Challenge, solution []byte) bool {
+	if challenge.Hash == "" {
+		challenge.Hash = HashSHA512
+	}
+
+	if s.h == nil || s.hash != challenge.Hash {
+		newHash := challenge.Hash.newFunc()
+		if newHash == nil {
+			return false
+		}
+		s.hash, s.h = challenge.Hash, newHash()
 	}
 	s.h.Reset()
 
@@ -277,15 +658,44 @@ func (s SolutionChecker) Check(challenge Challenge, solution []byte) bool {
 }
 
 func (m *manager) CheckSolution(seed, solution []byte) error {
-	if len(solution) > len(seed) {
-		return ErrInvalidSolution
+	return m.recordCheck(m.checkSolution(seed, solution, true))
+}
+
+func (m *manager) PeekSolution(seed, solution []byte) error {
+	return m.recordCheck(m.checkSolution(seed, solution, false))
+}
+
+// recordCheck records the outcome of a solution check in the stats, returning
+// the error as-is.
+func (m *manager) recordCheck(err error) error {
+	switch {
+	case err == nil:
+		m.stats.solutionsValid.Add(1)
+	case errors.Is(err, ErrInvalidSolution):
+		m.stats.solutionsInvalid.Add(1)
+	case errors.Is(err, ErrExpiredSeed):
+		m.stats.solutionsExpired.Add(1)
+	case errors.Is(err, ErrMalformedSeed):
+		m.stats.solutionsMalformed.Add(1)
+	case errors.Is(err, ErrSolutionReused):
+		m.stats.solutionsReused.Add(1)
+	case errors.Is(err, ErrSeedRevoked):
+		m.stats.solutionsRevoked.Add(1)
+	default:
+		m.stats.solutionsErrored.Add(1)
 	}
 
-	if m.store.IsSolution(seed, solution) {
-		return nil
+	return err
+}
+
+// checkSolution checks the solution, counting it as a use towards
+// MaxSolutionUses if use is true.
+func (m *manager) checkSolution(seed, solution []byte, use bool) error {
+	if len(solution) > len(seed) {
+		return ErrInvalidSolution
 	}
 
-	c, err := challengeParamsFromSeed(seed, m.secret)
+	c, hashes, err := challengeParamsFromSeed(seed, m.checkSecrets...)
 	if err != nil {
 		return fmt.Errorf("parsing challenge parameters from seed: %w", err)
 
@@ -293,37 +703,166 @@ func (m *manager) CheckSolution(seed, solution []byte) error {
 		return ErrExpiredSeed
 	}
 
-	solutionChecker := m.solutionCheckerPool.Get().(SolutionChecker)
+	expiresAt := time.Unix(c.expiresAt, 0)
+
+	if m.store.IsSeedRevoked(seed) {
+		return ErrSeedRevoked
+	}
+
+	if m.store.IsSolution(seed, solution) {
+		return m.useSolution(seed, solution, expiresAt, use)
+	}
+
+	solutionChecker := m.solutionCheckerPool.Get().(*SolutionChecker)
 	defer m.solutionCheckerPool.Put(solutionChecker)
 
 	ok := solutionChecker.Check(
-		Challenge{Seed: seed, Target: c.target}, solution,
+		Challenge{Seed: seed, Target: c.target, Hash: hashes.solution}, solution,
 	)
 
 	if !ok {
 		return ErrInvalidSolution
 	}
 
-	expiresAt := time.Unix(c.expiresAt, 0)
+	// If the store fails to record the solution then it will simply need to be
+	// checked again next time, there's no reason to fail the check itself.
 	if err := m.store.SetSolution(seed, solution, expiresAt); err != nil {
-		return fmt.Errorf("marking solution as solved: %w", err)
+		if m.opts.OnStoreError != nil {
+			m.opts.OnStoreError(fmt.Errorf("marking solution as solved: %w", err))
+		}
 	}
 
+	return m.useSolution(seed, solution, expiresAt, use)
+}
+
+// useSolution records a use of an already checked solution, returning
+// ErrSolutionReused if MaxSolutionUses has been exceeded. If use is false then
+// the use isn't recorded, and ErrSolutionReused is only returned if the
+// solution has already been used MaxSolutionUses times.
+func (m *manager) useSolution(
+	seed, solution []byte, expiresAt time.Time, use bool,
+) error {
+	if m.opts.MaxSolutionUses <= 0 {
+		return nil
+	}
+
+	if !use {
+		uses, err := m.store.SolutionUses(seed, solution)
+		if err != nil {
+			if m.opts.OnStoreError != nil {
+				m.opts.OnStoreError(fmt.Errorf("getting solution uses: %w", err))
+			}
+			return nil
+		} else if uses >= m.opts.MaxSolutionUses {
+			return ErrSolutionReused
+		}
+		return nil
+	}
+
+	uses, err := m.store.IncrSolutionUses(seed, solution, expiresAt)
+	if err != nil {
+		// As with SetSolution, a failure to record the use is not a reason to
+		// reject an otherwise valid solution.
+		if m.opts.OnStoreError != nil {
+			m.opts.OnStoreError(fmt.Errorf("incrementing solution uses: %w", err))
+		}
+		return nil
+	}
+
+	if uses > m.opts.MaxSolutionUses {
+		return ErrSolutionReused
+	}
+
+	return nil
+}
+
+func (m *manager) RevokeSeed(seed []byte) error {
+	c, _, err := challengeParamsFromSeed(seed, m.checkSecrets...)
+	if err != nil {
+		return fmt.Errorf("parsing challenge parameters from seed: %w", err)
+	}
+
+	if err := m.store.RevokeSeed(seed, time.Unix(c.expiresAt, 0)); err != nil {
+		return fmt.Errorf("revoking seed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *manager) NewPassToken(lifetime time.Duration) []byte {
+	expiresAt := m.opts.Clock.Now().Add(lifetime).Unix()
+	return newPassToken(expiresAt, m.secret)
+}
+
+func (m *manager) CheckPassToken(token []byte) error {
+	expiresAt, err := passTokenExpiresAt(token, m.checkSecrets...)
+	if err != nil {
+		return fmt.Errorf("parsing pass token: %w", err)
+	} else if now := m.opts.Clock.Now().Unix(); expiresAt <= now {
+		return ErrExpiredPassToken
+	}
 	return nil
 }
 
+// SeedTarget returns the Target of the Challenge which the seed was produced
+// for. The seed's signature is not checked, so this should only be used on
+// seeds which have already been checked using CheckSolution.
+func SeedTarget(seed []byte) (uint32, error) {
+	_, _, _, cb, err := splitSeed(seed)
+	if err != nil {
+		return 0, err
+	}
+
+	var c challengeParams
+	if err := c.UnmarshalBinary(cb); err != nil {
+		return 0, fmt.Errorf("unmarshaling challenge parameters: %w", err)
+	}
+
+	return c.target, nil
+}
+
+// ExpectedIterations returns the number of attempts a client can expect to
+// make, on average, before finding a solution to a Challenge with the given
+// target.
+func ExpectedIterations(target uint32) uint64 {
+	if target == 0 {
+		return math.MaxUint64
+	}
+	return math.MaxUint32 / uint64(target)
+}
+
 // Solve returns a solution for the given Challenge. This may take a while.
 func Solve(challenge Challenge) []byte {
+	b, err := SolveContext(context.Background(), challenge)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// solveContextCheckPeriod is how many solutions SolveContext will try between
+// checking if its Context has been canceled.
+const solveContextCheckPeriod = 1024
+
+// SolveContext is like Solve, but will return the Context's error if it is
+// canceled prior to a solution being found.
+func SolveContext(ctx context.Context, challenge Challenge) ([]byte, error) {
 	var (
-		chk = SolutionChecker{}
+		chk = new(SolutionChecker)
 		b   = make([]byte, len(challenge.Seed))
 	)
 
-	for {
+	for i := 0; ; i++ {
+		if i%solveContextCheckPeriod == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
 		if _, err := rand.Read(b); err != nil {
-			panic(err)
+			return nil, fmt.Errorf("reading random bytes: %w", err)
 		} else if chk.Check(challenge, b) {
-			return b
+			return b, nil
 		}
 	}
 }
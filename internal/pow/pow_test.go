@@ -1,8 +1,11 @@
 package pow
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"math"
 	"strconv"
 	"testing"
 	"time"
@@ -57,17 +60,18 @@ func TestChallengeParams(t *testing.T) {
 		for i, test := range tests {
 			t.Run(strconv.Itoa(i), func(t *testing.T) {
 				t.Parallel()
-				seed, err := newSeed(test, secret)
+				seed, err := newSeed(test, legacySeedHashes, secret)
 				assert.NoError(t, err)
 
 				// generating seed should be deterministic
-				seed2, err := newSeed(test, secret)
+				seed2, err := newSeed(test, legacySeedHashes, secret)
 				assert.NoError(t, err)
 				assert.Equal(t, seed, seed2)
 
-				c, err := challengeParamsFromSeed(seed, secret)
+				c, hashes, err := challengeParamsFromSeed(seed, secret)
 				assert.NoError(t, err)
 				assert.Equal(t, test, c)
+				assert.Equal(t, legacySeedHashes, hashes)
 			})
 		}
 	})
@@ -89,11 +93,38 @@ func TestChallengeParams(t *testing.T) {
 					panic(err)
 				}
 
-				_, err = challengeParamsFromSeed(seed, secret)
-				assert.ErrorIs(t, errMalformedSeed, err)
+				_, _, err = challengeParamsFromSeed(seed, secret)
+				assert.ErrorIs(t, ErrMalformedSeed, err)
 			})
 		}
 	})
+
+	t.Run("hashes", func(t *testing.T) {
+		t.Parallel()
+
+		c := challengeParams{target: 1, expiresAt: 3, random: []byte{0, 1, 2}}
+
+		for _, solution := range SolutionHashes {
+			for _, signature := range SignatureHashes {
+				hashes := seedHashes{solution: solution, signature: signature}
+				t.Run(string(solution)+"/"+string(signature), func(t *testing.T) {
+					t.Parallel()
+					seed, err := newSeed(c, hashes, secret)
+					require.NoError(t, err)
+
+					gotC, gotHashes, err := challengeParamsFromSeed(seed, secret)
+					assert.NoError(t, err)
+					assert.Equal(t, c, gotC)
+					assert.Equal(t, hashes, gotHashes)
+
+					t.Log("Checking that the version byte can't be swapped")
+					seed[0] ^= 1
+					_, _, err = challengeParamsFromSeed(seed, secret)
+					assert.ErrorIs(t, err, ErrMalformedSeed)
+				})
+			}
+		}
+	})
 }
 
 func TestManager(t *testing.T) {
@@ -173,3 +204,409 @@ func TestManager(t *testing.T) {
 		assert.ErrorIs(t, h.mgr.CheckSolution(c.Seed, solution), ErrExpiredSeed)
 	})
 }
+
+func TestManagerHash(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore(nil)
+	t.Cleanup(func() { store.Close() })
+
+	newManager := func(h Hash) Manager {
+		return NewManager(store, []byte("shhhhh"), &ManagerOpts{
+			Target:        0x0FFFFFFF,
+			Hash:          h,
+			SignatureHash: HashSHA256,
+		})
+	}
+
+	for _, h := range SolutionHashes {
+		t.Run(string(h), func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mgr      = newManager(h)
+				c        = mgr.NewChallenge()
+				solution = Solve(c)
+			)
+
+			assert.Equal(t, h, c.Hash)
+
+			t.Log("Checking that the solution round-trips")
+			assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+
+			t.Log("Checking that the solution must use the challenge's hash")
+			for _, otherH := range SolutionHashes {
+				if otherH == h {
+					continue
+				}
+
+				otherC := c
+				otherC.Hash = otherH
+				otherSolution := Solve(otherC)
+
+				// The target is easy enough that the other solution may happen
+				// to also be valid for the challenge's own hash.
+				chk := new(SolutionChecker)
+				if !chk.Check(c, otherSolution) {
+					assert.ErrorIs(
+						t, mgr.CheckSolution(c.Seed, otherSolution), ErrInvalidSolution,
+					)
+				}
+			}
+
+			t.Log("Checking that managers with other hashes accept the challenge")
+			for _, otherH := range SolutionHashes {
+				assert.NoError(t, newManager(otherH).CheckSolution(c.Seed, solution))
+			}
+		})
+	}
+
+	t.Log("Checking that an unsupported hash panics")
+	assert.Panics(t, func() { newManager(HashMD5) })
+}
+
+func TestManagerPassToken(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock = clock.NewMock(time.Now().Truncate(time.Hour))
+		store = NewMemoryStore(&MemoryStoreOpts{Clock: clock})
+		opts  = &ManagerOpts{Clock: clock}
+		mgr   = NewManager(store, []byte("shhhhh"), opts)
+	)
+	t.Cleanup(func() { store.Close() })
+
+	token := mgr.NewPassToken(time.Minute)
+
+	t.Log("Checking that token starts off valid")
+	assert.NoError(t, mgr.CheckPassToken(token))
+
+	t.Log("Checking that token is not valid with a different secret")
+	otherMgr := NewManager(store, []byte("other"), opts)
+	assert.ErrorIs(t, otherMgr.CheckPassToken(token), errMalformedPassToken)
+
+	t.Log("Checking that a tampered token is not valid")
+	tampered := append([]byte(nil), token...)
+	tampered[len(tampered)-1]++
+	assert.ErrorIs(t, mgr.CheckPassToken(tampered), errMalformedPassToken)
+
+	t.Log("Checking that a seed is not valid as a token")
+	c := mgr.NewChallenge()
+	assert.ErrorIs(t, mgr.CheckPassToken(c.Seed), errMalformedPassToken)
+
+	clock.Add(2 * time.Minute)
+	t.Log("Checking that token is no longer valid after expiry time has elapsed")
+	assert.ErrorIs(t, mgr.CheckPassToken(token), ErrExpiredPassToken)
+}
+
+func TestManagerOldSecrets(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock   = clock.NewMock(time.Now().Truncate(time.Hour))
+		store   = NewMemoryStore(&MemoryStoreOpts{Clock: clock})
+		newOpts = func(oldSecrets ...[]byte) *ManagerOpts {
+			return &ManagerOpts{
+				Target:           0x0FFFFFFF,
+				ChallengeTimeout: time.Minute,
+				Clock:            clock,
+				OldSecrets:       oldSecrets,
+			}
+		}
+		oldMgr     = NewManager(store, []byte("old"), newOpts())
+		rotatedMgr = NewManager(store, []byte("new"), newOpts([]byte("older"), []byte("old")))
+		newMgr     = NewManager(store, []byte("new"), newOpts())
+	)
+	t.Cleanup(func() { store.Close() })
+
+	var (
+		c        = oldMgr.NewChallenge()
+		solution = Solve(c)
+		token    = oldMgr.NewPassToken(time.Minute)
+	)
+
+	t.Log("Checking that challenges and pass tokens signed with an old secret are accepted")
+	assert.NoError(t, rotatedMgr.CheckSolution(c.Seed, solution))
+	assert.NoError(t, rotatedMgr.CheckPassToken(token))
+
+	t.Log("Checking that new challenges and pass tokens are signed with the primary secret")
+	var (
+		newC     = rotatedMgr.NewChallenge()
+		newToken = rotatedMgr.NewPassToken(time.Minute)
+	)
+	assert.NoError(t, newMgr.CheckSolution(newC.Seed, Solve(newC)))
+	assert.NoError(t, newMgr.CheckPassToken(newToken))
+	assert.ErrorIs(t, oldMgr.CheckPassToken(newToken), errMalformedPassToken)
+
+	t.Log("Checking that old secrets are not accepted once dropped")
+	assert.ErrorIs(t, newMgr.CheckSolution(c.Seed, solution), ErrMalformedSeed)
+	assert.ErrorIs(t, newMgr.CheckPassToken(token), errMalformedPassToken)
+
+	clock.Add(2 * time.Minute)
+	t.Log("Checking that the grace window for old secrets ends with the challenge timeout")
+	assert.ErrorIs(t, rotatedMgr.CheckSolution(c.Seed, solution), ErrExpiredSeed)
+	assert.ErrorIs(t, rotatedMgr.CheckPassToken(token), ErrExpiredPassToken)
+}
+
+func TestExpectedIterations(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		target uint32
+		exp    uint64
+	}{
+		{0xFFFFFFFF, 1},
+		{0x0FFFFFFF, 16},
+		{0x00FFFFFF, 256},
+		{0x000FFFFF, 4096},
+		{1, 0xFFFFFFFF},
+		{0, math.MaxUint64},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.FormatUint(uint64(test.target), 16), func(t *testing.T) {
+			assert.Equal(t, test.exp, ExpectedIterations(test.target))
+		})
+	}
+}
+
+func TestSeedTarget(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore(nil)
+	t.Cleanup(func() { store.Close() })
+
+	for _, target := range []uint32{0x0FFFFFFF, 0x000FFFFF} {
+		mgr := NewManager(store, []byte("shhhhh"), &ManagerOpts{
+			Target:        target,
+			SignatureHash: HashSHA256,
+		})
+
+		got, err := SeedTarget(mgr.NewChallenge().Seed)
+		assert.NoError(t, err)
+		assert.Equal(t, target, got)
+	}
+
+	t.Log("Checking that a malformed seed is an error")
+	_, err := SeedTarget([]byte{0})
+	assert.ErrorIs(t, err, ErrMalformedSeed)
+}
+
+func TestManagerStats(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock = clock.NewMock(time.Now().Truncate(time.Hour))
+		store = NewMemoryStore(&MemoryStoreOpts{Clock: clock})
+		mgr   = NewManager(store, []byte("shhhhh"), &ManagerOpts{
+			Target:           0x0FFFFFFF,
+			ChallengeTimeout: 1 * time.Second,
+			Clock:            clock,
+		})
+	)
+
+	t.Cleanup(func() { store.Close() })
+
+	var (
+		c        = mgr.NewChallenge()
+		solution = Solve(c)
+	)
+
+	_ = mgr.NewChallenge()
+
+	assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+	assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+	assert.ErrorIs(t, mgr.CheckSolution(c.Seed, make([]byte, len(c.Seed)+1)), ErrInvalidSolution)
+	assert.ErrorIs(t, mgr.CheckSolution([]byte{1, 2, 3}, []byte{1}), ErrMalformedSeed)
+
+	clock.Add(2 * time.Second)
+	assert.ErrorIs(t, mgr.CheckSolution(c.Seed, solution), ErrExpiredSeed)
+
+	assert.Equal(t, ManagerStats{
+		ChallengesIssued:   2,
+		SolutionsChecked:   5,
+		SolutionsValid:     2,
+		SolutionsInvalid:   1,
+		SolutionsExpired:   1,
+		SolutionsMalformed: 1,
+	}, mgr.(StatsReporter).Stats())
+}
+
+func TestManagerMaxSolutionUses(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock = clock.NewMock(time.Now().Truncate(time.Hour))
+		store = NewMemoryStore(&MemoryStoreOpts{Clock: clock})
+		mgr   = NewManager(store, []byte("shhhhh"), &ManagerOpts{
+			Target:          0x0FFFFFFF,
+			Clock:           clock,
+			MaxSolutionUses: 2,
+		})
+	)
+
+	t.Cleanup(func() { store.Close() })
+
+	var (
+		c        = mgr.NewChallenge()
+		solution = Solve(c)
+	)
+
+	t.Log("Checking that solution can be used up to the limit")
+	assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+	assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+
+	t.Log("Checking that solution is rejected once the limit is exceeded")
+	assert.ErrorIs(t, mgr.CheckSolution(c.Seed, solution), ErrSolutionReused)
+	assert.ErrorIs(t, mgr.CheckSolution(c.Seed, solution), ErrSolutionReused)
+
+	t.Log("Checking that other solutions are unaffected")
+	var (
+		otherC        = mgr.NewChallenge()
+		otherSolution = Solve(otherC)
+	)
+	assert.NoError(t, mgr.CheckSolution(otherC.Seed, otherSolution))
+
+	t.Log("Checking that uses are shared by Managers using the same Store")
+	otherMgr := NewManager(store, []byte("shhhhh"), &ManagerOpts{
+		Clock:           clock,
+		MaxSolutionUses: 2,
+	})
+	assert.NoError(t, otherMgr.CheckSolution(otherC.Seed, otherSolution))
+	assert.ErrorIs(
+		t, otherMgr.CheckSolution(otherC.Seed, otherSolution), ErrSolutionReused,
+	)
+
+	t.Log("Checking that peeking doesn't count as a use")
+	var (
+		peekC        = mgr.NewChallenge()
+		peekSolution = Solve(peekC)
+	)
+	for range 3 {
+		assert.NoError(t, mgr.PeekSolution(peekC.Seed, peekSolution))
+	}
+	assert.NoError(t, mgr.CheckSolution(peekC.Seed, peekSolution))
+	assert.NoError(t, mgr.CheckSolution(peekC.Seed, peekSolution))
+
+	t.Log("Checking that peeking fails once the solution has been used up")
+	assert.ErrorIs(t, mgr.PeekSolution(peekC.Seed, peekSolution), ErrSolutionReused)
+
+	t.Log("Checking that rejected uses are counted in the stats")
+	assert.Equal(t, uint64(3), mgr.(StatsReporter).Stats().SolutionsReused)
+}
+
+func TestManagerRevokeSeed(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock = clock.NewMock(time.Now().Truncate(time.Hour))
+		store = NewMemoryStore(&MemoryStoreOpts{Clock: clock})
+		mgr   = NewManager(store, []byte("shhhhh"), &ManagerOpts{
+			Target:           0x0FFFFFFF,
+			ChallengeTimeout: time.Second,
+			Clock:            clock,
+		})
+	)
+
+	t.Cleanup(func() { store.Close() })
+
+	var (
+		c        = mgr.NewChallenge()
+		solution = Solve(c)
+		otherC   = mgr.NewChallenge()
+	)
+
+	require.NoError(t, mgr.CheckSolution(c.Seed, solution))
+	require.NoError(t, mgr.RevokeSeed(c.Seed))
+
+	t.Log("Checking that an already checked solution is rejected once revoked")
+	assert.ErrorIs(t, mgr.CheckSolution(c.Seed, solution), ErrSeedRevoked)
+
+	t.Log("Checking that other seeds are unaffected")
+	assert.NoError(t, mgr.CheckSolution(otherC.Seed, Solve(otherC)))
+
+	t.Log("Checking that seeds not produced by the Manager can't be revoked")
+	assert.ErrorIs(t, mgr.RevokeSeed([]byte("bogus")), ErrMalformedSeed)
+
+	t.Log("Checking that revocations are counted in the stats")
+	assert.Equal(t, uint64(1), mgr.(StatsReporter).Stats().SolutionsRevoked)
+
+	t.Log("Checking that the revocation expires along with the seed")
+	clock.Add(2 * time.Second)
+	assert.False(t, store.IsSeedRevoked(c.Seed))
+}
+
+type errStore struct{ Store }
+
+func (errStore) SetSolution([]byte, []byte, time.Time) error {
+	return errors.New("store is down")
+}
+
+func TestManagerStoreError(t *testing.T) {
+	t.Parallel()
+
+	var (
+		clock    = clock.NewMock(time.Now().Truncate(time.Hour))
+		store    = NewMemoryStore(&MemoryStoreOpts{Clock: clock})
+		storeErr error
+		mgr      = NewManager(errStore{store}, []byte("shhhhh"), &ManagerOpts{
+			Target: 0x0FFFFFFF,
+			Clock:  clock,
+			OnStoreError: func(err error) {
+				storeErr = err
+			},
+		})
+	)
+
+	t.Cleanup(func() { store.Close() })
+
+	var (
+		c        = mgr.NewChallenge()
+		solution = Solve(c)
+	)
+
+	t.Log("Checking that solution is valid despite the store failing")
+	assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+	assert.ErrorContains(t, storeErr, "store is down")
+
+	t.Log("Checking that solution continues to be valid in subsequent checks")
+	assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+
+	// The target is easy enough that any particular bytes may happen to be a
+	// valid solution, so find some which aren't.
+	invalid := make([]byte, len(c.Seed))
+	for chk := new(SolutionChecker); chk.Check(c, invalid); invalid[0]++ {
+	}
+
+	t.Log("Checking that invalid solutions are still invalid")
+	assert.ErrorIs(t, mgr.CheckSolution(c.Seed, invalid), ErrInvalidSolution)
+}
+
+func TestSolveContext(t *testing.T) {
+	t.Parallel()
+
+	var (
+		store = NewMemoryStore(nil)
+		mgr   = NewManager(store, []byte("shhhhh"), &ManagerOpts{
+			Target: 0x0FFFFFFF,
+		})
+		c = mgr.NewChallenge()
+	)
+
+	t.Cleanup(func() { store.Close() })
+
+	t.Run("success", func(t *testing.T) {
+		solution, err := SolveContext(context.Background(), c)
+		require.NoError(t, err)
+		assert.NoError(t, mgr.CheckSolution(c.Seed, solution))
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// A target of 0 can never be solved.
+		_, err := SolveContext(ctx, Challenge{Seed: c.Seed, Target: 0})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
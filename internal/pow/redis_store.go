@@ -0,0 +1,133 @@
+package pow
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStoreParams are used to initialize a new Redis-backed Store. All fields
+// are required unless otherwise noted.
+type RedisStoreParams struct {
+	// Addr is the `host:port` address of the Redis server.
+	Addr string
+
+	// Optional password and database number to use when connecting.
+	Password string
+	DB       int
+
+	// Optional prefix which will be prepended to all keys written by the
+	// Store, allowing multiple independent Stores to share a database.
+	KeyPrefix string
+}
+
+type redisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+// NewRedisStore initializes and returns a Store implementation which is backed
+// by a Redis server, allowing multiple Caddy instances to share solutions.
+//
+// Each seed/solution combination is stored under its own key, whose expiry is
+// handled by Redis. If the server can't be reached then IsSolution will return
+// false, and so solutions will need to be checked again.
+func NewRedisStore(params RedisStoreParams) Store {
+	return &redisStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     params.Addr,
+			Password: params.Password,
+			DB:       params.DB,
+		}),
+		keyPrefix: params.KeyPrefix,
+	}
+}
+
+func (s *redisStore) key(kind string, seed, solution []byte) string {
+	return s.keyPrefix + "pow:" + kind + ":" +
+		hex.EncodeToString(seed) + ":" + hex.EncodeToString(solution)
+}
+
+func (s *redisStore) SetSolution(
+	seed, solution []byte, expiresAt time.Time,
+) error {
+	var (
+		ctx = context.Background()
+		key = s.key("solution", seed, solution)
+	)
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, 1, 0)
+		pipe.PExpireAt(ctx, key, expiresAt)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) IsSolution(seed, solution []byte) bool {
+	n, err := s.client.Exists(
+		context.Background(), s.key("solution", seed, solution),
+	).Result()
+	return err == nil && n > 0
+}
+
+func (s *redisStore) IncrSolutionUses(
+	seed, solution []byte, expiresAt time.Time,
+) (
+	int, error,
+) {
+	var (
+		ctx  = context.Background()
+		key  = s.key("uses", seed, solution)
+		incr *redis.IntCmd
+	)
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.PExpireAt(ctx, key, expiresAt)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(incr.Val()), nil
+}
+
+func (s *redisStore) SolutionUses(seed, solution []byte) (int, error) {
+	uses, err := s.client.Get(
+		context.Background(), s.key("uses", seed, solution),
+	).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return uses, err
+}
+
+func (s *redisStore) RevokeSeed(seed []byte, expiresAt time.Time) error {
+	var (
+		ctx = context.Background()
+		key = s.key("revoked", seed, nil)
+	)
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, 1, 0)
+		pipe.PExpireAt(ctx, key, expiresAt)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) IsSeedRevoked(seed []byte) bool {
+	n, err := s.client.Exists(
+		context.Background(), s.key("revoked", seed, nil),
+	).Result()
+	return err == nil && n > 0
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
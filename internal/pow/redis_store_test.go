@@ -0,0 +1,74 @@
+package pow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStore(t *testing.T) {
+	t.Parallel()
+
+	var (
+		server = miniredis.RunT(t)
+		now    = time.Now().Truncate(time.Second)
+		store  = NewRedisStore(RedisStoreParams{
+			Addr:      server.Addr(),
+			KeyPrefix: "test:",
+		})
+		seed, solution = []byte("seed"), []byte("solution")
+	)
+
+	t.Cleanup(func() { store.Close() })
+	server.SetTime(now)
+
+	t.Log("Checking that solution starts off not being stored")
+	assert.False(t, store.IsSolution(seed, solution))
+
+	require.NoError(t, store.SetSolution(seed, solution, now.Add(time.Second)))
+
+	t.Log("Checking that solution is stored")
+	assert.True(t, store.IsSolution(seed, solution))
+	assert.False(t, store.IsSolution(seed, []byte("other")))
+	assert.True(t, server.Exists("test:pow:solution:73656564:736f6c7574696f6e"))
+
+	t.Log("Checking that uses are counted")
+	uses, err := store.SolutionUses(seed, solution)
+	require.NoError(t, err)
+	assert.Equal(t, 0, uses)
+
+	for i := 1; i <= 3; i++ {
+		uses, err := store.IncrSolutionUses(seed, solution, now.Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, i, uses)
+	}
+
+	uses, err = store.SolutionUses(seed, solution)
+	require.NoError(t, err)
+	assert.Equal(t, 3, uses)
+
+	t.Log("Checking that seeds can be revoked")
+	assert.False(t, store.IsSeedRevoked(seed))
+	require.NoError(t, store.RevokeSeed(seed, now.Add(time.Second)))
+	assert.True(t, store.IsSeedRevoked(seed))
+	assert.False(t, store.IsSeedRevoked([]byte("other")))
+
+	server.FastForward(time.Second)
+
+	t.Log("Checking that solution, its uses, and the revocation have expired")
+	assert.False(t, store.IsSeedRevoked(seed))
+	assert.False(t, store.IsSolution(seed, solution))
+	assert.Empty(t, server.Keys())
+
+	t.Log("Checking that an already expired solution is not stored")
+	require.NoError(t, store.SetSolution(seed, solution, now.Add(-time.Second)))
+	assert.False(t, store.IsSolution(seed, solution))
+
+	t.Log("Checking that an unreachable server is treated as not stored")
+	server.Close()
+	assert.False(t, store.IsSolution(seed, solution))
+	assert.Error(t, store.SetSolution(seed, solution, now.Add(time.Second)))
+}
@@ -20,6 +20,24 @@ type Store interface {
 	// seed, and the expiry from that call has not yet elapsed.
 	IsSolution(seed, solution []byte) bool
 
+	// IncrSolutionUses increments the number of times the given seed/solution
+	// combination has been used, returning the new count. The count will be
+	// cleared from the Store once the expiry is reached.
+	IncrSolutionUses(seed, solution []byte, expiresAt time.Time) (int, error)
+
+	// SolutionUses returns the number of times the given seed/solution
+	// combination has been used, as counted by IncrSolutionUses.
+	SolutionUses(seed, solution []byte) (int, error)
+
+	// RevokeSeed stores that no solution should be accepted for the given
+	// seed. The revocation will be cleared from the Store once the expiry is
+	// reached.
+	RevokeSeed(seed []byte, expiresAt time.Time) error
+
+	// IsSeedRevoked returns true if RevokeSeed has been called with the given
+	// seed, and the expiry from that call has not yet elapsed.
+	IsSeedRevoked(seed []byte) bool
+
 	Close() error
 }
 
@@ -44,14 +62,23 @@ func (o *MemoryStoreOpts) withDefaults() *MemoryStoreOpts {
 	return o
 }
 
+// memStoreKey identifies a seed/solution combination. Revocations, which apply
+// to a seed regardless of solution, are keyed with an empty solution.
 type memStoreKey struct {
 	seed, solution string
 }
 
+type memStoreValue struct {
+	expiresAt time.Time
+	solved    bool
+	uses      int
+	revoked   bool
+}
+
 type inMemStore struct {
 	opts *MemoryStoreOpts
 
-	m          map[memStoreKey]time.Time
+	m          map[memStoreKey]memStoreValue
 	l          sync.RWMutex
 	closeCh    chan struct{}
 	spinLoopCh chan struct{} // only used by tests
@@ -63,7 +90,7 @@ const inMemStoreGCPeriod = 5 * time.Second
 func NewMemoryStore(opts *MemoryStoreOpts) Store {
 	s := &inMemStore{
 		opts:       opts.withDefaults(),
-		m:          map[memStoreKey]time.Time{},
+		m:          map[memStoreKey]memStoreValue{},
 		closeCh:    make(chan struct{}),
 		spinLoopCh: make(chan struct{}, 1),
 	}
@@ -80,8 +107,8 @@ func (s *inMemStore) spin(ticker *clock.Ticker) {
 			now := s.opts.Clock.Now()
 
 			s.l.Lock()
-			for key, expiresAt := range s.m {
-				if !now.Before(expiresAt) {
+			for key, v := range s.m {
+				if !now.Before(v.expiresAt) {
 					delete(s.m, key)
 				}
 			}
@@ -109,7 +136,10 @@ func (s *inMemStore) SetSolution(
 	s.l.Lock()
 	defer s.l.Unlock()
 
-	s.m[key] = expiresAt
+	v := s.m[key]
+	v.expiresAt = expiresAt
+	v.solved = true
+	s.m[key] = v
 	return nil
 }
 
@@ -122,8 +152,71 @@ func (s *inMemStore) IsSolution(seed, solution []byte) bool {
 	s.l.RLock()
 	defer s.l.RUnlock()
 
-	expiresAt, ok := s.m[key]
-	return ok && expiresAt.After(s.opts.Clock.Now())
+	v, ok := s.m[key]
+	return ok && v.solved && v.expiresAt.After(s.opts.Clock.Now())
+}
+
+func (s *inMemStore) IncrSolutionUses(
+	seed, solution []byte, expiresAt time.Time,
+) (
+	int, error,
+) {
+	key := memStoreKey{
+		seed:     string(seed),
+		solution: string(solution),
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	v, ok := s.m[key]
+	if !ok || !v.expiresAt.After(s.opts.Clock.Now()) {
+		v = memStoreValue{}
+	}
+
+	v.expiresAt = expiresAt
+	v.uses++
+	s.m[key] = v
+	return v.uses, nil
+}
+
+func (s *inMemStore) SolutionUses(seed, solution []byte) (int, error) {
+	key := memStoreKey{
+		seed:     string(seed),
+		solution: string(solution),
+	}
+
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	v, ok := s.m[key]
+	if !ok || !v.expiresAt.After(s.opts.Clock.Now()) {
+		return 0, nil
+	}
+	return v.uses, nil
+}
+
+func (s *inMemStore) RevokeSeed(seed []byte, expiresAt time.Time) error {
+	key := memStoreKey{seed: string(seed)}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	v := s.m[key]
+	v.expiresAt = expiresAt
+	v.revoked = true
+	s.m[key] = v
+	return nil
+}
+
+func (s *inMemStore) IsSeedRevoked(seed []byte) bool {
+	key := memStoreKey{seed: string(seed)}
+
+	s.l.RLock()
+	defer s.l.RUnlock()
+
+	v, ok := s.m[key]
+	return ok && v.revoked && v.expiresAt.After(s.opts.Clock.Now())
 }
 
 func (s *inMemStore) Close() error {
@@ -6,18 +6,42 @@ import (
 	"sync"
 )
 
-var bufPool = sync.Pool{
-	New: func() any {
-		return new(bytes.Buffer)
-	},
+// BufferPool is a pool of buffers which can be reused, which helps reduce
+// allocations. BufferPool is thread-safe.
+type BufferPool struct {
+	pool sync.Pool
 }
 
-// GetBuffer returns an empty buffer, along with a function which can be used to
-// return it to a global pool, which helps reduce allocations.
-func GetBuffer() (*bytes.Buffer, func()) {
-	buf := bufPool.Get().(*bytes.Buffer)
+// NewBufferPool initializes and returns a BufferPool. Newly allocated buffers
+// will have at least the given initial capacity.
+//
+// Components which deal with buffers of a particular size range should
+// generally have their own BufferPool, so that buffers of wildly different
+// sizes don't get mixed together.
+func NewBufferPool(initialCap int) *BufferPool {
+	return &BufferPool{
+		pool: sync.Pool{
+			New: func() any {
+				return bytes.NewBuffer(make([]byte, 0, initialCap))
+			},
+		},
+	}
+}
+
+// Get returns an empty buffer, along with a function which can be used to
+// return it to the pool.
+func (p *BufferPool) Get() (*bytes.Buffer, func()) {
+	buf := p.pool.Get().(*bytes.Buffer)
 	return buf, func() {
 		buf.Reset()
-		bufPool.Put(buf)
+		p.pool.Put(buf)
 	}
 }
+
+var bufPool = NewBufferPool(0)
+
+// GetBuffer returns an empty buffer, along with a function which can be used to
+// return it to a global pool, which helps reduce allocations.
+func GetBuffer() (*bytes.Buffer, func()) {
+	return bufPool.Get()
+}
@@ -0,0 +1,44 @@
+package toolkit
+
+import (
+	"bytes"
+	"testing"
+)
+
+// benchmarkBufferPools simulates handlers of small and large documents working
+// concurrently, with each getting its buffers via the given functions.
+func benchmarkBufferPools(
+	b *testing.B, getSmall, getLarge func() (*bytes.Buffer, func()),
+) {
+	var (
+		small = bytes.Repeat([]byte("a"), 1<<10)
+		large = bytes.Repeat([]byte("a"), 1<<20)
+	)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			get, data := getSmall, small
+			if i%2 == 0 {
+				get, data = getLarge, large
+			}
+
+			buf, done := get()
+			buf.Write(data)
+			done()
+		}
+	})
+}
+
+func BenchmarkBufferPool(b *testing.B) {
+	b.Run("shared", func(b *testing.B) {
+		benchmarkBufferPools(b, GetBuffer, GetBuffer)
+	})
+
+	b.Run("independent", func(b *testing.B) {
+		var (
+			smallPool = NewBufferPool(1 << 10)
+			largePool = NewBufferPool(1 << 20)
+		)
+		benchmarkBufferPools(b, smallPool.Get, largePool.Get)
+	})
+}